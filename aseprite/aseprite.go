@@ -0,0 +1,468 @@
+// Package aseprite reads sprites drawn in Aseprite, either from .aseprite
+// files or from sprite sheets exported as a PNG image and a JSON sheet.
+package aseprite
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"time"
+)
+
+var (
+	ErrFormat      = errors.New("aseprite_wrong_format")
+	ErrUnsupported = errors.New("aseprite_unsupported")
+	// ErrNoDirections is returned for sprites without direction tags or layers
+	ErrNoDirections = errors.New("aseprite_no_directions")
+)
+
+const (
+	fileMagic  = 0xA5E0
+	frameMagic = 0xF1FA
+
+	chunkOldPalette = 0x0004
+	chunkLayer      = 0x2004
+	chunkCel        = 0x2005
+	chunkTags       = 0x2018
+	chunkPalette    = 0x2019
+
+	celRaw        = 0
+	celLinked     = 1
+	celCompressed = 2
+
+	layerVisible = 1
+	layerGroup   = 1
+
+	// flagLayerOpacity is set in the header when layer opacity is valid
+	flagLayerOpacity = 1
+
+	frameHeaderSize = 16
+	chunkHeaderSize = 6
+
+	// maxCelArea is the number of pixels the cels of a sprite may decode
+	// to, sizes are checked against it before the pixels are read
+	maxCelArea = 1 << 22
+)
+
+// Sprite is the content of an Aseprite file needed to render its frames
+type Sprite struct {
+	Width  int
+	Height int
+	Frames []Frame
+	Layers []Layer
+	Tags   []Tag
+}
+
+type Frame struct {
+	Duration time.Duration
+	Cels     []Cel
+}
+
+type Layer struct {
+	Name    string
+	Visible bool
+	Group   bool
+	// Level is the depth of the layer in groups, 0 at the top level
+	Level   int
+	Opacity uint8
+}
+
+// Cel is the image of a layer in a frame
+type Cel struct {
+	Layer   int
+	X       int
+	Y       int
+	Opacity uint8
+	Image   *image.NRGBA
+}
+
+// Tag names the frames From to To, both included
+type Tag struct {
+	Name string
+	From int
+	To   int
+}
+
+type header struct {
+	Size             uint32
+	Magic            uint16
+	Frames           uint16
+	Width            uint16
+	Height           uint16
+	Depth            uint16
+	Flags            uint32
+	Speed            uint16
+	_                [2]uint32
+	TransparentIndex uint8
+	_                [3]uint8
+	Colors           uint16
+	_                [94]uint8
+}
+
+type frameHeader struct {
+	Size      uint32
+	Magic     uint16
+	OldChunks uint16
+	Duration  uint16
+	_         [2]uint8
+	Chunks    uint32
+}
+
+// decoder keeps the state shared by the chunks of a file
+type decoder struct {
+	header  header
+	palette color.Palette
+	// newPalette is set once a palette chunk replaces old palette chunks
+	newPalette bool
+	sprite     *Sprite
+	// celArea is the number of cel pixels decoded so far
+	celArea int
+}
+
+// Decode reads an .aseprite file. Sprites wider or higher than maxSide, or
+// with more than maxCelArea cel pixels, are rejected before their pixels
+// are read, tilemap layers are not supported.
+func Decode(r io.Reader, maxSide int) (*Sprite, error) {
+	d := decoder{palette: make(color.Palette, 256)}
+	for i := range d.palette {
+		d.palette[i] = color.NRGBA{}
+	}
+	if err := binary.Read(r, binary.LittleEndian, &d.header); err != nil {
+		return nil, ErrFormat
+	}
+	h := d.header
+	if h.Magic != fileMagic {
+		return nil, ErrFormat
+	}
+	if h.Depth != 32 && h.Depth != 16 && h.Depth != 8 {
+		return nil, fmt.Errorf("%w: color depth %d", ErrUnsupported, h.Depth)
+	}
+	if int(h.Width) > maxSide || int(h.Height) > maxSide {
+		return nil, fmt.Errorf("%w: %dx%d sprite", ErrUnsupported, h.Width, h.Height)
+	}
+	d.sprite = &Sprite{Width: int(h.Width), Height: int(h.Height)}
+
+	for i := 0; i < int(h.Frames); i++ {
+		var fh frameHeader
+		if err := binary.Read(r, binary.LittleEndian, &fh); err != nil || fh.Magic != frameMagic || fh.Size < frameHeaderSize {
+			return nil, ErrFormat
+		}
+		// chunks cannot be larger than what is left of their frame
+		remaining := fh.Size - frameHeaderSize
+		chunks := int(fh.Chunks)
+		if chunks == 0 {
+			chunks = int(fh.OldChunks)
+		}
+		frame := Frame{Duration: time.Duration(fh.Duration) * time.Millisecond}
+		for j := 0; j < chunks; j++ {
+			var size uint32
+			var kind uint16
+			if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+				return nil, ErrFormat
+			}
+			if err := binary.Read(r, binary.LittleEndian, &kind); err != nil || size < chunkHeaderSize || size > remaining {
+				return nil, ErrFormat
+			}
+			remaining -= size
+			// the buffer grows as the data is read, not to the size claimed
+			data, err := io.ReadAll(io.LimitReader(r, int64(size-chunkHeaderSize)))
+			if err != nil || len(data) != int(size-chunkHeaderSize) {
+				return nil, ErrFormat
+			}
+			if err := d.chunk(&frame, kind, data); err != nil {
+				return nil, err
+			}
+		}
+		d.sprite.Frames = append(d.sprite.Frames, frame)
+	}
+	return d.sprite, nil
+}
+
+func (d *decoder) chunk(frame *Frame, kind uint16, data []byte) error {
+	r := bytes.NewReader(data)
+	switch kind {
+	case chunkLayer:
+		var l struct {
+			Flags     uint16
+			Kind      uint16
+			Level     uint16
+			_         [2]uint16
+			BlendMode uint16
+			Opacity   uint8
+			_         [3]uint8
+		}
+		if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
+			return ErrFormat
+		}
+		name, err := readString(r)
+		if err != nil {
+			return err
+		}
+		if l.Kind > layerGroup {
+			return fmt.Errorf("%w: tilemap layer %q", ErrUnsupported, name)
+		}
+		opacity := l.Opacity
+		if d.header.Flags&flagLayerOpacity == 0 {
+			opacity = 255
+		}
+		d.sprite.Layers = append(d.sprite.Layers, Layer{
+			Name:    name,
+			Visible: l.Flags&layerVisible != 0,
+			Group:   l.Kind == layerGroup,
+			Level:   int(l.Level),
+			Opacity: opacity,
+		})
+	case chunkCel:
+		var c struct {
+			Layer   uint16
+			X       int16
+			Y       int16
+			Opacity uint8
+			Kind    uint16
+			_       int16
+			_       [5]uint8
+		}
+		if err := binary.Read(r, binary.LittleEndian, &c); err != nil {
+			return ErrFormat
+		}
+		cel := Cel{Layer: int(c.Layer), X: int(c.X), Y: int(c.Y), Opacity: c.Opacity}
+		switch c.Kind {
+		case celLinked:
+			var linked uint16
+			if err := binary.Read(r, binary.LittleEndian, &linked); err != nil {
+				return ErrFormat
+			}
+			if int(linked) >= len(d.sprite.Frames) {
+				return ErrFormat
+			}
+			for _, lc := range d.sprite.Frames[linked].Cels {
+				if lc.Layer == cel.Layer {
+					cel.Image = lc.Image
+				}
+			}
+			if cel.Image == nil {
+				return ErrFormat
+			}
+		case celRaw, celCompressed:
+			var size [2]uint16
+			if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+				return ErrFormat
+			}
+			var pixels io.Reader = r
+			if c.Kind == celCompressed {
+				zr, err := zlib.NewReader(r)
+				if err != nil {
+					return ErrFormat
+				}
+				defer zr.Close()
+				pixels = zr
+			}
+			img, err := d.pixels(pixels, int(size[0]), int(size[1]))
+			if err != nil {
+				return err
+			}
+			cel.Image = img
+		default:
+			// tilemap cels only exist in tilemap layers
+			return fmt.Errorf("%w: cel type %d", ErrUnsupported, c.Kind)
+		}
+		frame.Cels = append(frame.Cels, cel)
+	case chunkPalette:
+		var p struct {
+			Size  uint32
+			First uint32
+			Last  uint32
+			_     [8]uint8
+		}
+		if err := binary.Read(r, binary.LittleEndian, &p); err != nil || p.Last < p.First || p.Last > 0xFFFF {
+			return ErrFormat
+		}
+		for i := p.First; i <= p.Last; i++ {
+			var e struct {
+				Flags      uint16
+				R, G, B, A uint8
+			}
+			if err := binary.Read(r, binary.LittleEndian, &e); err != nil {
+				return ErrFormat
+			}
+			if e.Flags&1 != 0 {
+				if _, err := readString(r); err != nil {
+					return err
+				}
+			}
+			d.setPalette(int(i), color.NRGBA{R: e.R, G: e.G, B: e.B, A: e.A})
+		}
+		d.newPalette = true
+	case chunkOldPalette:
+		// files with a palette chunk keep old palette chunks for old readers
+		if d.newPalette {
+			return nil
+		}
+		var packets uint16
+		if err := binary.Read(r, binary.LittleEndian, &packets); err != nil {
+			return ErrFormat
+		}
+		index := 0
+		for i := 0; i < int(packets); i++ {
+			var p [2]uint8
+			if _, err := io.ReadFull(r, p[:]); err != nil {
+				return ErrFormat
+			}
+			index += int(p[0])
+			count := int(p[1])
+			if count == 0 {
+				count = 256
+			}
+			for j := 0; j < count; j++ {
+				var rgb [3]uint8
+				if _, err := io.ReadFull(r, rgb[:]); err != nil {
+					return ErrFormat
+				}
+				d.setPalette(index, color.NRGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: 255})
+				index++
+			}
+		}
+	case chunkTags:
+		var count uint16
+		if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+			return ErrFormat
+		}
+		if _, err := r.Seek(8, io.SeekCurrent); err != nil {
+			return ErrFormat
+		}
+		for i := 0; i < int(count); i++ {
+			var t struct {
+				From uint16
+				To   uint16
+				_    [13]uint8
+			}
+			if err := binary.Read(r, binary.LittleEndian, &t); err != nil {
+				return ErrFormat
+			}
+			name, err := readString(r)
+			if err != nil {
+				return err
+			}
+			d.sprite.Tags = append(d.sprite.Tags, Tag{Name: name, From: int(t.From), To: int(t.To)})
+		}
+	}
+	// other chunks do not change how frames are rendered
+	return nil
+}
+
+// pixels reads a width x height image in the color depth of the file
+func (d *decoder) pixels(r io.Reader, width, height int) (*image.NRGBA, error) {
+	if width > int(d.header.Width) || height > int(d.header.Height) {
+		return nil, ErrFormat
+	}
+	d.celArea += width * height
+	if d.celArea > maxCelArea {
+		return nil, fmt.Errorf("%w: more than %d cel pixels", ErrUnsupported, maxCelArea)
+	}
+	bpp := int(d.header.Depth) / 8
+	buf := make([]byte, width*height*bpp)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, ErrFormat
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < width*height; i++ {
+		p := buf[i*bpp:]
+		var c color.NRGBA
+		switch bpp {
+		case 4:
+			c = color.NRGBA{R: p[0], G: p[1], B: p[2], A: p[3]}
+		case 2:
+			c = color.NRGBA{R: p[0], G: p[0], B: p[0], A: p[1]}
+		case 1:
+			if p[0] != d.header.TransparentIndex {
+				c = d.palette[p[0]].(color.NRGBA)
+			}
+		}
+		img.SetNRGBA(i%width, i/width, c)
+	}
+	return img, nil
+}
+
+func (d *decoder) setPalette(i int, c color.NRGBA) {
+	if i < len(d.palette) {
+		d.palette[i] = c
+	}
+}
+
+func readString(r io.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", ErrFormat
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", ErrFormat
+	}
+	return string(b), nil
+}
+
+// Render composites the cels of frame in layers, or in every visible layer
+// if layers is nil. Layers are drawn from the bottom up whatever their
+// order in layers.
+func (s *Sprite) Render(frame int, layers []int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, s.Width, s.Height))
+	if frame < 0 || frame >= len(s.Frames) {
+		return img
+	}
+	include := make([]bool, len(s.Layers))
+	if layers == nil {
+		include = s.visibleLayers()
+	}
+	for _, layer := range layers {
+		if layer >= 0 && layer < len(include) {
+			include[layer] = true
+		}
+	}
+	for layer := range s.Layers {
+		if !include[layer] {
+			continue
+		}
+		for _, cel := range s.Frames[frame].Cels {
+			if cel.Layer != layer || cel.Image == nil {
+				continue
+			}
+			opacity := uint8(int(cel.Opacity) * int(s.Layers[layer].Opacity) / 255)
+			bounds := cel.Image.Bounds().Add(image.Pt(cel.X, cel.Y))
+			draw.DrawMask(img, bounds, cel.Image, image.Point{}, image.NewUniform(color.Alpha{A: opacity}), image.Point{}, draw.Over)
+		}
+	}
+	return img
+}
+
+// visibleLayers reports for each layer whether it and its groups are visible
+func (s *Sprite) visibleLayers() []bool {
+	visible := make([]bool, len(s.Layers))
+	// groups[level] is the visibility of the innermost group at level
+	var groups []bool
+	for i, l := range s.Layers {
+		groups = groups[:min(l.Level, len(groups))]
+		parent := len(groups) == 0 || groups[len(groups)-1]
+		visible[i] = l.Visible && parent
+		if l.Group {
+			groups = append(groups, visible[i])
+		}
+	}
+	return visible
+}
+
+// Children lists the layer and, if it is a group, the visible layers in it
+func (s *Sprite) Children(layer int) []int {
+	children := []int{layer}
+	for i := layer + 1; i < len(s.Layers) && s.Layers[i].Level > s.Layers[layer].Level; i++ {
+		if s.Layers[i].Visible {
+			children = append(children, i)
+		}
+	}
+	return children
+}
@@ -0,0 +1,197 @@
+package aseprite
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeFile(t *testing.T, name string) *Sprite {
+	f, err := os.Open("../assets/img/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s, err := Decode(f, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func decodePNG(t *testing.T, name string) image.Image {
+	f, err := os.Open("../assets/img/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestDecode(t *testing.T) {
+	s := decodeFile(t, "player.aseprite")
+	assert.Equal(t, 16, s.Width)
+	assert.Equal(t, 16, s.Height)
+	assert.Len(t, s.Frames, 2)
+	assert.Equal(t, 500*time.Millisecond, s.Frames[0].Duration)
+	assert.Equal(t, []string{"Layer 1", "Layer 1 Copy"}, []string{s.Layers[0].Name, s.Layers[1].Name})
+}
+
+func TestDecodeSheet(t *testing.T) {
+	f, err := os.Open("../assets/img/player.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s, err := DecodeSheet(decodePNG(t, "player.png"), f, 256)
+	assert.Nil(t, err)
+	assert.Len(t, s.Frames, 2)
+
+	// frames of the sheet and of the file are the same
+	file := decodeFile(t, "player.aseprite")
+	for i := range s.Frames {
+		assert.Equal(t, file.Render(i, nil).Pix, s.Render(i, nil).Pix, "frame %d", i)
+	}
+}
+
+func TestDecodeWrongFormat(t *testing.T) {
+	f, err := os.Open("../assets/img/player.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = Decode(f, 256)
+	assert.Equal(t, ErrFormat, err)
+
+	f, err = os.Open("../assets/img/player.aseprite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = Decode(f, 8)
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestParseDirection(t *testing.T) {
+	for name, want := range map[string]Direction{
+		"up":          Up,
+		"Walk_Down":   Down,
+		"left (idle)": Left,
+		"east":        Right,
+		"Layer 1":     "",
+		"up-left":     "",
+	} {
+		d, _ := ParseDirection(name)
+		assert.Equal(t, want, d, name)
+	}
+}
+
+func TestDirections(t *testing.T) {
+	s := decodeFile(t, "player.aseprite")
+	_, err := s.Directions()
+	assert.Equal(t, ErrNoDirections, err)
+
+	// tags take precedence over layers
+	s.Layers[0].Name = "left"
+	s.Tags = []Tag{{Name: "walk up", From: 0, To: 1}, {Name: "down", From: 1, To: 1}}
+	animations, err := s.Directions()
+	assert.Nil(t, err)
+	assert.Len(t, animations, 2)
+	assert.Len(t, animations[Up].Frames, 2)
+	assert.Equal(t, s.Render(1, nil).Pix, animations[Up].Frames[1].Pix)
+	assert.Equal(t, []time.Duration{500 * time.Millisecond}, animations[Down].Durations)
+
+	// layers are rendered alone, even if hidden
+	s.Tags = nil
+	s.Layers[0].Visible = false
+	animations, err = s.Directions()
+	assert.Nil(t, err)
+	assert.Len(t, animations, 1)
+	assert.Len(t, animations[Left].Frames, 1)
+	assert.Equal(t, s.Render(0, []int{0}).Pix, animations[Left].Frames[0].Pix)
+	assert.NotEqual(t, s.Render(0, nil).Pix, animations[Left].Frames[0].Pix)
+}
+
+// encodeFile writes a 32 bit side x side file with one frame of chunks,
+// the frame size is taken from chunks unless frameSize is set
+func encodeFile(side int, frameSize uint32, chunks ...[]byte) []byte {
+	var body bytes.Buffer
+	for _, c := range chunks {
+		body.Write(c)
+	}
+	if frameSize == 0 {
+		frameSize = uint32(frameHeaderSize + body.Len())
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header{
+		Magic: fileMagic, Frames: 1, Width: uint16(side), Height: uint16(side), Depth: 32,
+	})
+	binary.Write(&buf, binary.LittleEndian, frameHeader{
+		Size: frameSize, Magic: frameMagic, Chunks: uint32(len(chunks)),
+	})
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+// encodeCel returns a compressed transparent side x side cel chunk
+func encodeCel(side int) []byte {
+	var data bytes.Buffer
+	binary.Write(&data, binary.LittleEndian, struct {
+		Layer   uint16
+		X, Y    int16
+		Opacity uint8
+		Kind    uint16
+		_       [7]uint8
+		W, H    uint16
+	}{Opacity: 255, Kind: celCompressed, W: uint16(side), H: uint16(side)})
+	zw := zlib.NewWriter(&data)
+	zw.Write(make([]byte, side*side*4))
+	zw.Close()
+
+	var chunk bytes.Buffer
+	binary.Write(&chunk, binary.LittleEndian, uint32(chunkHeaderSize+data.Len()))
+	binary.Write(&chunk, binary.LittleEndian, uint16(chunkCel))
+	chunk.Write(data.Bytes())
+	return chunk.Bytes()
+}
+
+func TestDecodeChunkSize(t *testing.T) {
+	// a chunk larger than its frame is rejected before it is read
+	chunk := binary.LittleEndian.AppendUint32(nil, 1<<31)
+	chunk = binary.LittleEndian.AppendUint16(chunk, chunkCel)
+	_, err := Decode(bytes.NewReader(encodeFile(16, 0, chunk)), 256)
+	assert.Equal(t, ErrFormat, err)
+
+	// one within its frame but larger than the file fails once the file ends
+	_, err = Decode(bytes.NewReader(encodeFile(16, 1<<31+frameHeaderSize, chunk)), 256)
+	assert.Equal(t, ErrFormat, err)
+
+	s, err := Decode(bytes.NewReader(encodeFile(16, 0, encodeCel(16))), 256)
+	assert.Nil(t, err)
+	assert.Len(t, s.Frames[0].Cels, 1)
+}
+
+func TestDecodeCelArea(t *testing.T) {
+	side := 256
+	cels := make([][]byte, maxCelArea/(side*side))
+	for i := range cels {
+		cels[i] = encodeCel(side)
+	}
+	_, err := Decode(bytes.NewReader(encodeFile(side, 0, cels...)), side)
+	assert.Nil(t, err)
+
+	cels = append(cels, encodeCel(side))
+	_, err = Decode(bytes.NewReader(encodeFile(side, 0, cels...)), side)
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
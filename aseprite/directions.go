@@ -0,0 +1,99 @@
+package aseprite
+
+import (
+	"image"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Direction is a direction a player character faces
+type Direction string
+
+const (
+	Up    Direction = "up"
+	Down  Direction = "down"
+	Left  Direction = "left"
+	Right Direction = "right"
+)
+
+// directionWords are the words naming a direction in tag and layer names
+var directionWords = map[string]Direction{
+	"up":    Up,
+	"back":  Up,
+	"north": Up,
+	"down":  Down,
+	"front": Down,
+	"south": Down,
+	"left":  Left,
+	"west":  Left,
+	"right": Right,
+	"east":  Right,
+}
+
+// ParseDirection finds the direction named by a word of name, such as
+// "walk_up" or "Left (idle)". Names with words of several directions name
+// none.
+func ParseDirection(name string) (Direction, bool) {
+	var found Direction
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, w := range words {
+		d, ok := directionWords[w]
+		if !ok {
+			continue
+		}
+		if found != "" && found != d {
+			return "", false
+		}
+		found = d
+	}
+	return found, found != ""
+}
+
+// Animation is the frames of a direction with their durations
+type Animation struct {
+	Frames    []*image.NRGBA
+	Durations []time.Duration
+}
+
+// Directions renders the frames of each direction named by a tag or, in
+// sprites without direction tags, by a layer. Tagged directions are the
+// frames of their tag with every visible layer, layer directions are the
+// first frame of their layer, or of the visible layers of their group,
+// even if it is hidden.
+func (s *Sprite) Directions() (map[Direction]Animation, error) {
+	animations := map[Direction]Animation{}
+	for _, t := range s.Tags {
+		d, ok := ParseDirection(t.Name)
+		if _, seen := animations[d]; !ok || seen {
+			continue
+		}
+		var a Animation
+		for frame := t.From; frame <= t.To && frame < len(s.Frames); frame++ {
+			a.Frames = append(a.Frames, s.Render(frame, nil))
+			a.Durations = append(a.Durations, s.Frames[frame].Duration)
+		}
+		if len(a.Frames) > 0 {
+			animations[d] = a
+		}
+	}
+	if len(animations) > 0 {
+		return animations, nil
+	}
+	for i, l := range s.Layers {
+		d, ok := ParseDirection(l.Name)
+		if _, seen := animations[d]; !ok || seen || len(s.Frames) == 0 {
+			continue
+		}
+		animations[d] = Animation{
+			Frames:    []*image.NRGBA{s.Render(0, s.Children(i))},
+			Durations: []time.Duration{s.Frames[0].Duration},
+		}
+	}
+	if len(animations) == 0 {
+		return nil, ErrNoDirections
+	}
+	return animations, nil
+}
@@ -0,0 +1,126 @@
+package aseprite
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"time"
+)
+
+type rect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type sheetFrame struct {
+	Frame            rect `json:"frame"`
+	Rotated          bool `json:"rotated"`
+	SpriteSourceSize rect `json:"spriteSourceSize"`
+	SourceSize       struct {
+		W int `json:"w"`
+		H int `json:"h"`
+	} `json:"sourceSize"`
+	Duration int `json:"duration"`
+}
+
+type sheet struct {
+	// Frames is an array or an object keyed by file name, depending on
+	// the export options
+	Frames json.RawMessage `json:"frames"`
+	Meta   struct {
+		FrameTags []struct {
+			Name string `json:"name"`
+			From int    `json:"from"`
+			To   int    `json:"to"`
+		} `json:"frameTags"`
+	} `json:"meta"`
+}
+
+// sheetLayer is the only layer of sprites read from sheets, which merge
+// the layers of every frame
+const sheetLayer = "sheet"
+
+// DecodeSheet reads a sprite from a sheet image and the JSON data Aseprite
+// exports with it. Frames larger than maxSide are rejected.
+func DecodeSheet(img image.Image, data io.Reader, maxSide int) (*Sprite, error) {
+	var sh sheet
+	if err := json.NewDecoder(data).Decode(&sh); err != nil {
+		return nil, ErrFormat
+	}
+	frames, err := sheetFrames(sh.Frames)
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, ErrFormat
+	}
+
+	s := &Sprite{
+		Width:  frames[0].SourceSize.W,
+		Height: frames[0].SourceSize.H,
+		Layers: []Layer{{Name: sheetLayer, Visible: true, Opacity: 255}},
+	}
+	if s.Width <= 0 || s.Height <= 0 {
+		return nil, ErrFormat
+	}
+	if s.Width > maxSide || s.Height > maxSide {
+		return nil, fmt.Errorf("%w: %dx%d sprite", ErrUnsupported, s.Width, s.Height)
+	}
+	for _, f := range frames {
+		if f.Rotated {
+			return nil, fmt.Errorf("%w: rotated frames", ErrUnsupported)
+		}
+		r := image.Rect(f.Frame.X, f.Frame.Y, f.Frame.X+f.Frame.W, f.Frame.Y+f.Frame.H).Add(img.Bounds().Min)
+		if !r.In(img.Bounds()) || f.Frame.W > s.Width || f.Frame.H > s.Height {
+			return nil, ErrFormat
+		}
+		cel := image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+		draw.Draw(cel, cel.Bounds(), img, r.Min, draw.Src)
+		s.Frames = append(s.Frames, Frame{
+			Duration: time.Duration(f.Duration) * time.Millisecond,
+			Cels: []Cel{{
+				// trimmed frames are placed where they were in the sprite
+				X:       f.SpriteSourceSize.X,
+				Y:       f.SpriteSourceSize.Y,
+				Opacity: 255,
+				Image:   cel,
+			}},
+		})
+	}
+	for _, t := range sh.Meta.FrameTags {
+		if t.From < 0 || t.To < t.From || t.To >= len(s.Frames) {
+			return nil, ErrFormat
+		}
+		s.Tags = append(s.Tags, Tag{Name: t.Name, From: t.From, To: t.To})
+	}
+	return s, nil
+}
+
+// sheetFrames reads the frames of a sheet in the order they are listed
+func sheetFrames(raw json.RawMessage) ([]sheetFrame, error) {
+	var frames []sheetFrame
+	if err := json.Unmarshal(raw, &frames); err == nil {
+		return frames, nil
+	}
+	// objects are read token by token, maps would lose the frame order
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, ErrFormat
+	}
+	for dec.More() {
+		if _, err := dec.Token(); err != nil {
+			return nil, ErrFormat
+		}
+		var f sheetFrame
+		if err := dec.Decode(&f); err != nil {
+			return nil, ErrFormat
+		}
+		frames = append(frames, f)
+	}
+	return frames, nil
+}
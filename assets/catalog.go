@@ -0,0 +1,96 @@
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/fs"
+	"sort"
+)
+
+// ConfigPath is the path of the sprite catalog config, image paths in it
+// are relative to the same root
+const ConfigPath = "assets/config.json"
+
+var ErrCatalog = errors.New("invalid_sprite_catalog")
+
+type config struct {
+	Images struct {
+		Sprites map[string]Image `json:"sprites"`
+	} `json:"images"`
+}
+
+// Catalog is the built-in sprites of the game. Version changes whenever
+// their content does.
+type Catalog struct {
+	Version string  `json:"version"`
+	Images  []Image `json:"images"`
+}
+
+// LoadCatalog reads the sprites listed at ConfigPath in fsys with the PNG
+// image of each as its Data, sorted by name. Frames must lie within their
+// image and animations must play existing frames.
+func LoadCatalog(fsys fs.FS) (Catalog, error) {
+	var catalog Catalog
+	b, err := fs.ReadFile(fsys, ConfigPath)
+	if err != nil {
+		return catalog, err
+	}
+	var c config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return catalog, fmt.Errorf("%w: %v", ErrCatalog, err)
+	}
+	for key, img := range c.Images.Sprites {
+		if img.Name == "" {
+			img.Name = key
+		}
+		if img.Data, err = fs.ReadFile(fsys, img.Path); err != nil {
+			return catalog, fmt.Errorf("%w: sprite %s: %v", ErrCatalog, img.Name, err)
+		}
+		if err := validateImage(img); err != nil {
+			return catalog, fmt.Errorf("%w: sprite %s: %v", ErrCatalog, img.Name, err)
+		}
+		catalog.Images = append(catalog.Images, img)
+	}
+	sort.Slice(catalog.Images, func(i, j int) bool {
+		return catalog.Images[i].Name < catalog.Images[j].Name
+	})
+
+	content, err := json.Marshal(catalog.Images)
+	if err != nil {
+		return catalog, err
+	}
+	sum := sha256.Sum256(content)
+	catalog.Version = hex.EncodeToString(sum[:8])
+	return catalog, nil
+}
+
+func validateImage(img Image) error {
+	decoded, err := png.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		return err
+	}
+	bounds := decoded.Bounds()
+	if img.Width <= 0 || img.Height <= 0 || len(img.Frames) == 0 {
+		return errors.New("missing size or frames")
+	}
+	for i, f := range img.Frames {
+		r := image.Rect(f.X, f.Y, f.X+f.W, f.Y+f.H).Add(bounds.Min)
+		if f.W <= 0 || f.H <= 0 || !r.In(bounds) {
+			return fmt.Errorf("frame %d outside of %dx%d image", i, bounds.Dx(), bounds.Dy())
+		}
+	}
+	for name, a := range img.Animations {
+		for _, frame := range a.Frames {
+			if frame < 0 || frame >= len(img.Frames) {
+				return fmt.Errorf("animation %s plays missing frame %d", name, frame)
+			}
+		}
+	}
+	return nil
+}
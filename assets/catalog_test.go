@@ -0,0 +1,48 @@
+package assets
+
+import (
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadCatalog(t *testing.T) {
+	catalog, err := LoadCatalog(os.DirFS(".."))
+	assert.Nil(t, err)
+	assert.Len(t, catalog.Images, 2)
+	assert.Equal(t, "amethyst", catalog.Images[0].Name)
+	assert.Equal(t, "player", catalog.Images[1].Name)
+	assert.NotEmpty(t, catalog.Images[1].Data)
+	assert.Len(t, catalog.Images[1].Animations["up"].Frames, 2)
+	assert.NotEmpty(t, catalog.Version)
+
+	// the version depends on the content only
+	again, err := LoadCatalog(os.DirFS(".."))
+	assert.Nil(t, err)
+	assert.Equal(t, catalog.Version, again.Version)
+}
+
+func TestLoadCatalogInvalid(t *testing.T) {
+	png, err := os.ReadFile("img/amethyst.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, sprite := range map[string]string{
+		"frame-outside": `{"path": "a.png", "width": 16, "height": 16, "frames": [{"x": 8, "y": 0, "w": 16, "h": 16}]}`,
+		"missing-frame": `{"path": "a.png", "width": 16, "height": 16, "frames": [{"x": 0, "y": 0, "w": 16, "h": 16}],
+			"animations": {"idle": {"frames": [1]}}}`,
+		"missing-image": `{"path": "b.png", "width": 16, "height": 16, "frames": [{"x": 0, "y": 0, "w": 16, "h": 16}]}`,
+		"not-png":       `{"path": "assets/config.json", "width": 16, "height": 16, "frames": [{"x": 0, "y": 0, "w": 16, "h": 16}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			fsys := fstest.MapFS{
+				ConfigPath: {Data: []byte(`{"images": {"sprites": {"a": ` + sprite + `}}}`)},
+				"a.png":    {Data: png},
+			}
+			_, err := LoadCatalog(fsys)
+			assert.ErrorIs(t, err, ErrCatalog)
+		})
+	}
+}
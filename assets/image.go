@@ -3,12 +3,13 @@ package assets
 type AssetType string
 
 type Image struct {
-	Name   string      `json:"name"`
-	Path   string      `json:"path"`
-	Width  int         `json:"width"`
-	Height int         `json:"height"`
-	Frames []FrameSpec `json:"frames"`
-	Data   []byte      `json:"data"`
+	Name       string               `json:"name"`
+	Path       string               `json:"path"`
+	Width      int                  `json:"width"`
+	Height     int                  `json:"height"`
+	Frames     []FrameSpec          `json:"frames"`
+	Animations map[string]Animation `json:"animations"`
+	Data       []byte               `json:"data"`
 }
 
 type FrameSpec struct {
@@ -17,3 +18,9 @@ type FrameSpec struct {
 	W int `json:"w"`
 	H int `json:"h"`
 }
+
+// Animation plays frames of an image at a speed set for the game client
+type Animation struct {
+	Frames []int   `json:"frames"`
+	Speed  float64 `json:"speed"`
+}
@@ -0,0 +1,47 @@
+// Package cache keeps decoded database reads in memory
+package cache
+
+import "sync/atomic"
+
+// Cache stores values by key. Implementations must be safe for concurrent
+// use and may evict values at any time. A cache shared between servers can
+// implement it by encoding values.
+type Cache[V any] interface {
+	Get(key string) (V, bool)
+	Set(key string, value V)
+	Delete(key string)
+}
+
+// Stats counts the lookups of a Metered cache
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// Metered counts the hits and misses of a cache
+type Metered[V any] struct {
+	Cache[V]
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func NewMetered[V any](c Cache[V]) *Metered[V] {
+	return &Metered[V]{Cache: c}
+}
+
+func (m *Metered[V]) Get(key string) (V, bool) {
+	value, ok := m.Cache.Get(key)
+	if ok {
+		m.hits.Add(1)
+	} else {
+		m.misses.Add(1)
+	}
+	return value, ok
+}
+
+func (m *Metered[V]) Stats() Stats {
+	return Stats{
+		Hits:   m.hits.Load(),
+		Misses: m.misses.Load(),
+	}
+}
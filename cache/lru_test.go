@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRU(t *testing.T) {
+	c := NewLRU[int](2)
+	c.Set("a", 1)
+	c.Set("b", 2)
+
+	// reading a makes b the least recently used
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	c.Set("c", 3)
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+
+	c.Set("a", 4)
+	v, _ = c.Get("a")
+	assert.Equal(t, 4, v)
+	c.Delete("a")
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestMetered(t *testing.T) {
+	c := NewMetered[int](NewLRU[int](1))
+	c.Set("a", 1)
+	c.Get("a")
+	c.Get("a")
+	c.Get("b")
+	assert.Equal(t, Stats{Hits: 2, Misses: 1}, c.Stats())
+}
@@ -4,6 +4,8 @@ import (
 	"flag"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -14,10 +16,25 @@ type Vars struct {
 	ALLOWED_ORIGINS string
 	PORT            string
 	MONGO_URI       string
+	DATABASE        string
+	DATABASE_URL    string
+	AUTO_MIGRATE    string
 	SECRET          string
 	CLIENT_ID       string
 	CLIENT_SECRET   string
-	ADMIN_ID        string
+	// LEGACY_CLIENT_CREDENTIALS set to "true" still accepts the deprecated
+	// CLIENT_ID/CLIENT_SECRET pair
+	LEGACY_CLIENT_CREDENTIALS string
+	ADMIN_ID                  string
+	SMTP_HOST                 string
+	SMTP_PORT                 string
+	SMTP_USERNAME             string
+	SMTP_PASSWORD             string
+	SMTP_FROM                 string
+	MAIL_LOG_PATH             string
+	OIDC_PROVIDERS            string
+	DELETION_GRACE            string
+	CACHE_SIZE                string
 }
 
 // Env() returns Vars struct of environment variables
@@ -31,13 +48,60 @@ func Env() Vars {
 	}
 
 	return Vars{
-		SERVER_URL:      os.Getenv("SERVER_URL"),
-		ALLOWED_ORIGINS: os.Getenv("ALLOWED_ORIGINS"),
-		PORT:            os.Getenv("PORT"),
-		MONGO_URI:       os.Getenv("MONGO_URI"),
-		SECRET:          os.Getenv("SECRET"),
-		CLIENT_ID:       os.Getenv("CLIENT_ID"),
-		CLIENT_SECRET:   os.Getenv("CLIENT_SECRET"),
-		ADMIN_ID:        os.Getenv("ADMIN_ID"),
+		SERVER_URL:                os.Getenv("SERVER_URL"),
+		ALLOWED_ORIGINS:           os.Getenv("ALLOWED_ORIGINS"),
+		PORT:                      os.Getenv("PORT"),
+		MONGO_URI:                 os.Getenv("MONGO_URI"),
+		DATABASE:                  os.Getenv("DATABASE"),
+		DATABASE_URL:              os.Getenv("DATABASE_URL"),
+		AUTO_MIGRATE:              os.Getenv("AUTO_MIGRATE"),
+		SECRET:                    os.Getenv("SECRET"),
+		CLIENT_ID:                 os.Getenv("CLIENT_ID"),
+		CLIENT_SECRET:             os.Getenv("CLIENT_SECRET"),
+		LEGACY_CLIENT_CREDENTIALS: os.Getenv("LEGACY_CLIENT_CREDENTIALS"),
+		ADMIN_ID:                  os.Getenv("ADMIN_ID"),
+		SMTP_HOST:                 os.Getenv("SMTP_HOST"),
+		SMTP_PORT:                 os.Getenv("SMTP_PORT"),
+		SMTP_USERNAME:             os.Getenv("SMTP_USERNAME"),
+		SMTP_PASSWORD:             os.Getenv("SMTP_PASSWORD"),
+		SMTP_FROM:                 os.Getenv("SMTP_FROM"),
+		MAIL_LOG_PATH:             os.Getenv("MAIL_LOG_PATH"),
+		OIDC_PROVIDERS:            os.Getenv("OIDC_PROVIDERS"),
+		DELETION_GRACE:            os.Getenv("DELETION_GRACE"),
+		CACHE_SIZE:                os.Getenv("CACHE_SIZE"),
 	}
 }
+
+// AccountDeletionGracePeriod() parses DELETION_GRACE, a duration such as "168h"
+// during which deleted accounts can be restored. Deletion is immediate if unset.
+func AccountDeletionGracePeriod() time.Duration {
+	period := Env().DELETION_GRACE
+	if period == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil {
+		log.Println("invalid DELETION_GRACE: ", err)
+		return 0
+	}
+	return d
+}
+
+// DefaultCacheSize is the number of maps and of users' characters cached
+// when CACHE_SIZE is unset
+const DefaultCacheSize = 1000
+
+// CacheSize() parses CACHE_SIZE, the number of maps and of users' characters
+// kept in memory. Caching is disabled if it is "0".
+func CacheSize() int {
+	size := Env().CACHE_SIZE
+	if size == "" {
+		return DefaultCacheSize
+	}
+	n, err := strconv.Atoi(size)
+	if err != nil || n < 0 {
+		log.Println("invalid CACHE_SIZE: ", size)
+		return DefaultCacheSize
+	}
+	return n
+}
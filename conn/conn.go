@@ -1,6 +1,7 @@
 package conn
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
@@ -11,13 +12,17 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-	"github.com/snburman/game-server/config"
+	"github.com/snburman/game-server/db"
 )
 
 const (
 	WasmConn      ConnType      = "wasm"
 	ChatConn      ConnType      = "chat"
 	PING_INTERVAL time.Duration = 10 * time.Second
+	// DISPATCH_TIMEOUT bounds the database calls made while routing a dispatch
+	DISPATCH_TIMEOUT time.Duration = 5 * time.Second
+	// WebsocketScope is the API key scope required to authenticate a connection
+	WebsocketScope = "/game/ws"
 )
 
 // Connection pool for game wasm
@@ -75,6 +80,16 @@ func (c *conns) Delete(userID string) {
 	delete(c.pool, userID)
 }
 
+// CloseUserConns closes the game and chat connections of userID
+func CloseUserConns(userID string) {
+	for _, pool := range []*conns{&wasmConnPool, &chatConnPool} {
+		if c, ok := pool.Get(userID); ok {
+			// Close blocks until the listeners are done
+			go c.Close()
+		}
+	}
+}
+
 func NewConn(w http.ResponseWriter, r *http.Request, UserID string) (*Conn, error) {
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
@@ -168,11 +183,15 @@ func (c *Conn) Listen() {
 				if len(headers["CLIENT_SECRET"]) == 0 || len(headers["CLIENT_ID"]) == 0 {
 					log.Println("missing headers")
 					c.Close()
+					break
 				}
-				if headers["CLIENT_SECRET"][0] != config.Env().CLIENT_SECRET ||
-					headers["CLIENT_ID"][0] != config.Env().CLIENT_ID {
+				ctx, cancel := context.WithTimeout(context.Background(), DISPATCH_TIMEOUT)
+				key, err := db.AuthenticateAPIKey(ctx, db.DB, headers["CLIENT_ID"][0], headers["CLIENT_SECRET"][0])
+				cancel()
+				if err != nil || !key.Allows(WebsocketScope) {
 					log.Println("invalid headers")
 					c.Close()
+					break
 				} else {
 					c.authenticated = true
 				}
@@ -1,6 +1,7 @@
 package conn
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 
@@ -109,6 +110,9 @@ func RouteDispatch(d Dispatch[[]byte]) {
 		return
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), DISPATCH_TIMEOUT)
+	defer cancel()
+
 	switch d.Function {
 	case UpdatePlayer:
 		dispatch := ParseDispatch[PlayerUpdate](d)
@@ -181,7 +185,7 @@ func RouteDispatch(d Dispatch[[]byte]) {
 		player := Player(dispatch.Data)
 
 		// get new player characters
-		newPlayerCharacters, err := db.GetPlayerCharactersByUserIDs(db.MongoDB, []string{player.UserID})
+		newPlayerCharacters, err := db.GetPlayerCharactersByUserIDs(ctx, db.DB, []string{player.UserID})
 		if err != nil {
 			log.Println("error getting player characters: ", err)
 			return
@@ -191,7 +195,7 @@ func RouteDispatch(d Dispatch[[]byte]) {
 			if defaultPlayerCharacter == nil {
 				// get default player character
 				char, err := db.GetPlayerAssetByNameUserID(
-					db.MongoDB, "default_character", config.Env().ADMIN_ID,
+					ctx, db.DB, "default_character", config.Env().ADMIN_ID,
 				)
 				if err != nil || char.Data == nil {
 					log.Println("error getting default player character: ", err)
@@ -222,11 +226,15 @@ func RouteDispatch(d Dispatch[[]byte]) {
 		playerPool.Set(player)
 		// update conn with new map ID
 		d.conn.MapID = player.MapID
+		// count visit for map popularity
+		if err := db.RecordMapVisit(ctx, db.DB, player.MapID); err != nil {
+			log.Println("error recording map visit: ", err)
+		}
 
 		// get all player characters in new map
 		allCharacters := []db.PlayerAsset[db.PixelData]{}
 		if len(ids) > 0 {
-			allCharacters, err = db.GetPlayerCharactersByUserIDs(db.MongoDB, ids)
+			allCharacters, err = db.GetPlayerCharactersByUserIDs(ctx, db.DB, ids)
 			if err != nil {
 				log.Println("error getting player characters: ", err)
 				return
@@ -64,17 +64,19 @@ func TestRouteDispatch(t *testing.T) {
 		MapID:  "456",
 	}
 	dispatch := NewDispatch("123", conn, UpdatePlayer, playerUpdate)
+	character := db.CreateMockPlayerAsset("[]")
+	character.UserID = playerUpdate.UserID
 	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
 
 	mt.Run("new-player", func(mt *mtest.T) {
-		db.MongoDB = db.NewMockMongoDriver(mt.Client)
+		db.DB = db.NewMockMongoDriver(mt.Client)
 		// arrange
 		mt.AddMockResponses(
 			mtest.CreateCursorResponse(
 				1,
 				"game.player_images",
 				mtest.FirstBatch,
-				db.CreatePlayerAssetResponseData(db.CreateMockPlayerAsset("[]")),
+				db.CreatePlayerAssetResponseData(character),
 			),
 			db.CreateCursorEnd("game.player_images"),
 		)
@@ -91,13 +93,13 @@ func TestRouteDispatch(t *testing.T) {
 	})
 
 	mt.Run("new-map-id", func(mt *mtest.T) {
-		db.MongoDB = db.NewMockMongoDriver(mt.Client)
+		db.DB = db.NewMockMongoDriver(mt.Client)
 		mt.AddMockResponses(
 			mtest.CreateCursorResponse(
 				1,
 				"game.player_images",
 				mtest.FirstBatch,
-				db.CreatePlayerAssetResponseData(db.CreateMockPlayerAsset("[]")),
+				db.CreatePlayerAssetResponseData(character),
 			),
 			db.CreateCursorEnd("game.player_images"),
 		)
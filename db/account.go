@@ -0,0 +1,161 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/snburman/game-server/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserExport contains everything stored about a user
+type UserExport struct {
+	User       User                                    `json:"user"`
+	Identities []Identity                              `json:"identities"`
+	Assets     []PlayerAsset[PixelData]                `json:"assets"`
+	Maps       []Map[[]PlayerAsset[PixelData]]         `json:"maps"`
+	Revisions  []MapRevision[[]PlayerAsset[PixelData]] `json:"revisions"`
+}
+
+// ExportUserData collects the profile, identities, assets, maps and map
+// revisions of userID, including the maps and assets in the trash.
+// Secrets such as the password hash are omitted.
+func ExportUserData(ctx context.Context, db DatabaseClient, userID string) (UserExport, error) {
+	var export UserExport
+	user, err := GetUserByID(ctx, db, userID)
+	if err != nil {
+		return export, err
+	}
+	user.Password = ""
+	export.User = user
+
+	export.Identities, err = GetIdentitiesByUserID(ctx, db, userID)
+	if err != nil {
+		return export, err
+	}
+	filter := bson.M{"user_id": userID}
+
+	opts := assetDBOptions
+	opts.IncludeDeleted = true
+	var byteAssets []PlayerAsset[[]byte]
+	if err := db.Get(ctx, filter, opts, &byteAssets); err != nil {
+		return export, err
+	}
+	export.Assets = []PlayerAsset[PixelData]{}
+	for _, ba := range byteAssets {
+		asset, err := decodeAsset(ba)
+		if err != nil {
+			return export, errors.ErrImageWrongFormat
+		}
+		export.Assets = append(export.Assets, asset)
+	}
+
+	opts = mapsDBOptions
+	opts.IncludeDeleted = true
+	var byteMaps []Map[[]byte]
+	if err := db.Get(ctx, filter, opts, &byteMaps); err != nil {
+		return export, err
+	}
+	maps, err := bytesToPlayerAssetMaps(ctx, db, byteMaps)
+	if err != nil {
+		return export, err
+	}
+	export.Maps = append([]Map[[]PlayerAsset[PixelData]]{}, maps...)
+
+	export.Revisions, err = getUserMapRevisions(ctx, db, userID)
+	return export, err
+}
+
+// getUserMapRevisions retrieves every revision of the maps of userID
+// with their data, ordered by map and version
+func getUserMapRevisions(ctx context.Context, db DatabaseClient, userID string) ([]MapRevision[[]PlayerAsset[PixelData]], error) {
+	opts := revisionDBOptions
+	opts.Sort = bson.D{{Key: "map_id", Value: 1}, {Key: "version", Value: 1}}
+	var byteRevisions []MapRevision[[]byte]
+	if err := db.Get(ctx, bson.M{"user_id": userID}, opts, &byteRevisions); err != nil {
+		return nil, err
+	}
+	datas := make([][]byte, 0, len(byteRevisions))
+	for _, br := range byteRevisions {
+		datas = append(datas, br.Data)
+	}
+	loaded, err := loadMapData(ctx, db, datas...)
+	if err != nil {
+		return nil, err
+	}
+	revisions := make([]MapRevision[[]PlayerAsset[PixelData]], 0, len(byteRevisions))
+	for i, br := range byteRevisions {
+		revision := revisionWithoutData(br)
+		revision.Data = loaded[i]
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+// ScheduleUserDeletion marks userID for deletion once deleteAfter has passed
+func ScheduleUserDeletion(ctx context.Context, db DatabaseClient, userID string, deleteAfter time.Time) error {
+	user, err := GetUserByID(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	_, err = db.UpdateOne(ctx, user.ID.Hex(), Update{}.Set("delete_after", deleteAfter.UTC()), userDBOptions)
+	return err
+}
+
+// CancelUserDeletion undoes ScheduleUserDeletion
+func CancelUserDeletion(ctx context.Context, db DatabaseClient, userID string) error {
+	user, err := GetUserByID(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	if user.DeleteAfter == nil {
+		return errors.ErrDeletionNotScheduled
+	}
+	_, err = db.UpdateOne(ctx, user.ID.Hex(), Update{}.Set("delete_after", nil), userDBOptions)
+	return err
+}
+
+// GetUsersScheduledForDeletion retrieves users whose grace period ended before t
+func GetUsersScheduledForDeletion(ctx context.Context, db DatabaseClient, t time.Time) ([]User, error) {
+	users := []User{}
+	err := db.Get(ctx, bson.M{"delete_after": bson.M{"$lte": t}}, userDBOptions, &users)
+	return users, err
+}
+
+// DeleteUserData removes userID along with its maps, assets, identities
+// and outstanding tokens and returns the number of users deleted. The
+// profile is removed last so a failed deletion can be retried. The content
+// of assets placed on its maps is left to PurgeTrash, other maps may place it too.
+func DeleteUserData(ctx context.Context, db DatabaseClient, userID string) (count int, err error) {
+	_id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+	// maps in the trash are not cached
+	var maps []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := db.Get(ctx, bson.M{"user_id": userID}, mapsDBOptions, &maps); err != nil {
+		return 0, err
+	}
+	owned := []DatabaseClientOptions{
+		mapsDBOptions,
+		assetDBOptions,
+		identityDBOptions,
+		tokenDBOptions,
+		revisionDBOptions,
+	}
+	for _, opts := range owned {
+		// including what is in the trash
+		opts.IncludeDeleted = true
+		if _, err := db.DeleteMany(ctx, bson.M{"user_id": userID}, opts); err != nil {
+			return 0, err
+		}
+	}
+	for _, m := range maps {
+		invalidateMaps(m.ID.Hex())
+	}
+	invalidateCharacters(userID)
+	return db.Delete(ctx, bson.M{"_id": _id}, userDBOptions)
+}
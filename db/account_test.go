@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snburman/game-server/errors"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestDeleteUserData(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("success", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			// maps to invalidate
+			mtest.CreateCursorResponse(0, mapSource, mtest.FirstBatch),
+			// maps, assets, identities, tokens, revisions
+			SuccessResponse,
+			SuccessResponse,
+			SuccessResponse,
+			SuccessResponse,
+			SuccessResponse,
+			// user
+			SuccessResponse,
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := DeleteUserData(context.Background(), driver, MockID)
+
+		// assert
+		assert.Nil(t, err)
+	})
+
+	mt.Run("failure-invalid-id", func(mt *mtest.T) {
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := DeleteUserData(context.Background(), driver, "invalid")
+
+		// assert
+		assert.NotNil(t, err)
+	})
+}
+
+func TestCancelUserDeletion(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mockUser := createMockUser()
+	mockUser.ID = primitive.NewObjectID()
+
+	mt.Run("success", func(mt *mtest.T) {
+		_mockUser := mockUser
+		deleteAfter := time.Now().Add(time.Hour)
+		_mockUser.DeleteAfter = &deleteAfter
+		// arrange for success
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				1,
+				userSource,
+				mtest.FirstBatch,
+				createUserResponseData(_mockUser),
+			),
+			CreateCursorEnd(userSource),
+			SuccessResponse,
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := CancelUserDeletion(context.Background(), driver, mockUser.ID.Hex())
+
+		// assert
+		assert.Nil(t, err)
+	})
+
+	mt.Run("failure-not-scheduled", func(mt *mtest.T) {
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				1,
+				userSource,
+				mtest.FirstBatch,
+				createUserResponseData(mockUser),
+			),
+			CreateCursorEnd(userSource),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := CancelUserDeletion(context.Background(), driver, mockUser.ID.Hex())
+
+		// assert
+		assert.Equal(t, err, errors.ErrDeletionNotScheduled)
+	})
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"github.com/snburman/game-server/errors"
+)
+
+const (
+	// MaxAssetFrames limits the frames of an asset, including Data
+	MaxAssetFrames = 64
+	// MaxFrameDuration limits how long a frame of an animation is shown,
+	// in milliseconds
+	MaxFrameDuration = 10000
+)
+
+// Animation plays frames of an asset in order, each for its duration in
+// milliseconds. Frame 0 is the Data of the asset, frame i is Frames[i-1].
+type Animation struct {
+	Frames    []int `json:"frames" bson:"frames"`
+	Durations []int `json:"durations" bson:"durations"`
+}
+
+// validateAnimations returns ErrInvalidAnimation unless every animation
+// plays existing frames of an asset with frames frames for a valid duration
+func validateAnimations(frames int, animations map[string]Animation) error {
+	if frames > MaxAssetFrames {
+		return errors.ErrInvalidAnimation
+	}
+	for name, a := range animations {
+		if name == "" || len(a.Frames) == 0 || len(a.Frames) != len(a.Durations) {
+			return errors.ErrInvalidAnimation
+		}
+		for i, frame := range a.Frames {
+			if frame < 0 || frame >= frames {
+				return errors.ErrInvalidAnimation
+			}
+			if a.Durations[i] <= 0 || a.Durations[i] > MaxFrameDuration {
+				return errors.ErrInvalidAnimation
+			}
+		}
+	}
+	return nil
+}
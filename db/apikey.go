@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/snburman/game-server/config"
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var apiKeyDBOptions = DatabaseClientOptions{
+	Database: GameDatabase,
+	Table:    APIKeysCollection,
+}
+
+// AllScopes grants access to every route
+const AllScopes = "*"
+
+// APIKey identifies an application such as the WASM client or a bot.
+// Clients send the key ID as CLIENT_ID and the secret as CLIENT_SECRET.
+type APIKey struct {
+	ID   primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Name string             `json:"name" bson:"name"`
+	// KeyHash is the utils.HashToken hash of the secret
+	KeyHash string `json:"-" bson:"key_hash"`
+	// Scopes are route prefixes the key may access, or AllScopes
+	Scopes []string `json:"scopes" bson:"scopes"`
+	// RateLimit is the number of requests allowed per minute, 0 for unlimited
+	RateLimit int       `json:"rate_limit" bson:"rate_limit"`
+	Revoked   bool      `json:"revoked" bson:"revoked"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	RotatedAt time.Time `json:"rotated_at" bson:"rotated_at"`
+}
+
+// Allows reports whether route is within the key's scopes. A scope
+// matches whole path segments, /game/map does not allow /game/mapper.
+func (k APIKey) Allows(route string) bool {
+	for _, scope := range k.Scopes {
+		if scope == AllScopes || route == scope ||
+			strings.HasPrefix(route, strings.TrimSuffix(scope, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKey stores a new key and returns it with its secret.
+// The secret cannot be retrieved again.
+func CreateAPIKey(ctx context.Context, db DatabaseClient, k APIKey) (APIKey, string, error) {
+	secret, err := generateAPIKeySecret()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	key := APIKey{
+		Name:      k.Name,
+		KeyHash:   utils.HashToken(secret),
+		Scopes:    k.Scopes,
+		RateLimit: k.RateLimit,
+		CreatedAt: time.Now().UTC(),
+		RotatedAt: time.Now().UTC(),
+	}
+	if key.Scopes == nil {
+		key.Scopes = []string{}
+	}
+	id, err := db.CreateOne(ctx, key, apiKeyDBOptions)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	key.ID, err = primitive.ObjectIDFromHex(id)
+	return key, secret, err
+}
+
+// GetAPIKeys retrieves all keys including revoked keys
+func GetAPIKeys(ctx context.Context, db DatabaseClient) ([]APIKey, error) {
+	keys := []APIKey{}
+	err := db.Get(ctx, bson.M{}, apiKeyDBOptions, &keys)
+	return keys, err
+}
+
+func GetAPIKeyByID(ctx context.Context, db DatabaseClient, id string) (APIKey, error) {
+	var key APIKey
+	_id, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return key, errors.ErrAPIKeyNotFound
+	}
+	res, err := db.GetOne(ctx, bson.M{"_id": _id}, apiKeyDBOptions)
+	if err != nil {
+		return key, errors.ErrAPIKeyNotFound
+	}
+	err = utils.UnmarshalBSON(res, &key)
+	return key, err
+}
+
+// RotateAPIKey replaces the secret of a key and returns the new secret
+func RotateAPIKey(ctx context.Context, db DatabaseClient, id string) (string, error) {
+	key, err := GetAPIKeyByID(ctx, db, id)
+	if err != nil {
+		return "", err
+	}
+	if key.Revoked {
+		return "", errors.ErrInvalidAPIKey
+	}
+	secret, err := generateAPIKeySecret()
+	if err != nil {
+		return "", err
+	}
+	update := Update{}.
+		Set("key_hash", utils.HashToken(secret)).
+		Set("rotated_at", time.Now().UTC())
+	_, err = db.UpdateOne(ctx, key.ID.Hex(), update, apiKeyDBOptions)
+	return secret, err
+}
+
+// RevokeAPIKey permanently disables a key
+func RevokeAPIKey(ctx context.Context, db DatabaseClient, id string) error {
+	key, err := GetAPIKeyByID(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	_, err = db.UpdateOne(ctx, key.ID.Hex(), Update{}.Set("revoked", true), apiKeyDBOptions)
+	return err
+}
+
+// legacyScopes are the routes of the game client, the only ones the
+// deprecated CLIENT_ID/CLIENT_SECRET pair may access
+var legacyScopes = []string{
+	"/game/wasm",
+	"/token/refresh",
+	"/user/create",
+	"/user/login",
+	"/user/password",
+}
+
+// AuthenticateAPIKey returns the key matching the client credentials.
+// The deprecated CLIENT_ID/CLIENT_SECRET pair is only accepted, with the
+// game client's scopes, while LEGACY_CLIENT_CREDENTIALS is "true".
+func AuthenticateAPIKey(ctx context.Context, db DatabaseClient, clientID string, clientSecret string) (APIKey, error) {
+	if clientID == "" || clientSecret == "" {
+		return APIKey{}, errors.ErrInvalidAPIKey
+	}
+	env := config.Env()
+	if env.LEGACY_CLIENT_CREDENTIALS == "true" && env.CLIENT_ID != "" && env.CLIENT_SECRET != "" &&
+		subtle.ConstantTimeCompare([]byte(clientID), []byte(env.CLIENT_ID)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(clientSecret), []byte(env.CLIENT_SECRET)) == 1 {
+		log.Println("warning: deprecated CLIENT_ID/CLIENT_SECRET credentials used, create an API key for the client")
+		return APIKey{Name: "legacy", Scopes: legacyScopes}, nil
+	}
+
+	key, err := GetAPIKeyByID(ctx, db, clientID)
+	if err != nil || key.Revoked {
+		return APIKey{}, errors.ErrInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(utils.HashToken(clientSecret))) != 1 {
+		return APIKey{}, errors.ErrInvalidAPIKey
+	}
+	return key, nil
+}
+
+func generateAPIKeySecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
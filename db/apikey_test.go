@@ -0,0 +1,217 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/utils"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var apiKeySource = "game.api_keys"
+
+const mockAPIKeySecret = "secret"
+
+func createMockAPIKey() APIKey {
+	id, _ := primitive.ObjectIDFromHex(MockID)
+	return APIKey{
+		ID:        id,
+		Name:      "wasm",
+		KeyHash:   utils.HashToken(mockAPIKeySecret),
+		Scopes:    []string{"/game/"},
+		RateLimit: 60,
+	}
+}
+
+func createAPIKeyResponseData(k APIKey) bson.D {
+	scopes := bson.A{}
+	for _, s := range k.Scopes {
+		scopes = append(scopes, s)
+	}
+	return bson.D{
+		{Key: "_id", Value: k.ID},
+		{Key: "name", Value: k.Name},
+		{Key: "key_hash", Value: k.KeyHash},
+		{Key: "scopes", Value: scopes},
+		{Key: "rate_limit", Value: k.RateLimit},
+		{Key: "revoked", Value: k.Revoked},
+	}
+}
+
+func TestAPIKeyAllows(t *testing.T) {
+	key := createMockAPIKey()
+	assert.True(t, key.Allows("/game/wasm/map"))
+	assert.False(t, key.Allows("/user/login"))
+
+	key.Scopes = []string{"/game/map"}
+	assert.True(t, key.Allows("/game/map"))
+	assert.True(t, key.Allows("/game/map/123"))
+	assert.False(t, key.Allows("/game/mapper"))
+
+	key.Scopes = []string{"/game/"}
+	assert.True(t, key.Allows("/game/map"))
+	assert.False(t, key.Allows("/gamer"))
+
+	key.Scopes = []string{AllScopes}
+	assert.True(t, key.Allows("/user/login"))
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mockKey := createMockAPIKey()
+
+	mt.Run("success", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(SuccessResponse)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		key, secret, err := CreateAPIKey(context.Background(), driver, mockKey)
+
+		// assert
+		assert.Nil(t, err)
+		assert.NotEmpty(t, secret)
+		assert.Equal(t, utils.HashToken(secret), key.KeyHash)
+		assert.NotEqual(t, primitive.NilObjectID, key.ID)
+	})
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mockKey := createMockAPIKey()
+
+	mt.Run("success", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				apiKeySource,
+				mtest.FirstBatch,
+				createAPIKeyResponseData(mockKey),
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		key, err := AuthenticateAPIKey(context.Background(), driver, mockKey.ID.Hex(), mockAPIKeySecret)
+
+		// assert
+		assert.Nil(t, err)
+		assert.Equal(t, mockKey.Name, key.Name)
+	})
+
+	mt.Run("success-legacy-credentials", func(mt *mtest.T) {
+		mt.Setenv("CLIENT_ID", "legacy_id")
+		mt.Setenv("CLIENT_SECRET", "legacy_secret")
+		mt.Setenv("LEGACY_CLIENT_CREDENTIALS", "true")
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		key, err := AuthenticateAPIKey(context.Background(), driver, "legacy_id", "legacy_secret")
+
+		// assert
+		assert.Nil(t, err)
+		assert.True(t, key.Allows("/user/login"))
+		assert.True(t, key.Allows("/game/wasm/map"))
+		assert.False(t, key.Allows("/admin/keys"))
+	})
+
+	mt.Run("failure-legacy-credentials-disabled", func(mt *mtest.T) {
+		mt.Setenv("CLIENT_ID", "legacy_id")
+		mt.Setenv("CLIENT_SECRET", "legacy_secret")
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				apiKeySource,
+				mtest.FirstBatch,
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := AuthenticateAPIKey(context.Background(), driver, "legacy_id", "legacy_secret")
+
+		// assert
+		assert.Equal(t, errors.ErrInvalidAPIKey, err)
+	})
+
+	mt.Run("failure-wrong-secret", func(mt *mtest.T) {
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				apiKeySource,
+				mtest.FirstBatch,
+				createAPIKeyResponseData(mockKey),
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := AuthenticateAPIKey(context.Background(), driver, mockKey.ID.Hex(), "wrong_secret")
+
+		// assert
+		assert.Equal(t, err, errors.ErrInvalidAPIKey)
+	})
+
+	mt.Run("failure-revoked", func(mt *mtest.T) {
+		revokedKey := mockKey
+		revokedKey.Revoked = true
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				apiKeySource,
+				mtest.FirstBatch,
+				createAPIKeyResponseData(revokedKey),
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := AuthenticateAPIKey(context.Background(), driver, mockKey.ID.Hex(), mockAPIKeySecret)
+
+		// assert
+		assert.Equal(t, err, errors.ErrInvalidAPIKey)
+	})
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mockKey := createMockAPIKey()
+
+	mt.Run("success", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				apiKeySource,
+				mtest.FirstBatch,
+				createAPIKeyResponseData(mockKey),
+			),
+			SuccessResponse,
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		secret, err := RotateAPIKey(context.Background(), driver, mockKey.ID.Hex())
+
+		// assert
+		assert.Nil(t, err)
+		assert.NotEqual(t, mockAPIKeySecret, secret)
+	})
+
+	mt.Run("failure-not-found", func(mt *mtest.T) {
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := RotateAPIKey(context.Background(), driver, "invalid-id")
+
+		// assert
+		assert.Equal(t, err, errors.ErrAPIKeyNotFound)
+	})
+}
@@ -2,14 +2,18 @@ package db
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"log"
+	"slices"
+	"time"
 
+	"github.com/snburman/game-server/assets"
 	"github.com/snburman/game-server/config"
 	"github.com/snburman/game-server/errors"
 	"github.com/snburman/game-server/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var assetDBOptions DatabaseClientOptions = DatabaseClientOptions{
@@ -17,6 +21,11 @@ var assetDBOptions DatabaseClientOptions = DatabaseClientOptions{
 	Table:    PlayerImagesCollection,
 }
 
+var imageDBOptions DatabaseClientOptions = DatabaseClientOptions{
+	Database: GameDatabase,
+	Table:    ImagesCollection,
+}
+
 type Pixel struct {
 	X     int    `json:"x"`
 	Y     int    `json:"y"`
@@ -48,33 +57,92 @@ type PlayerAsset[T any] struct {
 	AssetType AssetType          `json:"asset_type" bson:"asset_type"`
 	X         int                `json:"x" bson:"x"`
 	Y         int                `json:"y" bson:"y"`
-	Width     int                `json:"width" bson:"width"`
-	Height    int                `json:"height" bson:"height"`
-	Data      T                  `json:"data" bson:"data"`
+	// Layer orders the assets of a map, higher layers are drawn on top
+	Layer     int       `json:"layer,omitempty" bson:"layer,omitempty"`
+	Width     int       `json:"width" bson:"width"`
+	Height    int       `json:"height" bson:"height"`
+	Version   int       `json:"version" bson:"version"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+	// DeletedAt is set while the asset is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at"`
+	Data      T          `json:"data" bson:"data"`
+	// Frames follow Data in animated assets, Animations play them by name
+	Frames     []T                  `json:"frames,omitempty" bson:"frames,omitempty"`
+	Animations map[string]Animation `json:"animations,omitempty" bson:"animations,omitempty"`
+}
+
+// GetImages retrieves all shared game images
+func GetImages(ctx context.Context, db DatabaseClient) ([]assets.Image, error) {
+	imgs := []assets.Image{}
+	err := db.Get(ctx, bson.M{}, imageDBOptions, &imgs)
+	return imgs, err
 }
 
-// CreatePlayerAsset will return an error if 'p' already exists.
+// listedImage keeps the _id of an image for the listing cursor
+type listedImage struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	assets.Image `bson:",inline"`
+}
+
+// ListImages gets a page of shared game images sorted by SortCreated or SortName
+func ListImages(ctx context.Context, db DatabaseClient, l ListOptions) (Page[assets.Image], error) {
+	page := Page[assets.Image]{Items: []assets.Image{}}
+	imgs, next, err := list[listedImage](ctx, db, bson.M{}, imageDBOptions, l, SortCreated, SortName)
+	if err != nil {
+		return page, err
+	}
+	for _, img := range imgs {
+		page.Items = append(page.Items, img.Image)
+	}
+	page.NextCursor = next
+	return page, nil
+}
+
+// CreatePlayerAsset will return an error if 'p' already exists, or
+// errors.FieldErrors if fields of 'p' are invalid, see validateAsset.
 // Stores PlayerAsset[[]byte] in db
-func CreatePlayerAsset(db *MongoDriver, p PlayerAsset[string]) (primitive.ObjectID, error) {
+func CreatePlayerAsset(ctx context.Context, db DatabaseClient, p PlayerAsset[string]) (primitive.ObjectID, error) {
+	fields := errors.FieldErrors{}
+	if p.Name == "" {
+		fields["name"] = errors.ErrFieldRequired
+	}
+	asset := PlayerAsset[PixelData]{
+		UserID:     p.UserID,
+		Name:       p.Name,
+		AssetType:  p.AssetType,
+		X:          p.X,
+		Y:          p.Y,
+		Width:      p.Width,
+		Height:     p.Height,
+		Version:    1,
+		Data:       parsePixelData(fields, "data", p.Data),
+		Frames:     parseFrames(fields, p.Frames),
+		Animations: p.Animations,
+	}
+	validateAsset(fields, asset)
+	if err := fieldsError(fields); err != nil {
+		return primitive.NilObjectID, err
+	}
+
 	// check if asset with same name and userID exists
-	_, err := db.GetOne(bson.M{"user_id": p.UserID, "name": p.Name}, assetDBOptions)
+	_, err := db.GetOne(ctx, bson.M{"user_id": p.UserID, "name": p.Name}, assetDBOptions)
 	if err == nil {
 		return primitive.NilObjectID, errors.ErrImageExists
 	}
 
 	// convert to byte asset
-	byteAsset := PlayerAsset[[]byte]{
-		UserID:    p.UserID,
-		Name:      p.Name,
-		AssetType: p.AssetType,
-		X:         p.X,
-		Y:         p.Y,
-		Width:     p.Width,
-		Height:    p.Height,
-		Data:      []byte(p.Data),
-	}
-
-	id, err := db.CreateOne(byteAsset, assetDBOptions)
+	byteAsset, err := encodeAsset(asset)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	id, err := db.CreateOne(ctx, byteAsset, assetDBOptions)
+	invalidateCharacters(p.UserID)
+	// the unique index catches concurrent creates the lookup above missed
+	if mongo.IsDuplicateKeyError(err) {
+		return primitive.NilObjectID, errors.ErrImageExists
+	}
 	if err != nil {
 		return primitive.NilObjectID, err
 	}
@@ -87,91 +155,141 @@ func CreatePlayerAsset(db *MongoDriver, p PlayerAsset[string]) (primitive.Object
 	return insertedID, nil
 }
 
-func GetPlayerAssetsByUserID(db *MongoDriver, userID string) ([]PlayerAsset[PixelData], error) {
+// CreatePlayerAssets creates every asset of ps in one transaction, see
+// CreatePlayerAsset. No asset is created if one of them fails.
+func CreatePlayerAssets(ctx context.Context, db DatabaseClient, ps []PlayerAsset[string]) ([]primitive.ObjectID, error) {
+	var ids []primitive.ObjectID
+	err := withTransaction(ctx, db, func(tx DatabaseClient) error {
+		ids = make([]primitive.ObjectID, 0, len(ps))
+		for _, p := range ps {
+			id, err := CreatePlayerAsset(ctx, tx, p)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func GetPlayerAssetsByUserID(ctx context.Context, db DatabaseClient, userID string) ([]PlayerAsset[PixelData], error) {
 	assets := []PlayerAsset[PixelData]{}
 
 	// get assets with byte data
 	byteAssets := []PlayerAsset[[]byte]{}
-	err := db.Get(bson.M{"user_id": userID}, assetDBOptions, &byteAssets)
+	err := db.Get(ctx, bson.M{"user_id": userID}, assetDBOptions, &byteAssets)
 	if err != nil {
 		return assets, err
 	}
 
 	for _, img := range byteAssets {
-		_img := new(PlayerAsset[PixelData])
-		// decode the json string
-		err := json.Unmarshal(img.Data, &_img.Data)
+		_img, err := decodeAsset(img)
 		if err != nil {
 			log.Println("error decoding image: ", err)
 			return assets, errors.ErrImageWrongFormat
 		}
-		_img.ID = img.ID
-		_img.UserID = img.UserID
-		_img.Name = img.Name
-		_img.AssetType = img.AssetType
-		_img.Width = img.Width
-		_img.Height = img.Height
-		assets = append(assets, *_img)
+		assets = append(assets, _img)
 	}
 
 	return assets, nil
 }
 
-func GetPlayerCharactersByUserIDs(db *MongoDriver, userIDs []string) ([]PlayerAsset[PixelData], error) {
-	filter := bson.A{
-		bson.D{{
-			Key: "$match", Value: bson.D{
-				{Key: "user_id", Value: bson.D{{Key: "$in", Value: userIDs}}},
-				{Key: "$or", Value: bson.A{
-					bson.D{{Key: "asset_type", Value: ASSET_PLAYER_UP}},
-					bson.D{{Key: "asset_type", Value: ASSET_PLAYER_DOWN}},
-					bson.D{{Key: "asset_type", Value: ASSET_PLAYER_LEFT}},
-					bson.D{{Key: "asset_type", Value: ASSET_PLAYER_RIGHT}},
-				}},
-			},
-		}},
+// ListPlayerAssets gets a page of player assets, filtered by l.UserID and
+// l.AssetType if set. Assets are sorted by SortCreated, SortUpdated or SortName.
+func ListPlayerAssets(ctx context.Context, db DatabaseClient, l ListOptions) (Page[PlayerAsset[PixelData]], error) {
+	page := Page[PlayerAsset[PixelData]]{Items: []PlayerAsset[PixelData]{}}
+	filter := bson.M{}
+	if l.UserID != "" {
+		filter["user_id"] = l.UserID
 	}
-	assets := []PlayerAsset[PixelData]{}
-	// get assets with byte data
-	byteAssets := []PlayerAsset[[]byte]{}
-	cursor, err := db.Client.
-		Database(assetDBOptions.Database).
-		Collection(assetDBOptions.Table).
-		Aggregate(context.Background(), filter)
-	if err != nil {
-		return assets, err
+	if l.AssetType != "" {
+		filter["asset_type"] = l.AssetType
 	}
-	defer cursor.Close(context.Background())
-	err = cursor.All(context.Background(), &byteAssets)
+	byteAssets, next, err := list[PlayerAsset[[]byte]](ctx, db, filter, assetDBOptions, l,
+		SortCreated, SortUpdated, SortName,
+	)
 	if err != nil {
-		return assets, err
+		return page, err
 	}
+	for _, byteAsset := range byteAssets {
+		asset := assetWithoutData(byteAsset)
+		if !l.Summary {
+			if asset, err = decodeAsset(byteAsset); err != nil {
+				return page, err
+			}
+		}
+		page.Items = append(page.Items, asset)
+	}
+	page.NextCursor = next
+	return page, nil
+}
 
-	// unmarshal data from []byte to PixelData
-	for _, img := range byteAssets {
-		_img := new(PlayerAsset[PixelData])
-		// decode the json string
-		err := json.Unmarshal(img.Data, &_img.Data)
+// GetPlayerCharactersByUserIDs retrieves the character assets of userIDs,
+// from the cache if enabled
+func GetPlayerCharactersByUserIDs(ctx context.Context, db DatabaseClient, userIDs []string) ([]PlayerAsset[PixelData], error) {
+	assets := []PlayerAsset[PixelData]{}
+	byUser := make(map[string][]PlayerAsset[PixelData])
+	var missing []string
+	for _, userID := range userIDs {
+		if _, ok := byUser[userID]; ok || slices.Contains(missing, userID) {
+			continue
+		}
+		if chars, ok := cachedCharacters(userID); ok {
+			byUser[userID] = chars
+		} else {
+			missing = append(missing, userID)
+		}
+	}
+
+	if len(missing) > 0 {
+		generation := characterFills.start()
+		filter := bson.D{
+			{Key: "user_id", Value: bson.D{{Key: "$in", Value: missing}}},
+			{Key: "$or", Value: bson.A{
+				bson.D{{Key: "asset_type", Value: ASSET_PLAYER_UP}},
+				bson.D{{Key: "asset_type", Value: ASSET_PLAYER_DOWN}},
+				bson.D{{Key: "asset_type", Value: ASSET_PLAYER_LEFT}},
+				bson.D{{Key: "asset_type", Value: ASSET_PLAYER_RIGHT}},
+			}},
+		}
+		// get assets with byte data
+		byteAssets := []PlayerAsset[[]byte]{}
+		err := db.Get(ctx, filter, assetDBOptions, &byteAssets)
 		if err != nil {
-			log.Println("error decoding image: ", err)
-			return assets, errors.ErrImageWrongFormat
+			return assets, err
+		}
+
+		// unmarshal data from []byte to PixelData
+		for _, img := range byteAssets {
+			_img, err := decodeAsset(img)
+			if err != nil {
+				log.Println("error decoding image: ", err)
+				return assets, errors.ErrImageWrongFormat
+			}
+			byUser[img.UserID] = append(byUser[img.UserID], _img)
+		}
+		// users without characters are cached too
+		for _, userID := range missing {
+			cacheCharacters(db, userID, slices.Clip(byUser[userID]), generation)
 		}
-		_img.ID = img.ID
-		_img.UserID = img.UserID
-		_img.Name = img.Name
-		_img.AssetType = img.AssetType
-		_img.Width = img.Width
-		_img.Height = img.Height
-		assets = append(assets, *_img)
 	}
 
+	for _, userID := range userIDs {
+		assets = append(assets, byUser[userID]...)
+		// each user is listed once
+		delete(byUser, userID)
+	}
 	return assets, nil
 }
 
 // AppendMapPlayerCharacter gets all character assets for a user and appends them to the map
-func AppendMapPlayerCharacter(db *MongoDriver, userID string, _map Map[[]PlayerAsset[PixelData]]) (Map[[]PlayerAsset[PixelData]], error) {
+func AppendMapPlayerCharacter(ctx context.Context, db DatabaseClient, userID string, _map Map[[]PlayerAsset[PixelData]]) (Map[[]PlayerAsset[PixelData]], error) {
 	// add character assets
-	charAssets, err := GetPlayerCharactersByUserIDs(db, []string{userID})
+	charAssets, err := GetPlayerCharactersByUserIDs(ctx, db, []string{userID})
 	if err != nil {
 		if err == errors.ErrImageWrongFormat {
 			return _map, err
@@ -187,9 +305,9 @@ func AppendMapPlayerCharacter(db *MongoDriver, userID string, _map Map[[]PlayerA
 	return _map, nil
 }
 
-func GetPlayerAssetByNameUserID(db *MongoDriver, name string, userID string) (PlayerAsset[PixelData], error) {
+func GetPlayerAssetByNameUserID(ctx context.Context, db DatabaseClient, name string, userID string) (PlayerAsset[PixelData], error) {
 	asset := PlayerAsset[PixelData]{}
-	res, err := db.GetOne(bson.M{"name": name, "user_id": userID}, assetDBOptions)
+	res, err := db.GetOne(ctx, bson.M{"name": name, "user_id": userID}, assetDBOptions)
 	if err != nil {
 		return asset, errors.ErrImageNotFound
 	}
@@ -199,49 +317,283 @@ func GetPlayerAssetByNameUserID(db *MongoDriver, name string, userID string) (Pl
 		return asset, err
 	}
 
-	// unmarshal data field from []byte to PixelData
-	if err = json.Unmarshal(byteAsset.Data, &asset.Data); err != nil {
-		return asset, errors.ErrImageWrongFormat
+	// decode data and frames from []byte to PixelData
+	if asset, err = decodeAsset(byteAsset); err != nil {
+		return asset, err
 	}
-	// copy properties
-	asset.ID = byteAsset.ID
-	asset.UserID = byteAsset.UserID
-	asset.Name = byteAsset.Name
-	asset.AssetType = byteAsset.AssetType
-	asset.X = byteAsset.X
-	asset.Y = byteAsset.Y
-	asset.Width = byteAsset.Width
-	asset.Height = byteAsset.Height
 
 	return asset, nil
 }
 
-func GetDefaultPlayerCharacter(db *MongoDriver) (PlayerAsset[PixelData], error) {
-	return GetPlayerAssetByNameUserID(
+// GetPlayerAssetByID retrieves the asset with ID
+func GetPlayerAssetByID(ctx context.Context, db DatabaseClient, ID string) (PlayerAsset[PixelData], error) {
+	asset := PlayerAsset[PixelData]{}
+	_id, err := primitive.ObjectIDFromHex(ID)
+	if err != nil {
+		return asset, errors.ErrImageNotFound
+	}
+	res, err := db.GetOne(ctx, bson.M{"_id": _id}, assetDBOptions)
+	if err != nil {
+		return asset, errors.ErrImageNotFound
+	}
+	var byteAsset PlayerAsset[[]byte]
+	if err = utils.UnmarshalBSON(res, &byteAsset); err != nil {
+		return asset, err
+	}
+	if asset, err = decodeAsset(byteAsset); err != nil {
+		return asset, err
+	}
+	return asset, nil
+}
+
+// encodeAsset returns a with its data and frames in the compact format
+func encodeAsset(a PlayerAsset[PixelData]) (PlayerAsset[[]byte], error) {
+	b := PlayerAsset[[]byte]{
+		ID:         a.ID,
+		UserID:     a.UserID,
+		Name:       a.Name,
+		AssetType:  a.AssetType,
+		X:          a.X,
+		Y:          a.Y,
+		Layer:      a.Layer,
+		Width:      a.Width,
+		Height:     a.Height,
+		Version:    a.Version,
+		CreatedAt:  a.CreatedAt,
+		UpdatedAt:  a.UpdatedAt,
+		DeletedAt:  a.DeletedAt,
+		Animations: a.Animations,
+	}
+	if err := validateAnimations(len(a.Frames)+1, a.Animations); err != nil {
+		return b, err
+	}
+	var err error
+	if b.Data, err = encodePixelData(a.Data, a.Width, a.Height); err != nil {
+		return b, err
+	}
+	for _, frame := range a.Frames {
+		data, err := encodePixelData(frame, a.Width, a.Height)
+		if err != nil {
+			return b, err
+		}
+		b.Frames = append(b.Frames, data)
+	}
+	return b, nil
+}
+
+// decodeAsset returns b with its data and frames decoded
+func decodeAsset(b PlayerAsset[[]byte]) (PlayerAsset[PixelData], error) {
+	a := assetWithoutData(b)
+	var err error
+	if a.Data, err = decodePixelData(b.Data); err != nil {
+		return a, err
+	}
+	for _, frame := range b.Frames {
+		data, err := decodePixelData(frame)
+		if err != nil {
+			return a, err
+		}
+		a.Frames = append(a.Frames, data)
+	}
+	return a, nil
+}
+
+// assetWithoutData copies every field of b except Data and Frames
+func assetWithoutData(b PlayerAsset[[]byte]) PlayerAsset[PixelData] {
+	return PlayerAsset[PixelData]{
+		ID:         b.ID,
+		UserID:     b.UserID,
+		Name:       b.Name,
+		AssetType:  b.AssetType,
+		X:          b.X,
+		Y:          b.Y,
+		Layer:      b.Layer,
+		Width:      b.Width,
+		Height:     b.Height,
+		Version:    b.Version,
+		CreatedAt:  b.CreatedAt,
+		UpdatedAt:  b.UpdatedAt,
+		DeletedAt:  b.DeletedAt,
+		Animations: b.Animations,
+	}
+}
+
+func GetDefaultPlayerCharacter(ctx context.Context, db DatabaseClient) (PlayerAsset[PixelData], error) {
+	return GetPlayerAssetByNameUserID(ctx,
 		db, "default_character", config.Env().ADMIN_ID,
 	)
 }
 
-func UpdatePlayerAsset(db *MongoDriver, p PlayerAsset[string]) error {
-	// convert to byte asset
-	byteAsset := PlayerAsset[[]byte]{
-		UserID:    p.UserID,
-		Name:      p.Name,
-		AssetType: p.AssetType,
-		X:         p.X,
-		Y:         p.Y,
-		Width:     p.Width,
-		Height:    p.Height,
-		Data:      []byte(p.Data),
+// PlayerAssetPatch lists the asset fields to change. Nil fields keep their stored value.
+type PlayerAssetPatch struct {
+	AssetType *AssetType `json:"asset_type"`
+	X         *int       `json:"x"`
+	Y         *int       `json:"y"`
+	Width     *int       `json:"width"`
+	Height    *int       `json:"height"`
+	// Data is the JSON encoded pixel data as in PlayerAsset[string]
+	Data *string `json:"data"`
+	// Frames replace every frame after Data, Animations every animation
+	Frames     *[]string             `json:"frames"`
+	Animations *map[string]Animation `json:"animations"`
+	// Version is the version the patch was made against. The update is
+	// rejected with ErrVersionConflict if the asset has changed since.
+	Version *int `json:"version"`
+}
+
+// UpdatePlayerAsset applies the fields set in p to the asset with ID and
+// returns its new version. A stale p.Version returns the current version
+// and ErrVersionConflict. Patches leaving the asset invalid return
+// errors.FieldErrors, see validateAsset.
+func UpdatePlayerAsset(ctx context.Context, db DatabaseClient, ID string, p PlayerAssetPatch) (version int, err error) {
+	_id, err := primitive.ObjectIDFromHex(ID)
+	if err != nil {
+		return 0, err
+	}
+	res, err := db.GetOne(ctx, bson.M{"_id": _id}, assetDBOptions)
+	if err == mongo.ErrNoDocuments {
+		return 0, errors.ErrImageNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	var current PlayerAsset[[]byte]
+	if err := utils.UnmarshalBSON(res, &current); err != nil {
+		return 0, errors.ErrImageWrongFormat
+	}
+	if p.Version != nil && *p.Version != current.Version {
+		return current.Version, errors.ErrVersionConflict
+	}
+
+	var update Update
+	if p.X != nil {
+		update = update.Set("x", *p.X)
+	}
+	if p.Y != nil {
+		update = update.Set("y", *p.Y)
 	}
-	_, err := db.UpdateOne(p.ID.Hex(), byteAsset, assetDBOptions)
-	return err
+	// the asset is validated as it is after the update if its pixels or
+	// what they are checked against change
+	if p.AssetType != nil || p.Width != nil || p.Height != nil ||
+		p.Data != nil || p.Frames != nil || p.Animations != nil {
+		asset, err := patchedAsset(current, p)
+		if err != nil {
+			return current.Version, err
+		}
+		if p.AssetType != nil {
+			update = update.Set("asset_type", asset.AssetType)
+		}
+		if p.Width != nil {
+			update = update.Set("width", asset.Width)
+		}
+		if p.Height != nil {
+			update = update.Set("height", asset.Height)
+		}
+		if p.Data != nil {
+			update = update.Set("data", asset.Data)
+		}
+		if p.Frames != nil {
+			update = update.Set("frames", asset.Frames)
+		}
+		if p.Animations != nil {
+			update = update.Set("animations", asset.Animations)
+		}
+	}
+	if len(update) == 0 {
+		return current.Version, nil
+	}
+	version, err = updateVersion(ctx, db, _id, current.Version, update, assetDBOptions)
+	invalidateCharacters(current.UserID)
+	return version, err
+}
+
+// patchedAsset validates current with the fields set in p applied and
+// returns it in the compact format
+func patchedAsset(current PlayerAsset[[]byte], p PlayerAssetPatch) (PlayerAsset[[]byte], error) {
+	fields := errors.FieldErrors{}
+	asset := assetWithoutData(current)
+	if p.AssetType != nil {
+		asset.AssetType = *p.AssetType
+	}
+	if p.Width != nil {
+		asset.Width = *p.Width
+	}
+	if p.Height != nil {
+		asset.Height = *p.Height
+	}
+	if p.Animations != nil {
+		asset.Animations = *p.Animations
+	}
+	if p.Data != nil {
+		asset.Data = parsePixelData(fields, "data", *p.Data)
+	} else {
+		data, err := decodePixelData(current.Data)
+		if err != nil {
+			fields["data"] = errors.ErrImageWrongFormat
+		}
+		asset.Data = data
+	}
+	if p.Frames != nil {
+		asset.Frames = parseFrames(fields, *p.Frames)
+	} else {
+		for i, frame := range current.Frames {
+			data, err := decodePixelData(frame)
+			if err != nil {
+				fields[fmt.Sprintf("frames[%d]", i)] = errors.ErrImageWrongFormat
+			}
+			asset.Frames = append(asset.Frames, data)
+		}
+	}
+	validateAsset(fields, asset)
+	if err := fieldsError(fields); err != nil {
+		return PlayerAsset[[]byte]{}, err
+	}
+	return encodeAsset(asset)
 }
 
-func DeletePlayerAsset(db *MongoDriver, id string) (count int, err error) {
+// DeletePlayerAsset moves the asset with id to the trash
+func DeletePlayerAsset(ctx context.Context, db DatabaseClient, id string) (count int, err error) {
 	_id, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return 0, err
 	}
-	return db.Delete(bson.M{"_id": _id}, assetDBOptions)
+	res, err := db.GetOne(ctx, bson.M{"_id": _id}, assetDBOptions)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var asset PlayerAsset[[]byte]
+	if err := utils.UnmarshalBSON(res, &asset); err != nil {
+		return 0, errors.ErrImageWrongFormat
+	}
+	count, err = db.UpdateMany(ctx, bson.M{"_id": _id}, bson.M{"deleted_at": time.Now().UTC()}, assetDBOptions)
+	invalidateCharacters(asset.UserID)
+	return count, err
+}
+
+// RestorePlayerAsset moves the asset with ID of userID out of the trash
+func RestorePlayerAsset(ctx context.Context, db DatabaseClient, userID string, ID string) error {
+	_id, err := primitive.ObjectIDFromHex(ID)
+	if err != nil {
+		return errors.ErrImageNotFound
+	}
+	trashOpts := assetDBOptions
+	trashOpts.IncludeDeleted = true
+	count, err := db.UpdateMany(ctx,
+		bson.M{"_id": _id, "user_id": userID, "deleted_at": bson.M{"$ne": nil}},
+		bson.M{"deleted_at": nil},
+		trashOpts,
+	)
+	invalidateCharacters(userID)
+	// an asset with the same name was created in the meantime
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.ErrImageExists
+	}
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.ErrImageNotFound
+	}
+	return nil
 }
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"testing"
 
 	"github.com/snburman/game-server/errors"
@@ -14,7 +15,7 @@ var assetSource = "game.player_images"
 
 func TestCreatePlayerAsset(t *testing.T) {
 	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
-	mockPlayerAsset := CreateMockPlayerAsset("test_data")
+	mockPlayerAsset := CreateMockPlayerAsset("[]")
 	mt.Run("success", func(mt *mtest.T) {
 		// arrange for success
 		mt.AddMockResponses(
@@ -35,7 +36,7 @@ func TestCreatePlayerAsset(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		res, err := CreatePlayerAsset(driver, mockPlayerAsset)
+		res, err := CreatePlayerAsset(context.Background(), driver, mockPlayerAsset)
 
 		// assert
 		assert.Nil(t, err, "expected nil but got error")
@@ -55,7 +56,7 @@ func TestCreatePlayerAsset(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		res, err := CreatePlayerAsset(driver, mockPlayerAsset)
+		res, err := CreatePlayerAsset(context.Background(), driver, mockPlayerAsset)
 
 		// assert
 		assert.NotNil(t, err, "expected error but got nil")
@@ -81,7 +82,7 @@ func TestGetPlayerAssetsByUserID(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		res, err := GetPlayerAssetsByUserID(driver, mockPlayerAsset.UserID)
+		res, err := GetPlayerAssetsByUserID(context.Background(), driver, mockPlayerAsset.UserID)
 
 		// assert nil error
 		assert.Nil(t, err, "expected nil but got error")
@@ -117,7 +118,7 @@ func TestGetPlayerAssetsByUserID(t *testing.T) {
 		)
 		driver := NewMockMongoDriver(mt.Client)
 		// act
-		_, err := GetPlayerAssetsByUserID(driver, mockPlayerAsset.UserID)
+		_, err := GetPlayerAssetsByUserID(context.Background(), driver, mockPlayerAsset.UserID)
 		// assert error
 		assert.NotNil(t, err, "expected error but got nil")
 		assert.Equal(t, err, errors.ErrImageWrongFormat, "expected ErrImageWrongFormat")
@@ -141,7 +142,7 @@ func TestGetPlayerCharactersByUserIDs(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := GetPlayerCharactersByUserIDs(driver, []string{mockPlayerAsset.UserID})
+		_, err := GetPlayerCharactersByUserIDs(context.Background(), driver, []string{mockPlayerAsset.UserID})
 
 		// assert
 		assert.Nil(t, err, "expected nil but got error")
@@ -162,7 +163,7 @@ func TestGetPlayerCharactersByUserIDs(t *testing.T) {
 		)
 		driver := NewMockMongoDriver(mt.Client)
 		// act
-		_, err := GetPlayerCharactersByUserIDs(driver, []string{mockPlayerAsset.UserID})
+		_, err := GetPlayerCharactersByUserIDs(context.Background(), driver, []string{mockPlayerAsset.UserID})
 		// assert error
 		assert.NotNil(t, err, "expected error but got nil")
 		assert.Equal(t, err, errors.ErrImageWrongFormat, "expected ErrImageWrongFormat")
@@ -188,7 +189,7 @@ func TestAppendMapPlayerCharacter(t *testing.T) {
 		}
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := AppendMapPlayerCharacter(driver, mockPlayerAsset.UserID, _map)
+		_, err := AppendMapPlayerCharacter(context.Background(), driver, mockPlayerAsset.UserID, _map)
 
 		// assert
 		assert.Nil(t, err, "expected nil but got error")
@@ -212,7 +213,7 @@ func TestAppendMapPlayerCharacter(t *testing.T) {
 		}
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := AppendMapPlayerCharacter(driver, mockPlayerAsset.UserID, _map)
+		_, err := AppendMapPlayerCharacter(context.Background(), driver, mockPlayerAsset.UserID, _map)
 
 		// assert
 		assert.NotNil(t, err, "expected error but got nil")
@@ -235,7 +236,7 @@ func TestGetPlayerAssetByNameUserID(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := GetPlayerAssetByNameUserID(driver, mockPlayerAsset.Name, mockPlayerAsset.UserID)
+		_, err := GetPlayerAssetByNameUserID(context.Background(), driver, mockPlayerAsset.Name, mockPlayerAsset.UserID)
 		// assert
 		assert.Nil(t, err, "expected nil but got error")
 	})
@@ -252,7 +253,7 @@ func TestGetPlayerAssetByNameUserID(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := GetPlayerAssetByNameUserID(driver, "", "")
+		_, err := GetPlayerAssetByNameUserID(context.Background(), driver, "", "")
 
 		// assert
 		assert.NotNil(t, err, "expected error but got nil")
@@ -273,7 +274,7 @@ func TestGetPlayerAssetByNameUserID(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := GetPlayerAssetByNameUserID(driver, mockPlayerAsset.Name, mockPlayerAsset.UserID)
+		_, err := GetPlayerAssetByNameUserID(context.Background(), driver, mockPlayerAsset.Name, mockPlayerAsset.UserID)
 
 		// assert
 		assert.NotNil(t, err, "expected error but got nil")
@@ -295,7 +296,7 @@ func TestGetDefaultPlayerCharacter(t *testing.T) {
 			),
 		)
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := GetDefaultPlayerCharacter(driver)
+		_, err := GetDefaultPlayerCharacter(context.Background(), driver)
 		if err != nil {
 			t.Fatalf("GetDefaultPlayerCharacter failed: %v", err)
 		}
@@ -304,25 +305,60 @@ func TestGetDefaultPlayerCharacter(t *testing.T) {
 
 func TestUpdatePlayerAsset(t *testing.T) {
 	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
-	mockPlayerAsset := CreateMockPlayerAsset("test_data")
+	mockPlayerAsset := CreateMockPlayerAsset("[]")
+	mockPlayerAsset.ID = primitive.NewObjectID()
+	mockPlayerAsset.Version = 2
+	x := 0
 	mt.Run("success", func(mt *mtest.T) {
 		// arrange for success
 		mt.AddMockResponses(
 			mtest.CreateCursorResponse(
-				1,
+				0,
 				assetSource,
 				mtest.FirstBatch,
 				CreatePlayerAssetResponseData(mockPlayerAsset),
 			),
 			// update operation is successful
-			SuccessResponse,
+			UpdatedResponse,
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		err := UpdatePlayerAsset(driver, mockPlayerAsset)
+		version, err := UpdatePlayerAsset(context.Background(), driver, mockPlayerAsset.ID.Hex(), PlayerAssetPatch{
+			X:       &x,
+			Data:    &mockPlayerAsset.Data,
+			Version: &mockPlayerAsset.Version,
+		})
 
 		// assert
 		assert.Nil(t, err, "expected nil but got error")
+		assert.Equal(t, 3, version)
+	})
+
+	mt.Run("failure-version-conflict", func(mt *mtest.T) {
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				assetSource,
+				mtest.FirstBatch,
+				CreatePlayerAssetResponseData(mockPlayerAsset),
+			),
+			// the asset changed after it was read
+			SuccessResponse,
+			mtest.CreateCursorResponse(
+				0,
+				assetSource,
+				mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: mockPlayerAsset.ID}, {Key: "version", Value: 3}},
+			),
+		)
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		version, err := UpdatePlayerAsset(context.Background(), driver, mockPlayerAsset.ID.Hex(), PlayerAssetPatch{X: &x})
+
+		// assert
+		assert.Equal(t, errors.ErrVersionConflict, err)
+		assert.Equal(t, 3, version)
 	})
 }
 
@@ -333,7 +369,7 @@ func TestDeletePlayerAsset(t *testing.T) {
 		mt.AddMockResponses(
 			// find operation is successful
 			mtest.CreateCursorResponse(
-				1,
+				0,
 				assetSource,
 				mtest.FirstBatch,
 				CreatePlayerAssetResponseData(mockPlayerAsset),
@@ -342,7 +378,7 @@ func TestDeletePlayerAsset(t *testing.T) {
 			SuccessResponse,
 		)
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := DeletePlayerAsset(driver, mockPlayerAsset.ID.Hex())
+		_, err := DeletePlayerAsset(context.Background(), driver, mockPlayerAsset.ID.Hex())
 		if err != nil {
 			t.Fatalf("DeletePlayerAsset failed: %v", err)
 		}
@@ -350,7 +386,7 @@ func TestDeletePlayerAsset(t *testing.T) {
 
 	mt.Run("failure", func(mt *mtest.T) {
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := DeletePlayerAsset(driver, "wrong_id_format")
+		_, err := DeletePlayerAsset(context.Background(), driver, "wrong_id_format")
 		// assert
 		assert.NotNil(t, err, "expected error but got nil")
 	})
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/snburman/game-server/cache"
+)
+
+// caches of decoded maps by ID and of player characters by user ID.
+// Cached values are shared between readers and must not be modified in place.
+// Visits of cached maps are not kept up to date.
+var (
+	mapCache       *cache.Metered[Map[[]PlayerAsset[PixelData]]]
+	characterCache *cache.Metered[[]PlayerAsset[PixelData]]
+	mapFills       = newFillGuard()
+	characterFills = newFillGuard()
+)
+
+// maxInvalidations bounds the invalidations a fillGuard remembers
+const maxInvalidations = 10000
+
+// fillGuard keeps values read before an invalidation of their key out of
+// a cache. Readers take a generation with start before reading the
+// database and fill the cache only if the key was not invalidated since.
+type fillGuard struct {
+	mu         sync.Mutex
+	generation uint64
+	// floor is the generation invalidated was last cleared at, fills
+	// started before it are refused
+	floor       uint64
+	invalidated map[string]uint64
+}
+
+func newFillGuard() *fillGuard {
+	return &fillGuard{invalidated: make(map[string]uint64)}
+}
+
+// start returns the generation of a read about to fill the cache
+func (g *fillGuard) start() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.generation
+}
+
+// fill calls set unless key was invalidated after generation
+func (g *fillGuard) fill(key string, generation uint64, set func()) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if generation < g.floor || g.invalidated[key] > generation {
+		return
+	}
+	set()
+}
+
+// invalidate calls del and refuses the fills of key started before
+func (g *fillGuard) invalidate(key string, del func()) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.generation++
+	g.invalidated[key] = g.generation
+	if len(g.invalidated) > maxInvalidations {
+		clear(g.invalidated)
+		g.floor = g.generation
+	}
+	del()
+}
+
+// inTransaction reports whether db is bound to a transaction, whose reads
+// may see writes that are never committed
+func inTransaction(db DatabaseClient) bool {
+	switch db := db.(type) {
+	case memoryTables:
+		return true
+	case *MongoDriver:
+		return db.session != nil
+	case *SQLDriver:
+		return db.tx != nil
+	}
+	return false
+}
+
+// SetCaches caches maps read by GetMapByID and characters read by
+// GetPlayerCharactersByUserIDs. Nil disables a cache. Only call it before
+// the database is used.
+func SetCaches(maps cache.Cache[Map[[]PlayerAsset[PixelData]]], characters cache.Cache[[]PlayerAsset[PixelData]]) {
+	mapCache, characterCache = nil, nil
+	if maps != nil {
+		mapCache = cache.NewMetered(maps)
+	}
+	if characters != nil {
+		characterCache = cache.NewMetered(characters)
+	}
+}
+
+// CacheStats returns the hits and misses of the enabled caches by name
+func CacheStats() map[string]cache.Stats {
+	stats := map[string]cache.Stats{}
+	if mapCache != nil {
+		stats["maps"] = mapCache.Stats()
+	}
+	if characterCache != nil {
+		stats["characters"] = characterCache.Stats()
+	}
+	return stats
+}
+
+func cachedMap(ID string) (Map[[]PlayerAsset[PixelData]], bool) {
+	if mapCache == nil {
+		return Map[[]PlayerAsset[PixelData]]{}, false
+	}
+	_map, ok := mapCache.Get(ID)
+	// appending to the data of the copy must not write to the cached array
+	_map.Data = slices.Clip(_map.Data)
+	return _map, ok
+}
+
+// cacheMap caches _map read by db since generation, see fillGuard
+func cacheMap(db DatabaseClient, _map Map[[]PlayerAsset[PixelData]], generation uint64) {
+	if mapCache == nil || inTransaction(db) {
+		return
+	}
+	ID := _map.ID.Hex()
+	mapFills.fill(ID, generation, func() { mapCache.Set(ID, _map) })
+}
+
+// invalidateMaps removes the maps with IDs from the cache
+func invalidateMaps(IDs ...string) {
+	if mapCache == nil {
+		return
+	}
+	for _, ID := range IDs {
+		mapFills.invalidate(ID, func() { mapCache.Delete(ID) })
+	}
+}
+
+func cachedCharacters(userID string) ([]PlayerAsset[PixelData], bool) {
+	if characterCache == nil {
+		return nil, false
+	}
+	return characterCache.Get(userID)
+}
+
+// cacheCharacters caches the characters of userID read by db since
+// generation, see fillGuard
+func cacheCharacters(db DatabaseClient, userID string, characters []PlayerAsset[PixelData], generation uint64) {
+	if characterCache == nil || inTransaction(db) {
+		return
+	}
+	characterFills.fill(userID, generation, func() { characterCache.Set(userID, characters) })
+}
+
+// invalidateCharacters removes the characters of userIDs from the cache
+func invalidateCharacters(userIDs ...string) {
+	if characterCache == nil {
+		return
+	}
+	for _, userID := range userIDs {
+		characterFills.invalidate(userID, func() { characterCache.Delete(userID) })
+	}
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/snburman/game-server/assets"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// catalogSource marks the shared game images created by SyncImages
+const catalogSource = "catalog"
+
+// catalogImage is a shared game image with the source that created it
+type catalogImage struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	Source       string             `bson:"source,omitempty"`
+	assets.Image `bson:",inline"`
+}
+
+// SyncImages makes the shared game images created from the catalog match
+// images by name: missing images are created, changed ones updated and
+// images no longer in images deleted. Images from other sources are left
+// alone, a catalog image whose name they already use is skipped.
+func SyncImages(ctx context.Context, db DatabaseClient, images []assets.Image) (changed int, err error) {
+	var stored []catalogImage
+	if err := db.Get(ctx, bson.M{}, imageDBOptions, &stored); err != nil {
+		return 0, err
+	}
+	byName := make(map[string]catalogImage, len(stored))
+	for _, img := range stored {
+		byName[img.Name] = img
+	}
+
+	for _, img := range images {
+		current, ok := byName[img.Name]
+		delete(byName, img.Name)
+		if ok && current.Source != catalogSource {
+			continue
+		}
+		if !ok {
+			created := catalogImage{Source: catalogSource, Image: img}
+			if _, err := db.CreateOne(ctx, created, imageDBOptions); err != nil {
+				return changed, err
+			}
+			changed++
+			continue
+		}
+		if sameImage(current.Image, img) {
+			continue
+		}
+		update := Update{}.
+			Set("path", img.Path).
+			Set("width", img.Width).
+			Set("height", img.Height).
+			Set("frames", img.Frames).
+			Set("animations", img.Animations).
+			Set("data", img.Data)
+		if _, err := db.UpdateOne(ctx, current.ID.Hex(), update, imageDBOptions); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	for _, img := range byName {
+		if img.Source != catalogSource {
+			continue
+		}
+		if _, err := db.Delete(ctx, bson.M{"_id": img.ID}, imageDBOptions); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// sameImage reports whether images a and b have the same content, as
+// their clients see it
+func sameImage(a, b assets.Image) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
@@ -1,17 +1,94 @@
 package db
 
+import (
+	"context"
+	"log"
+
+	"github.com/snburman/game-server/cache"
+	"github.com/snburman/game-server/config"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DB is the database used by the server, set by Connect
+var DB DatabaseClient
+
+// Connect opens DB and applies pending migrations unless AUTO_MIGRATE is
+// "false". It panics if a migration fails.
+func Connect(ctx context.Context) {
+	Open()
+	if config.Env().AUTO_MIGRATE == "false" {
+		return
+	}
+	// code relies on every migration, so the server does not start without them
+	if err := Migrate(ctx, DB); err != nil {
+		log.Panicln("error applying migrations: ", err)
+	}
+}
+
+// Migrate applies pending migrations if db has a versioned schema
+func Migrate(ctx context.Context, db DatabaseClient) error {
+	migrator, ok := db.(Migrator)
+	if !ok {
+		return nil
+	}
+	return migrator.Migrate(ctx)
+}
+
+// Open sets DB to the database selected by DATABASE.
+// "memory" keeps everything in memory, "sqlite" and "postgres" connect to
+// DATABASE_URL and anything else connects to MONGO_URI. Maps and player
+// characters are cached unless CACHE_SIZE is "0".
+func Open() {
+	switch dialect := config.Env().DATABASE; dialect {
+	case "memory":
+		DB = NewMemoryDriver()
+		log.Println("Using in-memory database...")
+	case SQLite, Postgres:
+		driver, err := NewSQLDriver(dialect, config.Env().DATABASE_URL)
+		if err != nil {
+			log.Panicln(err.Error())
+		}
+		DB = driver
+		log.Println("Connected to " + dialect + "...")
+	default:
+		NewMongoDriver()
+		DB = MongoDB
+	}
+	if size := config.CacheSize(); size > 0 {
+		SetCaches(
+			cache.NewLRU[Map[[]PlayerAsset[PixelData]]](size),
+			cache.NewLRU[[]PlayerAsset[PixelData]](size),
+		)
+	}
+}
+
 type DatabaseClientOptions struct {
 	Database string
 	// interchangeable with collection
 	Table string
+	// Sort orders the results of Get, 1 ascending and -1 descending.
+	// Without it results are in insertion order.
+	Sort bson.D
+	// Limit caps the number of results of Get if positive
+	Limit int
+	// Omit lists top level fields left out of the results of Get
+	Omit []string
+	// IncludeDeleted makes operations on soft delete collections
+	// match deleted documents too, see softDeleteCollections
+	IncludeDeleted bool
 }
 
 type DatabaseClient interface {
-	Get(params any, opts DatabaseClientOptions, dest any) error
-	GetOne(params any, opts DatabaseClientOptions) (any, error)
-	CreateOne(document any, opts DatabaseClientOptions) (string, error)
-	UpdateOne(id string, document any, opts DatabaseClientOptions) (any, error)
-	Delete(params any, opts DatabaseClientOptions) (count int, err error)
+	Get(ctx context.Context, params any, opts DatabaseClientOptions, dest any) error
+	GetOne(ctx context.Context, params any, opts DatabaseClientOptions) (any, error)
+	CreateOne(ctx context.Context, document any, opts DatabaseClientOptions) (string, error)
+	// UpdateOne sets the fields listed in update on the document with id
+	UpdateOne(ctx context.Context, id string, update Update, opts DatabaseClientOptions) (any, error)
+	// UpdateMany sets the fields of update on every document matching params
+	UpdateMany(ctx context.Context, params any, update any, opts DatabaseClientOptions) (count int, err error)
+	Delete(ctx context.Context, params any, opts DatabaseClientOptions) (count int, err error)
+	// DeleteMany deletes every document matching params
+	DeleteMany(ctx context.Context, params any, opts DatabaseClientOptions) (count int, err error)
 }
 
 //////////////////////////
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var identityDBOptions = DatabaseClientOptions{
+	Database: GameDatabase,
+	Table:    UserIdentitiesCollection,
+}
+
+// Identity links an external OpenID Connect subject to a user
+type Identity struct {
+	ID       primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID   string             `json:"user_id" bson:"user_id"`
+	Provider string             `json:"provider" bson:"provider"`
+	Subject  string             `json:"subject" bson:"subject"`
+	Email    string             `json:"email" bson:"email"`
+}
+
+// CreateIdentity will return an error if the subject is already linked
+func CreateIdentity(ctx context.Context, db DatabaseClient, i Identity) (primitive.ObjectID, error) {
+	_, err := db.GetOne(ctx, bson.M{"provider": i.Provider, "subject": i.Subject}, identityDBOptions)
+	if err == nil {
+		return primitive.NilObjectID, errors.ErrIdentityLinked
+	}
+	id, err := db.CreateOne(ctx, Identity{
+		UserID:   i.UserID,
+		Provider: i.Provider,
+		Subject:  i.Subject,
+		Email:    i.Email,
+	}, identityDBOptions)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return primitive.ObjectIDFromHex(id)
+}
+
+// GetIdentity retrieves the identity of subject at provider
+func GetIdentity(ctx context.Context, db DatabaseClient, provider string, subject string) (Identity, error) {
+	var identity Identity
+	res, err := db.GetOne(ctx, bson.M{"provider": provider, "subject": subject}, identityDBOptions)
+	if err != nil {
+		return identity, err
+	}
+	err = utils.UnmarshalBSON(res, &identity)
+	return identity, err
+}
+
+// GetIdentitiesByUserID retrieves all identities linked to userID
+func GetIdentitiesByUserID(ctx context.Context, db DatabaseClient, userID string) ([]Identity, error) {
+	identities := []Identity{}
+	err := db.Get(ctx, bson.M{"user_id": userID}, identityDBOptions, &identities)
+	return identities, err
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/snburman/game-server/errors"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var identitySource = "game.user_identities"
+
+func createMockIdentity() Identity {
+	return Identity{
+		UserID:   MockID,
+		Provider: "mock",
+		Subject:  "subject_1",
+		Email:    "player@example.com",
+	}
+}
+
+func createIdentityResponseData(i Identity) bson.D {
+	return bson.D{
+		{Key: "_id", Value: i.ID},
+		{Key: "user_id", Value: i.UserID},
+		{Key: "provider", Value: i.Provider},
+		{Key: "subject", Value: i.Subject},
+		{Key: "email", Value: i.Email},
+	}
+}
+
+func TestCreateIdentity(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mockIdentity := createMockIdentity()
+
+	mt.Run("success", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			// subject is not linked
+			mtest.CreateCursorResponse(
+				0,
+				identitySource,
+				mtest.FirstBatch,
+			),
+			SuccessResponse,
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		id, err := CreateIdentity(context.Background(), driver, mockIdentity)
+
+		// assert
+		assert.Nil(t, err)
+		assert.NotEqual(t, id, primitive.NilObjectID)
+	})
+
+	mt.Run("failure-identity-linked", func(mt *mtest.T) {
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				identitySource,
+				mtest.FirstBatch,
+				createIdentityResponseData(mockIdentity),
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := CreateIdentity(context.Background(), driver, mockIdentity)
+
+		// assert
+		assert.Equal(t, err, errors.ErrIdentityLinked)
+	})
+}
+
+func TestGetIdentity(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mockIdentity := createMockIdentity()
+
+	mt.Run("success", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				identitySource,
+				mtest.FirstBatch,
+				createIdentityResponseData(mockIdentity),
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		identity, err := GetIdentity(context.Background(), driver, mockIdentity.Provider, mockIdentity.Subject)
+
+		// assert
+		assert.Nil(t, err)
+		assert.Equal(t, identity.UserID, mockIdentity.UserID)
+	})
+
+	mt.Run("failure-not-found", func(mt *mtest.T) {
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				identitySource,
+				mtest.FirstBatch,
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := GetIdentity(context.Background(), driver, mockIdentity.Provider, mockIdentity.Subject)
+
+		// assert
+		assert.NotNil(t, err)
+	})
+}
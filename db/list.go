@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"regexp"
+	"slices"
+
+	"github.com/snburman/game-server/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ListSort is the order of a listing
+type ListSort string
+
+const (
+	SortCreated    ListSort = "created"
+	SortUpdated    ListSort = "updated"
+	SortName       ListSort = "name"
+	SortPopularity ListSort = "popularity"
+)
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 200
+)
+
+// sortFields are the document fields ordering each ListSort.
+// Ties are ordered by _id so every document has a unique position.
+var sortFields = map[ListSort]string{
+	// ObjectIDs grow with creation time
+	SortCreated:    "_id",
+	SortUpdated:    "updated_at",
+	SortName:       "name",
+	SortPopularity: "visits",
+}
+
+// ListOptions selects a page of a listing. Filters that do not
+// apply to the listed collection are ignored.
+type ListOptions struct {
+	// Sort defaults to SortCreated
+	Sort       ListSort
+	Descending bool
+	// Cursor continues the listing after the page that returned it
+	Cursor string
+	// Limit is the page size, DefaultListLimit if zero and at most MaxListLimit
+	Limit int
+	// UserID filters by owner
+	UserID     string
+	NamePrefix string
+	AssetType  AssetType
+	// Summary leaves out Data and Frames
+	Summary bool
+}
+
+// Page is one page of a listing. NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// listCursor is the position of the last document of a page
+type listCursor struct {
+	Sort       ListSort           `bson:"s"`
+	Descending bool               `bson:"d"`
+	Value      any                `bson:"v"`
+	ID         primitive.ObjectID `bson:"id"`
+}
+
+// list gets the page of documents matching filter selected by l into
+// items and returns the cursor of the next page. sorts lists the orders
+// the collection supports.
+func list[T any](ctx context.Context, db DatabaseClient, filter bson.M, opts DatabaseClientOptions, l ListOptions, sorts ...ListSort) (items []T, next string, err error) {
+	if l.Sort == "" {
+		l.Sort = SortCreated
+	}
+	if !slices.Contains(sorts, l.Sort) {
+		return nil, "", errors.ErrInvalidSort
+	}
+	limit := l.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	limit = min(limit, MaxListLimit)
+
+	if l.NamePrefix != "" {
+		filter["name"] = bson.M{"$regex": "^" + regexp.QuoteMeta(l.NamePrefix)}
+	}
+	field := sortFields[l.Sort]
+	dir, after := 1, "$gt"
+	if l.Descending {
+		dir, after = -1, "$lt"
+	}
+	if l.Cursor != "" {
+		c, err := decodeListCursor(l.Cursor)
+		if err != nil || c.Sort != l.Sort || c.Descending != l.Descending || !c.valid() {
+			return nil, "", errors.ErrInvalidCursor
+		}
+		rest := bson.M{"_id": bson.M{after: c.ID}}
+		if field != "_id" {
+			rest = bson.M{"$or": bson.A{
+				bson.M{field: bson.M{after: c.Value}},
+				bson.M{field: c.Value, "_id": bson.M{after: c.ID}},
+			}}
+		}
+		filter = bson.M{"$and": bson.A{filter, rest}}
+	}
+
+	opts.Sort = bson.D{{Key: field, Value: dir}}
+	if field != "_id" {
+		opts.Sort = append(opts.Sort, bson.E{Key: "_id", Value: dir})
+	}
+	// one more than requested tells if there is a next page
+	opts.Limit = limit + 1
+	if l.Summary {
+		opts.Omit = []string{"data", "frames"}
+	}
+	if err := db.Get(ctx, filter, opts, &items); err != nil {
+		return nil, "", err
+	}
+	if len(items) <= limit {
+		return items, "", nil
+	}
+	items = items[:limit]
+	next, err = encodeListCursor(l, field, items[limit-1])
+	return items, next, err
+}
+
+// encodeListCursor returns the cursor of the listing l after last
+func encodeListCursor(l ListOptions, field string, last any) (string, error) {
+	doc, err := toDocument(last)
+	if err != nil {
+		return "", err
+	}
+	id, _ := doc["_id"].(primitive.ObjectID)
+	value, _ := lookupField(doc, field)
+	b, err := bson.Marshal(listCursor{
+		Sort:       l.Sort,
+		Descending: l.Descending,
+		Value:      value,
+		ID:         id,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// valid reports whether c.Value has the type of the field sorted by, so
+// a crafted cursor cannot insert operators or documents into the filter
+func (c listCursor) valid() bool {
+	switch c.Sort {
+	case SortCreated:
+		// the position is c.ID alone
+		return true
+	case SortUpdated:
+		_, ok := c.Value.(primitive.DateTime)
+		return ok
+	case SortName:
+		_, ok := c.Value.(string)
+		return ok
+	case SortPopularity:
+		switch c.Value.(type) {
+		case int32, int64:
+			return true
+		}
+	}
+	return false
+}
+
+func decodeListCursor(cursor string) (listCursor, error) {
+	var c listCursor
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, err
+	}
+	return c, bson.Unmarshal(b, &c)
+}
+
+// backfillListFields sets the fields sorted by on documents created before
+// they existed. updated_at becomes the creation time and visits zero.
+func backfillListFields(ctx context.Context, db DatabaseClient) error {
+	for _, opts := range []DatabaseClientOptions{mapsDBOptions, assetDBOptions} {
+		var docs []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		err := db.Get(ctx, bson.M{"updated_at": bson.M{"$exists": false}}, opts, &docs)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			update := Update{}.Set("updated_at", doc.ID.Timestamp())
+			if opts.Table == PlayerMapsCollection {
+				update = update.Set("visits", 0)
+			}
+			if _, err := db.UpdateOne(ctx, doc.ID.Hex(), update, opts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
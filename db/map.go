@@ -1,13 +1,15 @@
 package db
 
 import (
-	"encoding/json"
+	"context"
 	"log"
+	"time"
 
 	"github.com/snburman/game-server/errors"
 	"github.com/snburman/game-server/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var mapsDBOptions = DatabaseClientOptions{
@@ -22,104 +24,201 @@ type Portal struct {
 }
 
 type Map[T any] struct {
-	ID       primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
-	UserID   string             `json:"user_id" bson:"user_id"`
-	UserName string             `json:"username" bson:"username"`
-	Name     string             `json:"name" bson:"name"`
-	Primary  bool               `json:"primary" bson:"primary"`
-	Entrance struct {
-		X int `json:"x" bson:"x"`
-		Y int `json:"y" bson:"y"`
-	} `json:"entrance" bson:"entrance"`
-	Portals []Portal `json:"portals" bson:"portals"`
-	Data    T        `json:"data" bson:"data"`
-}
-
-// CreateMap creates a new map and reassigns primary map if necessary
-func CreateMap(db DatabaseClient, m Map[string]) (primitive.ObjectID, error) {
+	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID    string             `json:"user_id" bson:"user_id"`
+	UserName  string             `json:"username" bson:"username"`
+	Name      string             `json:"name" bson:"name"`
+	Primary   bool               `json:"primary" bson:"primary"`
+	Entrance  Entrance           `json:"entrance" bson:"entrance"`
+	Portals   []Portal           `json:"portals" bson:"portals"`
+	Visits    int                `json:"visits" bson:"visits"`
+	Version   int                `json:"version" bson:"version"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+	// DeletedAt is set while the map is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at"`
+	Data      T          `json:"data" bson:"data"`
+}
+
+// Entrance is where players appear on a map
+type Entrance struct {
+	X int `json:"x" bson:"x"`
+	Y int `json:"y" bson:"y"`
+}
+
+// MapPatch lists the map fields to change. Nil fields keep their stored value.
+type MapPatch struct {
+	// Primary can only be set, the primary map stays primary until another
+	// map replaces it
+	Primary  *bool     `json:"primary"`
+	Entrance *Entrance `json:"entrance"`
+	Portals  *[]Portal `json:"portals"`
+	// Data is the JSON encoded map data as in Map[string]
+	Data *string `json:"data"`
+	// Version is the version the patch was made against. The update is
+	// rejected with ErrVersionConflict if the map has changed since.
+	Version *int `json:"version"`
+	// Message describes the revision saved by the update
+	Message string `json:"message"`
+	// Author is the user saving the revision, the owner of the map if empty
+	Author string `json:"-"`
+}
+
+// CreateMap creates a new map and its first revision. The first map of a user is always primary,
+// creating another primary map unsets the previous one in the same transaction.
+func CreateMap(ctx context.Context, db DatabaseClient, m Map[string]) (primitive.ObjectID, error) {
 	// check if map with the same name and userID exists
-	_, err := db.GetOne(bson.M{"user_id": m.UserID, "name": m.Name}, mapsDBOptions)
+	_, err := db.GetOne(ctx, bson.M{"user_id": m.UserID, "name": m.Name}, mapsDBOptions)
 	if err == nil {
 		return primitive.NilObjectID, errors.ErrMapExists
 	}
 
-	// check if primary map already exists
-	res, err := db.GetOne(bson.M{"user_id": m.UserID, "primary": true}, mapsDBOptions)
-	if err == nil && m.Primary {
-		var bm Map[[]byte]
-		if err = utils.UnmarshalBSON(res, &bm); err != nil {
-			return primitive.NilObjectID, errors.ErrCreatingMap
-		}
-		bm.Primary = false
-		_, err = db.UpdateOne(bm.ID.Hex(), bm, mapsDBOptions)
-		if err != nil {
-			return primitive.NilObjectID, errors.ErrCreatingMap
-		}
-	} else if err != nil && !m.Primary {
-		// if primary map does not exist, set primary to true
-		m.Primary = true
+	data, err := storeMapDataJSON(ctx, db, m.Data)
+	if err != nil {
+		return primitive.NilObjectID, err
 	}
-
 	// convert data to bytes
 	byteMap := Map[[]byte]{
 		UserID:   m.UserID,
 		UserName: m.UserName,
 		Name:     m.Name,
-		Primary:  m.Primary,
 		Entrance: m.Entrance,
 		Portals:  m.Portals,
-		Data:     []byte(m.Data),
+		Version:  1,
+		Data:     data,
 	}
 
-	id, err := db.CreateOne(byteMap, mapsDBOptions)
-	if err != nil {
-		return primitive.NilObjectID, err
+	var insertedID primitive.ObjectID
+	var unset []string
+	err = withTransaction(ctx, db, func(tx DatabaseClient) error {
+		byteMap.Primary = m.Primary
+		if m.Primary {
+			var err error
+			if unset, err = unsetPrimaryMaps(ctx, tx, m.UserID, primitive.NilObjectID); err != nil {
+				return err
+			}
+		} else {
+			_, err := tx.GetOne(ctx, bson.M{"user_id": m.UserID, "primary": true}, mapsDBOptions)
+			if err == mongo.ErrNoDocuments {
+				byteMap.Primary = true
+			} else if err != nil {
+				return err
+			}
+		}
+		id, err := tx.CreateOne(ctx, byteMap, mapsDBOptions)
+		if err != nil {
+			return err
+		}
+		insertedID, err = primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return err
+		}
+		byteMap.ID = insertedID
+		return createMapRevision(ctx, tx, byteMap, m.UserID, "")
+	})
+	invalidateMaps(unset...)
+	// the unique index catches concurrent creates the lookup above missed
+	if mongo.IsDuplicateKeyError(err) {
+		return primitive.NilObjectID, errors.ErrMapExists
 	}
-
-	insertedID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return primitive.NilObjectID, err
+		log.Println("error creating map: ", err)
+		return primitive.NilObjectID, errors.ErrCreatingMap
 	}
 	return insertedID, nil
 }
 
 // GetAllMaps retrieves all maps from every user
-func GetAllMaps(db DatabaseClient) ([]Map[[]PlayerAsset[PixelData]], error) {
+func GetAllMaps(ctx context.Context, db DatabaseClient) ([]Map[[]PlayerAsset[PixelData]], error) {
 	var byteMaps []Map[[]byte]
-	err := db.Get(bson.M{}, mapsDBOptions, &byteMaps)
+	err := db.Get(ctx, bson.M{}, mapsDBOptions, &byteMaps)
 	if err != nil || len(byteMaps) == 0 {
 		return nil, errors.ErrMapNotFound
 	}
-	return bytesToPlayerAssetMaps(byteMaps)
+	return bytesToPlayerAssetMaps(ctx, db, byteMaps)
+}
+
+// ListMaps gets a page of maps of every user, or of l.UserID if set.
+// Maps can be sorted by any ListSort.
+func ListMaps(ctx context.Context, db DatabaseClient, l ListOptions) (Page[Map[[]PlayerAsset[PixelData]]], error) {
+	page := Page[Map[[]PlayerAsset[PixelData]]]{Items: []Map[[]PlayerAsset[PixelData]]{}}
+	filter := bson.M{}
+	if l.UserID != "" {
+		filter["user_id"] = l.UserID
+	}
+	byteMaps, next, err := list[Map[[]byte]](ctx, db, filter, mapsDBOptions, l,
+		SortCreated, SortUpdated, SortName, SortPopularity,
+	)
+	if err != nil {
+		return page, err
+	}
+	var datas [][]byte
+	for _, bm := range byteMaps {
+		page.Items = append(page.Items, mapWithoutData(bm))
+		datas = append(datas, bm.Data)
+	}
+	if !l.Summary {
+		loaded, err := loadMapData(ctx, db, datas...)
+		if err != nil {
+			return page, err
+		}
+		for i := range page.Items {
+			page.Items[i].Data = loaded[i]
+		}
+	}
+	page.NextCursor = next
+	return page, nil
+}
+
+// RecordMapVisit counts a player entering the map with ID
+func RecordMapVisit(ctx context.Context, db DatabaseClient, ID string) error {
+	// visits are not an update of the map, increments leave updated_at alone
+	res, err := db.UpdateOne(ctx, ID, Update{}.Inc("visits", 1), mapsDBOptions)
+	if err != nil {
+		return err
+	}
+	if r, ok := res.(*mongo.UpdateResult); ok && r.MatchedCount == 0 {
+		return errors.ErrMapNotFound
+	}
+	return nil
 }
 
 // GetPrimaryMapByUserID retrieves the primary map by userID
-func GetPrimaryMapByUserID(db DatabaseClient, userID string) (Map[[]PlayerAsset[PixelData]], error) {
+func GetPrimaryMapByUserID(ctx context.Context, db DatabaseClient, userID string) (Map[[]PlayerAsset[PixelData]], error) {
 	_map := *new(Map[[]PlayerAsset[PixelData]])
-	res, err := db.GetOne(bson.M{"user_id": userID, "primary": true}, mapsDBOptions)
+	res, err := db.GetOne(ctx, bson.M{"user_id": userID, "primary": true}, mapsDBOptions)
 	if err != nil {
 		return _map, errors.ErrMapNotFound
 	}
-	return unmarshalMapBSON(res)
+	return unmarshalMapBSON(ctx, db, res)
 }
 
-// GetMapByID retrieves a map by ID
-func GetMapByID(db DatabaseClient, ID string) (Map[[]PlayerAsset[PixelData]], error) {
+// GetMapByID retrieves a map by ID, from the cache if enabled
+func GetMapByID(ctx context.Context, db DatabaseClient, ID string) (Map[[]PlayerAsset[PixelData]], error) {
 	empty := *new(Map[[]PlayerAsset[PixelData]])
 	_id, err := primitive.ObjectIDFromHex(ID)
 	if err != nil {
 		return empty, err
 	}
 
-	res, err := db.GetOne(bson.M{"_id": _id}, mapsDBOptions)
+	if _map, ok := cachedMap(ID); ok {
+		return _map, nil
+	}
+	generation := mapFills.start()
+	res, err := db.GetOne(ctx, bson.M{"_id": _id}, mapsDBOptions)
 	if err != nil {
 		return empty, err
 	}
-	return unmarshalMapBSON(res)
+	_map, err := unmarshalMapBSON(ctx, db, res)
+	if err != nil {
+		return _map, err
+	}
+	cacheMap(db, _map, generation)
+	return _map, nil
 }
 
 // GetMapsByIDs retrieves all maps by slice of ID strings
-func GetMapsByIDs(db DatabaseClient, IDs []string) ([]Map[[]PlayerAsset[PixelData]], error) {
+func GetMapsByIDs(ctx context.Context, db DatabaseClient, IDs []string) ([]Map[[]PlayerAsset[PixelData]], error) {
 	var byteMaps []Map[[]byte]
 	var objectIDs []primitive.ObjectID
 	for _, id := range IDs {
@@ -130,121 +229,333 @@ func GetMapsByIDs(db DatabaseClient, IDs []string) ([]Map[[]PlayerAsset[PixelDat
 		objectIDs = append(objectIDs, _id)
 	}
 
-	err := db.Get(bson.M{"_id": bson.M{"$in": objectIDs}}, mapsDBOptions, &byteMaps)
+	err := db.Get(ctx, bson.M{"_id": bson.M{"$in": objectIDs}}, mapsDBOptions, &byteMaps)
 	if err != nil || len(byteMaps) == 0 {
 		return nil, errors.ErrMapNotFound
 	}
-	return bytesToPlayerAssetMaps(byteMaps)
+	return bytesToPlayerAssetMaps(ctx, db, byteMaps)
 }
 
 // GetMapByNameUserID retrieves a map by name and userID
-func GetMapByNameUserID(db DatabaseClient, name, userID string) (Map[[]PlayerAsset[PixelData]], error) {
+func GetMapByNameUserID(ctx context.Context, db DatabaseClient, name, userID string) (Map[[]PlayerAsset[PixelData]], error) {
 	_map := *new(Map[[]PlayerAsset[PixelData]])
-	res, err := db.GetOne(bson.M{"user_id": userID, "name": name}, mapsDBOptions)
+	res, err := db.GetOne(ctx, bson.M{"user_id": userID, "name": name}, mapsDBOptions)
 	if err != nil {
 		return _map, errors.ErrMapNotFound
 	}
-	return unmarshalMapBSON(res)
+	return unmarshalMapBSON(ctx, db, res)
 }
 
 // GetMapsByUserID retrieves all maps by userID
-func GetMapsByUserID(db DatabaseClient, userID string) ([]Map[[]PlayerAsset[PixelData]], error) {
+func GetMapsByUserID(ctx context.Context, db DatabaseClient, userID string) ([]Map[[]PlayerAsset[PixelData]], error) {
 	var byteMaps []Map[[]byte]
-	err := db.Get(bson.M{"user_id": userID}, mapsDBOptions, &byteMaps)
+	err := db.Get(ctx, bson.M{"user_id": userID}, mapsDBOptions, &byteMaps)
 	if err != nil || len(byteMaps) == 0 {
 		return nil, errors.ErrMapNotFound
 	}
-	return bytesToPlayerAssetMaps(byteMaps)
+	return bytesToPlayerAssetMaps(ctx, db, byteMaps)
 }
 
-// UpdateMap updates a map and reassigns primary map if necessary
-func UpdateMap(db DatabaseClient, m Map[string]) error {
-	if m.Primary {
-		res, err := db.GetOne(bson.M{"user_id": m.UserID, "primary": true}, mapsDBOptions)
-		if err == nil {
-			// if primary map already exists, set it to false
-			var bm Map[[]byte]
-			if err = utils.UnmarshalBSON(res, &bm); err != nil {
-				return errors.ErrUpdatingMap
-			}
-			bm.Primary = false
-			_, err = db.UpdateOne(bm.ID.Hex(), bm, mapsDBOptions)
-			if err != nil {
-				return errors.ErrUpdatingMap
-			}
+// UpdateMap applies the fields set in p to the map with ID, saves the result
+// as a new revision and returns its version. Making it primary unsets the
+// previous primary map in the same transaction, after the version check.
+// A stale p.Version returns the current version and ErrVersionConflict.
+func UpdateMap(ctx context.Context, db DatabaseClient, ID string, p MapPatch) (version int, err error) {
+	_id, err := primitive.ObjectIDFromHex(ID)
+	if err != nil {
+		return 0, err
+	}
+	var update Update
+	if p.Entrance != nil {
+		update = update.Set("entrance", *p.Entrance)
+	}
+	if p.Portals != nil {
+		update = update.Set("portals", *p.Portals)
+	}
+	var data []byte
+	if p.Data != nil {
+		if data, err = storeMapDataJSON(ctx, db, *p.Data); err != nil {
+			return 0, err
 		}
+		update = update.Set("data", data)
 	}
 
-	// convert data to bytes
-	byteMap := Map[[]byte]{
-		UserID:   m.UserID,
-		UserName: m.UserName,
-		Name:     m.Name,
-		Primary:  m.Primary,
-		Entrance: m.Entrance,
-		Portals:  m.Portals,
-		Data:     []byte(m.Data),
+	var unset []string
+	err = withTransaction(ctx, db, func(tx DatabaseClient) error {
+		res, err := tx.GetOne(ctx, bson.M{"_id": _id}, mapsDBOptions)
+		if err != nil {
+			return err
+		}
+		var bm Map[[]byte]
+		if err := utils.UnmarshalBSON(res, &bm); err != nil {
+			return errors.ErrMapWrongFormat
+		}
+		version = bm.Version
+		if p.Version != nil && *p.Version != bm.Version {
+			return errors.ErrVersionConflict
+		}
+		makePrimary := p.Primary != nil && *p.Primary && !bm.Primary
+		if len(update) == 0 && !makePrimary {
+			return nil
+		}
+		version, err = updateVersion(ctx, tx, _id, bm.Version, update, mapsDBOptions)
+		if err != nil {
+			return err
+		}
+		// the previous primary is only unset once the version matched, as
+		// nothing is rolled back without a transaction
+		if makePrimary {
+			if unset, err = unsetPrimaryMaps(ctx, tx, bm.UserID, _id); err != nil {
+				return err
+			}
+			if _, err = tx.UpdateMany(ctx, bson.M{"_id": _id}, bson.M{"primary": true}, mapsDBOptions); err != nil {
+				return err
+			}
+		}
+		if p.Entrance != nil {
+			bm.Entrance = *p.Entrance
+		}
+		if p.Portals != nil {
+			bm.Portals = *p.Portals
+		}
+		if p.Data != nil {
+			bm.Data = data
+		}
+		bm.Version = version
+		author := p.Author
+		if author == "" {
+			author = bm.UserID
+		}
+		return createMapRevision(ctx, tx, bm, author, p.Message)
+	})
+	invalidateMaps(append(unset, ID)...)
+	switch err {
+	case nil, errors.ErrVersionConflict:
+		return version, err
+	case mongo.ErrNoDocuments:
+		return 0, errors.ErrMapNotFound
 	}
+	log.Println("error updating map: ", err)
+	return 0, errors.ErrUpdatingMap
+}
 
-	_, err := db.UpdateOne(m.ID.Hex(), byteMap, mapsDBOptions)
+// DeleteMap moves a map to the trash. If it was the primary map, another
+// map of the user becomes primary in the same transaction.
+func DeleteMap(ctx context.Context, db DatabaseClient, ID string) error {
+	_id, err := primitive.ObjectIDFromHex(ID)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	var promoted string
+	err = withTransaction(ctx, db, func(tx DatabaseClient) error {
+		res, err := tx.GetOne(ctx, bson.M{"_id": _id}, mapsDBOptions)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var bm Map[[]byte]
+		if err := utils.UnmarshalBSON(res, &bm); err != nil {
+			return errors.ErrMapWrongFormat
+		}
+		trashed := Update{}.Set("deleted_at", time.Now().UTC()).Set("primary", false)
+		if _, err := tx.UpdateOne(ctx, ID, trashed, mapsDBOptions); err != nil {
+			return err
+		}
+		if !bm.Primary {
+			return nil
+		}
+		res, err = tx.GetOne(ctx, bson.M{"user_id": bm.UserID}, mapsDBOptions)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var next Map[[]byte]
+		if err := utils.UnmarshalBSON(res, &next); err != nil {
+			return errors.ErrMapWrongFormat
+		}
+		promoted = next.ID.Hex()
+		_, err = tx.UpdateMany(ctx, bson.M{"_id": next.ID}, bson.M{"primary": true}, mapsDBOptions)
+		return err
+	})
+	invalidateMaps(ID, promoted)
+	return err
 }
 
-func DeleteMap(db DatabaseClient, ID string) error {
+// RestoreMap moves the map with ID of userID out of the trash. It becomes
+// primary if the user has no other primary map.
+func RestoreMap(ctx context.Context, db DatabaseClient, userID string, ID string) error {
 	_id, err := primitive.ObjectIDFromHex(ID)
 	if err != nil {
-		return err
+		return errors.ErrMapNotFound
 	}
+	trashOpts := mapsDBOptions
+	trashOpts.IncludeDeleted = true
 
-	_, err = db.Delete(bson.M{"_id": _id}, mapsDBOptions)
-	if err != nil {
+	err = withTransaction(ctx, db, func(tx DatabaseClient) error {
+		_, err := tx.GetOne(ctx, bson.M{"_id": _id, "user_id": userID, "deleted_at": bson.M{"$ne": nil}}, trashOpts)
+		if err == mongo.ErrNoDocuments {
+			return errors.ErrMapNotFound
+		} else if err != nil {
+			return err
+		}
+		update := Update{}.Set("deleted_at", nil)
+		_, err = tx.GetOne(ctx, bson.M{"user_id": userID, "primary": true}, mapsDBOptions)
+		if err == mongo.ErrNoDocuments {
+			update = update.Set("primary", true)
+		} else if err != nil {
+			return err
+		}
+		_, err = tx.UpdateOne(ctx, ID, update, trashOpts)
 		return err
+	})
+	invalidateMaps(ID)
+	// a map with the same name was created in the meantime
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.ErrMapExists
 	}
-	return nil
+	return err
+}
+
+// unsetPrimaryMaps unsets the primary flag on every map of userID except
+// the map with ID except and returns the IDs of the maps changed
+func unsetPrimaryMaps(ctx context.Context, tx DatabaseClient, userID string, except primitive.ObjectID) ([]string, error) {
+	filter := bson.M{"user_id": userID, "primary": true, "_id": bson.M{"$ne": except}}
+	var primaries []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := tx.Get(ctx, filter, mapsDBOptions, &primaries); err != nil {
+		return nil, err
+	}
+	if len(primaries) == 0 {
+		return nil, nil
+	}
+	IDs := make([]string, 0, len(primaries))
+	for _, p := range primaries {
+		IDs = append(IDs, p.ID.Hex())
+	}
+	_, err := tx.UpdateMany(ctx, filter, bson.M{"primary": false}, mapsDBOptions)
+	return IDs, err
 }
 
-// unmarshalMap unmarshals map data from bytes to the correct type
-func unmarshalMapBSON(data any) (Map[[]PlayerAsset[PixelData]], error) {
+// RepairPrimaryMaps ensures every user with maps has exactly one primary map.
+// Users without a primary map get their oldest map as primary, users with
+// several keep the oldest of them. Returns the number of users repaired.
+func RepairPrimaryMaps(ctx context.Context, db DatabaseClient) (repaired int, err error) {
+	opts := mapsDBOptions
+	opts.Omit = []string{"data"}
+	var maps []struct {
+		ID      primitive.ObjectID `bson:"_id"`
+		UserID  string             `bson:"user_id"`
+		Primary bool               `bson:"primary"`
+	}
+	if err := db.Get(ctx, bson.M{}, opts, &maps); err != nil {
+		return 0, err
+	}
+
+	type userMaps struct {
+		first     primitive.ObjectID
+		primary   primitive.ObjectID
+		primaries int
+	}
+	users := make(map[string]*userMaps)
+	var userIDs []string
+	for _, m := range maps {
+		u, ok := users[m.UserID]
+		if !ok {
+			u = &userMaps{}
+			users[m.UserID] = u
+			userIDs = append(userIDs, m.UserID)
+		}
+		// ObjectIDs grow with creation time
+		if u.first.IsZero() || m.ID.Hex() < u.first.Hex() {
+			u.first = m.ID
+		}
+		if m.Primary {
+			u.primaries++
+			if u.primary.IsZero() || m.ID.Hex() < u.primary.Hex() {
+				u.primary = m.ID
+			}
+		}
+	}
+
+	for _, userID := range userIDs {
+		u := users[userID]
+		if u.primaries == 1 {
+			continue
+		}
+		keep := u.primary
+		if u.primaries == 0 {
+			keep = u.first
+		}
+		var unset []string
+		err := withTransaction(ctx, db, func(tx DatabaseClient) error {
+			var err error
+			if unset, err = unsetPrimaryMaps(ctx, tx, userID, keep); err != nil {
+				return err
+			}
+			_, err = tx.UpdateMany(ctx, bson.M{"_id": keep}, bson.M{"primary": true}, mapsDBOptions)
+			return err
+		})
+		invalidateMaps(append(unset, keep.Hex())...)
+		if err != nil {
+			return repaired, err
+		}
+		log.Printf("repaired primary map of user %s: %d primaries, kept %s", userID, u.primaries, keep.Hex())
+		repaired++
+	}
+	return repaired, nil
+}
+
+// unmarshalMapBSON unmarshals a map and loads its assets
+func unmarshalMapBSON(ctx context.Context, db DatabaseClient, data any) (Map[[]PlayerAsset[PixelData]], error) {
 	_map := *new(Map[[]PlayerAsset[PixelData]])
 	var bm Map[[]byte]
 	if err := utils.UnmarshalBSON(data, &bm); err != nil {
 		return _map, errors.ErrMapWrongFormat
 	}
-	err := json.Unmarshal(bm.Data, &_map.Data)
+	maps, err := bytesToPlayerAssetMaps(ctx, db, []Map[[]byte]{bm})
 	if err != nil {
-		return _map, errors.ErrMapWrongFormat
+		return mapWithoutData(bm), err
 	}
-	_map.ID = bm.ID
-	_map.UserID = bm.UserID
-	_map.UserName = bm.UserName
-	_map.Name = bm.Name
-	_map.Primary = bm.Primary
-	_map.Entrance = bm.Entrance
-	_map.Portals = bm.Portals
-
-	return _map, nil
+	return maps[0], nil
 }
 
-func bytesToPlayerAssetMaps(byteMaps []Map[[]byte]) ([]Map[[]PlayerAsset[PixelData]], error) {
-	var maps []Map[[]PlayerAsset[PixelData]]
+func bytesToPlayerAssetMaps(ctx context.Context, db DatabaseClient, byteMaps []Map[[]byte]) ([]Map[[]PlayerAsset[PixelData]], error) {
+	datas := make([][]byte, 0, len(byteMaps))
 	for _, bm := range byteMaps {
-		_map := new(Map[[]PlayerAsset[PixelData]])
-		err := json.Unmarshal(bm.Data, &_map.Data)
-		if err != nil {
-			log.Println("error decoding map images: ", err)
-			return nil, err
-		}
-		_map.ID = bm.ID
-		_map.UserID = bm.UserID
-		_map.UserName = bm.UserName
-		_map.Name = bm.Name
-		_map.Primary = bm.Primary
-		_map.Entrance = bm.Entrance
-		_map.Portals = bm.Portals
-		maps = append(maps, *_map)
+		datas = append(datas, bm.Data)
+	}
+	loaded, err := loadMapData(ctx, db, datas...)
+	if err != nil {
+		log.Println("error decoding map images: ", err)
+		return nil, err
+	}
+	var maps []Map[[]PlayerAsset[PixelData]]
+	for i, bm := range byteMaps {
+		_map := mapWithoutData(bm)
+		_map.Data = loaded[i]
+		maps = append(maps, _map)
 	}
 	return maps, nil
 }
+
+// mapWithoutData copies every field of bm except Data
+func mapWithoutData(bm Map[[]byte]) Map[[]PlayerAsset[PixelData]] {
+	return Map[[]PlayerAsset[PixelData]]{
+		ID:        bm.ID,
+		UserID:    bm.UserID,
+		UserName:  bm.UserName,
+		Name:      bm.Name,
+		Primary:   bm.Primary,
+		Entrance:  bm.Entrance,
+		Portals:   bm.Portals,
+		Visits:    bm.Visits,
+		Version:   bm.Version,
+		CreatedAt: bm.CreatedAt,
+		UpdatedAt: bm.UpdatedAt,
+		DeletedAt: bm.DeletedAt,
+	}
+}
@@ -1,6 +1,8 @@
 package db
 
 import (
+	"context"
+	"encoding/base64"
 	"testing"
 
 	"github.com/snburman/game-server/errors"
@@ -34,38 +36,69 @@ func createMapResponseData[T any](m Map[T]) bson.D {
 			{Key: "y", Value: m.Entrance.Y},
 		}},
 		{Key: "portals", Value: m.Portals},
+		{Key: "version", Value: m.Version},
 		{Key: "data", Value: m.Data},
 	}
 }
 
 func TestCreateMap(t *testing.T) {
 	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
-	mockMap := createMockMap("data")
+	mockMap := createMockMap("[]")
 
 	mt.Run("success", func(mt *mtest.T) {
 		// arrange for success
 		mt.AddMockResponses(
+			// name lookup
 			mtest.CreateCursorResponse(
 				0,
 				mapSource,
 				mtest.FirstBatch,
 			),
+			// no previous primary to unset
+			mtest.CreateCursorResponse(0, mapSource, mtest.FirstBatch),
+			// insert
+			SuccessResponse,
+			// first revision
+			SuccessResponse,
+			// commit
+			SuccessResponse,
+		)
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		id, err := CreateMap(context.Background(), driver, mockMap)
+
+		// assert
+		assert.Nil(t, err)
+		assert.NotEqual(t, id, primitive.NilObjectID, "expected id to be non-zero")
+	})
+
+	mt.Run("success-first-map", func(mt *mtest.T) {
+		_mockMap := mockMap
+		_mockMap.Primary = false
+		// arrange for success
+		mt.AddMockResponses(
+			// name lookup
 			mtest.CreateCursorResponse(
 				0,
 				mapSource,
 				mtest.FirstBatch,
 			),
+			// primary lookup
 			mtest.CreateCursorResponse(
-				1,
+				0,
 				mapSource,
 				mtest.FirstBatch,
-				createMapResponseData(mockMap),
 			),
+			// insert
+			SuccessResponse,
+			// first revision
+			SuccessResponse,
+			// commit
 			SuccessResponse,
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		id, err := CreateMap(driver, mockMap)
+		id, err := CreateMap(context.Background(), driver, _mockMap)
 
 		// assert
 		assert.Nil(t, err)
@@ -84,14 +117,14 @@ func TestCreateMap(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := CreateMap(driver, mockMap)
+		_, err := CreateMap(context.Background(), driver, mockMap)
 
 		// assert
 		assert.NotNil(t, err, "expected error to be nil")
 		assert.Equal(t, err, errors.ErrMapExists)
 	})
 
-	mt.Run("failure-wrong-objectID-format", func(mt *mtest.T) {
+	mt.Run("failure-insert", func(mt *mtest.T) {
 		// arrange for failure
 		mt.AddMockResponses(
 			mtest.CreateCursorResponse(
@@ -99,21 +132,12 @@ func TestCreateMap(t *testing.T) {
 				mapSource,
 				mtest.FirstBatch,
 			),
-			mtest.CreateCursorResponse(
-				1,
-				mapSource,
-				mtest.FirstBatch,
-				createMapResponseData(mockMap),
-			),
-			mtest.CreateCursorResponse(
-				0,
-				mapSource,
-				mtest.NextBatch,
-			),
+			SuccessResponse,
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "insert failed"}),
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := CreateMap(driver, mockMap)
+		_, err := CreateMap(context.Background(), driver, mockMap)
 
 		// assert
 		assert.NotNil(t, err)
@@ -138,7 +162,7 @@ func TestGetAllMaps(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		maps, err := GetAllMaps(driver)
+		maps, err := GetAllMaps(context.Background(), driver)
 
 		// assert
 		assert.Nil(t, err)
@@ -156,7 +180,7 @@ func TestGetAllMaps(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		maps, err := GetAllMaps(driver)
+		maps, err := GetAllMaps(context.Background(), driver)
 
 		// assert
 		assert.Nil(t, maps)
@@ -164,6 +188,59 @@ func TestGetAllMaps(t *testing.T) {
 	})
 }
 
+func TestListMaps(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	first := createMockMap([]byte("[]"))
+	first.ID = primitive.NewObjectID()
+	second := first
+	second.ID = primitive.NewObjectID()
+
+	mt.Run("success-next-page", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				mapSource,
+				mtest.FirstBatch,
+				createMapResponseData(first),
+				createMapResponseData(second),
+			),
+		)
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		page, err := ListMaps(context.Background(), driver, ListOptions{Limit: 1})
+
+		// assert
+		assert.Nil(t, err)
+		assert.Len(t, page.Items, 1)
+		assert.Equal(t, first.ID, page.Items[0].ID)
+		assert.NotEmpty(t, page.NextCursor)
+	})
+
+	mt.Run("failure-invalid-cursor", func(mt *mtest.T) {
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := ListMaps(context.Background(), driver, ListOptions{Cursor: "invalid"})
+
+		// assert
+		assert.Equal(t, errors.ErrInvalidCursor, err)
+	})
+
+	mt.Run("failure-cursor-value-type", func(mt *mtest.T) {
+		// arrange a cursor with an operator instead of a name
+		b, err := bson.Marshal(listCursor{Sort: SortName, Value: bson.M{"$ne": nil}})
+		assert.Nil(t, err)
+		cursor := base64.RawURLEncoding.EncodeToString(b)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err = ListMaps(context.Background(), driver, ListOptions{Sort: SortName, Cursor: cursor})
+
+		// assert
+		assert.Equal(t, errors.ErrInvalidCursor, err)
+	})
+}
+
 func TestGetPrimaryMapByUserID(t *testing.T) {
 	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
 
@@ -180,7 +257,7 @@ func TestGetPrimaryMapByUserID(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		mapData, err := GetPrimaryMapByUserID(driver, mockMap.UserID)
+		mapData, err := GetPrimaryMapByUserID(context.Background(), driver, mockMap.UserID)
 
 		// assert
 		assert.Nil(t, err)
@@ -198,7 +275,7 @@ func TestGetPrimaryMapByUserID(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := GetPrimaryMapByUserID(driver, mockMap.UserID)
+		_, err := GetPrimaryMapByUserID(context.Background(), driver, mockMap.UserID)
 
 		// assert
 		assert.NotNil(t, err)
@@ -222,7 +299,7 @@ func TestGetMapByID(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		mapData, err := GetMapByID(driver, mockMap.ID.Hex())
+		mapData, err := GetMapByID(context.Background(), driver, mockMap.ID.Hex())
 
 		// assert
 		assert.Nil(t, err)
@@ -240,7 +317,7 @@ func TestGetMapByID(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := GetMapByID(driver, mockMap.ID.Hex())
+		_, err := GetMapByID(context.Background(), driver, mockMap.ID.Hex())
 
 		// assert
 		assert.NotNil(t, err)
@@ -257,7 +334,7 @@ func TestGetMapByID(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := GetMapByID(driver, "invalid-id")
+		_, err := GetMapByID(context.Background(), driver, "invalid-id")
 
 		// assert
 		assert.NotNil(t, err)
@@ -281,7 +358,7 @@ func TestGetMapsByIDs(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		mapData, err := GetMapsByIDs(driver, []string{mockMap.ID.Hex()})
+		mapData, err := GetMapsByIDs(context.Background(), driver, []string{mockMap.ID.Hex()})
 		// assert
 		assert.Nil(t, err)
 		assert.NotNil(t, mapData)
@@ -299,7 +376,7 @@ func TestGetMapsByIDs(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := GetMapsByIDs(driver, []string{mockMap.ID.Hex()})
+		_, err := GetMapsByIDs(context.Background(), driver, []string{mockMap.ID.Hex()})
 
 		// assert
 		assert.Equal(t, err, errors.ErrMapNotFound)
@@ -322,7 +399,7 @@ func TestGetMapByNameUserID(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		mapData, err := GetMapByNameUserID(driver, mockMap.Name, mockMap.UserID)
+		mapData, err := GetMapByNameUserID(context.Background(), driver, mockMap.Name, mockMap.UserID)
 
 		// assert
 		assert.Nil(t, err)
@@ -342,7 +419,7 @@ func TestGetMapByNameUserID(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := GetMapByNameUserID(driver, mockMap.Name, mockMap.UserID)
+		_, err := GetMapByNameUserID(context.Background(), driver, mockMap.Name, mockMap.UserID)
 
 		// assert
 		assert.NotNil(t, err)
@@ -367,7 +444,7 @@ func TestGetMapsByUserID(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		mapData, err := GetMapsByUserID(driver, mockMap.UserID)
+		mapData, err := GetMapsByUserID(context.Background(), driver, mockMap.UserID)
 
 		// assert
 		assert.Nil(t, err)
@@ -387,7 +464,7 @@ func TestGetMapsByUserID(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := GetMapsByUserID(driver, mockMap.UserID)
+		_, err := GetMapsByUserID(context.Background(), driver, mockMap.UserID)
 
 		// assert
 		assert.NotNil(t, err)
@@ -398,26 +475,89 @@ func TestGetMapsByUserID(t *testing.T) {
 func TestUpdateMap(t *testing.T) {
 	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
 
-	mockMap := createMockMap("[]")
+	mockMap := createMockMap([]byte("[]"))
+	mockMap.ID = primitive.NewObjectID()
+	mockMap.Primary = false
+	mockMap.Version = 2
+	primary := true
+	portals := []Portal{}
 	mt.Run("success", func(mt *mtest.T) {
 		// arrange for success
 		mt.AddMockResponses(
 			mtest.CreateCursorResponse(
-				1,
+				0,
 				mapSource,
 				mtest.FirstBatch,
 				createMapResponseData(mockMap),
 			),
-			CreateCursorEnd(mapSource),
+			// update
+			UpdatedResponse,
+			// no previous primary to unset
+			mtest.CreateCursorResponse(0, mapSource, mtest.FirstBatch),
+			// primary
+			UpdatedResponse,
+			// revision
 			SuccessResponse,
+			// commit
 			SuccessResponse,
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		err := UpdateMap(driver, mockMap)
+		version, err := UpdateMap(context.Background(), driver, mockMap.ID.Hex(), MapPatch{Primary: &primary, Portals: &portals})
 
 		// assert
 		assert.Nil(t, err)
+		assert.Equal(t, mockMap.Version+1, version)
+	})
+
+	mt.Run("failure-version-conflict", func(mt *mtest.T) {
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				mapSource,
+				mtest.FirstBatch,
+				createMapResponseData(mockMap),
+			),
+		)
+		stale := mockMap.Version - 1
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		version, err := UpdateMap(context.Background(), driver, mockMap.ID.Hex(), MapPatch{Portals: &portals, Version: &stale})
+
+		// assert
+		assert.Equal(t, errors.ErrVersionConflict, err)
+		assert.Equal(t, mockMap.Version, version)
+	})
+
+	mt.Run("failure-not-found", func(mt *mtest.T) {
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				mapSource,
+				mtest.FirstBatch,
+			),
+		)
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := UpdateMap(context.Background(), driver, mockMap.ID.Hex(), MapPatch{Portals: &portals})
+
+		// assert
+		assert.Equal(t, errors.ErrMapNotFound, err)
+	})
+
+	mt.Run("failure", func(mt *mtest.T) {
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "find failed"}),
+		)
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := UpdateMap(context.Background(), driver, mockMap.ID.Hex(), MapPatch{Portals: &portals})
+
+		// assert
+		assert.Equal(t, errors.ErrUpdatingMap, err)
 	})
 }
 
@@ -428,11 +568,26 @@ func TestDeleteMap(t *testing.T) {
 	mt.Run("success", func(mt *mtest.T) {
 		// arrange for success
 		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				mapSource,
+				mtest.FirstBatch,
+				createMapResponseData(mockMap),
+			),
+			// delete
+			SuccessResponse,
+			// no other map to promote
+			mtest.CreateCursorResponse(
+				0,
+				mapSource,
+				mtest.FirstBatch,
+			),
+			// commit
 			SuccessResponse,
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		err := DeleteMap(driver, mockMap.ID.Hex())
+		err := DeleteMap(context.Background(), driver, mockMap.ID.Hex())
 
 		// assert
 		assert.Nil(t, err)
@@ -445,7 +600,7 @@ func TestDeleteMap(t *testing.T) {
 		)
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		err := DeleteMap(driver, "invalid-id")
+		err := DeleteMap(context.Background(), driver, "invalid-id")
 
 		// assert
 		assert.NotNil(t, err)
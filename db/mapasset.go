@@ -0,0 +1,388 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/snburman/game-server/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Maps do not embed their assets. Every asset placed on a map is stored
+// once in map_assets by the hash of its content, its type, size, pixels
+// and animations. Maps list the hash with the placement and the identity
+// of the asset, such as its ID and name:
+//
+//	"PXM2" | flags uint8 | body
+//
+// where the body is the BSON encoded mapRefs, deflated if that is smaller.
+// Map data in the "PXM1" and JSON formats is still read.
+const mapRefsMagic = "PXM2"
+
+var mapAssetDBOptions = DatabaseClientOptions{
+	Database: GameDatabase,
+	Table:    MapAssetsCollection,
+}
+
+// assetContent is what assets with the same hash have in common. Pixel
+// data is in the compact format, which encodes the same pixels the same.
+type assetContent struct {
+	AssetType  AssetType            `json:"asset_type" bson:"asset_type"`
+	Width      int                  `json:"width" bson:"width"`
+	Height     int                  `json:"height" bson:"height"`
+	Data       []byte               `json:"data" bson:"data"`
+	Frames     [][]byte             `json:"frames,omitempty" bson:"frames,omitempty"`
+	Animations map[string]Animation `json:"animations,omitempty" bson:"animations,omitempty"`
+}
+
+// mapAsset is the content of assets placed on maps
+type mapAsset struct {
+	Hash    string       `bson:"hash"`
+	Content assetContent `bson:"content"`
+}
+
+// placement is an asset of a map by the hash of its content
+type placement struct {
+	Hash      string             `bson:"hash"`
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    string             `bson:"user_id,omitempty"`
+	Name      string             `bson:"name,omitempty"`
+	Version   int                `bson:"version,omitempty"`
+	CreatedAt time.Time          `bson:"created_at,omitempty"`
+	UpdatedAt time.Time          `bson:"updated_at,omitempty"`
+	DeletedAt *time.Time         `bson:"deleted_at,omitempty"`
+	X         int                `bson:"x"`
+	Y         int                `bson:"y"`
+	Layer     int                `bson:"layer,omitempty"`
+}
+
+type mapRefs struct {
+	Assets []placement `bson:"assets"`
+}
+
+// contentHash returns the hex encoded sha256 of c
+func contentHash(c assetContent) (string, error) {
+	// map keys of animations are sorted by encoding/json
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// storeMapData validates assets, see validateAsset, and stores them with
+// storeMapAssets. Invalid assets return errors.FieldErrors of the fields
+// data[i].<field>.
+func storeMapData(ctx context.Context, db DatabaseClient, assets []PlayerAsset[PixelData]) ([]byte, error) {
+	fields := errors.FieldErrors{}
+	for i, a := range assets {
+		assetFields := errors.FieldErrors{}
+		validateAsset(assetFields, a)
+		for field, err := range assetFields {
+			fields[fmt.Sprintf("data[%d].%s", i, field)] = err
+		}
+	}
+	if err := fieldsError(fields); err != nil {
+		return nil, err
+	}
+	return storeMapAssets(ctx, db, assets)
+}
+
+// storeMapAssets saves the content of assets missing from map_assets and
+// returns map data placing them
+func storeMapAssets(ctx context.Context, db DatabaseClient, assets []PlayerAsset[PixelData]) ([]byte, error) {
+	refs := mapRefs{Assets: make([]placement, 0, len(assets))}
+	contents := make(map[string]assetContent)
+	var hashes []string
+	for _, a := range assets {
+		b, err := encodeAsset(a)
+		if err != nil {
+			return nil, errors.ErrMapWrongFormat
+		}
+		content := assetContent{
+			AssetType:  b.AssetType,
+			Width:      b.Width,
+			Height:     b.Height,
+			Data:       b.Data,
+			Frames:     b.Frames,
+			Animations: b.Animations,
+		}
+		hash, err := contentHash(content)
+		if err != nil {
+			return nil, errors.ErrMapWrongFormat
+		}
+		refs.Assets = append(refs.Assets, placement{
+			Hash:      hash,
+			ID:        a.ID,
+			UserID:    a.UserID,
+			Name:      a.Name,
+			Version:   a.Version,
+			CreatedAt: a.CreatedAt,
+			UpdatedAt: a.UpdatedAt,
+			DeletedAt: a.DeletedAt,
+			X:         a.X,
+			Y:         a.Y,
+			Layer:     a.Layer,
+		})
+		if _, ok := contents[hash]; !ok {
+			contents[hash] = content
+			hashes = append(hashes, hash)
+		}
+	}
+
+	if len(hashes) > 0 {
+		opts := mapAssetDBOptions
+		opts.Omit = []string{"content"}
+		var stored []mapAsset
+		if err := db.Get(ctx, bson.M{"hash": bson.M{"$in": hashes}}, opts, &stored); err != nil {
+			return nil, err
+		}
+		existing := make([]string, 0, len(stored))
+		for _, s := range stored {
+			delete(contents, s.Hash)
+			existing = append(existing, s.Hash)
+		}
+		// used content is kept by purgeMapAssets
+		if len(existing) > 0 {
+			_, err := db.UpdateMany(ctx, bson.M{"hash": bson.M{"$in": existing}},
+				bson.M{"updated_at": time.Now().UTC()}, mapAssetDBOptions)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, hash := range hashes {
+			content, ok := contents[hash]
+			if !ok {
+				continue
+			}
+			_, err := db.CreateOne(ctx, mapAsset{Hash: hash, Content: content}, mapAssetDBOptions)
+			// stored concurrently by another map
+			if err != nil && !mongo.IsDuplicateKeyError(err) {
+				return nil, err
+			}
+		}
+	}
+
+	body, err := bson.Marshal(refs)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte(mapRefsMagic), 0)
+	return withBody(header, len(mapRefsMagic), body)
+}
+
+// storeMapDataJSON saves map data from the JSON format of clients, see
+// storeMapData
+func storeMapDataJSON(ctx context.Context, db DatabaseClient, s string) ([]byte, error) {
+	var assets []PlayerAsset[PixelData]
+	if err := json.Unmarshal([]byte(s), &assets); err != nil {
+		return nil, errors.ErrMapWrongFormat
+	}
+	return storeMapData(ctx, db, assets)
+}
+
+// decodeMapRefs reads the placements of map data in the "PXM2" format.
+// ok is false for map data in older formats.
+func decodeMapRefs(b []byte) (refs mapRefs, ok bool, err error) {
+	if !bytes.HasPrefix(b, []byte(mapRefsMagic)) {
+		return refs, false, nil
+	}
+	const headerSize = len(mapRefsMagic) + 1
+	if len(b) < headerSize {
+		return refs, true, errors.ErrMapWrongFormat
+	}
+	body, err := readBody(b[len(mapRefsMagic)], b[headerSize:])
+	if err != nil {
+		return refs, true, errors.ErrMapWrongFormat
+	}
+	if err := bson.Unmarshal(body, &refs); err != nil {
+		return refs, true, errors.ErrMapWrongFormat
+	}
+	return refs, true, nil
+}
+
+// loadMapData returns the assets of each map data in datas, reading the
+// content of every asset placed on them in a single query
+func loadMapData(ctx context.Context, db DatabaseClient, datas ...[]byte) ([][]PlayerAsset[PixelData], error) {
+	loaded := make([][]PlayerAsset[PixelData], len(datas))
+	refs := make([]*mapRefs, len(datas))
+	var hashes []string
+	seen := make(map[string]bool)
+	for i, b := range datas {
+		r, ok, err := decodeMapRefs(b)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			if loaded[i], err = decodeMapData(b); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		refs[i] = &r
+		for _, p := range r.Assets {
+			if !seen[p.Hash] {
+				seen[p.Hash] = true
+				hashes = append(hashes, p.Hash)
+			}
+		}
+	}
+
+	contents := make(map[string]PlayerAsset[PixelData], len(hashes))
+	if len(hashes) > 0 {
+		var stored []mapAsset
+		if err := db.Get(ctx, bson.M{"hash": bson.M{"$in": hashes}}, mapAssetDBOptions, &stored); err != nil {
+			return nil, err
+		}
+		for _, s := range stored {
+			content, err := decodeAsset(PlayerAsset[[]byte]{
+				AssetType:  s.Content.AssetType,
+				Width:      s.Content.Width,
+				Height:     s.Content.Height,
+				Data:       s.Content.Data,
+				Frames:     s.Content.Frames,
+				Animations: s.Content.Animations,
+			})
+			if err != nil {
+				return nil, errors.ErrMapWrongFormat
+			}
+			contents[s.Hash] = content
+		}
+	}
+	for i, r := range refs {
+		if r == nil {
+			continue
+		}
+		assets := make([]PlayerAsset[PixelData], 0, len(r.Assets))
+		for _, p := range r.Assets {
+			// placements of the same content share its pixel data
+			a, ok := contents[p.Hash]
+			if !ok {
+				return nil, errors.ErrMapWrongFormat
+			}
+			a.ID, a.UserID, a.Name, a.Version = p.ID, p.UserID, p.Name, p.Version
+			a.CreatedAt, a.UpdatedAt, a.DeletedAt = p.CreatedAt, p.UpdatedAt, p.DeletedAt
+			a.X, a.Y, a.Layer = p.X, p.Y, p.Layer
+			assets = append(assets, a)
+		}
+		loaded[i] = assets
+	}
+	return loaded, nil
+}
+
+// mapDataPageSize is the number of maps or revisions mapDataHashes
+// reads at once
+const mapDataPageSize = 100
+
+// mapDataHashes lists the content hashes placed by the map data of
+// every map and revision, including those in the trash. Documents are
+// read a page at a time so only their hashes are held in memory.
+func mapDataHashes(ctx context.Context, db DatabaseClient) (map[string]bool, error) {
+	hashes := make(map[string]bool)
+	for _, opts := range []DatabaseClientOptions{mapsDBOptions, revisionDBOptions} {
+		opts.IncludeDeleted = true
+		opts.Sort = bson.D{{Key: "_id", Value: 1}}
+		opts.Limit = mapDataPageSize
+		filter := bson.M{}
+		for {
+			var docs []struct {
+				ID   primitive.ObjectID `bson:"_id"`
+				Data []byte             `bson:"data"`
+			}
+			if err := db.Get(ctx, filter, opts, &docs); err != nil {
+				return nil, err
+			}
+			for _, doc := range docs {
+				refs, _, err := decodeMapRefs(doc.Data)
+				if err != nil {
+					return nil, err
+				}
+				for _, p := range refs.Assets {
+					hashes[p.Hash] = true
+				}
+			}
+			if len(docs) < mapDataPageSize {
+				break
+			}
+			filter = bson.M{"_id": bson.M{"$gt": docs[len(docs)-1].ID}}
+		}
+	}
+	return hashes, nil
+}
+
+// purgeMapAssets deletes the content no map or revision places that was
+// last used before t. Content used since may be placed by a map being saved.
+func purgeMapAssets(ctx context.Context, db DatabaseClient, t time.Time) (count int, err error) {
+	placed, err := mapDataHashes(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	opts := mapAssetDBOptions
+	opts.Omit = []string{"content"}
+	var stored []mapAsset
+	if err := db.Get(ctx, bson.M{"updated_at": bson.M{"$lt": t}}, opts, &stored); err != nil {
+		return 0, err
+	}
+	var unused []string
+	for _, s := range stored {
+		if !placed[s.Hash] {
+			unused = append(unused, s.Hash)
+		}
+	}
+	if len(unused) == 0 {
+		return 0, nil
+	}
+	// content used while listing is kept
+	return db.DeleteMany(ctx, bson.M{
+		"hash":       bson.M{"$in": unused},
+		"updated_at": bson.M{"$lt": t},
+	}, mapAssetDBOptions)
+}
+
+// dedupeMapAssets moves the assets embedded in the data of maps and
+// revisions to map_assets
+func dedupeMapAssets(ctx context.Context, db DatabaseClient) error {
+	for _, opts := range []DatabaseClientOptions{mapsDBOptions, revisionDBOptions} {
+		opts.IncludeDeleted = true
+		var docs []struct {
+			ID        primitive.ObjectID `bson:"_id"`
+			UpdatedAt time.Time          `bson:"updated_at"`
+			Data      []byte             `bson:"data"`
+		}
+		if err := db.Get(ctx, bson.M{}, opts, &docs); err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			if bytes.HasPrefix(doc.Data, []byte(mapRefsMagic)) {
+				continue
+			}
+			assets, err := decodeMapData(doc.Data)
+			if err == nil {
+				for i := range assets {
+					fitLegacyAsset(&assets[i])
+				}
+				doc.Data, err = storeMapAssets(ctx, db, assets)
+			}
+			if err != nil {
+				return fmt.Errorf("moving assets of %s %s: %w", opts.Table, doc.ID.Hex(), err)
+			}
+			update := Update{}.Set("data", doc.Data)
+			// moving assets is not an update of the document
+			if !doc.UpdatedAt.IsZero() {
+				update = update.Set("updated_at", doc.UpdatedAt)
+			}
+			if _, err := db.UpdateOne(ctx, doc.ID.Hex(), update, opts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
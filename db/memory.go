@@ -0,0 +1,621 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemoryDriver is a DatabaseClient that keeps documents in memory.
+// Filters support equality, $in, $nin, $ne, $exists, $gt, $gte, $lt,
+// $lte, $regex, $or and $and, which covers the queries made by this package.
+// Unique indexes are enforced like in MongoDB.
+type MemoryDriver struct {
+	mu     sync.RWMutex
+	tables memoryTables
+}
+
+func NewMemoryDriver() *MemoryDriver {
+	return &MemoryDriver{
+		tables: make(memoryTables),
+	}
+}
+
+// memoryRegistry decodes embedded documents as bson.M like the mongo client
+var memoryRegistry = func() *bsoncodec.Registry {
+	reg := bson.NewRegistry()
+	reg.RegisterTypeMapEntry(bson.TypeEmbeddedDocument, reflect.TypeOf(bson.M{}))
+	return reg
+}()
+
+func (m *MemoryDriver) Get(ctx context.Context, params any, opts DatabaseClientOptions, dest any) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.tables.Get(ctx, params, opts, dest)
+}
+
+func (m *MemoryDriver) GetOne(ctx context.Context, params any, opts DatabaseClientOptions) (any, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.tables.GetOne(ctx, params, opts)
+}
+
+func (m *MemoryDriver) CreateOne(ctx context.Context, document any, opts DatabaseClientOptions) (insertedID string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tables.CreateOne(ctx, document, opts)
+}
+
+func (m *MemoryDriver) UpdateOne(ctx context.Context, id string, update Update, opts DatabaseClientOptions) (any, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tables.UpdateOne(ctx, id, update, opts)
+}
+
+func (m *MemoryDriver) UpdateMany(ctx context.Context, params any, update any, opts DatabaseClientOptions) (count int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tables.UpdateMany(ctx, params, update, opts)
+}
+
+func (m *MemoryDriver) Delete(ctx context.Context, params any, opts DatabaseClientOptions) (count int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tables.Delete(ctx, params, opts)
+}
+
+func (m *MemoryDriver) DeleteMany(ctx context.Context, params any, opts DatabaseClientOptions) (count int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tables.DeleteMany(ctx, params, opts)
+}
+
+// WithTransaction runs fn on a copy of the tables that replaces them if fn
+// succeeds. Other operations wait until the transaction is done.
+func (m *MemoryDriver) WithTransaction(ctx context.Context, fn func(tx DatabaseClient) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tx := m.tables.clone()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	m.tables = tx
+	return nil
+}
+
+// memoryTables holds the documents of each collection by tableKey.
+// It implements DatabaseClient without locking.
+type memoryTables map[string][]bson.M
+
+// clone copies the document slices of t, documents are replaced on update
+// and can be shared
+func (t memoryTables) clone() memoryTables {
+	c := make(memoryTables, len(t))
+	for key, docs := range t {
+		c[key] = append([]bson.M(nil), docs...)
+	}
+	return c
+}
+
+func (t memoryTables) Get(ctx context.Context, params any, opts DatabaseClientOptions, dest any) error {
+	filter, err := liveFilter(params, opts)
+	if err != nil {
+		return err
+	}
+	destVal := reflect.ValueOf(dest)
+	if destVal.Kind() != reflect.Pointer || destVal.Elem().Kind() != reflect.Slice {
+		return errors.New("dest must be a pointer to a slice")
+	}
+	var docs []bson.M
+	for _, doc := range t[tableKey(opts)] {
+		if matchDocument(doc, filter) {
+			docs = append(docs, doc)
+		}
+	}
+
+	sliceType := destVal.Elem().Type()
+	results := reflect.MakeSlice(sliceType, 0, len(docs))
+	for _, doc := range findOptions(docs, opts) {
+		elem := reflect.New(sliceType.Elem())
+		if err := decodeDocument(doc, elem.Interface()); err != nil {
+			return err
+		}
+		results = reflect.Append(results, elem.Elem())
+	}
+	destVal.Elem().Set(results)
+	return nil
+}
+
+func (t memoryTables) GetOne(ctx context.Context, params any, opts DatabaseClientOptions) (any, error) {
+	filter, err := liveFilter(params, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range t[tableKey(opts)] {
+		if matchDocument(doc, filter) {
+			return toDocument(doc)
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (t memoryTables) CreateOne(ctx context.Context, document any, opts DatabaseClientOptions) (insertedID string, err error) {
+	doc, err := toDocument(document)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	id, ok := doc["_id"].(primitive.ObjectID)
+	if !ok {
+		return "", errors.New("_id must be an ObjectID")
+	}
+	stampCreated(doc, time.Now())
+
+	key := tableKey(opts)
+	for _, existing := range t[key] {
+		if existing["_id"] == id {
+			return "", duplicateKeyError(opts, "_id_")
+		}
+	}
+	if err := t.checkUnique(opts, doc, -1); err != nil {
+		return "", err
+	}
+	t[key] = append(t[key], doc)
+	return id.Hex(), nil
+}
+
+func (t memoryTables) UpdateOne(ctx context.Context, id string, update Update, opts DatabaseClientOptions) (any, error) {
+	_id, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := liveFilter(bson.M{"_id": _id}, opts)
+	if err != nil {
+		return nil, err
+	}
+	fields, inc, err := updateFields(bson.D(update))
+	if err != nil {
+		return nil, err
+	}
+	count, err := t.update(filter, fields, inc, opts, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.UpdateResult{
+		MatchedCount:  int64(count),
+		ModifiedCount: int64(count),
+	}, nil
+}
+
+func (t memoryTables) UpdateMany(ctx context.Context, params any, update any, opts DatabaseClientOptions) (count int, err error) {
+	filter, err := liveFilter(params, opts)
+	if err != nil {
+		return 0, err
+	}
+	fields, inc, err := updateFields(update)
+	if err != nil {
+		return 0, err
+	}
+	return t.update(filter, fields, inc, opts, -1)
+}
+
+func (t memoryTables) Delete(ctx context.Context, params any, opts DatabaseClientOptions) (count int, err error) {
+	return t.delete(params, opts, 1)
+}
+
+func (t memoryTables) DeleteMany(ctx context.Context, params any, opts DatabaseClientOptions) (count int, err error) {
+	return t.delete(params, opts, -1)
+}
+
+// update sets fields and increments the fields of inc on up to limit
+// documents matching filter, or all if limit < 0
+func (t memoryTables) update(filter bson.M, fields bson.M, inc bson.M, opts DatabaseClientOptions, limit int) (int, error) {
+	stampUpdated(fields, time.Now())
+	docs := t[tableKey(opts)]
+	count := 0
+	for i, doc := range docs {
+		if limit >= 0 && count >= limit {
+			break
+		}
+		if !matchDocument(doc, filter) {
+			continue
+		}
+		updated := make(bson.M, len(doc)+len(fields))
+		for k, v := range doc {
+			updated[k] = v
+		}
+		for k, v := range fields {
+			updated[k] = v
+		}
+		for k, n := range inc {
+			v, err := increment(updated[k], n)
+			if err != nil {
+				return count, err
+			}
+			updated[k] = v
+		}
+		if err := t.checkUnique(opts, updated, i); err != nil {
+			return count, err
+		}
+		docs[i] = updated
+		count++
+	}
+	return count, nil
+}
+
+// delete removes up to limit documents matching params, or all if limit < 0
+func (t memoryTables) delete(params any, opts DatabaseClientOptions, limit int) (int, error) {
+	filter, err := liveFilter(params, opts)
+	if err != nil {
+		return 0, err
+	}
+	key := tableKey(opts)
+	kept := t[key][:0]
+	count := 0
+	for _, doc := range t[key] {
+		if (limit < 0 || count < limit) && matchDocument(doc, filter) {
+			count++
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	t[key] = kept
+	return count, nil
+}
+
+// checkUnique returns a duplicate key error if doc conflicts with a
+// unique index. The document at index skip is the one being replaced.
+func (t memoryTables) checkUnique(opts DatabaseClientOptions, doc bson.M, skip int) error {
+	for _, index := range uniqueIndexes[opts.Table] {
+		if index.filter != nil && !matchDocument(doc, index.filter) {
+			continue
+		}
+		for i, existing := range t[tableKey(opts)] {
+			if i == skip || (index.filter != nil && !matchDocument(existing, index.filter)) {
+				continue
+			}
+			if sameKey(doc, existing, index.fields) {
+				return duplicateKeyError(opts, strings.Join(index.fields, "_1_")+"_1")
+			}
+		}
+	}
+	return nil
+}
+
+// sameKey reports whether a and b have equal values for all fields.
+// Documents missing a field are not indexed.
+func sameKey(a bson.M, b bson.M, fields []string) bool {
+	for _, field := range fields {
+		va, ok := lookupField(a, field)
+		if !ok {
+			return false
+		}
+		vb, ok := lookupField(b, field)
+		if !ok || !valuesEqual(va, vb) {
+			return false
+		}
+	}
+	return true
+}
+
+// duplicateKeyError returns an error matching mongo.IsDuplicateKeyError
+func duplicateKeyError(opts DatabaseClientOptions, index string) error {
+	return mongo.WriteException{
+		WriteErrors: mongo.WriteErrors{{
+			Code: 11000,
+			Message: fmt.Sprintf(
+				"E11000 duplicate key error collection: %s index: %s",
+				tableKey(opts), index,
+			),
+		}},
+	}
+}
+
+func tableKey(opts DatabaseClientOptions) string {
+	return opts.Database + "." + opts.Table
+}
+
+// toDocument converts v to a bson.M the way it would be stored
+func toDocument(v any) (bson.M, error) {
+	if v == nil {
+		return bson.M{}, nil
+	}
+	b, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	return doc, decodeDocument(bson.Raw(b), &doc)
+}
+
+func decodeDocument(doc any, dest any) error {
+	b, ok := doc.(bson.Raw)
+	if !ok {
+		var err error
+		if b, err = bson.Marshal(doc); err != nil {
+			return err
+		}
+	}
+	dec, err := bson.NewDecoder(bsonrw.NewBSONDocumentReader(b))
+	if err != nil {
+		return err
+	}
+	if err := dec.SetRegistry(memoryRegistry); err != nil {
+		return err
+	}
+	return dec.Decode(dest)
+}
+
+//////////////////////////
+// filters
+//////////////////////////
+
+func matchDocument(doc bson.M, filter bson.M) bool {
+	for key, cond := range filter {
+		switch key {
+		case "$or":
+			matched := false
+			for _, sub := range subFilters(cond) {
+				if matchDocument(doc, sub) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		case "$and":
+			for _, sub := range subFilters(cond) {
+				if !matchDocument(doc, sub) {
+					return false
+				}
+			}
+		default:
+			val, exists := lookupField(doc, key)
+			if !matchCondition(val, exists, cond) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func subFilters(cond any) []bson.M {
+	arr, _ := cond.(primitive.A)
+	filters := make([]bson.M, 0, len(arr))
+	for _, v := range arr {
+		if f, ok := v.(bson.M); ok {
+			filters = append(filters, f)
+		}
+	}
+	return filters
+}
+
+// lookupField returns the value at a dotted path
+func lookupField(doc bson.M, path string) (any, bool) {
+	var cur any = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(bson.M)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func matchCondition(val any, exists bool, cond any) bool {
+	ops, ok := cond.(bson.M)
+	if !ok || !isOperatorDocument(ops) {
+		return matchValue(val, exists, cond)
+	}
+	for op, arg := range ops {
+		switch op {
+		case "$eq":
+			if !matchValue(val, exists, arg) {
+				return false
+			}
+		case "$ne":
+			if matchValue(val, exists, arg) {
+				return false
+			}
+		case "$in", "$nin":
+			arr, _ := arg.(primitive.A)
+			found := false
+			for _, want := range arr {
+				if matchValue(val, exists, want) {
+					found = true
+					break
+				}
+			}
+			if found != (op == "$in") {
+				return false
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			if !exists {
+				return false
+			}
+			cmp, ok := compareValues(val, arg)
+			if !ok ||
+				(op == "$gt" && cmp <= 0) ||
+				(op == "$gte" && cmp < 0) ||
+				(op == "$lt" && cmp >= 0) ||
+				(op == "$lte" && cmp > 0) {
+				return false
+			}
+		case "$regex":
+			str, ok := val.(string)
+			pattern, _ := arg.(string)
+			if !ok {
+				return false
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil || !re.MatchString(str) {
+				return false
+			}
+		case "$exists":
+			want, _ := arg.(bool)
+			if exists != want {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isOperatorDocument(m bson.M) bool {
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+	return len(m) > 0
+}
+
+// matchValue compares like MongoDB: null matches missing fields
+// and arrays match if any element is equal
+func matchValue(val any, exists bool, want any) bool {
+	if want == nil {
+		return !exists || val == nil
+	}
+	if !exists {
+		return false
+	}
+	if arr, ok := val.(primitive.A); ok {
+		for _, elem := range arr {
+			if valuesEqual(elem, want) {
+				return true
+			}
+		}
+	}
+	return valuesEqual(val, want)
+}
+
+func valuesEqual(a, b any) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareValues orders numbers, strings, dates and ObjectIDs
+func compareValues(a, b any) (int, bool) {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case fa < fb:
+			return -1, true
+		case fa > fb:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case primitive.DateTime:
+		if b, ok := b.(primitive.DateTime); ok {
+			return compareValues(int64(a), int64(b))
+		}
+	case primitive.ObjectID:
+		if b, ok := b.(primitive.ObjectID); ok {
+			return strings.Compare(a.Hex(), b.Hex()), true
+		}
+	}
+	return 0, false
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+//////////////////////////
+// find options
+//////////////////////////
+
+// findOptions sorts, limits and projects docs like MongoDB Find.
+// Documents with omitted fields are copies.
+func findOptions(docs []bson.M, opts DatabaseClientOptions) []bson.M {
+	if len(opts.Sort) > 0 {
+		slices.SortStableFunc(docs, func(a, b bson.M) int {
+			for _, key := range opts.Sort {
+				va, aok := lookupField(a, key.Key)
+				vb, bok := lookupField(b, key.Key)
+				cmp := compareSortValues(va, aok, vb, bok)
+				if dir, _ := toFloat(key.Value); dir < 0 {
+					cmp = -cmp
+				}
+				if cmp != 0 {
+					return cmp
+				}
+			}
+			return 0
+		})
+	}
+	if opts.Limit > 0 && len(docs) > opts.Limit {
+		docs = docs[:opts.Limit]
+	}
+	if len(opts.Omit) == 0 {
+		return docs
+	}
+	projected := make([]bson.M, len(docs))
+	for i, doc := range docs {
+		projected[i] = make(bson.M, len(doc))
+		for k, v := range doc {
+			if !slices.Contains(opts.Omit, k) {
+				projected[i][k] = v
+			}
+		}
+	}
+	return projected
+}
+
+// compareSortValues orders missing and null values first like MongoDB
+func compareSortValues(a any, aExists bool, b any, bExists bool) int {
+	aNull := !aExists || a == nil
+	bNull := !bExists || b == nil
+	switch {
+	case aNull && bNull:
+		return 0
+	case aNull:
+		return -1
+	case bNull:
+		return 1
+	}
+	cmp, _ := compareValues(a, b)
+	return cmp
+}
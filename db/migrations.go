@@ -0,0 +1,398 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	SchemaMigrationsCollection = "schema_migrations"
+	// SchemaLockCollection holds the lock taken while applying migrations
+	SchemaLockCollection = "schema_lock"
+
+	migrationLockID = "migrations"
+	// migrationLockTTL is how long until a lock is taken as left behind
+	// by an instance that crashed while applying migrations
+	migrationLockTTL  = 15 * time.Minute
+	migrationLockPoll = time.Second
+)
+
+// Migrator is implemented by databases with a versioned schema
+type Migrator interface {
+	// Migrate applies pending migrations in order
+	Migrate(ctx context.Context) error
+}
+
+// uniqueIndex is a set of fields that must be unique in a collection
+type uniqueIndex struct {
+	fields []string
+	// filter limits the index to matching documents like a partial index
+	filter bson.M
+}
+
+// uniqueIndexes lists the unique indexes per collection. MongoDB and SQL
+// create these in their migrations, MemoryDriver checks them on write.
+var uniqueIndexes = map[string][]uniqueIndex{
+	UserProfilesCollection: {{fields: []string{"username"}}},
+	// names are reusable once the asset or map is in the trash
+	PlayerImagesCollection: {{fields: []string{"user_id", "name"}, filter: bson.M{"deleted_at": nil}}},
+	PlayerMapsCollection: {
+		{fields: []string{"user_id", "name"}, filter: bson.M{"deleted_at": nil}},
+		// a user has at most one primary map
+		{fields: []string{"user_id"}, filter: bson.M{"primary": true}},
+	},
+	UserIdentitiesCollection: {{fields: []string{"provider", "subject"}}},
+	MapRevisionsCollection:   {{fields: []string{"map_id", "version"}}},
+	MapAssetsCollection:      {{fields: []string{"hash"}}},
+}
+
+type mongoMigration struct {
+	version     int
+	description string
+	up          func(ctx context.Context, m *MongoDriver, game *mongo.Database) error
+}
+
+// mongoMigrations are applied in order and recorded in schema_migrations.
+// Never change an applied migration, append a new one instead.
+var mongoMigrations = []mongoMigration{
+	{
+		version:     1,
+		description: "lowercase usernames",
+		up: func(ctx context.Context, m *MongoDriver, game *mongo.Database) error {
+			_, err := game.Collection(UserProfilesCollection).UpdateMany(ctx,
+				bson.M{"username": bson.M{"$type": "string"}},
+				bson.A{bson.M{"$set": bson.M{"username": bson.M{"$toLower": "$username"}}}},
+			)
+			return err
+		},
+	},
+	{
+		version:     2,
+		description: "unique indexes",
+		up: func(ctx context.Context, m *MongoDriver, game *mongo.Database) error {
+			indexes := []struct {
+				table string
+				keys  bson.D
+			}{
+				{UserProfilesCollection, bson.D{{Key: "username", Value: 1}}},
+				{PlayerImagesCollection, bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}}},
+				{PlayerMapsCollection, bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}}},
+				{UserIdentitiesCollection, bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}}},
+			}
+			for _, index := range indexes {
+				if err := createIndex(ctx, game.Collection(index.table), index.keys, options.Index().SetUnique(true)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     3,
+		description: "lookup indexes",
+		up: func(ctx context.Context, m *MongoDriver, game *mongo.Database) error {
+			indexes := []struct {
+				table string
+				keys  bson.D
+			}{
+				{PlayerImagesCollection, bson.D{{Key: "user_id", Value: 1}, {Key: "asset_type", Value: 1}}},
+				{PlayerMapsCollection, bson.D{{Key: "user_id", Value: 1}, {Key: "primary", Value: 1}}},
+				{UserIdentitiesCollection, bson.D{{Key: "user_id", Value: 1}}},
+				{UserTokensCollection, bson.D{{Key: "user_id", Value: 1}}},
+				{TokenRevocationsCollection, bson.D{{Key: "user_id", Value: 1}}},
+				{UserProfilesCollection, bson.D{{Key: "email", Value: 1}}},
+				{UserProfilesCollection, bson.D{{Key: "delete_after", Value: 1}}},
+			}
+			for _, index := range indexes {
+				if err := createIndex(ctx, game.Collection(index.table), index.keys, options.Index()); err != nil {
+					return err
+				}
+			}
+			// expired single-use tokens are removed by mongo
+			return createIndex(ctx, game.Collection(UserTokensCollection),
+				bson.D{{Key: "expires_at", Value: 1}},
+				options.Index().SetExpireAfterSeconds(0),
+			)
+		},
+	},
+	{
+		version:     4,
+		description: "backfill map usernames from user profiles",
+		up: func(ctx context.Context, m *MongoDriver, game *mongo.Database) error {
+			cursor, err := game.Collection(UserProfilesCollection).Find(ctx, bson.M{},
+				options.Find().SetProjection(bson.M{"username": 1}),
+			)
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+			for cursor.Next(ctx) {
+				var user User
+				if err := cursor.Decode(&user); err != nil {
+					return err
+				}
+				_, err := game.Collection(PlayerMapsCollection).UpdateMany(ctx,
+					bson.M{"user_id": user.ID.Hex()},
+					bson.M{"$set": bson.M{"username": user.UserName}},
+				)
+				if err != nil {
+					return err
+				}
+			}
+			return cursor.Err()
+		},
+	},
+	{
+		version:     5,
+		description: "unique primary map per user",
+		up: func(ctx context.Context, m *MongoDriver, game *mongo.Database) error {
+			// existing duplicates would prevent the index
+			if _, err := RepairPrimaryMaps(ctx, m); err != nil {
+				return err
+			}
+			return createIndex(ctx, game.Collection(PlayerMapsCollection),
+				bson.D{{Key: "user_id", Value: 1}},
+				options.Index().
+					SetName("user_id_1_primary_unique").
+					SetUnique(true).
+					SetPartialFilterExpression(bson.M{"primary": true}),
+			)
+		},
+	},
+	{
+		version:     6,
+		description: "list sort fields and indexes",
+		up: func(ctx context.Context, m *MongoDriver, game *mongo.Database) error {
+			if err := backfillListFields(ctx, m); err != nil {
+				return err
+			}
+			indexes := []struct {
+				table string
+				keys  bson.D
+			}{
+				{PlayerMapsCollection, bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}},
+				{PlayerMapsCollection, bson.D{{Key: "updated_at", Value: 1}, {Key: "_id", Value: 1}}},
+				{PlayerMapsCollection, bson.D{{Key: "visits", Value: 1}, {Key: "_id", Value: 1}}},
+				{PlayerImagesCollection, bson.D{{Key: "user_id", Value: 1}, {Key: "updated_at", Value: 1}, {Key: "_id", Value: 1}}},
+				{ImagesCollection, bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}},
+			}
+			for _, index := range indexes {
+				if err := createIndex(ctx, game.Collection(index.table), index.keys, options.Index()); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     7,
+		description: "soft delete of maps and assets",
+		up: func(ctx context.Context, m *MongoDriver, game *mongo.Database) error {
+			for _, table := range []string{PlayerMapsCollection, PlayerImagesCollection} {
+				coll := game.Collection(table)
+				// partial indexes cannot match missing fields, only explicit nulls
+				_, err := coll.UpdateMany(ctx,
+					bson.M{"deleted_at": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"deleted_at": nil}},
+				)
+				if err != nil {
+					return err
+				}
+				// names stay unique among documents that are not deleted
+				if _, err := coll.Indexes().DropOne(ctx, "user_id_1_name_1"); err != nil && !isIndexNotFound(err) {
+					return err
+				}
+				err = createIndex(ctx, coll,
+					bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}},
+					options.Index().
+						SetName("user_id_1_name_1_live_unique").
+						SetUnique(true).
+						SetPartialFilterExpression(bson.M{"deleted_at": bson.M{"$type": "null"}}),
+				)
+				if err != nil {
+					return err
+				}
+				err = createIndex(ctx, coll, bson.D{{Key: "deleted_at", Value: 1}}, options.Index())
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     8,
+		description: "map and asset versions",
+		up: func(ctx context.Context, m *MongoDriver, game *mongo.Database) error {
+			for _, table := range []string{PlayerMapsCollection, PlayerImagesCollection} {
+				_, err := game.Collection(table).UpdateMany(ctx,
+					bson.M{"version": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"version": 1}},
+				)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     9,
+		description: "map revisions",
+		up: func(ctx context.Context, m *MongoDriver, game *mongo.Database) error {
+			err := createIndex(ctx, game.Collection(MapRevisionsCollection),
+				bson.D{{Key: "map_id", Value: 1}, {Key: "version", Value: 1}},
+				options.Index().SetUnique(true),
+			)
+			if err != nil {
+				return err
+			}
+			return backfillMapRevisions(ctx, m)
+		},
+	},
+	{
+		version:     10,
+		description: "compact pixel data",
+		up: func(ctx context.Context, m *MongoDriver, game *mongo.Database) error {
+			return compactPixelData(ctx, m)
+		},
+	},
+	{
+		version:     11,
+		description: "content addressed map assets",
+		up: func(ctx context.Context, m *MongoDriver, game *mongo.Database) error {
+			err := createIndex(ctx, game.Collection(MapAssetsCollection),
+				bson.D{{Key: "hash", Value: 1}},
+				options.Index().SetUnique(true),
+			)
+			if err != nil {
+				return err
+			}
+			return dedupeMapAssets(ctx, m)
+		},
+	},
+	{
+		version:     12,
+		description: "default user roles",
+		up: func(ctx context.Context, m *MongoDriver, game *mongo.Database) error {
+			return backfillUserRoles(ctx, m)
+		},
+	},
+}
+
+func createIndex(ctx context.Context, coll *mongo.Collection, keys bson.D, opts *options.IndexOptions) error {
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys, Options: opts})
+	if err != nil {
+		return fmt.Errorf("creating index %v on %s: %w", keys, coll.Name(), err)
+	}
+	return nil
+}
+
+// isIndexNotFound reports whether err is mongo's IndexNotFound error
+func isIndexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 27
+}
+
+type appliedMigration struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Migrate applies pending migrations and records them in schema_migrations
+func (m *MongoDriver) Migrate(ctx context.Context) error {
+	game := m.Client.Database(GameDatabase)
+	records := game.Collection(SchemaMigrationsCollection)
+
+	pending, err := pendingMongoMigrations(ctx, records)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	locks := game.Collection(SchemaLockCollection)
+	err = acquireMigrationLock(ctx, func() (bool, error) {
+		// a lock left behind by a crashed instance expires
+		now := time.Now().UTC()
+		_, err := locks.DeleteOne(ctx, bson.M{"_id": migrationLockID, "expires_at": bson.M{"$lt": now}})
+		if err != nil {
+			return false, err
+		}
+		_, err = locks.InsertOne(ctx, bson.M{"_id": migrationLockID, "expires_at": now.Add(migrationLockTTL)})
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		return err
+	}
+	defer locks.DeleteOne(context.WithoutCancel(ctx), bson.M{"_id": migrationLockID})
+	// skip what was applied while waiting for the lock
+	if pending, err = pendingMongoMigrations(ctx, records); err != nil {
+		return err
+	}
+
+	for _, migration := range pending {
+		log.Printf("applying migration %d: %s", migration.version, migration.description)
+		if err := migration.up(ctx, m, game); err != nil {
+			return fmt.Errorf("migration %d: %w", migration.version, err)
+		}
+		_, err := records.InsertOne(ctx, appliedMigration{
+			Version:     migration.version,
+			Description: migration.description,
+			AppliedAt:   time.Now().UTC(),
+		})
+		// another instance may have applied it after taking an expired lock
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// pendingMongoMigrations returns the migrations not recorded in records
+func pendingMongoMigrations(ctx context.Context, records *mongo.Collection) ([]mongoMigration, error) {
+	cursor, err := records.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var applied []appliedMigration
+	if err := cursor.All(ctx, &applied); err != nil {
+		return nil, err
+	}
+	done := make(map[int]bool, len(applied))
+	for _, a := range applied {
+		done[a.Version] = true
+	}
+	var pending []mongoMigration
+	for _, migration := range mongoMigrations {
+		if !done[migration.version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// acquireMigrationLock calls tryLock until it takes the lock, so instances
+// starting together apply migrations one after another
+func acquireMigrationLock(ctx context.Context, tryLock func() (bool, error)) error {
+	for {
+		ok, err := tryLock()
+		if err != nil || ok {
+			return err
+		}
+		log.Println("waiting for another instance to apply migrations...")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(migrationLockPoll):
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMongoMigrate(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	migrationSource := GameDatabase + "." + SchemaMigrationsCollection
+
+	applied := func(versions ...int) []bson.D {
+		docs := []bson.D{}
+		for _, v := range versions {
+			docs = append(docs, bson.D{{Key: "_id", Value: v}})
+		}
+		return docs
+	}
+
+	mt.Run("up-to-date", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, migrationSource, mtest.FirstBatch, applied(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12)...),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := driver.Migrate(context.Background())
+
+		// assert
+		assert.Nil(t, err)
+	})
+
+	mt.Run("applies-pending", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, migrationSource, mtest.FirstBatch, applied(1, 2, 3)...),
+			// take the lock and check again
+			SuccessResponse,
+			SuccessResponse,
+			mtest.CreateCursorResponse(0, migrationSource, mtest.FirstBatch, applied(1, 2, 3)...),
+			// backfill finds no users
+			mtest.CreateCursorResponse(0, GameDatabase+"."+UserProfilesCollection, mtest.FirstBatch),
+			// record migration
+			SuccessResponse,
+			// repair finds no maps
+			mtest.CreateCursorResponse(0, GameDatabase+"."+PlayerMapsCollection, mtest.FirstBatch),
+			// primary map index
+			SuccessResponse,
+			// record migration
+			SuccessResponse,
+			// backfill finds no maps or assets
+			mtest.CreateCursorResponse(0, GameDatabase+"."+PlayerMapsCollection, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, GameDatabase+"."+PlayerImagesCollection, mtest.FirstBatch),
+			// sort indexes
+			SuccessResponse,
+			SuccessResponse,
+			SuccessResponse,
+			SuccessResponse,
+			SuccessResponse,
+			// record migration
+			SuccessResponse,
+			// maps and assets: backfill, drop and create name index, deleted_at index
+			SuccessResponse,
+			SuccessResponse,
+			SuccessResponse,
+			SuccessResponse,
+			SuccessResponse,
+			SuccessResponse,
+			SuccessResponse,
+			SuccessResponse,
+			// record migration
+			SuccessResponse,
+			// maps and assets versions
+			SuccessResponse,
+			SuccessResponse,
+			// record migration
+			SuccessResponse,
+			// revision index, backfill finds no maps
+			SuccessResponse,
+			mtest.CreateCursorResponse(0, GameDatabase+"."+PlayerMapsCollection, mtest.FirstBatch),
+			// record migration
+			SuccessResponse,
+			// no maps, assets or revisions to compact
+			mtest.CreateCursorResponse(0, GameDatabase+"."+PlayerMapsCollection, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, GameDatabase+"."+PlayerImagesCollection, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, GameDatabase+"."+MapRevisionsCollection, mtest.FirstBatch),
+			// record migration
+			SuccessResponse,
+			// map asset index, no maps or revisions to move assets of
+			SuccessResponse,
+			mtest.CreateCursorResponse(0, GameDatabase+"."+PlayerMapsCollection, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, GameDatabase+"."+MapRevisionsCollection, mtest.FirstBatch),
+			// record migration
+			SuccessResponse,
+			// no users without a role
+			mtest.CreateCursorResponse(0, GameDatabase+"."+UserProfilesCollection, mtest.FirstBatch),
+			// record migration
+			SuccessResponse,
+			// release the lock
+			SuccessResponse,
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := driver.Migrate(context.Background())
+
+		// assert
+		assert.Nil(t, err)
+	})
+
+	mt.Run("failure", func(mt *mtest.T) {
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, migrationSource, mtest.FirstBatch, applied(1, 2, 3)...),
+			// take the lock and check again
+			SuccessResponse,
+			SuccessResponse,
+			mtest.CreateCursorResponse(0, migrationSource, mtest.FirstBatch, applied(1, 2, 3)...),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "find failed"}),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := driver.Migrate(context.Background())
+
+		// assert
+		assert.NotNil(t, err)
+	})
+
+	mt.Run("applied-while-waiting", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, migrationSource, mtest.FirstBatch, applied(1, 2, 3)...),
+			// another instance holds the lock
+			SuccessResponse,
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 11000, Message: "duplicate key"}),
+			// and has applied every migration once it is released
+			SuccessResponse,
+			SuccessResponse,
+			mtest.CreateCursorResponse(0, migrationSource, mtest.FirstBatch, applied(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12)...),
+			// release the lock
+			SuccessResponse,
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := driver.Migrate(context.Background())
+
+		// assert
+		assert.Nil(t, err)
+	})
+}
@@ -2,13 +2,13 @@ package db
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"reflect"
-	"strings"
 	"time"
 
 	"github.com/snburman/game-server/config"
-	"github.com/snburman/game-server/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -23,11 +23,20 @@ const UserProfilesCollection = "user_profiles"
 const ImagesCollection = "images"
 const PlayerImagesCollection = "player_images"
 const PlayerMapsCollection = "player_maps"
+const UserTokensCollection = "user_tokens"
+const SettingsCollection = "settings"
+const UserIdentitiesCollection = "user_identities"
+const APIKeysCollection = "api_keys"
+const TokenRevocationsCollection = "token_revocations"
+const MapRevisionsCollection = "map_revisions"
+const MapAssetsCollection = "map_assets"
 
 var MongoDB *MongoDriver
 
 type MongoDriver struct {
 	Client *mongo.Client
+	// session is set on drivers bound to a transaction
+	session mongo.Session
 }
 
 func NewMongoDriver() {
@@ -55,6 +64,18 @@ func (m *MongoDriver) Connect() error {
 	}
 	m.Client = client
 	log.Println("Connected to MongoDB...")
+
+	// writes spanning several documents rely on transactions, which
+	// standalone servers do not support
+	var hello bson.M
+	err = client.Database("admin").RunCommand(context.TODO(), bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return fmt.Errorf("detecting MongoDB topology: %w", err)
+	}
+	// replica set members report setName, mongos reports isdbgrid
+	if _, replicaSet := hello["setName"]; !replicaSet && hello["msg"] != "isdbgrid" {
+		return errors.New("MongoDB is standalone, transactions require a replica set or a sharded cluster")
+	}
 	return nil
 }
 
@@ -67,73 +88,165 @@ func (m *MongoDriver) Disconnect() error {
 	return nil
 }
 
-func (m *MongoDriver) Get(params any, opts DatabaseClientOptions, dest any) error {
+func (m *MongoDriver) Get(ctx context.Context, params any, opts DatabaseClientOptions, dest any) error {
 	mdb := m.Client.Database(opts.Database)
-	ctx := context.Background()
-	res, err := mdb.Collection(opts.Table).Find(ctx, params)
+	filter, err := liveFilter(params, opts)
+	if err != nil {
+		return err
+	}
+	ctx = m.sessionContext(ctx)
+	findOpts := options.Find()
+	if len(opts.Sort) > 0 {
+		findOpts.SetSort(opts.Sort)
+	}
+	if opts.Limit > 0 {
+		findOpts.SetLimit(int64(opts.Limit))
+	}
+	if len(opts.Omit) > 0 {
+		projection := bson.M{}
+		for _, field := range opts.Omit {
+			projection[field] = 0
+		}
+		findOpts.SetProjection(projection)
+	}
+	res, err := mdb.Collection(opts.Table).Find(ctx, filter, findOpts)
 	if err == nil {
 		res.All(ctx, dest)
 	}
 	return err
 }
 
-func (m *MongoDriver) GetOne(params any, opts DatabaseClientOptions) (any, error) {
+func (m *MongoDriver) GetOne(ctx context.Context, params any, opts DatabaseClientOptions) (any, error) {
 	mdb := m.Client.Database(opts.Database)
-	res := mdb.Collection(opts.Table).FindOne(context.Background(), params)
+	filter, err := liveFilter(params, opts)
+	if err != nil {
+		return nil, err
+	}
+	res := mdb.Collection(opts.Table).FindOne(m.sessionContext(ctx), filter)
 	var dest any
-	err := res.Decode(&dest)
+	err = res.Decode(&dest)
 	return dest, err
 }
 
-func (m *MongoDriver) CreateOne(document any, opts DatabaseClientOptions) (insertedID string, err error) {
+func (m *MongoDriver) CreateOne(ctx context.Context, document any, opts DatabaseClientOptions) (insertedID string, err error) {
 	mdb := m.Client.Database(opts.Database)
-	res, err := mdb.Collection(opts.Table).InsertOne(context.Background(), document)
+	doc, err := toDocument(document)
+	if err != nil {
+		return "", err
+	}
+	stampCreated(doc, time.Now())
+	res, err := mdb.Collection(opts.Table).InsertOne(m.sessionContext(ctx), doc)
+	if err != nil {
+		return "", err
+	}
 	id := res.InsertedID.(primitive.ObjectID)
-	return id.Hex(), err
+	return id.Hex(), nil
 }
 
-func (m *MongoDriver) UpdateOne(id string, document any, opts DatabaseClientOptions) (any, error) {
+func (m *MongoDriver) UpdateOne(ctx context.Context, id string, update Update, opts DatabaseClientOptions) (any, error) {
 	mdb := m.Client.Database(opts.Database)
-	var updates bson.D
-
-	typeData := reflect.TypeOf(document)
-	values := reflect.ValueOf(document)
-
-	// https://joshua-etim.medium.com/how-i-update-documents-in-mongodb-with-golang-94485dbe54f7
-	for i := 1; i < typeData.NumField(); i++ {
-		field := typeData.Field(i)
-		val := values.Field(i)
-		tag := field.Tag.Get("bson")
-		// disregard fields without bson tag
-		if tag == "" {
-			continue
-		}
-		// disregard secondary tag fragment
-		tagStripped := strings.Split(tag, ",")
-		if len(tagStripped) > 1 {
-			tag = tagStripped[0]
-			if tagStripped[0] == "_id" {
-				continue
-			}
-		}
-		if !utils.IsZeroType(val) {
-			update := bson.E{Key: tag, Value: val.Interface()}
-			updates = append(updates, update)
-		}
-	}
-	updateFilter := bson.D{{Key: "$set", Value: updates}}
-
 	_id, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
 	}
-	return mdb.Collection(opts.Table).UpdateOne(context.Background(), bson.M{
-		"_id": _id,
-	}, updateFilter)
+	// mongo rejects an empty $set
+	if len(update) == 0 {
+		return &mongo.UpdateResult{}, nil
+	}
+	filter, err := liveFilter(bson.M{"_id": _id}, opts)
+	if err != nil {
+		return nil, err
+	}
+	fields, inc, err := updateFields(bson.D(update))
+	if err != nil {
+		return nil, err
+	}
+	stampUpdated(fields, time.Now())
+	return mdb.Collection(opts.Table).UpdateOne(m.sessionContext(ctx), filter, mongoUpdate(fields, inc))
 }
 
-func (m *MongoDriver) Delete(params any, opts DatabaseClientOptions) (count int, err error) {
+func (m *MongoDriver) UpdateMany(ctx context.Context, params any, update any, opts DatabaseClientOptions) (count int, err error) {
 	mdb := m.Client.Database(opts.Database)
-	res, err := mdb.Collection(opts.Table).DeleteOne(context.Background(), params)
-	return int(res.DeletedCount), err
+	filter, err := liveFilter(params, opts)
+	if err != nil {
+		return 0, err
+	}
+	fields, inc, err := updateFields(update)
+	if err != nil {
+		return 0, err
+	}
+	stampUpdated(fields, time.Now())
+	res, err := mdb.Collection(opts.Table).UpdateMany(
+		m.sessionContext(ctx),
+		filter,
+		mongoUpdate(fields, inc),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.ModifiedCount), nil
+}
+
+// mongoUpdate sets fields and increments the fields of inc.
+// Mongo rejects empty operators.
+func mongoUpdate(fields bson.M, inc bson.M) bson.D {
+	update := bson.D{}
+	if len(fields) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: fields})
+	}
+	if len(inc) > 0 {
+		update = append(update, bson.E{Key: incKey, Value: inc})
+	}
+	return update
+}
+
+func (m *MongoDriver) Delete(ctx context.Context, params any, opts DatabaseClientOptions) (count int, err error) {
+	mdb := m.Client.Database(opts.Database)
+	filter, err := liveFilter(params, opts)
+	if err != nil {
+		return 0, err
+	}
+	res, err := mdb.Collection(opts.Table).DeleteOne(m.sessionContext(ctx), filter)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.DeletedCount), nil
+}
+
+func (m *MongoDriver) DeleteMany(ctx context.Context, params any, opts DatabaseClientOptions) (count int, err error) {
+	mdb := m.Client.Database(opts.Database)
+	filter, err := liveFilter(params, opts)
+	if err != nil {
+		return 0, err
+	}
+	res, err := mdb.Collection(opts.Table).DeleteMany(m.sessionContext(ctx), filter)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.DeletedCount), nil
+}
+
+// sessionContext binds ctx to the transaction of m, if any
+func (m *MongoDriver) sessionContext(ctx context.Context) context.Context {
+	if m.session != nil {
+		return mongo.NewSessionContext(ctx, m.session)
+	}
+	return ctx
+}
+
+// WithTransaction runs fn in a multi-document transaction, which mongo
+// retries on conflicts
+func (m *MongoDriver) WithTransaction(ctx context.Context, fn func(tx DatabaseClient) error) error {
+	if m.session != nil {
+		return fn(m)
+	}
+	session, err := m.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		return nil, fn(&MongoDriver{Client: m.Client, session: sc})
+	})
+	return err
 }
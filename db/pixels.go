@@ -0,0 +1,392 @@
+package db
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/snburman/game-server/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Pixel data of assets and maps is stored in a compact binary format and
+// converted from and to the JSON format of clients by the db layer.
+// Documents stored as JSON before the compact format existed are still read.
+//
+// Compact pixel data is a header followed by a body, deflated if that is
+// smaller:
+//
+//	"PXD1" | flags uint8 | width uint16 | height uint16 | body
+//
+// The body is a palette and one palette index per position, row by row.
+// Index 0 is a position without a pixel, indexes are one byte for palettes
+// of at most 255 entries and two bytes otherwise:
+//
+//	count uint16 | count * (r, g, b, a uint8 | len uint8 | color) | width * height indexes
+//
+// Compact map data is "PXM1" | flags uint8 | body, where the body is the
+// BSON encoded mapData with compact pixel data in every asset. Maps are
+// saved with references to their assets since, see mapRefsMagic.
+const (
+	pixelDataMagic = "PXD1"
+	mapDataMagic   = "PXM1"
+)
+
+const flagDeflated uint8 = 1
+
+// paletteEntry is a pixel without its position
+type paletteEntry struct {
+	R, G, B, A int
+	Color      string
+}
+
+type mapData struct {
+	Assets []PlayerAsset[[]byte] `bson:"assets"`
+}
+
+// encodePixelData returns the data of an asset of assetWidth x assetHeight
+// in the compact format. Pixels must be within the asset, which is at most
+// maxAssetSide wide and high, and have channels that fit in the format.
+func encodePixelData(data PixelData, assetWidth, assetHeight int) ([]byte, error) {
+	if assetWidth < 0 || assetHeight < 0 || assetWidth > maxAssetSide || assetHeight > maxAssetSide {
+		return nil, errors.ErrImageWrongFormat
+	}
+	// the grid covers the pixels, which may leave out empty rows and columns
+	var width, height int
+	for _, row := range data {
+		for _, p := range row {
+			if p.X < 0 || p.Y < 0 || p.X >= assetWidth || p.Y >= assetHeight {
+				return nil, errors.ErrImageWrongFormat
+			}
+			width = max(width, p.X+1)
+			height = max(height, p.Y+1)
+		}
+	}
+
+	var entries []paletteEntry
+	indexes := make(map[paletteEntry]int)
+	grid := make([]int, width*height)
+	for _, row := range data {
+		for _, p := range row {
+			entry := paletteEntry{R: p.R, G: p.G, B: p.B, A: p.A, Color: p.Color}
+			i, ok := indexes[entry]
+			if !ok {
+				if !validChannels(entry) {
+					return nil, errors.ErrImageWrongFormat
+				}
+				entries = append(entries, entry)
+				i = len(entries)
+				indexes[entry] = i
+			}
+			// later pixels at a position replace earlier ones
+			grid[p.Y*width+p.X] = i
+		}
+	}
+	// the palette is ordered by first use in the grid, so the same
+	// pixels encode the same in any order
+	palette := []paletteEntry{}
+	order := make([]int, len(entries)+1)
+	for j, i := range grid {
+		if i == 0 {
+			continue
+		}
+		if order[i] == 0 {
+			if len(palette) == math.MaxUint16-1 {
+				return nil, errors.ErrImageWrongFormat
+			}
+			palette = append(palette, entries[i-1])
+			order[i] = len(palette)
+		}
+		grid[j] = order[i]
+	}
+
+	var body bytes.Buffer
+	binary.Write(&body, binary.LittleEndian, uint16(len(palette)))
+	for _, e := range palette {
+		body.Write([]byte{uint8(e.R), uint8(e.G), uint8(e.B), uint8(e.A), uint8(len(e.Color))})
+		body.WriteString(e.Color)
+	}
+	wide := len(palette) > math.MaxUint8-1
+	for _, i := range grid {
+		if wide {
+			binary.Write(&body, binary.LittleEndian, uint16(i))
+		} else {
+			body.WriteByte(uint8(i))
+		}
+	}
+
+	header := []byte(pixelDataMagic)
+	header = append(header, 0)
+	header = binary.LittleEndian.AppendUint16(header, uint16(width))
+	header = binary.LittleEndian.AppendUint16(header, uint16(height))
+	return withBody(header, len(pixelDataMagic), body.Bytes())
+}
+
+// decodePixelData reads compact or JSON pixel data. Compact data is
+// returned as one row per y with the pixels of the row ordered by x.
+func decodePixelData(b []byte) (PixelData, error) {
+	if !bytes.HasPrefix(b, []byte(pixelDataMagic)) {
+		var data PixelData
+		if err := json.Unmarshal(b, &data); err != nil {
+			return nil, errors.ErrImageWrongFormat
+		}
+		return data, nil
+	}
+	const headerSize = len(pixelDataMagic) + 5
+	if len(b) < headerSize {
+		return nil, errors.ErrImageWrongFormat
+	}
+	width := int(binary.LittleEndian.Uint16(b[len(pixelDataMagic)+1:]))
+	height := int(binary.LittleEndian.Uint16(b[len(pixelDataMagic)+3:]))
+	body, err := readBody(b[len(pixelDataMagic)], b[headerSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(body)
+	var count uint16
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, errors.ErrImageWrongFormat
+	}
+	palette := make([]paletteEntry, count)
+	for i := range palette {
+		var fixed [5]byte
+		if _, err := io.ReadFull(r, fixed[:]); err != nil {
+			return nil, errors.ErrImageWrongFormat
+		}
+		color := make([]byte, fixed[4])
+		if _, err := io.ReadFull(r, color); err != nil {
+			return nil, errors.ErrImageWrongFormat
+		}
+		palette[i] = paletteEntry{
+			R:     int(fixed[0]),
+			G:     int(fixed[1]),
+			B:     int(fixed[2]),
+			A:     int(fixed[3]),
+			Color: string(color),
+		}
+	}
+
+	wide := len(palette) > math.MaxUint8-1
+	data := make(PixelData, height)
+	for y := range data {
+		data[y] = []Pixel{}
+		for x := 0; x < width; x++ {
+			var i int
+			if wide {
+				var i16 uint16
+				if err := binary.Read(r, binary.LittleEndian, &i16); err != nil {
+					return nil, errors.ErrImageWrongFormat
+				}
+				i = int(i16)
+			} else {
+				i8, err := r.ReadByte()
+				if err != nil {
+					return nil, errors.ErrImageWrongFormat
+				}
+				i = int(i8)
+			}
+			if i == 0 {
+				continue
+			}
+			if i > len(palette) {
+				return nil, errors.ErrImageWrongFormat
+			}
+			e := palette[i-1]
+			data[y] = append(data[y], Pixel{X: x, Y: y, R: e.R, G: e.G, B: e.B, A: e.A, Color: e.Color})
+		}
+	}
+	return data, nil
+}
+
+// encodeMapData returns the assets of a map in the compact format
+func encodeMapData(assets []PlayerAsset[PixelData]) ([]byte, error) {
+	doc := mapData{Assets: make([]PlayerAsset[[]byte], 0, len(assets))}
+	for _, a := range assets {
+		b, err := encodeAsset(a)
+		if err != nil {
+			return nil, errors.ErrMapWrongFormat
+		}
+		doc.Assets = append(doc.Assets, b)
+	}
+	body, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte(mapDataMagic), 0)
+	return withBody(header, len(mapDataMagic), body)
+}
+
+// decodeMapData reads compact or JSON map data
+func decodeMapData(b []byte) ([]PlayerAsset[PixelData], error) {
+	if !bytes.HasPrefix(b, []byte(mapDataMagic)) {
+		var assets []PlayerAsset[PixelData]
+		if err := json.Unmarshal(b, &assets); err != nil {
+			return nil, errors.ErrMapWrongFormat
+		}
+		return assets, nil
+	}
+	const headerSize = len(mapDataMagic) + 1
+	if len(b) < headerSize {
+		return nil, errors.ErrMapWrongFormat
+	}
+	body, err := readBody(b[len(mapDataMagic)], b[headerSize:])
+	if err != nil {
+		return nil, errors.ErrMapWrongFormat
+	}
+	var doc mapData
+	if err := bson.Unmarshal(body, &doc); err != nil {
+		return nil, errors.ErrMapWrongFormat
+	}
+	assets := make([]PlayerAsset[PixelData], 0, len(doc.Assets))
+	for _, a := range doc.Assets {
+		asset, err := decodeAsset(a)
+		if err != nil {
+			return nil, errors.ErrMapWrongFormat
+		}
+		assets = append(assets, asset)
+	}
+	return assets, nil
+}
+
+// withBody appends body to header, deflated if that is smaller, and sets
+// the flag byte at flags of header accordingly
+func withBody(header []byte, flags int, body []byte) ([]byte, error) {
+	var deflated bytes.Buffer
+	w, err := flate.NewWriter(&deflated, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if deflated.Len() < len(body) {
+		header[flags] |= flagDeflated
+		return append(header, deflated.Bytes()...), nil
+	}
+	return append(header, body...), nil
+}
+
+func readBody(flags uint8, body []byte) ([]byte, error) {
+	if flags&flagDeflated == 0 {
+		return body, nil
+	}
+	inflated, err := io.ReadAll(flate.NewReader(bytes.NewReader(body)))
+	if err != nil {
+		return nil, errors.ErrImageWrongFormat
+	}
+	return inflated, nil
+}
+
+func validChannels(e paletteEntry) bool {
+	for _, c := range []int{e.R, e.G, e.B, e.A} {
+		if c < 0 || c > math.MaxUint8 {
+			return false
+		}
+	}
+	return len(e.Color) <= math.MaxUint8
+}
+
+// fitLegacyAsset prepares an asset stored before sizes were validated for
+// encodePixelData. Pixels outside of the largest asset are dropped and the
+// size of a grows to cover the others.
+func fitLegacyAsset(a *PlayerAsset[PixelData]) {
+	a.Width = min(max(a.Width, 0), maxAssetSide)
+	a.Height = min(max(a.Height, 0), maxAssetSide)
+	fit := func(data PixelData) PixelData {
+		fitted := make(PixelData, 0, len(data))
+		for _, row := range data {
+			kept := []Pixel{}
+			for _, p := range row {
+				if p.X < 0 || p.Y < 0 || p.X >= maxAssetSide || p.Y >= maxAssetSide {
+					continue
+				}
+				a.Width = max(a.Width, p.X+1)
+				a.Height = max(a.Height, p.Y+1)
+				kept = append(kept, p)
+			}
+			fitted = append(fitted, kept)
+		}
+		return fitted
+	}
+	a.Data = fit(a.Data)
+	for i := range a.Frames {
+		a.Frames[i] = fit(a.Frames[i])
+	}
+}
+
+// compactPixelData converts the JSON data of maps, assets and revisions to
+// the compact format
+func compactPixelData(ctx context.Context, db DatabaseClient) error {
+	type document struct {
+		ID        primitive.ObjectID `bson:"_id"`
+		UpdatedAt time.Time          `bson:"updated_at"`
+		Width     int                `bson:"width"`
+		Height    int                `bson:"height"`
+		Data      []byte             `bson:"data"`
+	}
+	compactMap := func(doc document) ([]byte, error) {
+		assets, err := decodeMapData(doc.Data)
+		if err != nil {
+			return nil, err
+		}
+		for i := range assets {
+			fitLegacyAsset(&assets[i])
+		}
+		return encodeMapData(assets)
+	}
+	compactAsset := func(doc document) ([]byte, error) {
+		data, err := decodePixelData(doc.Data)
+		if err != nil {
+			return nil, err
+		}
+		// only the data is converted, the size stays as it was
+		a := PlayerAsset[PixelData]{Width: doc.Width, Height: doc.Height, Data: data}
+		fitLegacyAsset(&a)
+		return encodePixelData(a.Data, a.Width, a.Height)
+	}
+	tables := []struct {
+		opts   DatabaseClientOptions
+		encode func(document) ([]byte, error)
+	}{
+		{mapsDBOptions, compactMap},
+		{assetDBOptions, compactAsset},
+		{revisionDBOptions, compactMap},
+	}
+	for _, table := range tables {
+		opts := table.opts
+		opts.IncludeDeleted = true
+		var docs []document
+		if err := db.Get(ctx, bson.M{}, opts, &docs); err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			if bytes.HasPrefix(doc.Data, []byte(pixelDataMagic)) || bytes.HasPrefix(doc.Data, []byte(mapDataMagic)) {
+				continue
+			}
+			data, err := table.encode(doc)
+			if err != nil {
+				return fmt.Errorf("converting data of %s %s: %w", opts.Table, doc.ID.Hex(), err)
+			}
+			update := Update{}.Set("data", data)
+			// converting is not an update of the document
+			if !doc.UpdatedAt.IsZero() {
+				update = update.Set("updated_at", doc.UpdatedAt)
+			}
+			if _, err := db.UpdateOne(ctx, doc.ID.Hex(), update, opts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
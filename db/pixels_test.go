@@ -0,0 +1,117 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/snburman/game-server/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func createMockPixelData(width, height int, colors int) PixelData {
+	data := make(PixelData, height)
+	for y := range data {
+		data[y] = []Pixel{}
+		for x := 0; x < width; x++ {
+			c := (y*width + x) % colors
+			data[y] = append(data[y], Pixel{
+				X: x, Y: y,
+				R: c % 256, G: c / 256, B: 10, A: 255,
+				Color: fmt.Sprintf("#%02x%02x0aff", c%256, c/256),
+			})
+		}
+	}
+	return data
+}
+
+func TestPixelDataRoundTrip(t *testing.T) {
+	tests := map[string]PixelData{
+		"empty":        {},
+		"small":        createMockPixelData(4, 3, 2),
+		"wide-palette": createMockPixelData(32, 32, 1000),
+		"sparse": {
+			{{X: 2, Y: 0, R: 1, G: 2, B: 3, A: 4, Color: "c"}},
+			{},
+			{{X: 0, Y: 2, R: 255, A: 128}},
+		},
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			b, err := encodePixelData(data, 32, 32)
+			assert.Nil(t, err)
+			assert.True(t, bytes.HasPrefix(b, []byte(pixelDataMagic)))
+
+			decoded, err := decodePixelData(b)
+			assert.Nil(t, err)
+			assert.Equal(t, data, decoded)
+		})
+	}
+}
+
+func TestPixelDataCompact(t *testing.T) {
+	data := createMockPixelData(32, 32, 4)
+	legacy, err := json.Marshal(data)
+	assert.Nil(t, err)
+	b, err := encodePixelData(data, 32, 32)
+	assert.Nil(t, err)
+	assert.Less(t, len(b)*10, len(legacy))
+}
+
+func TestPixelDataLegacy(t *testing.T) {
+	data := createMockPixelData(2, 2, 2)
+	legacy, err := json.Marshal(data)
+	assert.Nil(t, err)
+
+	decoded, err := decodePixelData(legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, data, decoded)
+
+	_, err = decodePixelData([]byte("wrong_data"))
+	assert.Equal(t, errors.ErrImageWrongFormat, err)
+}
+
+func TestPixelDataInvalid(t *testing.T) {
+	for name, p := range map[string]Pixel{
+		"negative-position": {X: -1},
+		"outside-asset":     {X: 6000, Y: 6000},
+		"channel-overflow":  {R: 256},
+		"negative-channel":  {A: -1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := encodePixelData(PixelData{{p}}, 1, 1)
+			assert.Equal(t, errors.ErrImageWrongFormat, err)
+		})
+	}
+
+	_, err := encodePixelData(PixelData{}, maxAssetSide+1, 1)
+	assert.Equal(t, errors.ErrImageWrongFormat, err)
+
+	b, err := encodePixelData(createMockPixelData(2, 2, 2), 2, 2)
+	assert.Nil(t, err)
+	_, err = decodePixelData(b[:len(b)-1])
+	assert.Equal(t, errors.ErrImageWrongFormat, err)
+}
+
+func TestMapDataRoundTrip(t *testing.T) {
+	assets := []PlayerAsset[PixelData]{
+		{Name: "tree", AssetType: ASSET_OBJECT, X: 16, Y: 32, Width: 4, Height: 3, Data: createMockPixelData(4, 3, 2)},
+		{Name: "grass", AssetType: ASSET_TILE, Data: PixelData{}},
+	}
+	legacy, err := json.Marshal(assets)
+	assert.Nil(t, err)
+
+	b, err := encodeMapData(assets)
+	assert.Nil(t, err)
+	assert.True(t, bytes.HasPrefix(b, []byte(mapDataMagic)))
+	decoded, err := decodeMapData(b)
+	assert.Nil(t, err)
+	compact, err := json.Marshal(decoded)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(legacy), string(compact))
+
+	decoded, err = decodeMapData(legacy)
+	assert.Nil(t, err)
+	assert.Len(t, decoded, 2)
+}
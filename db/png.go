@@ -0,0 +1,82 @@
+package db
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// RenderPixelData draws data into a width x height image.
+// If width or height is 0 the size is derived from the pixels.
+// Pixels outside the bounds are ignored.
+func RenderPixelData(data PixelData, width, height int) *image.NRGBA {
+	if width <= 0 || height <= 0 {
+		for _, row := range data {
+			for _, p := range row {
+				width = max(width, p.X+1)
+				height = max(height, p.Y+1)
+			}
+		}
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for _, row := range data {
+		for _, p := range row {
+			img.SetNRGBA(p.X, p.Y, color.NRGBA{
+				R: uint8(p.R),
+				G: uint8(p.G),
+				B: uint8(p.B),
+				A: uint8(p.A),
+			})
+		}
+	}
+	return img
+}
+
+// EncodePNG writes data to w as a PNG image
+func EncodePNG(w io.Writer, data PixelData, width, height int) error {
+	return png.Encode(w, RenderPixelData(data, width, height))
+}
+
+// EncodeScaledPNG writes data to w as a PNG image with every pixel drawn
+// as a square of scale x scale
+func EncodeScaledPNG(w io.Writer, data PixelData, width, height, scale int) error {
+	img := RenderPixelData(data, width, height)
+	if scale <= 1 {
+		return png.Encode(w, img)
+	}
+	bounds := img.Bounds()
+	scaled := image.NewNRGBA(image.Rect(0, 0, bounds.Dx()*scale, bounds.Dy()*scale))
+	for y := 0; y < scaled.Rect.Dy(); y++ {
+		for x := 0; x < scaled.Rect.Dx(); x++ {
+			scaled.SetNRGBA(x, y, img.NRGBAAt(x/scale, y/scale))
+		}
+	}
+	return png.Encode(w, scaled)
+}
+
+// ImagePixelData converts img to pixel data with one row per y. Fully
+// transparent pixels are left out and positions start at 0 whatever the
+// bounds of img.
+func ImagePixelData(img image.Image) PixelData {
+	bounds := img.Bounds()
+	data := make(PixelData, bounds.Dy())
+	for y := range data {
+		data[y] = []Pixel{}
+		for x := 0; x < bounds.Dx(); x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			if c.A == 0 {
+				continue
+			}
+			data[y] = append(data[y], Pixel{
+				X: x,
+				Y: y,
+				R: int(c.R),
+				G: int(c.G),
+				B: int(c.B),
+				A: int(c.A),
+			})
+		}
+	}
+	return data
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImagePixelData(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(10, 20, 13, 22))
+	img.SetNRGBA(10, 20, color.NRGBA{R: 255, A: 255})
+	img.SetNRGBA(12, 21, color.NRGBA{G: 128, B: 64, A: 100})
+
+	data := ImagePixelData(img)
+	assert.Equal(t, PixelData{
+		{{X: 0, Y: 0, R: 255, A: 255}},
+		{{X: 2, Y: 1, G: 128, B: 64, A: 100}},
+	}, data)
+
+	// rendering restores the image at the origin
+	rendered := RenderPixelData(data, 3, 2)
+	assert.Equal(t, img.Pix, rendered.Pix)
+}
+
+func TestEncodeScaledPNG(t *testing.T) {
+	data := PixelData{{{X: 1, Y: 0, R: 255, A: 255}}}
+	var buf bytes.Buffer
+	assert.Nil(t, EncodeScaledPNG(&buf, data, 2, 1, 4))
+
+	img, err := png.Decode(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 8, 4), img.Bounds())
+	_, _, _, a := img.At(3, 3).RGBA()
+	assert.Equal(t, uint32(0), a)
+	r, _, _, _ := img.At(4, 3).RGBA()
+	assert.Equal(t, uint32(0xffff), r)
+}
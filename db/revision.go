@@ -0,0 +1,243 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var revisionDBOptions = DatabaseClientOptions{
+	Database: GameDatabase,
+	Table:    MapRevisionsCollection,
+}
+
+// MapRevision is the immutable content of a map at one of its versions.
+// Every save of a map adds a revision, rolling back adds one too.
+type MapRevision[T any] struct {
+	ID    primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	MapID string             `json:"map_id" bson:"map_id"`
+	// UserID owns the map, Author saved the revision
+	UserID    string    `json:"user_id" bson:"user_id"`
+	Author    string    `json:"author" bson:"author"`
+	Message   string    `json:"message" bson:"message"`
+	Version   int       `json:"version" bson:"version"`
+	Entrance  Entrance  `json:"entrance" bson:"entrance"`
+	Portals   []Portal  `json:"portals" bson:"portals"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	Data      T         `json:"data" bson:"data"`
+}
+
+// PlacedAsset is an asset at a position of a map
+type PlacedAsset struct {
+	Name      string    `json:"name"`
+	AssetType AssetType `json:"asset_type"`
+	X         int       `json:"x"`
+	Y         int       `json:"y"`
+}
+
+// MovedAsset is an asset placed at another position between two revisions
+type MovedAsset struct {
+	Name      string    `json:"name"`
+	AssetType AssetType `json:"asset_type"`
+	FromX     int       `json:"from_x"`
+	FromY     int       `json:"from_y"`
+	X         int       `json:"x"`
+	Y         int       `json:"y"`
+}
+
+// MapDiff lists the asset changes from one revision of a map to another
+type MapDiff struct {
+	From    int           `json:"from"`
+	To      int           `json:"to"`
+	Added   []PlacedAsset `json:"added"`
+	Removed []PlacedAsset `json:"removed"`
+	Moved   []MovedAsset  `json:"moved"`
+}
+
+// createMapRevision saves the content of bm as the revision at its version
+func createMapRevision(ctx context.Context, db DatabaseClient, bm Map[[]byte], author string, message string) error {
+	_, err := db.CreateOne(ctx, MapRevision[[]byte]{
+		MapID:    bm.ID.Hex(),
+		UserID:   bm.UserID,
+		Author:   author,
+		Message:  message,
+		Version:  bm.Version,
+		Entrance: bm.Entrance,
+		Portals:  bm.Portals,
+		Data:     bm.Data,
+	}, revisionDBOptions)
+	return err
+}
+
+// GetMapRevisions retrieves the revisions of the map with mapID without
+// their data, newest first
+func GetMapRevisions(ctx context.Context, db DatabaseClient, mapID string) ([]MapRevision[[]PlayerAsset[PixelData]], error) {
+	opts := revisionDBOptions
+	opts.Sort = bson.D{{Key: "version", Value: -1}}
+	opts.Omit = []string{"data"}
+	var byteRevisions []MapRevision[[]byte]
+	if err := db.Get(ctx, bson.M{"map_id": mapID}, opts, &byteRevisions); err != nil {
+		return nil, err
+	}
+	revisions := []MapRevision[[]PlayerAsset[PixelData]]{}
+	for _, br := range byteRevisions {
+		revisions = append(revisions, revisionWithoutData(br))
+	}
+	return revisions, nil
+}
+
+// GetMapRevision retrieves the revision at version of the map with mapID
+func GetMapRevision(ctx context.Context, db DatabaseClient, mapID string, version int) (MapRevision[[]PlayerAsset[PixelData]], error) {
+	var revision MapRevision[[]PlayerAsset[PixelData]]
+	res, err := db.GetOne(ctx, bson.M{"map_id": mapID, "version": version}, revisionDBOptions)
+	if err == mongo.ErrNoDocuments {
+		return revision, errors.ErrRevisionNotFound
+	} else if err != nil {
+		return revision, err
+	}
+	var br MapRevision[[]byte]
+	if err := utils.UnmarshalBSON(res, &br); err != nil {
+		return revision, errors.ErrMapWrongFormat
+	}
+	revision = revisionWithoutData(br)
+	loaded, err := loadMapData(ctx, db, br.Data)
+	if err != nil {
+		return revision, err
+	}
+	revision.Data = loaded[0]
+	return revision, nil
+}
+
+// DiffMapRevisions compares the assets of the map with mapID at two versions.
+// Assets at the same position in both are unchanged, an asset missing from
+// one position and added at another is moved.
+func DiffMapRevisions(ctx context.Context, db DatabaseClient, mapID string, from int, to int) (MapDiff, error) {
+	diff := MapDiff{
+		From:    from,
+		To:      to,
+		Added:   []PlacedAsset{},
+		Removed: []PlacedAsset{},
+		Moved:   []MovedAsset{},
+	}
+	before, err := GetMapRevision(ctx, db, mapID, from)
+	if err != nil {
+		return diff, err
+	}
+	after, err := GetMapRevision(ctx, db, mapID, to)
+	if err != nil {
+		return diff, err
+	}
+
+	removed := placedAssets(before.Data)
+	var added []PlacedAsset
+	for _, p := range placedAssets(after.Data) {
+		if i := slices.Index(removed, p); i >= 0 {
+			removed = slices.Delete(removed, i, i+1)
+			continue
+		}
+		added = append(added, p)
+	}
+	for _, p := range added {
+		i := slices.IndexFunc(removed, func(r PlacedAsset) bool {
+			return r.Name == p.Name && r.AssetType == p.AssetType
+		})
+		if i < 0 {
+			diff.Added = append(diff.Added, p)
+			continue
+		}
+		diff.Moved = append(diff.Moved, MovedAsset{
+			Name:      p.Name,
+			AssetType: p.AssetType,
+			FromX:     removed[i].X,
+			FromY:     removed[i].Y,
+			X:         p.X,
+			Y:         p.Y,
+		})
+		removed = slices.Delete(removed, i, i+1)
+	}
+	diff.Removed = append(diff.Removed, removed...)
+	return diff, nil
+}
+
+// RollbackMap saves the content of the revision at version of the map with
+// ID as a new revision and returns the new version of the map. A stale
+// p.Version returns the current version and ErrVersionConflict, the
+// content fields of p are ignored.
+func RollbackMap(ctx context.Context, db DatabaseClient, ID string, version int, p MapPatch) (int, error) {
+	revision, err := GetMapRevision(ctx, db, ID, version)
+	if err != nil {
+		return 0, err
+	}
+	data, err := json.Marshal(revision.Data)
+	if err != nil {
+		return 0, errors.ErrMapWrongFormat
+	}
+	encoded := string(data)
+	p.Entrance = &revision.Entrance
+	p.Portals = &revision.Portals
+	p.Data = &encoded
+	if p.Message == "" {
+		p.Message = fmt.Sprintf("rollback to revision %d", version)
+	}
+	return UpdateMap(ctx, db, ID, p)
+}
+
+// backfillMapRevisions saves the current content of maps created before
+// revisions existed as their first revision
+func backfillMapRevisions(ctx context.Context, db DatabaseClient) error {
+	opts := mapsDBOptions
+	opts.IncludeDeleted = true
+	var byteMaps []Map[[]byte]
+	if err := db.Get(ctx, bson.M{}, opts, &byteMaps); err != nil {
+		return err
+	}
+	for _, bm := range byteMaps {
+		_, err := db.GetOne(ctx, bson.M{"map_id": bm.ID.Hex(), "version": bm.Version}, revisionDBOptions)
+		if err == nil {
+			continue
+		} else if err != mongo.ErrNoDocuments {
+			return err
+		}
+		if err := createMapRevision(ctx, db, bm, bm.UserID, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// placedAssets lists the name, type and position of the assets of a map
+func placedAssets(data []PlayerAsset[PixelData]) []PlacedAsset {
+	placed := make([]PlacedAsset, 0, len(data))
+	for _, a := range data {
+		placed = append(placed, PlacedAsset{
+			Name:      a.Name,
+			AssetType: a.AssetType,
+			X:         a.X,
+			Y:         a.Y,
+		})
+	}
+	return placed
+}
+
+// revisionWithoutData copies every field of br except Data
+func revisionWithoutData(br MapRevision[[]byte]) MapRevision[[]PlayerAsset[PixelData]] {
+	return MapRevision[[]PlayerAsset[PixelData]]{
+		ID:        br.ID,
+		MapID:     br.MapID,
+		UserID:    br.UserID,
+		Author:    br.Author,
+		Message:   br.Message,
+		Version:   br.Version,
+		Entrance:  br.Entrance,
+		Portals:   br.Portals,
+		CreatedAt: br.CreatedAt,
+	}
+}
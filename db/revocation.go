@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var revocationDBOptions = DatabaseClientOptions{
+	Database: GameDatabase,
+	Table:    TokenRevocationsCollection,
+}
+
+// TokenRevocation invalidates every token of a user issued at or before RevokedAt
+type TokenRevocation struct {
+	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID    string             `json:"user_id" bson:"user_id"`
+	RevokedAt time.Time          `json:"revoked_at" bson:"revoked_at"`
+}
+
+// revocations caches the latest revocation per user so
+// tokens can be checked without a database round trip
+var revocations = struct {
+	mu   sync.RWMutex
+	byID map[string]time.Time
+	// loadedAt is when LoadTokenRevocations last read the database
+	loadedAt time.Time
+}{
+	byID: make(map[string]time.Time),
+}
+
+// revocationClockSkew is how far the clocks of servers sharing the
+// database may differ. Loads overlap by it so no revocation is missed.
+const revocationClockSkew = time.Minute
+
+func cacheRevocation(userID string, revokedAt time.Time) {
+	revocations.mu.Lock()
+	defer revocations.mu.Unlock()
+	if revokedAt.After(revocations.byID[userID]) {
+		revocations.byID[userID] = revokedAt
+	}
+}
+
+// RevokeUserTokens invalidates all tokens issued to userID so far
+func RevokeUserTokens(ctx context.Context, db DatabaseClient, userID string) error {
+	// the precision of stored times and of the iat_ms claim of tokens
+	revokedAt := time.Now().UTC().Truncate(time.Millisecond)
+	_, err := db.CreateOne(ctx, TokenRevocation{
+		UserID:    userID,
+		RevokedAt: revokedAt,
+	}, revocationDBOptions)
+	if err != nil {
+		return err
+	}
+	cacheRevocation(userID, revokedAt)
+	return nil
+}
+
+// TokenRevoked reports whether a token of userID issued at issuedAt was revoked
+func TokenRevoked(userID string, issuedAt time.Time) bool {
+	revocations.mu.RLock()
+	defer revocations.mu.RUnlock()
+	revokedAt, ok := revocations.byID[userID]
+	return ok && !issuedAt.After(revokedAt)
+}
+
+// LoadTokenRevocations fills the revocation cache from the database.
+// The first load reads every revocation, later ones only those made
+// since, so servers sharing the database see each other's revocations
+// by loading them periodically.
+func LoadTokenRevocations(ctx context.Context, db DatabaseClient) error {
+	now := time.Now().UTC()
+	revocations.mu.RLock()
+	since := revocations.loadedAt
+	revocations.mu.RUnlock()
+	filter := bson.M{}
+	if !since.IsZero() {
+		filter["revoked_at"] = bson.M{"$gte": since.Add(-revocationClockSkew)}
+	}
+	stored := []TokenRevocation{}
+	if err := db.Get(ctx, filter, revocationDBOptions, &stored); err != nil {
+		return err
+	}
+	for _, r := range stored {
+		cacheRevocation(r.UserID, r.RevokedAt)
+	}
+	revocations.mu.Lock()
+	defer revocations.mu.Unlock()
+	revocations.loadedAt = now
+	return nil
+}
+
+// PurgeTokenRevocations removes revocations older than before.
+// before should be at least the lifetime of the longest lived token.
+func PurgeTokenRevocations(ctx context.Context, db DatabaseClient, before time.Time) error {
+	_, err := db.DeleteMany(ctx, bson.M{"revoked_at": bson.M{"$lt": before}}, revocationDBOptions)
+	if err != nil {
+		return err
+	}
+	revocations.mu.Lock()
+	defer revocations.mu.Unlock()
+	for userID, revokedAt := range revocations.byID {
+		if revokedAt.Before(before) {
+			delete(revocations.byID, userID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var revocationSource = "game.token_revocations"
+
+func TestRevokeUserTokens(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("success", func(mt *mtest.T) {
+		issuedAt := time.Now().Add(-time.Minute)
+		// arrange for success
+		mt.AddMockResponses(
+			SuccessResponse,
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := RevokeUserTokens(context.Background(), driver, MockID)
+
+		// assert
+		assert.Nil(t, err)
+		assert.True(t, TokenRevoked(MockID, issuedAt))
+		assert.True(t, TokenRevoked(MockID, time.Time{}))
+		assert.False(t, TokenRevoked(MockID, time.Now().Add(time.Minute)))
+		// tokens issued right after are valid, such as on login after a password reset
+		time.Sleep(2 * time.Millisecond)
+		assert.False(t, TokenRevoked(MockID, time.Now().Truncate(time.Millisecond)))
+		assert.False(t, TokenRevoked("other", issuedAt))
+	})
+}
+
+func TestLoadTokenRevocations(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("success", func(mt *mtest.T) {
+		issuedAt := time.Now().Add(-time.Minute)
+		// arrange for success
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, revocationSource, mtest.FirstBatch),
+			// revoked by another server since the first load
+			mtest.CreateCursorResponse(0, revocationSource, mtest.FirstBatch, bson.D{
+				{Key: "user_id", Value: "remote"},
+				{Key: "revoked_at", Value: time.Now()},
+			}),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := LoadTokenRevocations(context.Background(), driver)
+		assert.Nil(t, err)
+		assert.False(t, TokenRevoked("remote", issuedAt))
+		err = LoadTokenRevocations(context.Background(), driver)
+
+		// assert
+		assert.Nil(t, err)
+		assert.True(t, TokenRevoked("remote", issuedAt))
+	})
+}
+
+func TestPurgeTokenRevocations(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("success", func(mt *mtest.T) {
+		userID := "purged"
+		cacheRevocation(userID, time.Now().Add(-time.Hour))
+		// arrange for success
+		mt.AddMockResponses(
+			SuccessResponse,
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := PurgeTokenRevocations(context.Background(), driver, time.Now())
+
+		// assert
+		assert.Nil(t, err)
+		assert.False(t, TokenRevoked(userID, time.Time{}))
+	})
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"slices"
+
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var settingsDBOptions = DatabaseClientOptions{
+	Database: GameDatabase,
+	Table:    SettingsCollection,
+}
+
+// Settings holds server wide settings managed by the admin.
+// There is at most one settings document.
+type Settings struct {
+	ID               primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	MFARequiredRoles []Role             `json:"mfa_required_roles" bson:"mfa_required_roles"`
+}
+
+// GetSettings returns the stored settings or empty settings if none exist
+func GetSettings(ctx context.Context, db DatabaseClient) (Settings, error) {
+	var settings Settings
+	res, err := db.GetOne(ctx, bson.M{}, settingsDBOptions)
+	if err == mongo.ErrNoDocuments {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	if err = utils.UnmarshalBSON(res, &settings); err != nil {
+		return settings, err
+	}
+	return settings, nil
+}
+
+// SetMFARequiredRoles replaces the roles that must use MFA. Tokens of
+// users in the roles without MFA are revoked, they have to enroll.
+func SetMFARequiredRoles(ctx context.Context, db DatabaseClient, roles []Role) error {
+	for _, role := range roles {
+		if !slices.Contains(Roles, role) {
+			return errors.ErrInvalidRole
+		}
+	}
+	settings, err := GetSettings(ctx, db)
+	if err != nil {
+		return err
+	}
+	settings.MFARequiredRoles = roles
+	if settings.ID.IsZero() {
+		_, err = db.CreateOne(ctx, settings, settingsDBOptions)
+	} else {
+		_, err = db.UpdateOne(ctx, settings.ID.Hex(), Update{}.Set("mfa_required_roles", roles), settingsDBOptions)
+	}
+	if err != nil || len(roles) == 0 {
+		return err
+	}
+
+	var users []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	filter := bson.M{"role": bson.M{"$in": roles}, "mfa_enabled": bson.M{"$ne": true}}
+	if err := db.Get(ctx, filter, userDBOptions, &users); err != nil {
+		return err
+	}
+	for _, u := range users {
+		if err := RevokeUserTokens(ctx, db, u.ID.Hex()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RoleRequiresMFA reports whether users with role must use MFA
+func (s Settings) RoleRequiresMFA(role Role) bool {
+	return slices.Contains(s.MFARequiredRoles, role)
+}
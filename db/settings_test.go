@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/snburman/game-server/errors"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var settingsSource = "game.settings"
+
+func TestGetSettings(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("success", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				settingsSource,
+				mtest.FirstBatch,
+				bson.D{
+					{Key: "_id", Value: primitive.NewObjectID()},
+					{Key: "mfa_required_roles", Value: bson.A{CreatorRole}},
+				},
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		settings, err := GetSettings(context.Background(), driver)
+
+		// assert
+		assert.Nil(t, err)
+		assert.True(t, settings.RoleRequiresMFA(CreatorRole))
+		assert.False(t, settings.RoleRequiresMFA(PlayerRole))
+	})
+
+	mt.Run("success-no-settings", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				settingsSource,
+				mtest.FirstBatch,
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		settings, err := GetSettings(context.Background(), driver)
+
+		// assert
+		assert.Nil(t, err)
+		assert.False(t, settings.RoleRequiresMFA(CreatorRole))
+	})
+}
+
+func TestSetMFARequiredRoles(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("success", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			// no existing settings
+			mtest.CreateCursorResponse(
+				0,
+				settingsSource,
+				mtest.FirstBatch,
+			),
+			// insert settings
+			SuccessResponse,
+			// no users without MFA in the roles
+			mtest.CreateCursorResponse(
+				0,
+				userSource,
+				mtest.FirstBatch,
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := SetMFARequiredRoles(context.Background(), driver, []Role{CreatorRole, AdminRole})
+
+		// assert
+		assert.Nil(t, err)
+	})
+
+	mt.Run("failure-invalid-role", func(mt *mtest.T) {
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := SetMFARequiredRoles(context.Background(), driver, []Role{"superuser"})
+
+		// assert
+		assert.Equal(t, err, errors.ErrInvalidRole)
+	})
+}
@@ -0,0 +1,815 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	SQLite   = "sqlite"
+	Postgres = "postgres"
+)
+
+// SQLDriver is a DatabaseClient backed by SQLite or PostgreSQL.
+// Users, assets and maps are stored relationally with a column per field
+// (see sqlRelations). Every other collection is a table holding the BSON
+// encoded document next to indexed key columns (see sqlColumns).
+// Filters, sorts and updates on columns run in SQL. Any other condition
+// is evaluated on the decoded documents like MemoryDriver, which then
+// also sorts and limits them.
+// DatabaseClientOptions.Database is ignored, all tables share one schema.
+type SQLDriver struct {
+	db      *sql.DB
+	dialect sqlDialect
+	// tx is set on drivers bound to a transaction by WithTransaction
+	tx *sql.Tx
+	// relational is set once the schema stores users, assets and maps
+	// relationally, see sqlRelationalVersion
+	relational bool
+}
+
+type sqlDialect struct {
+	name   string
+	driver string
+	serial string
+	blob   string
+	// timestamp is the type of time columns
+	timestamp string
+	// collate compares text byte by byte like MongoDB
+	collate string
+	// lock locks the rows selected, sqlite serializes all connections already
+	lock string
+}
+
+var sqlDialects = map[string]sqlDialect{
+	SQLite: {
+		name:   SQLite,
+		driver: "sqlite",
+		serial: "INTEGER PRIMARY KEY AUTOINCREMENT",
+		blob:   "BLOB",
+		// text in sqlTimeFormat
+		timestamp: "TEXT",
+	},
+	Postgres: {
+		name:      Postgres,
+		driver:    "pgx",
+		serial:    "BIGSERIAL PRIMARY KEY",
+		blob:      "BYTEA",
+		timestamp: "TIMESTAMPTZ",
+		collate:   ` COLLATE "C"`,
+		lock:      " FOR UPDATE",
+	},
+}
+
+// sqlTimeFormat formats times in key columns and in the time columns of
+// sqlite. The fixed width keeps the text in time order.
+const sqlTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// NewSQLDriver opens dsn with dialect SQLite or Postgres.
+// Call Migrate to create or update the schema.
+func NewSQLDriver(dialect string, dsn string) (*SQLDriver, error) {
+	d, ok := sqlDialects[dialect]
+	if !ok {
+		return nil, fmt.Errorf("unknown sql dialect %q", dialect)
+	}
+	conn, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if d.name == SQLite {
+		// sqlite allows a single writer, serialize instead of failing with SQLITE_BUSY
+		conn.SetMaxOpenConns(1)
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s := &SQLDriver{db: conn, dialect: d}
+	// the schema is unversioned until migrated
+	if version, err := s.schemaVersion(context.Background()); err == nil {
+		s.relational = version >= sqlRelationalVersion
+	}
+	return s, nil
+}
+
+func (s *SQLDriver) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLDriver) Get(ctx context.Context, params any, opts DatabaseClientOptions, dest any) error {
+	filter, err := liveFilter(params, opts)
+	if err != nil {
+		return err
+	}
+	destVal := reflect.ValueOf(dest)
+	if destVal.Kind() != reflect.Pointer || destVal.Elem().Kind() != reflect.Slice {
+		return errors.New("dest must be a pointer to a slice")
+	}
+	limit := -1
+	if opts.Limit > 0 {
+		limit = opts.Limit
+	}
+	rows, sorted, err := s.find(ctx, s.queryer(), filter, opts, limit, false)
+	if err != nil {
+		return err
+	}
+	docs := make([]bson.M, len(rows))
+	for i, row := range rows {
+		docs[i] = row.doc
+	}
+	if sorted {
+		opts.Sort = nil
+	}
+	sliceType := destVal.Elem().Type()
+	results := reflect.MakeSlice(sliceType, 0, len(rows))
+	for _, doc := range findOptions(docs, opts) {
+		elem := reflect.New(sliceType.Elem())
+		if err := decodeDocument(doc, elem.Interface()); err != nil {
+			return err
+		}
+		results = reflect.Append(results, elem.Elem())
+	}
+	destVal.Elem().Set(results)
+	return nil
+}
+
+func (s *SQLDriver) GetOne(ctx context.Context, params any, opts DatabaseClientOptions) (any, error) {
+	filter, err := liveFilter(params, opts)
+	if err != nil {
+		return nil, err
+	}
+	// the first match in insertion order like MongoDriver
+	opts.Sort, opts.Omit = nil, nil
+	rows, _, err := s.find(ctx, s.queryer(), filter, opts, 1, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return rows[0].doc, nil
+}
+
+func (s *SQLDriver) CreateOne(ctx context.Context, document any, opts DatabaseClientOptions) (insertedID string, err error) {
+	doc, err := toDocument(document)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	id, ok := doc["_id"].(primitive.ObjectID)
+	if !ok {
+		return "", errors.New("_id must be an ObjectID")
+	}
+	stampCreated(doc, time.Now())
+	if err := s.insert(ctx, s.queryer(), s.table(opts.Table), id.Hex(), doc); err != nil {
+		return "", s.translateError(err, opts)
+	}
+	return id.Hex(), nil
+}
+
+// insert stores doc in a new row of t with id
+func (s *SQLDriver) insert(ctx context.Context, q sqlQueryer, t sqlTable, id string, doc bson.M) error {
+	names := []string{`"id"`}
+	args := []any{id}
+	for _, col := range t.columns {
+		names = append(names, col.ident())
+	}
+	if t.relational {
+		values, err := s.dialect.relationalValues(t, doc)
+		if err != nil {
+			return err
+		}
+		args = append(args, values...)
+	} else {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		for _, col := range t.columns {
+			args = append(args, columnValue(doc, col.field))
+		}
+		names = append(names, `"doc"`)
+		args = append(args, data)
+	}
+	query := fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s)",
+		quoteIdent(t.name),
+		strings.Join(names, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", "),
+	)
+	_, err := q.ExecContext(ctx, s.dialect.rebind(query), args...)
+	return err
+}
+
+func (s *SQLDriver) UpdateOne(ctx context.Context, id string, update Update, opts DatabaseClientOptions) (any, error) {
+	_id, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := liveFilter(bson.M{"_id": _id}, opts)
+	if err != nil {
+		return nil, err
+	}
+	fields, inc, err := updateFields(bson.D(update))
+	if err != nil {
+		return nil, err
+	}
+	count, err := s.update(ctx, filter, fields, inc, opts, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.UpdateResult{
+		MatchedCount:  int64(count),
+		ModifiedCount: int64(count),
+	}, nil
+}
+
+func (s *SQLDriver) UpdateMany(ctx context.Context, params any, update any, opts DatabaseClientOptions) (count int, err error) {
+	filter, err := liveFilter(params, opts)
+	if err != nil {
+		return 0, err
+	}
+	fields, inc, err := updateFields(update)
+	if err != nil {
+		return 0, err
+	}
+	return s.update(ctx, filter, fields, inc, opts, -1)
+}
+
+func (s *SQLDriver) Delete(ctx context.Context, params any, opts DatabaseClientOptions) (count int, err error) {
+	return s.delete(ctx, params, opts, 1)
+}
+
+func (s *SQLDriver) DeleteMany(ctx context.Context, params any, opts DatabaseClientOptions) (count int, err error) {
+	return s.delete(ctx, params, opts, -1)
+}
+
+type sqlRow struct {
+	id  string
+	doc bson.M
+}
+
+// sqlQueryer is implemented by *sql.DB and *sql.Tx
+type sqlQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// find returns up to limit documents matching filter, or all if limit < 0.
+// Documents are ordered by opts.Sort if it only has columns, which sorted
+// reports, and in insertion order otherwise. Filters that run in SQL
+// entirely also leave out the fields of opts.Omit.
+// With lock the rows read are locked until the transaction of q ends.
+func (s *SQLDriver) find(ctx context.Context, q sqlQueryer, filter bson.M, opts DatabaseClientOptions, limit int, lock bool) (found []sqlRow, sorted bool, err error) {
+	t := s.table(opts.Table)
+	where, args, exact := s.dialect.where(filter, t)
+	order, sorted := s.dialect.orderBy(opts.Sort, t)
+	if !sorted {
+		// the caller sorts every match once decoded
+		order, limit = `"seq"`, -1
+	}
+	omit := opts.Omit
+	if !exact {
+		// the rest of the filter may need omitted fields
+		omit = nil
+	}
+	selected := []string{`"id"`}
+	var cols []sqlColumn
+	if t.relational {
+		for _, col := range t.columns {
+			if !slices.Contains(omit, col.field) {
+				cols = append(cols, col)
+				selected = append(selected, col.ident())
+			}
+		}
+	} else {
+		selected = append(selected, `"doc"`)
+	}
+	query := fmt.Sprintf(`SELECT %s FROM %s`, strings.Join(selected, ", "), quoteIdent(t.name))
+	if where != "" {
+		query += " WHERE " + where
+	}
+	query += " ORDER BY " + order
+	if exact && limit >= 0 {
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+	if lock {
+		query += s.dialect.lock
+	}
+	rows, err := q.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() && (limit < 0 || len(found) < limit) {
+		var row sqlRow
+		if t.relational {
+			if row, err = s.dialect.scanRelational(rows, cols); err != nil {
+				return nil, false, err
+			}
+		} else {
+			var data []byte
+			if err := rows.Scan(&row.id, &data); err != nil {
+				return nil, false, err
+			}
+			if row.doc, err = decodeRow(data, omit); err != nil {
+				return nil, false, err
+			}
+		}
+		if exact || matchDocument(row.doc, filter) {
+			found = append(found, row)
+		}
+	}
+	return found, sorted, rows.Err()
+}
+
+// decodeRow decodes a stored document without the top level fields in omit
+func decodeRow(data []byte, omit []string) (bson.M, error) {
+	raw := bson.Raw(data)
+	if len(omit) > 0 {
+		elems, err := raw.Elements()
+		if err != nil {
+			return nil, err
+		}
+		idx, kept := bsoncore.AppendDocumentStart(nil)
+		for _, e := range elems {
+			if !slices.Contains(omit, e.Key()) {
+				kept = append(kept, e...)
+			}
+		}
+		if kept, err = bsoncore.AppendDocumentEnd(kept, idx); err != nil {
+			return nil, err
+		}
+		raw = bson.Raw(kept)
+	}
+	doc := bson.M{}
+	return doc, decodeDocument(raw, &doc)
+}
+
+// update sets fields and increments the fields of inc on up to limit
+// documents matching filter, or all if limit < 0. Encoded documents are
+// locked until they are written so concurrent updates do not overwrite
+// each other, relational rows are updated in SQL.
+func (s *SQLDriver) update(ctx context.Context, filter bson.M, fields bson.M, inc bson.M, opts DatabaseClientOptions, limit int) (count int, err error) {
+	stampUpdated(fields, time.Now())
+	opts.Sort, opts.Omit = nil, nil
+	if t := s.table(opts.Table); t.relational {
+		return s.updateColumns(ctx, t, filter, fields, inc, opts, limit)
+	}
+	err = s.write(ctx, func(tx *sql.Tx) error {
+		rows, _, err := s.find(ctx, tx, filter, opts, limit, true)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			for k, v := range fields {
+				row.doc[k] = v
+			}
+			for k, n := range inc {
+				if row.doc[k], err = increment(row.doc[k], n); err != nil {
+					return err
+				}
+			}
+			if err := s.writeRow(ctx, tx, row, opts); err != nil {
+				return err
+			}
+		}
+		count = len(rows)
+		return nil
+	})
+	return count, err
+}
+
+// updateColumns updates the rows of the relational table t like update
+func (s *SQLDriver) updateColumns(ctx context.Context, t sqlTable, filter bson.M, fields bson.M, inc bson.M, opts DatabaseClientOptions, limit int) (count int, err error) {
+	sets, setArgs, err := s.dialect.relationalSets(t, fields, inc)
+	if err != nil {
+		return 0, err
+	}
+	if len(sets) == 0 {
+		// counts the matches
+		sets = []string{`"id" = "id"`}
+	}
+	err = s.write(ctx, func(tx *sql.Tx) error {
+		where, args, err := s.scope(ctx, tx, t, filter, opts, limit)
+		if err != nil {
+			return err
+		}
+		query := fmt.Sprintf("UPDATE %s SET %s WHERE %s", quoteIdent(t.name), strings.Join(sets, ", "), where)
+		res, err := tx.ExecContext(ctx, s.dialect.rebind(query), append(setArgs, args...)...)
+		if err != nil {
+			return s.translateError(err, opts)
+		}
+		n, err := res.RowsAffected()
+		count = int(n)
+		return err
+	})
+	return count, err
+}
+
+// scope returns the condition selecting up to limit rows of t matching
+// filter, or all if limit < 0, the first ones in insertion order. Rows of
+// filters that do not run in SQL entirely are found and selected by ID.
+func (s *SQLDriver) scope(ctx context.Context, q sqlQueryer, t sqlTable, filter bson.M, opts DatabaseClientOptions, limit int) (string, []any, error) {
+	where, args, exact := s.dialect.where(filter, t)
+	if !exact {
+		rows, _, err := s.find(ctx, q, filter, opts, limit, true)
+		if err != nil || len(rows) == 0 {
+			return "1 = 0", nil, err
+		}
+		ids := make([]any, len(rows))
+		for i, row := range rows {
+			ids[i] = row.id
+		}
+		return `"id" IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + ")", ids, nil
+	}
+	if where == "" {
+		where = "1 = 1"
+	}
+	if limit < 0 {
+		return where, args, nil
+	}
+	return fmt.Sprintf(`"id" IN (SELECT "id" FROM %s WHERE %s ORDER BY "seq" LIMIT %d)`,
+		quoteIdent(t.name), where, limit), args, nil
+}
+
+// writeRow stores row.doc and its key columns in the row with row.id
+func (s *SQLDriver) writeRow(ctx context.Context, tx *sql.Tx, row sqlRow, opts DatabaseClientOptions) error {
+	cols := sqlColumns[opts.Table]
+	sets := make([]string, 0, len(cols)+1)
+	args := make([]any, 0, len(cols)+2)
+	for _, col := range cols {
+		sets = append(sets, quoteIdent(col)+" = ?")
+		args = append(args, columnValue(row.doc, col))
+	}
+	data, err := bson.Marshal(row.doc)
+	if err != nil {
+		return err
+	}
+	sets = append(sets, `"doc" = ?`)
+	args = append(args, data, row.id)
+	query := s.dialect.rebind(fmt.Sprintf(
+		`UPDATE %s SET %s WHERE "id" = ?`,
+		quoteIdent(opts.Table), strings.Join(sets, ", "),
+	))
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return s.translateError(err, opts)
+	}
+	return nil
+}
+
+// delete removes up to limit documents matching params, or all if limit < 0
+func (s *SQLDriver) delete(ctx context.Context, params any, opts DatabaseClientOptions, limit int) (count int, err error) {
+	filter, err := liveFilter(params, opts)
+	if err != nil {
+		return 0, err
+	}
+	opts.Sort, opts.Omit = nil, nil
+	t := s.table(opts.Table)
+	err = s.write(ctx, func(tx *sql.Tx) error {
+		where, args, err := s.scope(ctx, tx, t, filter, opts, limit)
+		if err != nil {
+			return err
+		}
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s", quoteIdent(t.name), where)
+		res, err := tx.ExecContext(ctx, s.dialect.rebind(query), args...)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		count = int(n)
+		return err
+	})
+	return count, err
+}
+
+// queryer returns the transaction s is bound to, or the database
+func (s *SQLDriver) queryer() sqlQueryer {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+// write runs fn in the transaction s is bound to, or in a new one
+// committed when fn succeeds
+func (s *SQLDriver) write(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// maxTransactionAttempts bounds retries of serialization failures
+const maxTransactionAttempts = 3
+
+// WithTransaction runs fn in a transaction. Postgres transactions are
+// serializable and retried if they conflict with a concurrent one,
+// sqlite serializes all connections already.
+func (s *SQLDriver) WithTransaction(ctx context.Context, fn func(tx DatabaseClient) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	opts := &sql.TxOptions{}
+	if s.dialect.name == Postgres {
+		opts.Isolation = sql.LevelSerializable
+	}
+	var err error
+	for attempt := 0; attempt < maxTransactionAttempts; attempt++ {
+		err = s.transaction(ctx, opts, fn)
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.Code != "40001" {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *SQLDriver) transaction(ctx context.Context, opts *sql.TxOptions, fn func(tx DatabaseClient) error) error {
+	tx, err := s.db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(&SQLDriver{db: s.db, dialect: s.dialect, tx: tx, relational: s.relational}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// translateError converts unique and primary key violations to
+// mongo duplicate key errors so callers can use mongo.IsDuplicateKeyError
+func (s *SQLDriver) translateError(err error, opts DatabaseClientOptions) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && (sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE ||
+		sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY) {
+		return duplicateKeyError(opts, err.Error())
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return duplicateKeyError(opts, pgErr.ConstraintName)
+	}
+	return err
+}
+
+// rebind replaces ? placeholders with $n for postgres
+func (d sqlDialect) rebind(query string) string {
+	if d.name != Postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// sqlIntColumns are key columns of integers, compared as numbers
+var sqlIntColumns = []string{"version", "visits"}
+
+// sqlComparisons are the SQL operators of comparison operators
+var sqlComparisons = map[string]string{"$gt": ">", "$gte": ">=", "$lt": "<", "$lte": "<="}
+
+// where narrows filter to conditions on the columns of t. The result may
+// match more rows than filter, never fewer, and exact reports whether it
+// matches the same rows so filter need not be evaluated on documents.
+func (d sqlDialect) where(filter bson.M, t sqlTable) (where string, args []any, exact bool) {
+	var conds []string
+	exact = true
+	for key, value := range filter {
+		cond, condArgs, condExact := d.whereKey(key, value, t)
+		if cond != "" {
+			conds = append(conds, cond)
+			args = append(args, condArgs...)
+		}
+		exact = exact && condExact
+	}
+	return strings.Join(conds, " AND "), args, exact
+}
+
+// whereKey returns the condition of key in a filter, empty if it cannot
+// be narrowed in SQL
+func (d sqlDialect) whereKey(key string, value any, t sqlTable) (string, []any, bool) {
+	switch key {
+	case "$and", "$or":
+		filters, ok := value.(primitive.A)
+		if !ok || len(filters) == 0 {
+			return "", nil, false
+		}
+		var conds []string
+		var args []any
+		exact := true
+		for _, f := range filters {
+			filter, ok := f.(bson.M)
+			if !ok {
+				return "", nil, false
+			}
+			cond, condArgs, condExact := d.where(filter, t)
+			exact = exact && condExact
+			if cond == "" {
+				// a branch matching any row matches them all
+				if key == "$or" {
+					return "", nil, false
+				}
+				continue
+			}
+			conds = append(conds, "("+cond+")")
+			args = append(args, condArgs...)
+		}
+		if key == "$or" {
+			return "(" + strings.Join(conds, " OR ") + ")", args, exact
+		}
+		return strings.Join(conds, " AND "), args, exact
+	}
+
+	col, ok := t.column(key)
+	if !ok {
+		return "", nil, false
+	}
+	ops, ok := value.(bson.M)
+	if !ok {
+		return d.compare(col, "$eq", value)
+	}
+	var conds []string
+	var args []any
+	exact := true
+	for op, v := range ops {
+		cond, condArgs, condExact := d.compare(col, op, v)
+		if cond != "" {
+			conds = append(conds, cond)
+			args = append(args, condArgs...)
+		}
+		exact = exact && condExact
+	}
+	return strings.Join(conds, " AND "), args, exact
+}
+
+// compare returns the condition of comparing col to v with op, empty if
+// it cannot be narrowed in SQL
+func (d sqlDialect) compare(col sqlColumn, op string, v any) (string, []any, bool) {
+	if col.kind != sqlKey && col.kind != sqlKeyInt {
+		return d.compareColumn(col, op, v)
+	}
+	ident := col.ident()
+	switch op {
+	case "$eq", "$ne":
+		if v == nil {
+			if op == "$eq" {
+				return ident + " IS NULL", nil, true
+			}
+			return ident + " IS NOT NULL", nil, true
+		}
+		s, ok := sqlValue(v)
+		if !ok {
+			return "", nil, false
+		}
+		if op == "$eq" {
+			return ident + " = ?", []any{s}, true
+		}
+		return "(" + ident + " IS NULL OR " + ident + " <> ?)", []any{s}, true
+	case "$in":
+		in, ok := v.(primitive.A)
+		if !ok || len(in) == 0 {
+			return "", nil, false
+		}
+		values := make([]any, 0, len(in))
+		for _, v := range in {
+			s, ok := sqlValue(v)
+			if !ok {
+				return "", nil, false
+			}
+			values = append(values, s)
+		}
+		return fmt.Sprintf(
+			"%s IN (%s)",
+			ident,
+			strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", "),
+		), values, true
+	case "$gt", "$gte", "$lt", "$lte":
+		if col.kind == sqlKeyInt {
+			var n int64
+			switch v := v.(type) {
+			case int32:
+				n = int64(v)
+			case int64:
+				n = v
+			default:
+				return "", nil, false
+			}
+			return "CAST(" + ident + " AS BIGINT) " + sqlComparisons[op] + " ?", []any{n}, true
+		}
+		switch v.(type) {
+		case string, primitive.ObjectID, primitive.DateTime:
+		default:
+			return "", nil, false
+		}
+		s, _ := sqlValue(v)
+		return ident + d.collate + " " + sqlComparisons[op] + " ?", []any{s}, true
+	}
+	return "", nil, false
+}
+
+// orderBy returns the ORDER BY terms of sort, false unless every key
+// of sort is a column of t sortable in SQL
+func (d sqlDialect) orderBy(sort bson.D, t sqlTable) (string, bool) {
+	terms := make([]string, 0, len(sort)+1)
+	for _, key := range sort {
+		col, ok := t.column(key.Key)
+		if !ok {
+			return "", false
+		}
+		var term string
+		switch col.kind {
+		case sqlKey:
+			term = col.ident() + d.collate
+		case sqlKeyInt:
+			term = "CAST(" + col.ident() + " AS BIGINT)"
+		default:
+			if term, ok = d.relationalOrder(col); !ok {
+				return "", false
+			}
+		}
+		// missing values are the least like in MongoDB
+		if dir, _ := toFloat(key.Value); dir < 0 {
+			term += " DESC NULLS LAST"
+		} else {
+			term += " ASC NULLS FIRST"
+		}
+		terms = append(terms, term)
+	}
+	// ties keep their insertion order
+	return strings.Join(append(terms, `"seq"`), ", "), true
+}
+
+// columnValue returns the key column value of field in doc, nil if unset
+func columnValue(doc bson.M, field string) any {
+	v, ok := lookupField(doc, field)
+	if !ok {
+		return nil
+	}
+	if s, ok := sqlValue(v); ok {
+		return s
+	}
+	return nil
+}
+
+// sqlValue converts scalar bson values to their key column text
+func sqlValue(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case primitive.ObjectID:
+		return v.Hex(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int32:
+		return strconv.FormatInt(int64(v), 10), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case primitive.DateTime:
+		return v.Time().UTC().Format(sqlTimeFormat), true
+	}
+	return "", false
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
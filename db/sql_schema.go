@@ -0,0 +1,424 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// sqlColumns lists the document fields copied into indexed columns of each
+// collection table. Queries on these fields are narrowed in SQL, every
+// other field is only stored in the encoded document. Users, assets and
+// maps are stored like this until migrated to sqlRelations.
+var sqlColumns = map[string][]string{
+	UserProfilesCollection:     {"username", "email"},
+	ImagesCollection:           {"name"},
+	PlayerImagesCollection:     {"user_id", "name", "asset_type", "deleted_at", "updated_at"},
+	PlayerMapsCollection:       {"user_id", "name", "primary", "deleted_at", "updated_at", "visits"},
+	UserTokensCollection:       {"user_id"},
+	SettingsCollection:         {},
+	UserIdentitiesCollection:   {"user_id", "provider", "subject"},
+	APIKeysCollection:          {},
+	TokenRevocationsCollection: {"user_id"},
+	MapRevisionsCollection:     {"map_id", "user_id", "version"},
+	MapAssetsCollection:        {"hash"},
+}
+
+// sqlInitialColumns are the key columns created by the first migration,
+// later migrations add the columns since added to sqlColumns
+var sqlInitialColumns = map[string][]string{
+	UserProfilesCollection:     {"username", "email"},
+	ImagesCollection:           {"name"},
+	PlayerImagesCollection:     {"user_id", "name", "asset_type"},
+	PlayerMapsCollection:       {"user_id", "name", "primary"},
+	UserTokensCollection:       {"user_id"},
+	SettingsCollection:         {},
+	UserIdentitiesCollection:   {"user_id", "provider", "subject"},
+	APIKeysCollection:          {},
+	TokenRevocationsCollection: {"user_id"},
+}
+
+// sqlRelationalVersion is the migration storing users, assets and maps
+// relationally, see sqlRelations. Earlier migrations run on the encoded
+// documents of every collection.
+const sqlRelationalVersion = 15
+
+type sqlMigration struct {
+	version     int
+	description string
+	// up optionally prepares data before statements run in the same transaction
+	up         func(ctx context.Context, tx DatabaseClient) error
+	statements func(d sqlDialect) []string
+}
+
+// sqlMigrations are applied in order and recorded in schema_migrations.
+// Never change an applied migration, append a new one instead.
+var sqlMigrations = []sqlMigration{
+	{
+		version:     1,
+		description: "create collection tables",
+		statements: func(d sqlDialect) []string {
+			var stmts []string
+			for _, table := range []string{
+				UserProfilesCollection,
+				ImagesCollection,
+				PlayerImagesCollection,
+				PlayerMapsCollection,
+				UserTokensCollection,
+				SettingsCollection,
+				UserIdentitiesCollection,
+				APIKeysCollection,
+				TokenRevocationsCollection,
+			} {
+				stmts = append(stmts, d.createTable(table, sqlInitialColumns[table])...)
+			}
+			return append(stmts, fmt.Sprintf(
+				`CREATE UNIQUE INDEX IF NOT EXISTS "%s_username_unique" ON "%s" ("username")`,
+				UserProfilesCollection, UserProfilesCollection,
+			))
+		},
+	},
+	{
+		version:     2,
+		description: "compound unique indexes",
+		statements: func(d sqlDialect) []string {
+			return []string{
+				`CREATE UNIQUE INDEX IF NOT EXISTS "player_images_user_id_name_unique" ON "player_images" ("user_id", "name")`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS "player_maps_user_id_name_unique" ON "player_maps" ("user_id", "name")`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS "user_identities_provider_subject_unique" ON "user_identities" ("provider", "subject")`,
+			}
+		},
+	},
+	{
+		version:     3,
+		description: "unique primary map per user",
+		// existing duplicates would prevent the index
+		up: func(ctx context.Context, tx DatabaseClient) error {
+			_, err := RepairPrimaryMaps(ctx, tx)
+			return err
+		},
+		statements: func(d sqlDialect) []string {
+			return []string{
+				`CREATE UNIQUE INDEX IF NOT EXISTS "player_maps_user_id_primary_unique" ON "player_maps" ("user_id") WHERE "primary" = 'true'`,
+			}
+		},
+	},
+	{
+		version:     4,
+		description: "backfill list sort fields",
+		up:          backfillListFields,
+		// sorting happens after documents are decoded
+		statements: func(d sqlDialect) []string {
+			return nil
+		},
+	},
+	{
+		version:     5,
+		description: "soft delete of maps and assets",
+		statements: func(d sqlDialect) []string {
+			var stmts []string
+			for _, table := range []string{PlayerMapsCollection, PlayerImagesCollection} {
+				stmts = append(stmts,
+					fmt.Sprintf(`ALTER TABLE %s ADD COLUMN "deleted_at" TEXT`, quoteIdent(table)),
+					fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s ("deleted_at")`,
+						quoteIdent(table+"_deleted_at"), quoteIdent(table)),
+					// names stay unique among rows that are not deleted
+					fmt.Sprintf(`DROP INDEX IF EXISTS %s`, quoteIdent(table+"_user_id_name_unique")),
+					fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s ("user_id", "name") WHERE "deleted_at" IS NULL`,
+						quoteIdent(table+"_user_id_name_live_unique"), quoteIdent(table)),
+				)
+			}
+			return stmts
+		},
+	},
+	{
+		version:     6,
+		description: "map and asset versions",
+		up:          backfillVersions,
+		statements: func(d sqlDialect) []string {
+			return nil
+		},
+	},
+	{
+		version:     7,
+		description: "map revisions",
+		statements: func(d sqlDialect) []string {
+			return append(d.createTable(MapRevisionsCollection, sqlColumns[MapRevisionsCollection]),
+				`CREATE UNIQUE INDEX IF NOT EXISTS "map_revisions_map_id_version_unique" ON "map_revisions" ("map_id", "version")`,
+			)
+		},
+	},
+	{
+		version:     8,
+		description: "backfill map revisions",
+		up:          backfillMapRevisions,
+		statements: func(d sqlDialect) []string {
+			return nil
+		},
+	},
+	{
+		version:     9,
+		description: "compact pixel data",
+		up:          compactPixelData,
+		statements: func(d sqlDialect) []string {
+			return nil
+		},
+	},
+	{
+		version:     10,
+		description: "map assets",
+		statements: func(d sqlDialect) []string {
+			return append(d.createTable(MapAssetsCollection, sqlColumns[MapAssetsCollection]),
+				`CREATE UNIQUE INDEX IF NOT EXISTS "map_assets_hash_unique" ON "map_assets" ("hash")`,
+			)
+		},
+	},
+	{
+		version:     11,
+		description: "content addressed map assets",
+		up:          dedupeMapAssets,
+		statements: func(d sqlDialect) []string {
+			return nil
+		},
+	},
+	{
+		version:     12,
+		description: "list sort columns",
+		statements: func(d sqlDialect) []string {
+			var stmts []string
+			for _, c := range []struct{ table, col string }{
+				{PlayerMapsCollection, "updated_at"},
+				{PlayerMapsCollection, "visits"},
+				{PlayerImagesCollection, "updated_at"},
+			} {
+				stmts = append(stmts,
+					fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s TEXT`, quoteIdent(c.table), quoteIdent(c.col)),
+					fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (%s)`,
+						quoteIdent(c.table+"_"+c.col), quoteIdent(c.table), quoteIdent(c.col)),
+				)
+			}
+			return stmts
+		},
+	},
+	{
+		version:     13,
+		description: "backfill list sort columns",
+		// times in key columns were not sortable before
+		up: func(ctx context.Context, tx DatabaseClient) error {
+			s, ok := tx.(*SQLDriver)
+			if !ok {
+				return nil
+			}
+			for _, table := range []string{PlayerMapsCollection, PlayerImagesCollection} {
+				if err := s.rewriteKeyColumns(ctx, table); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		statements: func(d sqlDialect) []string {
+			return nil
+		},
+	},
+	{
+		version:     14,
+		description: "default user roles",
+		up:          backfillUserRoles,
+		statements: func(d sqlDialect) []string {
+			return nil
+		},
+	},
+	{
+		version:     sqlRelationalVersion,
+		description: "relational users, assets and maps",
+		up: func(ctx context.Context, tx DatabaseClient) error {
+			s, ok := tx.(*SQLDriver)
+			if !ok {
+				return nil
+			}
+			for _, collection := range []string{UserProfilesCollection, PlayerImagesCollection, PlayerMapsCollection} {
+				if err := s.convertRelational(ctx, collection); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		statements: func(d sqlDialect) []string {
+			return nil
+		},
+	},
+}
+
+// createTable returns the statements creating table with indexed key columns
+func (d sqlDialect) createTable(table string, columns []string) []string {
+	defs := []string{
+		`"seq" ` + d.serial,
+		`"id" TEXT NOT NULL UNIQUE`,
+	}
+	for _, col := range columns {
+		defs = append(defs, quoteIdent(col)+" TEXT")
+	}
+	defs = append(defs, `"doc" `+d.blob+" NOT NULL")
+	stmts := []string{fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (%s)",
+		quoteIdent(table), strings.Join(defs, ", "),
+	)}
+	for _, col := range columns {
+		stmts = append(stmts, fmt.Sprintf(
+			"CREATE INDEX IF NOT EXISTS %s ON %s (%s)",
+			quoteIdent(table+"_"+col), quoteIdent(table), quoteIdent(col),
+		))
+	}
+	return stmts
+}
+
+// rewriteKeyColumns sets the key columns of every row of table from its document
+func (s *SQLDriver) rewriteKeyColumns(ctx context.Context, table string) error {
+	return s.write(ctx, func(tx *sql.Tx) error {
+		opts := DatabaseClientOptions{Table: table}
+		rows, _, err := s.find(ctx, tx, bson.M{}, opts, -1, false)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := s.writeRow(ctx, tx, row, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// convertRelational moves the encoded documents of collection to a table
+// with the columns of sqlRelations. Fields without a column are dropped.
+func (s *SQLDriver) convertRelational(ctx context.Context, collection string) error {
+	return s.write(ctx, func(tx *sql.Tx) error {
+		rows, _, err := s.find(ctx, tx, bson.M{}, DatabaseClientOptions{Table: collection}, -1, false)
+		if err != nil {
+			return err
+		}
+		t := relationalTable(collection)
+		t.name = collection + "_relational"
+		if _, err := tx.ExecContext(ctx, s.dialect.createRelational(t)); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			for field := range row.doc {
+				if _, ok := t.column(field); !ok {
+					log.Printf("dropping field %s of %s %s", field, collection, row.id)
+					delete(row.doc, field)
+				}
+			}
+			if err := s.insert(ctx, tx, t, row.id, row.doc); err != nil {
+				return fmt.Errorf("converting %s %s: %w", collection, row.id, err)
+			}
+		}
+		stmts := []string{
+			fmt.Sprintf("DROP TABLE %s", quoteIdent(collection)),
+			fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quoteIdent(t.name), quoteIdent(collection)),
+		}
+		for _, stmt := range append(stmts, sqlRelationIndexes[collection]...) {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Migrate applies pending migrations and records them in schema_migrations
+func (s *SQLDriver) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS "schema_migrations" (
+		"version" INTEGER PRIMARY KEY,
+		"description" TEXT NOT NULL,
+		"applied_at" TEXT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	current, err := s.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	s.relational = current >= sqlRelationalVersion
+	if current == sqlMigrations[len(sqlMigrations)-1].version {
+		return nil
+	}
+	_, err = s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS "schema_lock" (
+		"id" TEXT PRIMARY KEY,
+		"expires_at" TEXT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	err = acquireMigrationLock(ctx, func() (bool, error) {
+		// a lock left behind by a crashed instance expires
+		now := time.Now().UTC()
+		_, err := s.db.ExecContext(ctx, s.dialect.rebind(`DELETE FROM "schema_lock" WHERE "id" = ? AND "expires_at" < ?`),
+			migrationLockID, now.Format(time.RFC3339))
+		if err != nil {
+			return false, err
+		}
+		_, err = s.db.ExecContext(ctx, s.dialect.rebind(`INSERT INTO "schema_lock" ("id", "expires_at") VALUES (?, ?)`),
+			migrationLockID, now.Add(migrationLockTTL).Format(time.RFC3339))
+		if err = s.translateError(err, DatabaseClientOptions{Table: "schema_lock"}); mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		return err
+	}
+	defer s.db.ExecContext(context.WithoutCancel(ctx), s.dialect.rebind(`DELETE FROM "schema_lock" WHERE "id" = ?`), migrationLockID)
+	// skip what was applied while waiting for the lock
+	if current, err = s.schemaVersion(ctx); err != nil {
+		return err
+	}
+	s.relational = current >= sqlRelationalVersion
+	for _, m := range sqlMigrations {
+		if m.version <= current {
+			continue
+		}
+		log.Printf("applying migration %d: %s", m.version, m.description)
+		err := s.write(ctx, func(tx *sql.Tx) error {
+			if m.up != nil {
+				driver := &SQLDriver{db: s.db, dialect: s.dialect, tx: tx, relational: m.version > sqlRelationalVersion}
+				if err := m.up(ctx, driver); err != nil {
+					return err
+				}
+			}
+			for _, stmt := range m.statements(s.dialect) {
+				if _, err := tx.ExecContext(ctx, stmt); err != nil {
+					return err
+				}
+			}
+			_, err := tx.ExecContext(ctx, s.dialect.rebind(
+				`INSERT INTO "schema_migrations" ("version", "description", "applied_at") VALUES (?, ?, ?)`),
+				m.version, m.description, time.Now().UTC().Format(time.RFC3339),
+			)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d: %w", m.version, err)
+		}
+		s.relational = m.version >= sqlRelationalVersion
+	}
+	return nil
+}
+
+// schemaVersion returns the version of the last applied migration
+func (s *SQLDriver) schemaVersion(ctx context.Context) (int, error) {
+	var current sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `SELECT MAX("version") FROM "schema_migrations"`).Scan(&current)
+	return int(current.Int64), err
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
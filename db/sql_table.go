@@ -0,0 +1,492 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sqlKind is how a column stores the values of a document field
+type sqlKind int
+
+const (
+	// sqlKey columns copy scalar fields of encoded documents as text
+	sqlKey sqlKind = iota
+	// sqlKeyInt columns copy integer fields of encoded documents as text
+	sqlKeyInt
+	sqlText
+	sqlInt
+	sqlBool
+	sqlTime
+	sqlBytes
+	// sqlEncoded columns hold embedded documents and arrays BSON encoded
+	sqlEncoded
+)
+
+// sqlColumn is a column holding the field of a document
+type sqlColumn struct {
+	field string
+	kind  sqlKind
+}
+
+// ident returns the quoted name of the column, "id" for _id
+func (c sqlColumn) ident() string {
+	if c.field == "_id" {
+		return `"id"`
+	}
+	return quoteIdent(c.field)
+}
+
+// sqlRelations lists the columns of the collections stored relationally,
+// one column per field. Documents with fields missing here are rejected.
+var sqlRelations = map[string][]sqlColumn{
+	UserProfilesCollection: {
+		{"username", sqlText},
+		{"password", sqlText},
+		{"email", sqlText},
+		{"email_verified", sqlBool},
+		{"role", sqlText},
+		{"banned", sqlBool},
+		{"username_history", sqlEncoded},
+		{"mfa_enabled", sqlBool},
+		{"mfa_secret", sqlText},
+		{"recovery_codes", sqlEncoded},
+		{"mfa_last_step", sqlInt},
+		{"delete_after", sqlTime},
+		{"created_at", sqlTime},
+		{"updated_at", sqlTime},
+	},
+	PlayerImagesCollection: {
+		{"user_id", sqlText},
+		{"name", sqlText},
+		{"asset_type", sqlText},
+		{"x", sqlInt},
+		{"y", sqlInt},
+		{"layer", sqlInt},
+		{"width", sqlInt},
+		{"height", sqlInt},
+		{"version", sqlInt},
+		{"created_at", sqlTime},
+		{"updated_at", sqlTime},
+		{"deleted_at", sqlTime},
+		{"data", sqlBytes},
+		{"frames", sqlEncoded},
+		{"animations", sqlEncoded},
+	},
+	PlayerMapsCollection: {
+		{"user_id", sqlText},
+		{"username", sqlText},
+		{"name", sqlText},
+		{"primary", sqlBool},
+		{"entrance", sqlEncoded},
+		{"portals", sqlEncoded},
+		{"visits", sqlInt},
+		{"version", sqlInt},
+		{"created_at", sqlTime},
+		{"updated_at", sqlTime},
+		{"deleted_at", sqlTime},
+		{"data", sqlBytes},
+	},
+}
+
+// sqlRelationIndexes are the indexes of the relational tables besides
+// those of "id"
+var sqlRelationIndexes = map[string][]string{
+	UserProfilesCollection: {
+		`CREATE UNIQUE INDEX IF NOT EXISTS "user_profiles_username_unique" ON "user_profiles" ("username")`,
+		`CREATE INDEX IF NOT EXISTS "user_profiles_email" ON "user_profiles" ("email")`,
+		`CREATE INDEX IF NOT EXISTS "user_profiles_role" ON "user_profiles" ("role")`,
+	},
+	PlayerImagesCollection: {
+		`CREATE UNIQUE INDEX IF NOT EXISTS "player_images_user_id_name_live_unique" ON "player_images" ("user_id", "name") WHERE "deleted_at" IS NULL`,
+		`CREATE INDEX IF NOT EXISTS "player_images_asset_type" ON "player_images" ("asset_type")`,
+		`CREATE INDEX IF NOT EXISTS "player_images_deleted_at" ON "player_images" ("deleted_at")`,
+		`CREATE INDEX IF NOT EXISTS "player_images_updated_at" ON "player_images" ("updated_at")`,
+	},
+	PlayerMapsCollection: {
+		`CREATE UNIQUE INDEX IF NOT EXISTS "player_maps_user_id_name_live_unique" ON "player_maps" ("user_id", "name") WHERE "deleted_at" IS NULL`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS "player_maps_user_id_primary_unique" ON "player_maps" ("user_id") WHERE "primary"`,
+		`CREATE INDEX IF NOT EXISTS "player_maps_name" ON "player_maps" ("name")`,
+		`CREATE INDEX IF NOT EXISTS "player_maps_deleted_at" ON "player_maps" ("deleted_at")`,
+		`CREATE INDEX IF NOT EXISTS "player_maps_updated_at" ON "player_maps" ("updated_at")`,
+		`CREATE INDEX IF NOT EXISTS "player_maps_visits" ON "player_maps" ("visits")`,
+	},
+}
+
+// sqlTable is how the documents of a collection are stored. Relational
+// tables store each field in a column, other tables store the encoded
+// document in "doc" and copy key fields into columns to query them.
+type sqlTable struct {
+	name       string
+	columns    []sqlColumn
+	relational bool
+}
+
+// table returns how the documents of collection are stored
+func (s *SQLDriver) table(collection string) sqlTable {
+	if _, ok := sqlRelations[collection]; ok && s.relational {
+		return relationalTable(collection)
+	}
+	return documentTable(collection)
+}
+
+// documentTable returns the table of collection storing encoded documents
+func documentTable(collection string) sqlTable {
+	t := sqlTable{name: collection}
+	for _, field := range sqlColumns[collection] {
+		kind := sqlKey
+		if containsString(sqlIntColumns, field) {
+			kind = sqlKeyInt
+		}
+		t.columns = append(t.columns, sqlColumn{field, kind})
+	}
+	return t
+}
+
+// column returns the column of field, "id" for _id
+func (t sqlTable) column(field string) (sqlColumn, bool) {
+	if field == "_id" {
+		return sqlColumn{"_id", sqlKey}, true
+	}
+	for _, col := range t.columns {
+		if col.field == field {
+			return col, true
+		}
+	}
+	return sqlColumn{}, false
+}
+
+// columnType returns the SQL type of columns of kind
+func (d sqlDialect) columnType(kind sqlKind) string {
+	switch kind {
+	case sqlInt:
+		return "BIGINT"
+	case sqlBool:
+		return "BOOLEAN"
+	case sqlTime:
+		return d.timestamp
+	case sqlBytes, sqlEncoded:
+		return d.blob
+	}
+	return "TEXT"
+}
+
+// arg converts v, a value of the field of col, to a query argument.
+// ok is false if col cannot hold v.
+func (d sqlDialect) arg(col sqlColumn, v any) (arg any, ok bool) {
+	if v == nil {
+		return nil, true
+	}
+	switch col.kind {
+	case sqlKey, sqlKeyInt:
+		s, ok := sqlValue(v)
+		return s, ok
+	case sqlText:
+		s, ok := v.(string)
+		return s, ok
+	case sqlInt:
+		switch v := v.(type) {
+		case int32:
+			return int64(v), true
+		case int64:
+			return v, true
+		}
+	case sqlBool:
+		b, ok := v.(bool)
+		return b, ok
+	case sqlTime:
+		t, ok := v.(primitive.DateTime)
+		if !ok {
+			return nil, false
+		}
+		if d.name == SQLite {
+			// text in a fixed width sorts in time order
+			return t.Time().UTC().Format(sqlTimeFormat), true
+		}
+		return t.Time().UTC(), true
+	case sqlBytes:
+		switch v := v.(type) {
+		case primitive.Binary:
+			return v.Data, true
+		case []byte:
+			return v, true
+		}
+	case sqlEncoded:
+		b, err := bson.Marshal(bson.M{"v": v})
+		return b, err == nil
+	}
+	return nil, false
+}
+
+// value converts the value scanned from col back to its document value
+func (d sqlDialect) value(col sqlColumn, v any) (any, error) {
+	switch col.kind {
+	case sqlText:
+		switch v := v.(type) {
+		case string:
+			return v, nil
+		case []byte:
+			return string(v), nil
+		}
+	case sqlInt:
+		if n, ok := v.(int64); ok {
+			return n, nil
+		}
+	case sqlBool:
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		case int64:
+			return v != 0, nil
+		}
+	case sqlTime:
+		switch v := v.(type) {
+		case time.Time:
+			return primitive.NewDateTimeFromTime(v), nil
+		case string:
+			t, err := time.Parse(sqlTimeFormat, v)
+			if err != nil {
+				return nil, err
+			}
+			return primitive.NewDateTimeFromTime(t), nil
+		}
+	case sqlBytes:
+		if b, ok := v.([]byte); ok {
+			return primitive.Binary{Data: b}, nil
+		}
+	case sqlEncoded:
+		if b, ok := v.([]byte); ok {
+			doc := bson.M{}
+			if err := decodeDocument(bson.Raw(b), &doc); err != nil {
+				return nil, err
+			}
+			return doc["v"], nil
+		}
+	}
+	return nil, fmt.Errorf("cannot read %T from column %s", v, col.field)
+}
+
+// relationalValues returns the arguments storing the fields of doc in the
+// columns of t, nil for missing fields
+func (d sqlDialect) relationalValues(t sqlTable, doc bson.M) ([]any, error) {
+	for field := range doc {
+		if _, ok := t.column(field); !ok {
+			return nil, fmt.Errorf("%s has no column %q", t.name, field)
+		}
+	}
+	args := make([]any, 0, len(t.columns))
+	for _, col := range t.columns {
+		arg, ok := d.arg(col, doc[col.field])
+		if !ok {
+			return nil, fmt.Errorf("cannot store %T in column %s of %s", doc[col.field], col.field, t.name)
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// relationalSets returns the assignments of an update of t setting
+// fields and incrementing the fields of inc
+func (d sqlDialect) relationalSets(t sqlTable, fields bson.M, inc bson.M) (sets []string, args []any, err error) {
+	for field, v := range fields {
+		col, ok := t.column(field)
+		if !ok || field == "_id" {
+			return nil, nil, fmt.Errorf("%s has no column %q", t.name, field)
+		}
+		arg, ok := d.arg(col, v)
+		if !ok {
+			return nil, nil, fmt.Errorf("cannot store %T in column %s of %s", v, field, t.name)
+		}
+		sets = append(sets, col.ident()+" = ?")
+		args = append(args, arg)
+	}
+	for field, n := range inc {
+		col, ok := t.column(field)
+		if !ok || col.kind != sqlInt {
+			return nil, nil, fmt.Errorf("cannot increment %s of %s", field, t.name)
+		}
+		arg, ok := d.arg(col, n)
+		if !ok {
+			return nil, nil, fmt.Errorf("cannot increment by %T", n)
+		}
+		sets = append(sets, col.ident()+" = COALESCE("+col.ident()+", 0) + ?")
+		args = append(args, arg)
+	}
+	return sets, args, nil
+}
+
+// compareColumn returns the condition of comparing the relational column
+// col to v with op, empty if it cannot run in SQL
+func (d sqlDialect) compareColumn(col sqlColumn, op string, v any) (string, []any, bool) {
+	ident := col.ident()
+	switch col.kind {
+	case sqlBytes, sqlEncoded:
+		// compared once decoded
+		return "", nil, false
+	case sqlText:
+		ident += d.collate
+	}
+	switch op {
+	case "$eq", "$ne":
+		if v == nil {
+			// missing fields are stored as NULL too
+			if op == "$eq" {
+				return ident + " IS NULL", nil, true
+			}
+			return ident + " IS NOT NULL", nil, true
+		}
+		arg, ok := d.arg(col, v)
+		if !ok {
+			return "", nil, false
+		}
+		if op == "$eq" {
+			return ident + " = ?", []any{arg}, true
+		}
+		return "(" + ident + " IS NULL OR " + ident + " <> ?)", []any{arg}, true
+	case "$in", "$nin":
+		in, ok := v.(primitive.A)
+		if !ok {
+			return "", nil, false
+		}
+		var args []any
+		null := false
+		for _, v := range in {
+			if v == nil {
+				null = true
+				continue
+			}
+			arg, ok := d.arg(col, v)
+			if !ok {
+				return "", nil, false
+			}
+			args = append(args, arg)
+		}
+		list := fmt.Sprintf("%s IN (%s)", ident, strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", "))
+		switch {
+		case op == "$in" && len(args) == 0 && !null:
+			return "1 = 0", nil, true
+		case op == "$in" && len(args) == 0:
+			return ident + " IS NULL", nil, true
+		case op == "$in" && null:
+			return "(" + ident + " IS NULL OR " + list + ")", args, true
+		case op == "$in":
+			return list, args, true
+		case len(args) == 0 && !null:
+			return "", nil, true
+		case len(args) == 0:
+			return ident + " IS NOT NULL", nil, true
+		case null:
+			return ident + " IS NOT NULL AND NOT " + list, args, true
+		}
+		return "(" + ident + " IS NULL OR NOT " + list + ")", args, true
+	case "$gt", "$gte", "$lt", "$lte":
+		if col.kind == sqlBool {
+			return "", nil, false
+		}
+		arg, ok := d.arg(col, v)
+		if !ok || arg == nil {
+			return "", nil, false
+		}
+		return ident + " " + sqlComparisons[op] + " ?", []any{arg}, true
+	case "$exists":
+		exists, ok := v.(bool)
+		if !ok {
+			return "", nil, false
+		}
+		if exists {
+			return ident + " IS NOT NULL", nil, true
+		}
+		return ident + " IS NULL", nil, true
+	case "$regex":
+		pattern, ok := v.(string)
+		prefix, literal := literalPrefix(pattern)
+		if !ok || !literal || col.kind != sqlText {
+			return "", nil, false
+		}
+		n := utf8.RuneCountInString(prefix)
+		return "SUBSTR(" + col.ident() + ", 1, " + strconv.Itoa(n) + ") = ?", []any{prefix}, true
+	}
+	return "", nil, false
+}
+
+// literalPrefix returns the prefix matched by pattern, literal is false
+// unless pattern is "^" followed by a literal like those quoted by
+// regexp.QuoteMeta
+func literalPrefix(pattern string) (prefix string, literal bool) {
+	rest, ok := strings.CutPrefix(pattern, "^")
+	if !ok {
+		return "", false
+	}
+	re, err := regexp.Compile(rest)
+	if err != nil {
+		return "", false
+	}
+	return re.LiteralPrefix()
+}
+
+// relationalOrder returns the ORDER BY term of col, false if it cannot be
+// sorted in SQL
+func (d sqlDialect) relationalOrder(col sqlColumn) (string, bool) {
+	switch col.kind {
+	case sqlBytes, sqlEncoded:
+		return "", false
+	case sqlText:
+		return col.ident() + d.collate, true
+	}
+	return col.ident(), true
+}
+
+// relationalTable returns the table of collection storing its documents
+// in the columns of sqlRelations
+func relationalTable(collection string) sqlTable {
+	return sqlTable{name: collection, columns: sqlRelations[collection], relational: true}
+}
+
+// createRelational returns the statement creating the relational table t
+func (d sqlDialect) createRelational(t sqlTable) string {
+	defs := []string{
+		`"seq" ` + d.serial,
+		`"id" TEXT NOT NULL UNIQUE`,
+	}
+	for _, col := range t.columns {
+		defs = append(defs, col.ident()+" "+d.columnType(col.kind))
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", quoteIdent(t.name), strings.Join(defs, ", "))
+}
+
+// scanRelational reads the document of a row of a relational table
+// selecting "id" and cols
+func (d sqlDialect) scanRelational(rows *sql.Rows, cols []sqlColumn) (sqlRow, error) {
+	var row sqlRow
+	values := make([]any, len(cols))
+	dest := make([]any, 0, len(cols)+1)
+	dest = append(dest, &row.id)
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return row, err
+	}
+	id, err := primitive.ObjectIDFromHex(row.id)
+	if err != nil {
+		return row, err
+	}
+	row.doc = bson.M{"_id": id}
+	for i, col := range cols {
+		// missing and null fields are both NULL
+		if values[i] == nil {
+			continue
+		}
+		if row.doc[col.field], err = d.value(col, values[i]); err != nil {
+			return row, err
+		}
+	}
+	return row, nil
+}
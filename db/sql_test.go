@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSQLDialectRebind(t *testing.T) {
+	query := `SELECT "doc" FROM "t" WHERE "a" = ? AND "b" IN (?, ?)`
+	assert.Equal(t, query, sqlDialects[SQLite].rebind(query))
+	assert.Equal(t,
+		`SELECT "doc" FROM "t" WHERE "a" = $1 AND "b" IN ($2, $3)`,
+		sqlDialects[Postgres].rebind(query),
+	)
+}
+
+func TestSQLWhere(t *testing.T) {
+	filter, err := toDocument(bson.M{
+		"user_id": bson.M{"$in": []string{"a", "b"}},
+		"name":    "map",
+		// not a key column, only evaluated in go
+		"data": "ignored",
+	})
+	assert.Nil(t, err)
+
+	where, args, exact := sqlDialects[SQLite].where(filter, relationalTable(PlayerMapsCollection))
+	assert.Contains(t, where, `"user_id" IN (?, ?)`)
+	assert.Contains(t, where, `"name" = ?`)
+	assert.NotContains(t, where, "data")
+	assert.ElementsMatch(t, []any{"a", "b", "map"}, args)
+	assert.False(t, exact)
+
+	// list cursors run in sql entirely
+	filter, err = toDocument(bson.M{
+		"deleted_at": nil,
+		"$or": bson.A{
+			bson.M{"visits": bson.M{"$lt": 3}},
+			bson.M{"visits": 3, "_id": bson.M{"$lt": primitive.NilObjectID}},
+		},
+	})
+	assert.Nil(t, err)
+	where, args, exact = sqlDialects[Postgres].where(filter, relationalTable(PlayerMapsCollection))
+	assert.Contains(t, where, `"deleted_at" IS NULL`)
+	assert.Contains(t, where, `(("visits" < ?) OR (`)
+	assert.Contains(t, where, `"id" COLLATE "C" < ?`)
+	assert.Len(t, args, 3)
+	assert.True(t, exact)
+
+	// a branch that cannot be narrowed leaves the whole $or to go
+	filter, err = toDocument(bson.M{"$or": bson.A{bson.M{"name": "map"}, bson.M{"data": "ignored"}}})
+	assert.Nil(t, err)
+	where, _, exact = sqlDialects[SQLite].where(filter, relationalTable(PlayerMapsCollection))
+	assert.Empty(t, where)
+	assert.False(t, exact)
+
+	// prefixes, lists and missing fields of relational tables run in sql
+	filter, err = toDocument(bson.M{
+		"name":    bson.M{"$regex": "^" + regexp.QuoteMeta("a.b")},
+		"user_id": bson.M{"$nin": bson.A{"a"}},
+		"version": bson.M{"$exists": false},
+	})
+	assert.Nil(t, err)
+	where, args, exact = sqlDialects[SQLite].where(filter, relationalTable(PlayerMapsCollection))
+	assert.Contains(t, where, `SUBSTR("name", 1, 3) = ?`)
+	assert.Contains(t, where, `("user_id" IS NULL OR NOT "user_id" IN (?))`)
+	assert.Contains(t, where, `"version" IS NULL`)
+	assert.ElementsMatch(t, []any{"a.b", "a"}, args)
+	assert.True(t, exact)
+}
+
+func TestSQLOrderBy(t *testing.T) {
+	order, ok := sqlDialects[SQLite].orderBy(bson.D{{Key: "visits", Value: -1}, {Key: "_id", Value: -1}}, relationalTable(PlayerMapsCollection))
+	assert.True(t, ok)
+	assert.Equal(t, `"visits" DESC NULLS LAST, "id" DESC NULLS LAST, "seq"`, order)
+
+	// sorted once decoded
+	_, ok = sqlDialects[SQLite].orderBy(bson.D{{Key: "data", Value: 1}}, relationalTable(PlayerMapsCollection))
+	assert.False(t, ok)
+}
+
+func TestSQLMigrationLock(t *testing.T) {
+	driver, err := NewSQLDriver(SQLite, filepath.Join(t.TempDir(), "game.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+	_, err = driver.db.Exec(`CREATE TABLE "schema_lock" ("id" TEXT PRIMARY KEY, "expires_at" TEXT NOT NULL)`)
+	assert.Nil(t, err)
+	lock := func(expires time.Time) {
+		_, err := driver.db.Exec(`INSERT OR REPLACE INTO "schema_lock" ("id", "expires_at") VALUES (?, ?)`,
+			migrationLockID, expires.UTC().Format(time.RFC3339))
+		assert.Nil(t, err)
+	}
+
+	// migrations wait while another instance holds the lock
+	lock(time.Now().Add(time.Minute))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, driver.Migrate(ctx))
+
+	// and take over a lock left behind
+	lock(time.Now().Add(-time.Minute))
+	assert.Nil(t, driver.Migrate(context.Background()))
+	var locks int
+	assert.Nil(t, driver.db.QueryRow(`SELECT COUNT(*) FROM "schema_lock"`).Scan(&locks))
+	assert.Equal(t, 0, locks)
+}
+
+func TestSQLRelationalMigration(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "game.db")
+	driver, err := NewSQLDriver(SQLite, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+
+	// documents stored before users, assets and maps were relational
+	migrations := sqlMigrations
+	sqlMigrations = migrations[:sqlRelationalVersion-1]
+	err = driver.Migrate(ctx)
+	sqlMigrations = migrations
+	assert.Nil(t, err)
+	assert.False(t, driver.relational)
+	userID, err := CreateUser(ctx, driver, createMockUser())
+	assert.Nil(t, err)
+	_, err = driver.UpdateOne(ctx, userID.Hex(), Update{}.Set("legacy", true), userDBOptions)
+	assert.Nil(t, err)
+	mapID, err := CreateMap(ctx, driver, createMockMap("[]"))
+	assert.Nil(t, err)
+	assetID, err := CreatePlayerAsset(ctx, driver, CreateMockPlayerAsset("[]"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, driver.Migrate(ctx))
+	assert.True(t, driver.relational)
+	user, err := GetUserByID(ctx, driver, userID.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, "username", user.UserName)
+	assert.Equal(t, DefaultRole, user.Role)
+	_map, err := GetMapByID(ctx, driver, mapID.Hex())
+	assert.Nil(t, err)
+	assert.True(t, _map.Primary)
+	assert.Equal(t, 1, _map.Version)
+	asset, err := GetPlayerAssetByID(ctx, driver, assetID.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, "test_image", asset.Name)
+
+	// fields are columns, those without one are dropped
+	var primary bool
+	var visits int64
+	err = driver.db.QueryRow(`SELECT "primary", "visits" FROM "player_maps" WHERE "id" = ?`, mapID.Hex()).Scan(&primary, &visits)
+	assert.Nil(t, err)
+	assert.True(t, primary)
+	assert.Equal(t, int64(0), visits)
+	_, err = driver.UpdateOne(ctx, userID.Hex(), Update{}.Set("legacy", true), userDBOptions)
+	assert.NotNil(t, err)
+
+	// driver reopened on the migrated schema
+	reopened, err := NewSQLDriver(SQLite, path)
+	assert.Nil(t, err)
+	defer reopened.Close()
+	assert.True(t, reopened.relational)
+}
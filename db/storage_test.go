@@ -0,0 +1,1406 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/snburman/game-server/assets"
+	"github.com/snburman/game-server/cache"
+	"github.com/snburman/game-server/config"
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/utils"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// storageTests run against every DatabaseClient implementation
+// without mocked responses
+var storageTests = map[string]func(t *testing.T, driver DatabaseClient){
+	"users":         testStorageUsers,
+	"unique-index":  testStorageUniqueIndex,
+	"filters":       testStorageFilters,
+	"maps":          testStorageMaps,
+	"primary-maps":  testStoragePrimaryMaps,
+	"patches":       testStoragePartialUpdates,
+	"listing":       testStorageListing,
+	"visits":        testStorageVisits,
+	"trash":         testStorageTrash,
+	"versions":      testStorageVersions,
+	"revisions":     testStorageRevisions,
+	"cache":         testStorageCache,
+	"pixels":        testStoragePixels,
+	"animations":    testStorageAnimations,
+	"validation":    testStorageValidation,
+	"map-assets":    testStorageMapAssets,
+	"shared-assets": testStorageSharedMapAssets,
+	"images":        testStorageImages,
+	"tokens":        testStorageTokens,
+	"repair-maps":   testStorageRepairPrimaryMaps,
+	"map-queries":   testStorageMapQueries,
+	"asset-queries": testStorageAssetQueries,
+	"user-queries":  testStorageUserQueries,
+	"totp-steps":    testStorageTOTPSteps,
+	"mfa-roles":     testStorageMFARoles,
+	"roles":         testStorageRoles,
+	"export":        testStorageExport,
+	"create-assets": testStorageCreateAssets,
+}
+
+func runStorageTests(t *testing.T, newDriver func(t *testing.T) DatabaseClient) {
+	for name, test := range storageTests {
+		t.Run(name, func(t *testing.T) {
+			test(t, newDriver(t))
+		})
+	}
+}
+
+func TestMemoryDriver(t *testing.T) {
+	runStorageTests(t, func(t *testing.T) DatabaseClient {
+		return NewMemoryDriver()
+	})
+}
+
+func TestSQLiteDriver(t *testing.T) {
+	runStorageTests(t, func(t *testing.T) DatabaseClient {
+		driver, err := NewSQLDriver(SQLite, filepath.Join(t.TempDir(), "game.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { driver.Close() })
+		if err := driver.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return driver
+	})
+}
+
+// TestPostgresDriver runs against TEST_POSTGRES_URL and is skipped if unset.
+// Tables in that database are emptied before each test.
+func TestPostgresDriver(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_URL")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_URL not set")
+	}
+	runStorageTests(t, func(t *testing.T) DatabaseClient {
+		driver, err := NewSQLDriver(Postgres, dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { driver.Close() })
+		if err := driver.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		for table := range sqlColumns {
+			if _, err := driver.db.Exec("DELETE FROM " + quoteIdent(table)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return driver
+	})
+}
+
+func testStorageUsers(t *testing.T, driver DatabaseClient) {
+	id, err := CreateUser(context.Background(), driver, createMockUser())
+	assert.Nil(t, err)
+
+	// get by id and username
+	user, err := GetUserByID(context.Background(), driver, id.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, "username", user.UserName)
+	user, err = GetUserByUserName(context.Background(), driver, "UserName")
+	assert.Nil(t, err)
+	assert.Equal(t, id, user.ID)
+
+	// update keeps unchanged fields
+	err = SetUserEmailVerified(context.Background(), driver, id.Hex())
+	assert.Nil(t, err)
+	user, err = GetUserByID(context.Background(), driver, id.Hex())
+	assert.Nil(t, err)
+	assert.True(t, user.EmailVerified)
+	assert.Equal(t, "username", user.UserName)
+
+	// rename keeps history and renames cached and trashed maps
+	SetCaches(cache.NewLRU[Map[[]PlayerAsset[PixelData]]](10), nil)
+	defer SetCaches(nil, nil)
+	m := createMockMap("[]")
+	m.UserID = id.Hex()
+	mapID, err := CreateMap(context.Background(), driver, m)
+	assert.Nil(t, err)
+	_, err = GetMapByID(context.Background(), driver, mapID.Hex())
+	assert.Nil(t, err)
+	m.Name = "trashed"
+	m.Primary = false
+	trashedID, err := CreateMap(context.Background(), driver, m)
+	assert.Nil(t, err)
+	assert.Nil(t, DeleteMap(context.Background(), driver, trashedID.Hex()))
+
+	err = RenameUser(context.Background(), driver, id.Hex(), "renamed")
+	assert.Nil(t, err)
+	user, err = GetUserByUserName(context.Background(), driver, "renamed")
+	assert.Nil(t, err)
+	assert.Len(t, user.UserNameHistory, 1)
+	cached, err := GetMapByID(context.Background(), driver, mapID.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, "renamed", cached.UserName)
+	trash, err := GetTrash(context.Background(), driver, id.Hex())
+	assert.Nil(t, err)
+	assert.Len(t, trash.Maps, 1)
+	assert.Equal(t, "renamed", trash.Maps[0].UserName)
+
+	// delete
+	count, err := DeleteUser(context.Background(), driver, id.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	_, err = GetUserByID(context.Background(), driver, id.Hex())
+	assert.Equal(t, mongo.ErrNoDocuments, err)
+}
+
+func testStorageUserQueries(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	for _, c := range []struct {
+		user User
+		err  error
+	}{
+		{User{UserName: "weak", Password: "password"}, errors.ErrWeakPassword},
+		{User{UserName: "mail", Password: "passwordABC123", Email: "not-an-email"}, errors.ErrInvalidEmail},
+		{User{UserName: "a", Password: "passwordABC123"}, errors.ErrInvalidUserName},
+	} {
+		_, err := CreateUser(ctx, driver, c.user)
+		assert.Equal(t, c.err, err, c.user.UserName)
+	}
+	mockUser := createMockUser()
+	mockUser.Email = "player@example.com"
+	id, err := CreateUser(ctx, driver, mockUser)
+	assert.Nil(t, err)
+	_, err = CreateUser(ctx, driver, mockUser)
+	assert.Equal(t, errors.ErrUserExists, err)
+
+	// emails match in any case
+	user, err := GetUserByEmail(ctx, driver, "Player@Example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, id, user.ID)
+	_, err = GetUserByEmail(ctx, driver, "other@example.com")
+	assert.NotNil(t, err)
+	_, err = GetUserByID(ctx, driver, primitive.NewObjectID().Hex())
+	assert.NotNil(t, err)
+
+	// passwords are checked on update
+	user.Password = "password"
+	assert.Equal(t, errors.ErrWeakPassword, UpdateUser(ctx, driver, user))
+	user.Password, user.Email = "", "not-an-email"
+	assert.Equal(t, errors.ErrInvalidEmail, UpdateUser(ctx, driver, user))
+	user.Email = "new@example.com"
+	assert.Nil(t, UpdateUser(ctx, driver, user))
+	assert.Equal(t, errors.ErrWeakPassword, SetUserPassword(ctx, driver, id.Hex(), "password"))
+	assert.Nil(t, SetUserPassword(ctx, driver, id.Hex(), "newPassword123"))
+	user, err = GetUserByID(ctx, driver, id.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, "new@example.com", user.Email)
+	assert.True(t, utils.CheckPasswordHash("newPassword123", user.Password))
+
+	// mfa is enabled after enrollment, recovery codes are used once
+	assert.Equal(t, errors.ErrMFANotPending, EnableUserMFA(ctx, driver, id.Hex(), []string{"aaaaa-bbbbb"}))
+	assert.Nil(t, SetUserMFASecret(ctx, driver, id.Hex(), "secret"))
+	assert.Nil(t, EnableUserMFA(ctx, driver, id.Hex(), []string{"aaaaa-bbbbb", "ccccc-ddddd"}))
+	user, err = GetUserByID(ctx, driver, id.Hex())
+	assert.Nil(t, err)
+	ok, err := ConsumeRecoveryCode(ctx, driver, user, "CCCCC-DDDDD")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	user, err = GetUserByID(ctx, driver, id.Hex())
+	assert.Nil(t, err)
+	ok, err = ConsumeRecoveryCode(ctx, driver, user, "ccccc-ddddd")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// usernames
+	name, err := UniqueUserName(ctx, driver, "Player.One!")
+	assert.Nil(t, err)
+	assert.Equal(t, "playerone", name)
+	name, err = UniqueUserName(ctx, driver, mockUser.UserName)
+	assert.Nil(t, err)
+	assert.NotEqual(t, mockUser.UserName, name)
+	assert.Contains(t, name, mockUser.UserName)
+	assert.Equal(t, errors.ErrReservedUserName, RenameUser(ctx, driver, id.Hex(), "admin"))
+	_, err = DeleteUser(ctx, driver, "invalid-id")
+	assert.NotNil(t, err)
+}
+
+func testStorageUniqueIndex(t *testing.T, driver DatabaseClient) {
+	_, err := CreateUser(context.Background(), driver, createMockUser())
+	assert.Nil(t, err)
+	_, err = driver.CreateOne(context.Background(), User{UserName: "username"}, userDBOptions)
+	assert.True(t, mongo.IsDuplicateKeyError(err))
+
+	// renaming onto a taken name is rejected
+	other := createMockUser()
+	other.UserName = "other"
+	otherID, err := CreateUser(context.Background(), driver, other)
+	assert.Nil(t, err)
+	err = RenameUser(context.Background(), driver, otherID.Hex(), "username")
+	assert.Equal(t, errors.ErrUserExists, err)
+
+	// compound indexes only conflict when every field matches
+	_, err = driver.CreateOne(context.Background(), PlayerAsset[[]byte]{UserID: "a", Name: "tile"}, assetDBOptions)
+	assert.Nil(t, err)
+	_, err = driver.CreateOne(context.Background(), PlayerAsset[[]byte]{UserID: "b", Name: "tile"}, assetDBOptions)
+	assert.Nil(t, err)
+	_, err = driver.CreateOne(context.Background(), PlayerAsset[[]byte]{UserID: "a", Name: "tile"}, assetDBOptions)
+	assert.True(t, mongo.IsDuplicateKeyError(err))
+}
+
+func testStorageFilters(t *testing.T, driver DatabaseClient) {
+	assets := []PlayerAsset[string]{
+		{UserID: "a", Name: "up", AssetType: ASSET_PLAYER_UP, Width: 16, Height: 16, Data: "[]"},
+		{UserID: "a", Name: "tile", AssetType: ASSET_TILE, Width: 16, Height: 16, Data: "[]"},
+		{UserID: "b", Name: "down", AssetType: ASSET_PLAYER_DOWN, Width: 16, Height: 16, Data: "[]"},
+		{UserID: "c", Name: "left", AssetType: ASSET_PLAYER_LEFT, Width: 16, Height: 16, Data: "[]"},
+	}
+	for _, a := range assets {
+		_, err := CreatePlayerAsset(context.Background(), driver, a)
+		assert.Nil(t, err)
+	}
+
+	// $in and $or
+	chars, err := GetPlayerCharactersByUserIDs(context.Background(), driver, []string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Len(t, chars, 2)
+
+	// equality
+	byUser, err := GetPlayerAssetsByUserID(context.Background(), driver, "a")
+	assert.Nil(t, err)
+	assert.Len(t, byUser, 2)
+
+	// comparison on dates and null matching
+	past := time.Now().Add(-time.Hour)
+	_, err = driver.CreateOne(context.Background(), User{UserName: "scheduled", DeleteAfter: &past}, userDBOptions)
+	assert.Nil(t, err)
+	_, err = driver.CreateOne(context.Background(), User{UserName: "active"}, userDBOptions)
+	assert.Nil(t, err)
+	users, err := GetUsersScheduledForDeletion(context.Background(), driver, time.Now())
+	assert.Nil(t, err)
+	assert.Len(t, users, 1)
+	active := []User{}
+	err = driver.Get(context.Background(), bson.M{"delete_after": nil}, userDBOptions, &active)
+	assert.Nil(t, err)
+	assert.Len(t, active, 1)
+
+	// many
+	count, err := driver.UpdateMany(context.Background(), bson.M{"user_id": "a"}, bson.M{"user_id": "z"}, assetDBOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	count, err = driver.DeleteMany(context.Background(), bson.M{"user_id": bson.M{"$ne": "z"}}, assetDBOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func testStorageMaps(t *testing.T, driver DatabaseClient) {
+	first := createMockMap("[]")
+	firstID, err := CreateMap(context.Background(), driver, first)
+	assert.Nil(t, err)
+	_, err = CreateMap(context.Background(), driver, first)
+	assert.Equal(t, errors.ErrMapExists, err)
+
+	// new primary map replaces the old one
+	second := createMockMap("[]")
+	second.Name = "second"
+	secondID, err := CreateMap(context.Background(), driver, second)
+	assert.Nil(t, err)
+	primary, err := GetPrimaryMapByUserID(context.Background(), driver, MockID)
+	assert.Nil(t, err)
+	assert.Equal(t, secondID, primary.ID)
+
+	maps, err := GetMapsByUserID(context.Background(), driver, MockID)
+	assert.Nil(t, err)
+	assert.Len(t, maps, 2)
+
+	// the primary map cannot be unset, only replaced
+	unset, set := false, true
+	_, err = UpdateMap(context.Background(), driver, secondID.Hex(), MapPatch{Primary: &unset})
+	assert.Nil(t, err)
+	primary, err = GetPrimaryMapByUserID(context.Background(), driver, MockID)
+	assert.Nil(t, err)
+	assert.Equal(t, secondID, primary.ID)
+
+	_, err = UpdateMap(context.Background(), driver, firstID.Hex(), MapPatch{Primary: &set})
+	assert.Nil(t, err)
+	primary, err = GetPrimaryMapByUserID(context.Background(), driver, MockID)
+	assert.Nil(t, err)
+	assert.Equal(t, firstID, primary.ID)
+
+	// a second primary map is rejected by the unique index
+	_, err = driver.CreateOne(context.Background(), Map[[]byte]{UserID: MockID, Name: "third", Primary: true}, mapsDBOptions)
+	assert.True(t, mongo.IsDuplicateKeyError(err))
+
+	// deleting the primary map promotes another one
+	err = DeleteMap(context.Background(), driver, firstID.Hex())
+	assert.Nil(t, err)
+	_, err = GetMapByID(context.Background(), driver, firstID.Hex())
+	assert.Equal(t, mongo.ErrNoDocuments, err)
+	primary, err = GetPrimaryMapByUserID(context.Background(), driver, MockID)
+	assert.Nil(t, err)
+	assert.Equal(t, secondID, primary.ID)
+}
+
+func testStorageMapQueries(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	_, err := GetAllMaps(ctx, driver)
+	assert.Equal(t, errors.ErrMapNotFound, err)
+	_, err = GetPrimaryMapByUserID(ctx, driver, MockID)
+	assert.Equal(t, errors.ErrMapNotFound, err)
+	_, err = GetMapsByUserID(ctx, driver, MockID)
+	assert.Equal(t, errors.ErrMapNotFound, err)
+	_, err = GetMapByNameUserID(ctx, driver, "name", MockID)
+	assert.Equal(t, errors.ErrMapNotFound, err)
+	_, err = GetMapsByIDs(ctx, driver, []string{primitive.NewObjectID().Hex()})
+	assert.Equal(t, errors.ErrMapNotFound, err)
+	_, err = GetMapByID(ctx, driver, "invalid-id")
+	assert.NotNil(t, err)
+	portals := []Portal{}
+	_, err = UpdateMap(ctx, driver, primitive.NewObjectID().Hex(), MapPatch{Portals: &portals})
+	assert.Equal(t, errors.ErrMapNotFound, err)
+	assert.NotNil(t, DeleteMap(ctx, driver, "invalid-id"))
+
+	m := createMockMap("[]")
+	mapID, err := CreateMap(ctx, driver, m)
+	assert.Nil(t, err)
+	maps, err := GetAllMaps(ctx, driver)
+	assert.Nil(t, err)
+	assert.Len(t, maps, 1)
+	maps, err = GetMapsByIDs(ctx, driver, []string{mapID.Hex()})
+	assert.Nil(t, err)
+	assert.Len(t, maps, 1)
+	byName, err := GetMapByNameUserID(ctx, driver, m.Name, m.UserID)
+	assert.Nil(t, err)
+	assert.Equal(t, mapID, byName.ID)
+
+	// a stale version returns the current one
+	version, err := UpdateMap(ctx, driver, mapID.Hex(), MapPatch{Portals: &portals})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, version)
+	stale := 1
+	version, err = UpdateMap(ctx, driver, mapID.Hex(), MapPatch{Portals: &portals, Version: &stale})
+	assert.Equal(t, errors.ErrVersionConflict, err)
+	assert.Equal(t, 2, version)
+
+	// pages start with the first map created
+	second := createMockMap("[]")
+	second.Name = "second"
+	_, err = CreateMap(ctx, driver, second)
+	assert.Nil(t, err)
+	page, err := ListMaps(ctx, driver, ListOptions{Limit: 1})
+	assert.Nil(t, err)
+	if assert.Len(t, page.Items, 1) {
+		assert.Equal(t, mapID, page.Items[0].ID)
+	}
+	assert.NotEmpty(t, page.NextCursor)
+	_, err = ListMaps(ctx, driver, ListOptions{Cursor: "invalid"})
+	assert.Equal(t, errors.ErrInvalidCursor, err)
+}
+
+func testStorageRepairPrimaryMaps(t *testing.T, driver DatabaseClient) {
+	for _, m := range []Map[[]byte]{
+		// no primary
+		{UserID: "a", Name: "one", Data: []byte("[]")},
+		{UserID: "a", Name: "two", Data: []byte("[]")},
+		// one primary
+		{UserID: "b", Name: "one", Primary: true, Data: []byte("[]")},
+		{UserID: "b", Name: "two", Data: []byte("[]")},
+	} {
+		_, err := driver.CreateOne(context.Background(), m, mapsDBOptions)
+		assert.Nil(t, err)
+	}
+
+	repaired, err := RepairPrimaryMaps(context.Background(), driver)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, repaired)
+
+	primary, err := GetPrimaryMapByUserID(context.Background(), driver, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "one", primary.Name)
+
+	repaired, err = RepairPrimaryMaps(context.Background(), driver)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, repaired)
+}
+
+func testStoragePartialUpdates(t *testing.T, driver DatabaseClient) {
+	m := createMockMap("[]")
+	m.Entrance = Entrance{X: 3, Y: 4}
+	m.Portals = []Portal{{MapID: MockID, X: 1, Y: 2}}
+	id, err := CreateMap(context.Background(), driver, m)
+	assert.Nil(t, err)
+
+	// fields missing from the patch are kept
+	data := `[{"name":"tile","asset_type":"tile","width":16,"height":16}]`
+	_, err = UpdateMap(context.Background(), driver, id.Hex(), MapPatch{Data: &data})
+	assert.Nil(t, err)
+	stored, err := GetMapByID(context.Background(), driver, id.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, m.Entrance, stored.Entrance)
+	assert.Equal(t, m.Portals, stored.Portals)
+	assert.Len(t, stored.Data, 1)
+
+	// zero values are written when set
+	portals := []Portal{}
+	_, err = UpdateMap(context.Background(), driver, id.Hex(), MapPatch{Entrance: &Entrance{}, Portals: &portals})
+	assert.Nil(t, err)
+	stored, err = GetMapByID(context.Background(), driver, id.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, Entrance{}, stored.Entrance)
+	assert.Empty(t, stored.Portals)
+	assert.Len(t, stored.Data, 1)
+
+	asset := CreateMockPlayerAsset("[]")
+	asset.X = 5
+	assetID, err := CreatePlayerAsset(context.Background(), driver, asset)
+	assert.Nil(t, err)
+	zero := 0
+	_, err = UpdatePlayerAsset(context.Background(), driver, assetID.Hex(), PlayerAssetPatch{X: &zero})
+	assert.Nil(t, err)
+	storedAsset, err := GetPlayerAssetByNameUserID(context.Background(), driver, asset.Name, asset.UserID)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, storedAsset.X)
+	assert.Equal(t, asset.AssetType, storedAsset.AssetType)
+}
+
+func testStorageListing(t *testing.T, driver DatabaseClient) {
+	names := []string{"cave", "beach", "castle", "desert", "city"}
+	ids := map[string]string{}
+	for i, name := range names {
+		m := createMockMap("[]")
+		m.Name = name
+		if i == 3 {
+			m.UserID = "other"
+		}
+		id, err := CreateMap(context.Background(), driver, m)
+		assert.Nil(t, err)
+		ids[name] = id.Hex()
+	}
+
+	// pages follow each other without gaps or repeats
+	var listed []string
+	l := ListOptions{Sort: SortName, Limit: 2}
+	for {
+		page, err := ListMaps(context.Background(), driver, l)
+		assert.Nil(t, err)
+		for _, m := range page.Items {
+			listed = append(listed, m.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		assert.Len(t, page.Items, 2)
+		l.Cursor = page.NextCursor
+	}
+	assert.Equal(t, []string{"beach", "castle", "cave", "city", "desert"}, listed)
+
+	// filters and descending order
+	page, err := ListMaps(context.Background(), driver, ListOptions{
+		Sort:       SortName,
+		Descending: true,
+		NamePrefix: "c",
+		UserID:     MockID,
+	})
+	assert.Nil(t, err)
+	listed = nil
+	for _, m := range page.Items {
+		listed = append(listed, m.Name)
+	}
+	assert.Equal(t, []string{"city", "cave", "castle"}, listed)
+
+	// popularity with summaries
+	assert.Nil(t, RecordMapVisit(context.Background(), driver, ids["city"]))
+	assert.Nil(t, RecordMapVisit(context.Background(), driver, ids["city"]))
+	assert.Nil(t, RecordMapVisit(context.Background(), driver, ids["beach"]))
+	page, err = ListMaps(context.Background(), driver, ListOptions{
+		Sort:       SortPopularity,
+		Descending: true,
+		Limit:      2,
+		Summary:    true,
+	})
+	assert.Nil(t, err)
+	assert.Len(t, page.Items, 2)
+	assert.Equal(t, "city", page.Items[0].Name)
+	assert.Equal(t, 2, page.Items[0].Visits)
+	assert.Equal(t, "beach", page.Items[1].Name)
+	assert.Nil(t, page.Items[0].Data)
+	assert.NotEmpty(t, page.NextCursor)
+
+	// ties are paged by creation
+	for _, sort := range []ListSort{SortPopularity, SortUpdated} {
+		listed = nil
+		l = ListOptions{Sort: sort, Descending: true, Limit: 2, Summary: true}
+		for {
+			page, err := ListMaps(context.Background(), driver, l)
+			assert.Nil(t, err)
+			for _, m := range page.Items {
+				listed = append(listed, m.Name)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			l.Cursor = page.NextCursor
+		}
+		if sort == SortPopularity {
+			assert.Equal(t, []string{"city", "beach", "desert", "castle", "cave"}, listed)
+		} else {
+			assert.ElementsMatch(t, names, listed)
+		}
+	}
+
+	// a cursor only continues the listing that returned it
+	_, err = ListMaps(context.Background(), driver, ListOptions{Sort: SortName, Cursor: page.NextCursor})
+	assert.Equal(t, errors.ErrInvalidCursor, err)
+
+	asset := CreateMockPlayerAsset("[]")
+	_, err = CreatePlayerAsset(context.Background(), driver, asset)
+	assert.Nil(t, err)
+	assets, err := ListPlayerAssets(context.Background(), driver, ListOptions{UserID: MockID, AssetType: ASSET_TILE})
+	assert.Nil(t, err)
+	assert.Empty(t, assets.Items)
+	assets, err = ListPlayerAssets(context.Background(), driver, ListOptions{UserID: MockID, AssetType: asset.AssetType})
+	assert.Nil(t, err)
+	assert.Len(t, assets.Items, 1)
+	_, err = ListPlayerAssets(context.Background(), driver, ListOptions{Sort: SortPopularity})
+	assert.Equal(t, errors.ErrInvalidSort, err)
+}
+
+func testStorageVisits(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	mapID, err := CreateMap(ctx, driver, createMockMap("[]"))
+	assert.Nil(t, err)
+	before, err := GetMapByID(ctx, driver, mapID.Hex())
+	assert.Nil(t, err)
+
+	// concurrent visits are all counted
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, RecordMapVisit(ctx, driver, mapID.Hex()))
+		}()
+	}
+	wg.Wait()
+	after, err := GetMapByID(ctx, driver, mapID.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, 10, after.Visits)
+	assert.True(t, before.UpdatedAt.Equal(after.UpdatedAt))
+
+	// increments and sets combine
+	_, err = driver.UpdateOne(ctx, mapID.Hex(), Update{}.Inc("visits", 2).Set("name", "busy").Inc("version", 1), mapsDBOptions)
+	assert.Nil(t, err)
+	var m struct {
+		Name    string `bson:"name"`
+		Visits  int    `bson:"visits"`
+		Version int    `bson:"version"`
+	}
+	res, err := driver.GetOne(ctx, bson.M{"_id": mapID}, mapsDBOptions)
+	assert.Nil(t, err)
+	assert.Nil(t, utils.UnmarshalBSON(res, &m))
+	assert.Equal(t, "busy", m.Name)
+	assert.Equal(t, 12, m.Visits)
+	assert.Equal(t, 2, m.Version)
+
+	assert.Equal(t, errors.ErrMapNotFound, RecordMapVisit(ctx, driver, primitive.NewObjectID().Hex()))
+}
+
+func testStorageTrash(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	mapID, err := CreateMap(ctx, driver, createMockMap("[]"))
+	assert.Nil(t, err)
+	_map, err := GetMapByID(ctx, driver, mapID.Hex())
+	assert.Nil(t, err)
+	assert.False(t, _map.CreatedAt.IsZero())
+	assert.False(t, _map.UpdatedAt.IsZero())
+	assert.Nil(t, _map.DeletedAt)
+
+	assetID, err := CreatePlayerAsset(ctx, driver, CreateMockPlayerAsset("[]"))
+	assert.Nil(t, err)
+
+	// deleted documents are hidden and free their name
+	assert.Nil(t, DeleteMap(ctx, driver, mapID.Hex()))
+	_, err = DeletePlayerAsset(ctx, driver, assetID.Hex())
+	assert.Nil(t, err)
+	_, err = GetMapByID(ctx, driver, mapID.Hex())
+	assert.Equal(t, mongo.ErrNoDocuments, err)
+	assets, err := GetPlayerAssetsByUserID(ctx, driver, MockID)
+	assert.Nil(t, err)
+	assert.Len(t, assets, 0)
+
+	trash, err := GetTrash(ctx, driver, MockID)
+	assert.Nil(t, err)
+	if assert.Len(t, trash.Maps, 1) && assert.Len(t, trash.Assets, 1) {
+		assert.Equal(t, mapID, trash.Maps[0].ID)
+		assert.NotNil(t, trash.Maps[0].DeletedAt)
+		assert.Equal(t, assetID, trash.Assets[0].ID)
+	}
+
+	replacementID, err := CreateMap(ctx, driver, createMockMap("[]"))
+	assert.Nil(t, err)
+	assert.Equal(t, errors.ErrMapExists, RestoreMap(ctx, driver, MockID, mapID.Hex()))
+	assert.Nil(t, DeleteMap(ctx, driver, replacementID.Hex()))
+
+	// only the owner restores, once
+	assert.Equal(t, errors.ErrMapNotFound, RestoreMap(ctx, driver, "other", mapID.Hex()))
+	assert.Nil(t, RestoreMap(ctx, driver, MockID, mapID.Hex()))
+	assert.Equal(t, errors.ErrMapNotFound, RestoreMap(ctx, driver, MockID, mapID.Hex()))
+	primary, err := GetPrimaryMapByUserID(ctx, driver, MockID)
+	assert.Nil(t, err)
+	assert.Equal(t, mapID, primary.ID)
+
+	assert.Nil(t, RestorePlayerAsset(ctx, driver, MockID, assetID.Hex()))
+	assert.Equal(t, errors.ErrImageNotFound, RestorePlayerAsset(ctx, driver, MockID, assetID.Hex()))
+
+	// only documents deleted before the cutoff are purged
+	count, err := PurgeTrash(ctx, driver, time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+	count, err = PurgeTrash(ctx, driver, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	trash, err = GetTrash(ctx, driver, MockID)
+	assert.Nil(t, err)
+	assert.Len(t, trash.Maps, 0)
+	assert.Len(t, trash.Assets, 0)
+}
+
+func testStorageExport(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	userID, err := CreateUser(ctx, driver, User{UserName: "username", Password: "passwordABC123"})
+	assert.Nil(t, err)
+	m := createMockMap("[]")
+	m.UserID = userID.Hex()
+	mapID, err := CreateMap(ctx, driver, m)
+	assert.Nil(t, err)
+	data := `[{"name":"edited","asset_type":"tile","width":16,"height":16}]`
+	_, err = UpdateMap(ctx, driver, mapID.Hex(), MapPatch{Data: &data})
+	assert.Nil(t, err)
+	asset := CreateMockPlayerAsset("[]")
+	asset.UserID = userID.Hex()
+	assetID, err := CreatePlayerAsset(ctx, driver, asset)
+	assert.Nil(t, err)
+
+	// trashed maps and assets are exported with every revision
+	assert.Nil(t, DeleteMap(ctx, driver, mapID.Hex()))
+	_, err = DeletePlayerAsset(ctx, driver, assetID.Hex())
+	assert.Nil(t, err)
+	export, err := ExportUserData(ctx, driver, userID.Hex())
+	assert.Nil(t, err)
+	assert.Empty(t, export.User.Password)
+	if assert.Len(t, export.Maps, 1) {
+		assert.Equal(t, mapID, export.Maps[0].ID)
+		assert.NotNil(t, export.Maps[0].DeletedAt)
+	}
+	if assert.Len(t, export.Assets, 1) {
+		assert.Equal(t, assetID, export.Assets[0].ID)
+		assert.NotNil(t, export.Assets[0].DeletedAt)
+	}
+	if assert.Len(t, export.Revisions, 2) {
+		assert.Equal(t, 1, export.Revisions[0].Version)
+		assert.Len(t, export.Revisions[0].Data, 0)
+		assert.Equal(t, 2, export.Revisions[1].Version)
+		if assert.Len(t, export.Revisions[1].Data, 1) {
+			assert.Equal(t, "edited", export.Revisions[1].Data[0].Name)
+		}
+	}
+}
+
+func testStorageCreateAssets(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	up := CreateMockPlayerAsset("[]")
+	up.Name = "sprite_up"
+	_, err := CreatePlayerAsset(ctx, driver, up)
+	assert.Nil(t, err)
+
+	// an existing name rolls back the assets created before it
+	down := CreateMockPlayerAsset("[]")
+	down.Name = "sprite_down"
+	down.AssetType = ASSET_PLAYER_DOWN
+	_, err = CreatePlayerAssets(ctx, driver, []PlayerAsset[string]{down, up})
+	assert.Equal(t, errors.ErrImageExists, err)
+	_, err = GetPlayerAssetByNameUserID(ctx, driver, down.Name, MockID)
+	assert.NotNil(t, err)
+
+	up.Name = "other_up"
+	ids, err := CreatePlayerAssets(ctx, driver, []PlayerAsset[string]{down, up})
+	assert.Nil(t, err)
+	assert.Len(t, ids, 2)
+	assets, err := GetPlayerAssetsByUserID(ctx, driver, MockID)
+	assert.Nil(t, err)
+	assert.Len(t, assets, 3)
+}
+
+func testStorageVersions(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	mapID, err := CreateMap(ctx, driver, createMockMap("[]"))
+	assert.Nil(t, err)
+	_map, err := GetMapByID(ctx, driver, mapID.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, _map.Version)
+
+	// every update increments the version
+	data := `[{"name":"edited","asset_type":"tile","width":16,"height":16}]`
+	version, err := UpdateMap(ctx, driver, mapID.Hex(), MapPatch{Data: &data})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, version)
+
+	// a patch of an older version is rejected with the current one
+	stale := 1
+	version, err = UpdateMap(ctx, driver, mapID.Hex(), MapPatch{Data: &data, Version: &stale})
+	assert.Equal(t, errors.ErrVersionConflict, err)
+	assert.Equal(t, 2, version)
+	version, err = UpdateMap(ctx, driver, mapID.Hex(), MapPatch{Data: &data, Version: &version})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, version)
+
+	assetID, err := CreatePlayerAsset(ctx, driver, CreateMockPlayerAsset("[]"))
+	assert.Nil(t, err)
+	x := 4
+	version, err = UpdatePlayerAsset(ctx, driver, assetID.Hex(), PlayerAssetPatch{X: &x, Version: &stale})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, version)
+	version, err = UpdatePlayerAsset(ctx, driver, assetID.Hex(), PlayerAssetPatch{X: &x, Version: &stale})
+	assert.Equal(t, errors.ErrVersionConflict, err)
+	assert.Equal(t, 2, version)
+	asset, err := GetPlayerAssetByNameUserID(ctx, driver, "test_image", MockID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, asset.Version)
+	assert.Equal(t, 4, asset.X)
+}
+
+func testStorageRevisions(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	mapData := func(assets ...PlayerAsset[PixelData]) string {
+		b, err := json.Marshal(assets)
+		assert.Nil(t, err)
+		return string(b)
+	}
+	tree := PlayerAsset[PixelData]{Name: "tree", AssetType: ASSET_OBJECT, X: 1, Y: 1, Width: 16, Height: 16}
+	rock := PlayerAsset[PixelData]{Name: "rock", AssetType: ASSET_OBJECT, X: 2, Y: 2, Width: 16, Height: 16}
+	grass := PlayerAsset[PixelData]{Name: "grass", AssetType: ASSET_TILE, X: 0, Y: 0, Width: 16, Height: 16}
+
+	m := createMockMap(mapData(tree, rock))
+	mapID, err := CreateMap(ctx, driver, m)
+	assert.Nil(t, err)
+
+	// move the tree, remove the rock and add grass
+	movedTree := tree
+	movedTree.X = 5
+	data := mapData(movedTree, grass)
+	version, err := UpdateMap(ctx, driver, mapID.Hex(), MapPatch{Data: &data, Message: "edit", Author: "editor"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, version)
+
+	revisions, err := GetMapRevisions(ctx, driver, mapID.Hex())
+	assert.Nil(t, err)
+	if assert.Len(t, revisions, 2) {
+		assert.Equal(t, 2, revisions[0].Version)
+		assert.Equal(t, "edit", revisions[0].Message)
+		assert.Equal(t, "editor", revisions[0].Author)
+		assert.Nil(t, revisions[0].Data)
+		assert.Equal(t, 1, revisions[1].Version)
+		assert.Equal(t, MockID, revisions[1].Author)
+	}
+
+	first, err := GetMapRevision(ctx, driver, mapID.Hex(), 1)
+	assert.Nil(t, err)
+	assert.Len(t, first.Data, 2)
+	_, err = GetMapRevision(ctx, driver, mapID.Hex(), 3)
+	assert.Equal(t, errors.ErrRevisionNotFound, err)
+
+	diff, err := DiffMapRevisions(ctx, driver, mapID.Hex(), 1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []PlacedAsset{{Name: "grass", AssetType: ASSET_TILE}}, diff.Added)
+	assert.Equal(t, []PlacedAsset{{Name: "rock", AssetType: ASSET_OBJECT, X: 2, Y: 2}}, diff.Removed)
+	assert.Equal(t, []MovedAsset{{Name: "tree", AssetType: ASSET_OBJECT, FromX: 1, FromY: 1, X: 5, Y: 1}}, diff.Moved)
+
+	// rolling back adds a revision with the old content
+	stale := 1
+	_, err = RollbackMap(ctx, driver, mapID.Hex(), 1, MapPatch{Version: &stale})
+	assert.Equal(t, errors.ErrVersionConflict, err)
+	version, err = RollbackMap(ctx, driver, mapID.Hex(), 1, MapPatch{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, version)
+	_map, err := GetMapByID(ctx, driver, mapID.Hex())
+	assert.Nil(t, err)
+	if assert.Len(t, _map.Data, 2) {
+		assert.Equal(t, "tree", _map.Data[0].Name)
+		assert.Equal(t, 1, _map.Data[0].X)
+	}
+	latest, err := GetMapRevision(ctx, driver, mapID.Hex(), 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "rollback to revision 1", latest.Message)
+
+	// revisions are purged with their map
+	assert.Nil(t, DeleteMap(ctx, driver, mapID.Hex()))
+	_, err = PurgeTrash(ctx, driver, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	revisions, err = GetMapRevisions(ctx, driver, mapID.Hex())
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 0)
+}
+
+func testStorageCache(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	SetCaches(cache.NewLRU[Map[[]PlayerAsset[PixelData]]](10), cache.NewLRU[[]PlayerAsset[PixelData]](10))
+	defer SetCaches(nil, nil)
+
+	mapID, err := CreateMap(ctx, driver, createMockMap("[]"))
+	assert.Nil(t, err)
+	_map, err := GetMapByID(ctx, driver, mapID.Hex())
+	assert.Nil(t, err)
+	// appending characters does not change the cached map
+	_, err = AppendMapPlayerCharacter(ctx, driver, MockID, _map)
+	assert.Nil(t, err)
+	_map, err = GetMapByID(ctx, driver, mapID.Hex())
+	assert.Nil(t, err)
+	assert.Len(t, _map.Data, 0)
+	assert.Equal(t, cache.Stats{Hits: 1, Misses: 1}, CacheStats()["maps"])
+
+	// updates are read back
+	data := `[{"name":"tree","asset_type":"tile","width":16,"height":16}]`
+	_, err = UpdateMap(ctx, driver, mapID.Hex(), MapPatch{Data: &data})
+	assert.Nil(t, err)
+	_map, err = GetMapByID(ctx, driver, mapID.Hex())
+	assert.Nil(t, err)
+	assert.Len(t, _map.Data, 1)
+
+	// reads started before an invalidation are not cached
+	generation := mapFills.start()
+	invalidateMaps(mapID.Hex())
+	cacheMap(driver, _map, generation)
+	_, ok := cachedMap(mapID.Hex())
+	assert.False(t, ok)
+	// nor are reads in transactions
+	assert.Nil(t, withTransaction(ctx, driver, func(tx DatabaseClient) error {
+		_, err := GetMapByID(ctx, tx, mapID.Hex())
+		return err
+	}))
+	_, ok = cachedMap(mapID.Hex())
+	assert.False(t, ok)
+	assert.Nil(t, DeleteMap(ctx, driver, mapID.Hex()))
+	_, err = GetMapByID(ctx, driver, mapID.Hex())
+	assert.Equal(t, mongo.ErrNoDocuments, err)
+
+	// users without characters are cached until they create one
+	chars, err := GetPlayerCharactersByUserIDs(ctx, driver, []string{MockID})
+	assert.Nil(t, err)
+	assert.Len(t, chars, 0)
+	assetID, err := CreatePlayerAsset(ctx, driver, CreateMockPlayerAsset("[]"))
+	assert.Nil(t, err)
+	chars, err = GetPlayerCharactersByUserIDs(ctx, driver, []string{MockID, MockID})
+	assert.Nil(t, err)
+	assert.Len(t, chars, 1)
+	_, err = GetPlayerCharactersByUserIDs(ctx, driver, []string{MockID})
+	assert.Nil(t, err)
+	assert.Equal(t, cache.Stats{Hits: 2, Misses: 2}, CacheStats()["characters"])
+
+	_, err = DeletePlayerAsset(ctx, driver, assetID.Hex())
+	assert.Nil(t, err)
+	chars, err = GetPlayerCharactersByUserIDs(ctx, driver, []string{MockID})
+	assert.Nil(t, err)
+	assert.Len(t, chars, 0)
+}
+
+func testStoragePrimaryMaps(t *testing.T, driver DatabaseClient) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := createMockMap("[]")
+			m.Name = fmt.Sprintf("map%d", i)
+			m.Primary = i%2 == 0
+			// conflicting transactions may give up, the invariant must hold regardless
+			CreateMap(context.Background(), driver, m)
+		}()
+	}
+	wg.Wait()
+
+	maps, err := GetMapsByUserID(context.Background(), driver, MockID)
+	assert.Nil(t, err)
+	primaries := 0
+	for _, m := range maps {
+		if m.Primary {
+			primaries++
+		}
+	}
+	assert.Equal(t, 1, primaries)
+
+	// a primary switch with a stale version keeps the primary map
+	primary, stale := true, 0
+	for _, m := range maps {
+		if m.Primary {
+			continue
+		}
+		_, err = UpdateMap(context.Background(), driver, m.ID.Hex(), MapPatch{Primary: &primary, Version: &stale})
+		assert.Equal(t, errors.ErrVersionConflict, err)
+		break
+	}
+	for _, m := range maps {
+		if m.Primary {
+			current, err := GetMapByID(context.Background(), driver, m.ID.Hex())
+			assert.Nil(t, err)
+			assert.True(t, current.Primary)
+		}
+	}
+}
+
+func testStorageTokens(t *testing.T, driver DatabaseClient) {
+	token, err := CreateUserToken(context.Background(), driver, MockID, utils.PasswordResetPurpose, time.Hour)
+	assert.Nil(t, err)
+
+	userID, err := ConsumeUserToken(context.Background(), driver, token, utils.PasswordResetPurpose)
+	assert.Nil(t, err)
+	assert.Equal(t, MockID, userID)
+
+	// single use
+	_, err = ConsumeUserToken(context.Background(), driver, token, utils.PasswordResetPurpose)
+	assert.Equal(t, errors.ErrInvalidToken, err)
+}
+
+func testStorageMFARoles(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	ids := map[string]primitive.ObjectID{}
+	for _, u := range []User{
+		{UserName: "creator", Role: CreatorRole},
+		{UserName: "enrolled", Role: CreatorRole, MFAEnabled: true},
+		{UserName: "player", Role: PlayerRole},
+	} {
+		id, err := driver.CreateOne(ctx, u, userDBOptions)
+		assert.Nil(t, err)
+		ids[u.UserName], _ = primitive.ObjectIDFromHex(id)
+	}
+	issuedAt := time.Now().Add(-time.Minute)
+
+	// requiring MFA revokes the tokens of users who have to enroll
+	assert.Nil(t, SetMFARequiredRoles(ctx, driver, []Role{CreatorRole}))
+	assert.True(t, TokenRevoked(ids["creator"].Hex(), issuedAt))
+	assert.False(t, TokenRevoked(ids["enrolled"].Hex(), issuedAt))
+	assert.False(t, TokenRevoked(ids["player"].Hex(), issuedAt))
+	settings, err := GetSettings(ctx, driver)
+	assert.Nil(t, err)
+	assert.Equal(t, []Role{CreatorRole}, settings.MFARequiredRoles)
+}
+
+func testStorageRoles(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	id, err := CreateUser(ctx, driver, createMockUser())
+	assert.Nil(t, err)
+	user, err := GetUserByID(ctx, driver, id.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultRole, user.Role)
+
+	// users created before roles get the default
+	old, err := driver.CreateOne(ctx, bson.M{"username": "old"}, userDBOptions)
+	assert.Nil(t, err)
+	assert.Nil(t, backfillUserRoles(ctx, driver))
+	user, err = GetUserByID(ctx, driver, old)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultRole, user.Role)
+
+	assert.Equal(t, errors.ErrInvalidRole, SetUserRole(ctx, driver, id.Hex(), "superuser"))
+	assert.Nil(t, SetMFARequiredRoles(ctx, driver, []Role{AdminRole}))
+	issuedAt := time.Now().Add(-time.Minute)
+	assert.False(t, TokenRevoked(id.Hex(), issuedAt))
+	// a role requiring MFA revokes the tokens of the user
+	assert.Nil(t, SetUserRole(ctx, driver, id.Hex(), AdminRole))
+	user, err = GetUserByID(ctx, driver, id.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, AdminRole, user.Role)
+	assert.True(t, TokenRevoked(id.Hex(), issuedAt))
+}
+
+func testStorageTOTPSteps(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	id, err := CreateUser(ctx, driver, createMockUser())
+	assert.Nil(t, err)
+	user := User{ID: id}
+
+	// each time step is accepted once and earlier ones not at all
+	for _, c := range []struct {
+		step int64
+		ok   bool
+	}{{5, true}, {5, false}, {4, false}, {6, true}} {
+		ok, err := AcceptTOTPStep(ctx, driver, user, c.step)
+		assert.Nil(t, err)
+		assert.Equal(t, c.ok, ok, c.step)
+	}
+}
+
+func testStoragePixels(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	pixels := createMockPixelData(8, 8, 3)
+	legacyPixels, err := json.Marshal(pixels)
+	assert.Nil(t, err)
+	legacyMap, err := json.Marshal([]PlayerAsset[PixelData]{{Name: "tree", X: 16, Data: pixels}})
+	assert.Nil(t, err)
+
+	// documents saved before the compact format are read as JSON
+	asset := CreateMockPlayerAsset("")
+	_, err = driver.CreateOne(ctx, PlayerAsset[[]byte]{
+		UserID:    asset.UserID,
+		Name:      asset.Name,
+		AssetType: asset.AssetType,
+		Version:   1,
+		Data:      legacyPixels,
+	}, assetDBOptions)
+	assert.Nil(t, err)
+	mapID, err := driver.CreateOne(ctx, Map[[]byte]{
+		UserID:  MockID,
+		Name:    "legacy",
+		Version: 1,
+		Data:    legacyMap,
+	}, mapsDBOptions)
+	assert.Nil(t, err)
+	before, err := GetMapByID(ctx, driver, mapID)
+	assert.Nil(t, err)
+	assert.Equal(t, pixels, before.Data[0].Data)
+
+	// the migration converts them without changing what clients read
+	assert.Nil(t, compactPixelData(ctx, driver))
+	assert.Nil(t, compactPixelData(ctx, driver))
+	var stored []Map[[]byte]
+	assert.Nil(t, driver.Get(ctx, bson.M{}, mapsDBOptions, &stored))
+	assert.Len(t, stored, 1)
+	assert.Equal(t, mapDataMagic, string(stored[0].Data[:len(mapDataMagic)]))
+	assert.Less(t, len(stored[0].Data), len(legacyMap))
+	after, err := GetMapByID(ctx, driver, mapID)
+	assert.Nil(t, err)
+	assert.Equal(t, before.Data[0].Data, after.Data[0].Data)
+	assert.True(t, before.UpdatedAt.Equal(after.UpdatedAt))
+	// assets saved without a size get the size of their pixels
+	assert.Equal(t, 8, after.Data[0].Width)
+	assert.Equal(t, 8, after.Data[0].Height)
+
+	chars, err := GetPlayerCharactersByUserIDs(ctx, driver, []string{MockID})
+	assert.Nil(t, err)
+	assert.Len(t, chars, 1)
+	assert.Equal(t, pixels, chars[0].Data)
+}
+
+func testStorageAnimations(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	walk := map[string]Animation{"up": {Frames: []int{0, 1, 2, 1}, Durations: []int{100, 100, 200, 100}}}
+	frames := []PixelData{createMockPixelData(2, 2, 1), createMockPixelData(2, 2, 2)}
+	var encoded []string
+	for _, f := range frames {
+		b, err := json.Marshal(f)
+		assert.Nil(t, err)
+		encoded = append(encoded, string(b))
+	}
+
+	asset := CreateMockPlayerAsset("[]")
+	asset.Frames = encoded
+	asset.Animations = map[string]Animation{"up": {Frames: []int{3}, Durations: []int{100}}}
+	_, err := CreatePlayerAsset(ctx, driver, asset)
+	assert.Equal(t, errors.FieldErrors{"animations": errors.ErrInvalidAnimation}, err)
+	asset.Animations = walk
+	assetID, err := CreatePlayerAsset(ctx, driver, asset)
+	assert.Nil(t, err)
+
+	// frames are delivered with characters
+	chars, err := GetPlayerCharactersByUserIDs(ctx, driver, []string{MockID})
+	assert.Nil(t, err)
+	assert.Len(t, chars, 1)
+	assert.Equal(t, frames, chars[0].Frames)
+	assert.Equal(t, walk, chars[0].Animations)
+
+	// summaries leave frames out
+	page, err := ListPlayerAssets(ctx, driver, ListOptions{Summary: true})
+	assert.Nil(t, err)
+	assert.Len(t, page.Items, 1)
+	assert.Nil(t, page.Items[0].Frames)
+	assert.Equal(t, walk, page.Items[0].Animations)
+
+	// removing frames an animation plays is rejected
+	none := []string{}
+	_, err = UpdatePlayerAsset(ctx, driver, assetID.Hex(), PlayerAssetPatch{Frames: &none})
+	assert.Equal(t, errors.FieldErrors{"animations": errors.ErrInvalidAnimation}, err)
+	noAnimations := map[string]Animation{}
+	_, err = UpdatePlayerAsset(ctx, driver, assetID.Hex(), PlayerAssetPatch{Frames: &none, Animations: &noAnimations})
+	assert.Nil(t, err)
+	updated, err := GetPlayerAssetByID(ctx, driver, assetID.Hex())
+	assert.Nil(t, err)
+	assert.Len(t, updated.Frames, 0)
+	assert.Len(t, updated.Animations, 0)
+
+	// animated map objects
+	objects, err := json.Marshal([]PlayerAsset[PixelData]{{
+		Name:       "torch",
+		AssetType:  ASSET_OBJECT,
+		Width:      2,
+		Height:     2,
+		Data:       frames[0],
+		Frames:     frames[1:],
+		Animations: map[string]Animation{"burn": {Frames: []int{0, 1}, Durations: []int{150, 150}}},
+	}})
+	assert.Nil(t, err)
+	mapID, err := CreateMap(ctx, driver, createMockMap(string(objects)))
+	assert.Nil(t, err)
+	_map, err := GetMapByID(ctx, driver, mapID.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, frames[1:], _map.Data[0].Frames)
+	assert.Len(t, _map.Data[0].Animations, 1)
+}
+
+func testStorageAssetQueries(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	asset := CreateMockPlayerAsset("[]")
+	assetID, err := CreatePlayerAsset(ctx, driver, asset)
+	assert.Nil(t, err)
+	_, err = CreatePlayerAsset(ctx, driver, asset)
+	assert.Equal(t, errors.ErrImageExists, err)
+
+	assets, err := GetPlayerAssetsByUserID(ctx, driver, asset.UserID)
+	assert.Nil(t, err)
+	if assert.Len(t, assets, 1) {
+		assert.Equal(t, asset.Name, assets[0].Name)
+		assert.Equal(t, asset.AssetType, assets[0].AssetType)
+		assert.Equal(t, asset.Width, assets[0].Width)
+		assert.Equal(t, asset.Height, assets[0].Height)
+	}
+	byName, err := GetPlayerAssetByNameUserID(ctx, driver, asset.Name, asset.UserID)
+	assert.Nil(t, err)
+	assert.Equal(t, assetID, byName.ID)
+	_, err = GetPlayerAssetByNameUserID(ctx, driver, "", "")
+	assert.Equal(t, errors.ErrImageNotFound, err)
+
+	// characters are appended to maps, falling back to the default one
+	_map, err := AppendMapPlayerCharacter(ctx, driver, asset.UserID, createMockMap([]PlayerAsset[PixelData]{}))
+	assert.Nil(t, err)
+	assert.Len(t, _map.Data, 1)
+	_, err = GetDefaultPlayerCharacter(ctx, driver)
+	assert.Equal(t, errors.ErrImageNotFound, err)
+	defaultChar := CreateMockPlayerAsset("[]")
+	defaultChar.UserID, defaultChar.Name = config.Env().ADMIN_ID, "default_character"
+	_, err = CreatePlayerAsset(ctx, driver, defaultChar)
+	assert.Nil(t, err)
+	_, err = GetDefaultPlayerCharacter(ctx, driver)
+	assert.Nil(t, err)
+
+	// a stale version returns the current one
+	x := 1
+	version, err := UpdatePlayerAsset(ctx, driver, assetID.Hex(), PlayerAssetPatch{X: &x})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, version)
+	stale := 1
+	version, err = UpdatePlayerAsset(ctx, driver, assetID.Hex(), PlayerAssetPatch{X: &x, Version: &stale})
+	assert.Equal(t, errors.ErrVersionConflict, err)
+	assert.Equal(t, 2, version)
+
+	_, err = DeletePlayerAsset(ctx, driver, "wrong_id_format")
+	assert.NotNil(t, err)
+	count, err := DeletePlayerAsset(ctx, driver, assetID.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func testStorageImages(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	images := []assets.Image{
+		{Name: "grass", Width: 16, Height: 16, Frames: []assets.FrameSpec{{W: 16, H: 16}}, Data: []byte{1}},
+		{Name: "rock", Width: 16, Height: 16, Frames: []assets.FrameSpec{{W: 16, H: 16}}, Data: []byte{2}},
+	}
+	// images from other sources are not the catalog's to change
+	_, err := driver.CreateOne(ctx, assets.Image{Name: "uploaded", Data: []byte{4}}, imageDBOptions)
+	assert.Nil(t, err)
+	_, err = driver.CreateOne(ctx, assets.Image{Name: "rock", Data: []byte{5}}, imageDBOptions)
+	assert.Nil(t, err)
+
+	changed, err := SyncImages(ctx, driver, images)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, changed)
+
+	// unchanged images are left alone
+	changed, err = SyncImages(ctx, driver, images)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, changed)
+
+	// images are updated and removed to match
+	images = []assets.Image{images[0]}
+	images[0].Data = []byte{3}
+	changed, err = SyncImages(ctx, driver, images)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, changed)
+	changed, err = SyncImages(ctx, driver, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, changed)
+	stored, err := GetImages(ctx, driver)
+	assert.Nil(t, err)
+	data := map[string][]byte{}
+	for _, img := range stored {
+		data[img.Name] = img.Data
+	}
+	assert.Equal(t, map[string][]byte{"uploaded": {4}, "rock": {5}}, data)
+}
+
+func testStorageValidation(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	asset := CreateMockPlayerAsset(`[[{"x":20,"y":0,"r":300,"g":0,"b":0,"a":255,"color":"red"}]]`)
+	asset.AssetType = "hat"
+	asset.Height = 1000
+	asset.Frames = []string{"not pixels"}
+	_, err := CreatePlayerAsset(ctx, driver, asset)
+	assert.Equal(t, errors.FieldErrors{
+		"asset_type": errors.ErrUnknownAssetType,
+		"data":       errors.ErrSizeMismatch,
+		"frames[0]":  errors.ErrImageWrongFormat,
+	}, err)
+	asset.AssetType = ASSET_TILE
+	asset.Frames = nil
+	_, err = CreatePlayerAsset(ctx, driver, asset)
+	assert.Equal(t, errors.FieldErrors{
+		"height": errors.ErrImageTooLarge,
+		"data":   errors.ErrSizeMismatch,
+	}, err)
+
+	// channels out of range are clamped
+	asset.Width, asset.Height = 32, 16
+	assetID, err := CreatePlayerAsset(ctx, driver, asset)
+	assert.Nil(t, err)
+	stored, err := GetPlayerAssetByID(ctx, driver, assetID.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, 255, stored.Data[0][0].R)
+
+	// updates are checked against the stored fields they leave unchanged
+	width := 16
+	_, err = UpdatePlayerAsset(ctx, driver, assetID.Hex(), PlayerAssetPatch{Width: &width})
+	assert.Equal(t, errors.FieldErrors{"data": errors.ErrSizeMismatch}, err)
+	data := "[]"
+	version, err := UpdatePlayerAsset(ctx, driver, assetID.Hex(), PlayerAssetPatch{Width: &width, Data: &data})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, version)
+
+	// assets of maps are validated too
+	mapData := `[{"name":"tile","asset_type":"tile","width":16,"height":16,"data":[[{"x":6000,"y":6000}]]}]`
+	_, err = CreateMap(ctx, driver, createMockMap(mapData))
+	assert.Equal(t, errors.FieldErrors{"data[0].data": errors.ErrSizeMismatch}, err)
+}
+
+func testStorageMapAssets(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	grass := PlayerAsset[PixelData]{Name: "grass", AssetType: ASSET_TILE, Width: 2, Height: 2, Data: createMockPixelData(2, 2, 3)}
+	tree := PlayerAsset[PixelData]{Name: "tree", AssetType: ASSET_OBJECT, Width: 4, Height: 4, Layer: 1, Data: createMockPixelData(4, 4, 2)}
+	var assets []PlayerAsset[PixelData]
+	for x := 0; x < 10; x++ {
+		grass.X = x * 2
+		assets = append(assets, grass)
+	}
+	assets = append(assets, tree)
+	data, err := json.Marshal(assets)
+	assert.Nil(t, err)
+
+	m := createMockMap(string(data))
+	mapID, err := CreateMap(ctx, driver, m)
+	assert.Nil(t, err)
+	m.Name = "copy"
+	_, err = CreateMap(ctx, driver, m)
+	assert.Nil(t, err)
+	var stored []mapAsset
+	assert.Nil(t, driver.Get(ctx, bson.M{}, mapAssetDBOptions, &stored))
+	assert.Len(t, stored, 2)
+
+	// maps are returned with their assets placed
+	loaded, err := GetMapByID(ctx, driver, mapID.Hex())
+	assert.Nil(t, err)
+	loadedData, err := json.Marshal(loaded.Data)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(data), string(loadedData))
+	revision, err := GetMapRevision(ctx, driver, mapID.Hex(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, loaded.Data, revision.Data)
+
+	// maps stored before assets were moved out keep their assets
+	embedded, err := encodeMapData(assets)
+	assert.Nil(t, err)
+	legacy := Map[[]byte]{UserID: "legacy", Name: "legacy", Version: 1, Data: embedded}
+	legacyID, err := driver.CreateOne(ctx, legacy, mapsDBOptions)
+	assert.Nil(t, err)
+	assert.Nil(t, dedupeMapAssets(ctx, driver))
+	migrated, err := GetMapByID(ctx, driver, legacyID)
+	assert.Nil(t, err)
+	assert.Equal(t, loaded.Data, migrated.Data)
+	assert.Nil(t, driver.Get(ctx, bson.M{}, mapAssetDBOptions, &stored))
+	assert.Len(t, stored, 2)
+
+	// maps placing content are found past the first page
+	var legacyMaps []Map[[]byte]
+	assert.Nil(t, driver.Get(ctx, bson.M{"user_id": "legacy"}, mapsDBOptions, &legacyMaps))
+	assert.Len(t, legacyMaps, 1)
+	for i := 0; i < mapDataPageSize; i++ {
+		_, err = driver.CreateOne(ctx, Map[[]byte]{UserID: "legacy", Name: fmt.Sprintf("empty %d", i)}, mapsDBOptions)
+		assert.Nil(t, err)
+	}
+	paged := legacyMaps[0]
+	paged.ID, paged.Name = primitive.NilObjectID, "paged"
+	_, err = driver.CreateOne(ctx, paged, mapsDBOptions)
+	assert.Nil(t, err)
+	_, err = driver.Delete(ctx, bson.M{"_id": legacyMaps[0].ID}, mapsDBOptions)
+	assert.Nil(t, err)
+
+	// content no map or revision places is purged
+	for _, opts := range []DatabaseClientOptions{mapsDBOptions, revisionDBOptions} {
+		_, err = driver.DeleteMany(ctx, bson.M{"user_id": MockID}, opts)
+		assert.Nil(t, err)
+	}
+	_, err = PurgeTrash(ctx, driver, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Nil(t, driver.Get(ctx, bson.M{}, mapAssetDBOptions, &stored))
+	assert.Len(t, stored, 2)
+	_, err = driver.DeleteMany(ctx, bson.M{"user_id": "legacy"}, mapsDBOptions)
+	assert.Nil(t, err)
+	_, err = PurgeTrash(ctx, driver, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Nil(t, driver.Get(ctx, bson.M{}, mapAssetDBOptions, &stored))
+	assert.Empty(t, stored)
+}
+
+func testStorageSharedMapAssets(t *testing.T, driver DatabaseClient) {
+	ctx := context.Background()
+	pixels := createMockPixelData(2, 2, 3)
+	var reversed PixelData
+	for i := len(pixels) - 1; i >= 0; i-- {
+		reversed = append(reversed, pixels[i])
+	}
+	// the same pixels placed by two users as differently named assets
+	placed := []PlayerAsset[PixelData]{
+		{ID: primitive.NewObjectID(), UserID: MockID, Name: "grass", Version: 1, AssetType: ASSET_TILE, Width: 2, Height: 2, Data: pixels},
+		{ID: primitive.NewObjectID(), UserID: "other", Name: "meadow", Version: 3, AssetType: ASSET_TILE, Width: 2, Height: 2, Data: reversed},
+	}
+	var mapIDs []primitive.ObjectID
+	for _, a := range placed {
+		data, err := json.Marshal([]PlayerAsset[PixelData]{a})
+		assert.Nil(t, err)
+		m := createMockMap(string(data))
+		m.UserID = a.UserID
+		mapID, err := CreateMap(ctx, driver, m)
+		assert.Nil(t, err)
+		mapIDs = append(mapIDs, mapID)
+	}
+	var stored []mapAsset
+	assert.Nil(t, driver.Get(ctx, bson.M{}, mapAssetDBOptions, &stored))
+	assert.Len(t, stored, 1)
+
+	// each map keeps the identity of the asset it placed
+	for i, mapID := range mapIDs {
+		loaded, err := GetMapByID(ctx, driver, mapID.Hex())
+		assert.Nil(t, err)
+		if assert.Len(t, loaded.Data, 1) {
+			assert.Equal(t, placed[i].ID, loaded.Data[0].ID)
+			assert.Equal(t, placed[i].UserID, loaded.Data[0].UserID)
+			assert.Equal(t, placed[i].Name, loaded.Data[0].Name)
+			assert.Equal(t, placed[i].Version, loaded.Data[0].Version)
+		}
+	}
+}
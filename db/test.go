@@ -24,6 +24,16 @@ var SuccessResponse = mtest.CreateSuccessResponse(
 	}...,
 )
 
+// UpdatedResponse acknowledges an update of one document
+var UpdatedResponse = mtest.CreateSuccessResponse(
+	bson.D{
+		{Key: "ok", Value: 1},
+		{Key: "acknowledged", Value: true},
+		{Key: "n", Value: 1},
+		{Key: "nModified", Value: 1},
+	}...,
+)
+
 func CreateCursorEnd(dbTable string) bson.D {
 	return mtest.CreateCursorResponse(
 		0,
@@ -55,6 +65,7 @@ func CreatePlayerAssetResponseData[T any](p PlayerAsset[T]) bson.D {
 		{Key: "y", Value: p.Y},
 		{Key: "width", Value: p.Width},
 		{Key: "height", Value: p.Height},
+		{Key: "version", Value: p.Version},
 		{Key: "data", Value: p.Data},
 	}
 }
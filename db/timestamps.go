@@ -0,0 +1,48 @@
+package db
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// softDeleteCollections keep deleted documents with deleted_at set until
+// PurgeTrash removes them. Drivers skip deleted documents unless
+// DatabaseClientOptions.IncludeDeleted is set.
+var softDeleteCollections = map[string]bool{
+	PlayerMapsCollection:   true,
+	PlayerImagesCollection: true,
+}
+
+// liveFilter converts params to a filter that skips soft deleted documents.
+// Filters on deleted_at are kept as they are.
+func liveFilter(params any, opts DatabaseClientOptions) (bson.M, error) {
+	filter, err := toDocument(params)
+	if err != nil || opts.IncludeDeleted || !softDeleteCollections[opts.Table] {
+		return filter, err
+	}
+	if _, ok := filter["deleted_at"]; !ok {
+		// matches null and missing
+		filter["deleted_at"] = nil
+	}
+	return filter, nil
+}
+
+// stampCreated sets created_at and updated_at of a new document
+// unless they are set already
+func stampCreated(doc bson.M, now time.Time) {
+	for _, field := range []string{"created_at", "updated_at"} {
+		if t, ok := doc[field].(primitive.DateTime); !ok || t.Time().IsZero() {
+			doc[field] = primitive.NewDateTimeFromTime(now)
+		}
+	}
+}
+
+// stampUpdated sets updated_at on the fields of a non-empty update
+// unless the update sets it explicitly
+func stampUpdated(fields bson.M, now time.Time) {
+	if _, ok := fields["updated_at"]; !ok && len(fields) > 0 {
+		fields["updated_at"] = primitive.NewDateTimeFromTime(now)
+	}
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var tokenDBOptions = DatabaseClientOptions{
+	Database: GameDatabase,
+	Table:    UserTokensCollection,
+}
+
+// UserToken records an outstanding single-use token.
+// The token itself is a JWT carrying the record ID as its jti claim.
+type UserToken struct {
+	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID    string             `json:"user_id" bson:"user_id"`
+	Purpose   utils.TokenPurpose `json:"purpose" bson:"purpose"`
+	ExpiresAt time.Time          `json:"expires_at" bson:"expires_at"`
+}
+
+// CreateUserToken stores a single-use token record and returns the signed token
+func CreateUserToken(ctx context.Context, db DatabaseClient, userID string, purpose utils.TokenPurpose, expiry time.Duration) (string, error) {
+	id, err := db.CreateOne(ctx, UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(expiry),
+	}, tokenDBOptions)
+	if err != nil {
+		return "", err
+	}
+	return utils.GeneratePurposeJWT(userID, purpose, id, expiry), nil
+}
+
+// ConsumeUserToken validates a signed token for purpose and deletes its record
+// so it cannot be used again. Returns the userID the token was issued for.
+func ConsumeUserToken(ctx context.Context, db DatabaseClient, token string, purpose utils.TokenPurpose) (string, error) {
+	claims, err := utils.DecodePurposeJWT(token, purpose)
+	if err != nil {
+		return "", errors.ErrInvalidToken
+	}
+	_id, err := primitive.ObjectIDFromHex(claims.ID)
+	if err != nil {
+		return "", errors.ErrInvalidToken
+	}
+	count, err := db.Delete(ctx, bson.M{
+		"_id":     _id,
+		"user_id": claims.UserID,
+		"purpose": purpose,
+	}, tokenDBOptions)
+	if err != nil || count == 0 {
+		return "", errors.ErrInvalidToken
+	}
+	return claims.UserID, nil
+}
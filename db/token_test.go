@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/utils"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCreateUserToken(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("success", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(SuccessResponse)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		token, err := CreateUserToken(context.Background(), driver, MockID, utils.PasswordResetPurpose, time.Hour)
+
+		// assert
+		assert.Nil(t, err)
+		claims, err := utils.DecodePurposeJWT(token, utils.PasswordResetPurpose)
+		assert.Nil(t, err)
+		assert.Equal(t, MockID, claims.UserID)
+		assert.NotEmpty(t, claims.ID)
+	})
+}
+
+func TestConsumeUserToken(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	tokenID := "67bfa82f165e6e4169699148"
+	token := utils.GeneratePurposeJWT(MockID, utils.PasswordResetPurpose, tokenID, time.Hour)
+
+	mt.Run("success", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(SuccessResponse)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		userID, err := ConsumeUserToken(context.Background(), driver, token, utils.PasswordResetPurpose)
+
+		// assert
+		assert.Nil(t, err)
+		assert.Equal(t, MockID, userID)
+	})
+
+	mt.Run("failure-already-used", func(mt *mtest.T) {
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(
+				bson.D{
+					{Key: "ok", Value: 1},
+					{Key: "n", Value: 0},
+				}...,
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := ConsumeUserToken(context.Background(), driver, token, utils.PasswordResetPurpose)
+
+		// assert
+		assert.Equal(t, err, errors.ErrInvalidToken)
+	})
+
+	mt.Run("failure-wrong-purpose", func(mt *mtest.T) {
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := ConsumeUserToken(context.Background(), driver, token, utils.EmailVerificationPurpose)
+
+		// assert
+		assert.Equal(t, err, errors.ErrInvalidToken)
+	})
+
+	mt.Run("failure-access-token", func(mt *mtest.T) {
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := ConsumeUserToken(context.Background(), driver, utils.GenerateJWT(MockID, time.Hour), utils.PasswordResetPurpose)
+
+		// assert
+		assert.Equal(t, err, errors.ErrInvalidToken)
+	})
+}
@@ -0,0 +1,21 @@
+package db
+
+import "context"
+
+// Transactional is implemented by databases that can apply several
+// operations atomically
+type Transactional interface {
+	// WithTransaction calls fn with a DatabaseClient bound to a new transaction.
+	// The transaction is committed if fn returns nil and discarded otherwise.
+	// fn may be called again if the transaction conflicts with another one.
+	WithTransaction(ctx context.Context, fn func(tx DatabaseClient) error) error
+}
+
+// withTransaction runs fn in a transaction if db supports them
+// and directly against db otherwise
+func withTransaction(ctx context.Context, db DatabaseClient, fn func(tx DatabaseClient) error) error {
+	if t, ok := db.(Transactional); ok {
+		return t.WithTransaction(ctx, fn)
+	}
+	return fn(db)
+}
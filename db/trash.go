@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Trash lists the deleted maps and assets of a user without their data,
+// most recently deleted first
+type Trash struct {
+	Maps   []Map[[]PlayerAsset[PixelData]] `json:"maps"`
+	Assets []PlayerAsset[PixelData]        `json:"assets"`
+}
+
+// trashOptions returns opts selecting deleted documents without data
+func trashOptions(opts DatabaseClientOptions) DatabaseClientOptions {
+	opts.IncludeDeleted = true
+	opts.Sort = bson.D{{Key: "deleted_at", Value: -1}}
+	opts.Omit = []string{"data"}
+	return opts
+}
+
+// GetTrash retrieves the deleted maps and assets of userID
+func GetTrash(ctx context.Context, db DatabaseClient, userID string) (Trash, error) {
+	trash := Trash{
+		Maps:   []Map[[]PlayerAsset[PixelData]]{},
+		Assets: []PlayerAsset[PixelData]{},
+	}
+	filter := bson.M{"user_id": userID, "deleted_at": bson.M{"$ne": nil}}
+
+	var byteMaps []Map[[]byte]
+	if err := db.Get(ctx, filter, trashOptions(mapsDBOptions), &byteMaps); err != nil {
+		return trash, err
+	}
+	for _, bm := range byteMaps {
+		trash.Maps = append(trash.Maps, mapWithoutData(bm))
+	}
+
+	var byteAssets []PlayerAsset[[]byte]
+	if err := db.Get(ctx, filter, trashOptions(assetDBOptions), &byteAssets); err != nil {
+		return trash, err
+	}
+	for _, ba := range byteAssets {
+		trash.Assets = append(trash.Assets, assetWithoutData(ba))
+	}
+	return trash, nil
+}
+
+// PurgeTrash permanently deletes maps and assets deleted before t,
+// along with the revisions of the maps and the map assets no longer
+// placed on any map since t
+func PurgeTrash(ctx context.Context, db DatabaseClient, t time.Time) (count int, err error) {
+	expired := bson.M{"deleted_at": bson.M{"$lt": t}}
+	opts := mapsDBOptions
+	opts.IncludeDeleted = true
+	var maps []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := db.Get(ctx, expired, opts, &maps); err != nil {
+		return 0, err
+	}
+	if len(maps) > 0 {
+		mapIDs := make([]string, 0, len(maps))
+		for _, m := range maps {
+			mapIDs = append(mapIDs, m.ID.Hex())
+		}
+		if _, err := db.DeleteMany(ctx, bson.M{"map_id": bson.M{"$in": mapIDs}}, revisionDBOptions); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, opts := range []DatabaseClientOptions{mapsDBOptions, assetDBOptions} {
+		opts.IncludeDeleted = true
+		n, err := db.DeleteMany(ctx, expired, opts)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	n, err := purgeMapAssets(ctx, db, t)
+	return count + n, err
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// incKey holds the increments of an Update
+const incKey = "$inc"
+
+// Update lists the fields UpdateOne sets. Fields not listed keep their
+// stored value, zero values are written only when listed explicitly.
+type Update bson.D
+
+// Set returns u with field set to value
+func (u Update) Set(field string, value any) Update {
+	return append(u, bson.E{Key: field, Value: value})
+}
+
+// Inc returns u with field incremented by n. The stored value is
+// incremented atomically, a missing field counts as 0. Updates that only
+// increment fields leave updated_at alone.
+func (u Update) Inc(field string, n int) Update {
+	u = slices.Clone(u)
+	for i, e := range u {
+		if e.Key == incKey {
+			u[i].Value = append(slices.Clip(e.Value.(bson.D)), bson.E{Key: field, Value: n})
+			return u
+		}
+	}
+	return append(u, bson.E{Key: incKey, Value: bson.D{{Key: field, Value: n}}})
+}
+
+// updateFields splits update, an Update or a document of fields to set,
+// into the fields it sets and the fields it increments
+func updateFields(update any) (set bson.M, inc bson.M, err error) {
+	set, err = toDocument(update)
+	if err != nil {
+		return nil, nil, err
+	}
+	inc, _ = set[incKey].(bson.M)
+	delete(set, incKey)
+	return set, inc, nil
+}
+
+// increment adds n, a number from updateFields, to the stored value v
+func increment(v any, n any) (any, error) {
+	var by int64
+	switch n := n.(type) {
+	case int32:
+		by = int64(n)
+	case int64:
+		by = n
+	default:
+		return nil, fmt.Errorf("cannot increment by %T", n)
+	}
+	switch v := v.(type) {
+	case nil:
+		return by, nil
+	case int32:
+		return int64(v) + by, nil
+	case int64:
+		return v + by, nil
+	case float64:
+		return v + float64(by), nil
+	}
+	return nil, fmt.Errorf("cannot increment %T", v)
+}
@@ -1,12 +1,21 @@
 package db
 
 import (
+	"context"
+	"crypto/subtle"
 	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/mail"
+	"slices"
 	"strings"
+	"time"
 
+	serverErrors "github.com/snburman/game-server/errors"
 	"github.com/snburman/game-server/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var userDBOptions = DatabaseClientOptions{
@@ -18,18 +27,64 @@ type Role string
 
 const CreatorRole Role = "creator"
 const PlayerRole Role = "player"
+const AdminRole Role = "admin"
+
+// Roles lists every known role
+var Roles = []Role{CreatorRole, PlayerRole, AdminRole}
+
+// DefaultRole is the role of new users
+const DefaultRole = PlayerRole
 
 type User struct {
-	ID       primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
-	UserName string             `json:"username,omitempty" bson:"username"`
-	Password string             `json:"password,omitempty" bson:"password"`
-	Role     Role               `json:"role" bson:"role"`
-	Banned   bool               `json:"banned" bson:"banned"`
+	ID            primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserName      string             `json:"username,omitempty" bson:"username"`
+	Password      string             `json:"password,omitempty" bson:"password"`
+	Email         string             `json:"email,omitempty" bson:"email"`
+	EmailVerified bool               `json:"email_verified" bson:"email_verified"`
+	Role          Role               `json:"role" bson:"role"`
+	Banned        bool               `json:"banned" bson:"banned"`
+	// UserNameHistory lists previous usernames, oldest first
+	UserNameHistory []UserNameChange `json:"username_history,omitempty" bson:"username_history"`
+	MFAEnabled      bool             `json:"mfa_enabled" bson:"mfa_enabled"`
+	// MFASecret is set on enrollment and only active once MFAEnabled
+	MFASecret string `json:"-" bson:"mfa_secret"`
+	// RecoveryCodes are stored as utils.HashToken hashes
+	RecoveryCodes []string `json:"-" bson:"recovery_codes"`
+	// MFALastStep is the TOTP time step of the last accepted code
+	MFALastStep int64 `json:"-" bson:"mfa_last_step"`
+	// DeleteAfter is set while the account is scheduled for deletion
+	DeleteAfter *time.Time `json:"delete_after,omitempty" bson:"delete_after"`
+	CreatedAt   time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" bson:"updated_at"`
+}
+
+type UserNameChange struct {
+	UserName  string    `json:"username" bson:"username"`
+	ChangedAt time.Time `json:"changed_at" bson:"changed_at"`
 }
 
-func CreateUser(db DatabaseClient, u User) (instertedID primitive.ObjectID, err error) {
+// NormalizeEmail validates and lowercases an email address
+func NormalizeEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", serverErrors.ErrInvalidEmail
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+func CreateUser(ctx context.Context, db DatabaseClient, u User) (instertedID primitive.ObjectID, err error) {
+	if err := ValidateUserName(u.UserName); err != nil {
+		return primitive.NilObjectID, err
+	}
 	user := User{
 		UserName: strings.ToLower(u.UserName),
+		Role:     DefaultRole,
+	}
+	if u.Email != "" {
+		user.Email, err = NormalizeEmail(u.Email)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
 	}
 	password, err := utils.HashPassword(u.Password)
 	if err != nil {
@@ -37,7 +92,10 @@ func CreateUser(db DatabaseClient, u User) (instertedID primitive.ObjectID, err
 	}
 	user.Password = password
 
-	id, err := db.CreateOne(user, userDBOptions)
+	id, err := db.CreateOne(ctx, user, userDBOptions)
+	if mongo.IsDuplicateKeyError(err) {
+		return primitive.NilObjectID, serverErrors.ErrUserExists
+	}
 	if err != nil {
 		return primitive.NilObjectID, err
 	}
@@ -48,12 +106,69 @@ func CreateUser(db DatabaseClient, u User) (instertedID primitive.ObjectID, err
 	return objectID, err
 }
 
-func GetUserByID(db DatabaseClient, userID string) (User, error) {
+// CreateExternalUser creates a user without a password for logins
+// through an external identity provider
+func CreateExternalUser(ctx context.Context, db DatabaseClient, u User) (primitive.ObjectID, error) {
+	user := User{
+		UserName:      strings.ToLower(u.UserName),
+		EmailVerified: u.EmailVerified,
+		Role:          DefaultRole,
+	}
+	if u.Email != "" {
+		email, err := NormalizeEmail(u.Email)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		user.Email = email
+	}
+	id, err := db.CreateOne(ctx, user, userDBOptions)
+	if mongo.IsDuplicateKeyError(err) {
+		return primitive.NilObjectID, serverErrors.ErrUserExists
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return primitive.ObjectIDFromHex(id)
+}
+
+// UniqueUserName derives an unused valid username from base
+func UniqueUserName(ctx context.Context, db DatabaseClient, base string) (string, error) {
+	var b strings.Builder
+	for _, c := range strings.ToLower(base) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' {
+			b.WriteRune(c)
+		}
+	}
+	name := b.String()
+	if len(name) > MaxUserNameLength-4 {
+		name = name[:MaxUserNameLength-4]
+	}
+	// fall back if no suffix can make the name valid
+	if ValidateUserName(name+"0000") != nil {
+		name = "player"
+	}
+
+	candidate := name
+	for i := 0; i < 10; i++ {
+		if ValidateUserName(candidate) == nil {
+			_, err := GetUserByUserName(ctx, db, candidate)
+			if err == mongo.ErrNoDocuments {
+				return candidate, nil
+			} else if err != nil {
+				return "", err
+			}
+		}
+		candidate = fmt.Sprintf("%s%04d", name, rand.IntN(10000))
+	}
+	return "", serverErrors.ErrUserExists
+}
+
+func GetUserByID(ctx context.Context, db DatabaseClient, userID string) (User, error) {
 	_id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		return User{}, err
 	}
-	res, err := db.GetOne(bson.M{"_id": _id}, userDBOptions)
+	res, err := db.GetOne(ctx, bson.M{"_id": _id}, userDBOptions)
 	if err != nil {
 		return User{}, err
 	}
@@ -64,8 +179,8 @@ func GetUserByID(db DatabaseClient, userID string) (User, error) {
 	return user, nil
 }
 
-func GetUserByUserName(db DatabaseClient, userName string) (User, error) {
-	res, err := db.GetOne(bson.M{"username": userName}, userDBOptions)
+func GetUserByUserName(ctx context.Context, db DatabaseClient, userName string) (User, error) {
+	res, err := db.GetOne(ctx, bson.M{"username": strings.ToLower(userName)}, userDBOptions)
 	if err != nil {
 		return User{}, err
 	}
@@ -78,15 +193,138 @@ func GetUserByUserName(db DatabaseClient, userName string) (User, error) {
 	return user, nil
 }
 
-func DeleteUser(db DatabaseClient, userID string) (count int, err error) {
+func GetUserByEmail(ctx context.Context, db DatabaseClient, email string) (User, error) {
+	res, err := db.GetOne(ctx, bson.M{"email": strings.ToLower(email)}, userDBOptions)
+	if err != nil {
+		return User{}, err
+	}
+	var user User
+	if err = utils.UnmarshalBSON(res, &user); err != nil {
+		return User{}, errors.New("error umarshalling user")
+	}
+	return user, nil
+}
+
+// SetUserPassword validates and stores a new password for userID
+func SetUserPassword(ctx context.Context, db DatabaseClient, userID string, password string) error {
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	user, err := GetUserByID(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	_, err = db.UpdateOne(ctx, user.ID.Hex(), Update{}.Set("password", hash), userDBOptions)
+	return err
+}
+
+// SetUserEmailVerified marks the email of userID as verified
+func SetUserEmailVerified(ctx context.Context, db DatabaseClient, userID string) error {
+	user, err := GetUserByID(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	_, err = db.UpdateOne(ctx, user.ID.Hex(), Update{}.Set("email_verified", true), userDBOptions)
+	return err
+}
+
+// SetUserMFASecret starts MFA enrollment for userID
+func SetUserMFASecret(ctx context.Context, db DatabaseClient, userID string, secret string) error {
+	user, err := GetUserByID(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	if user.MFAEnabled {
+		return serverErrors.ErrMFAEnabled
+	}
+	_, err = db.UpdateOne(ctx, user.ID.Hex(), Update{}.Set("mfa_secret", secret), userDBOptions)
+	return err
+}
+
+// EnableUserMFA completes enrollment and replaces the user's recovery codes
+func EnableUserMFA(ctx context.Context, db DatabaseClient, userID string, recoveryCodes []string) error {
+	user, err := GetUserByID(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	if user.MFASecret == "" {
+		return serverErrors.ErrMFANotPending
+	}
+	update := Update{}.
+		Set("mfa_enabled", true).
+		Set("recovery_codes", hashRecoveryCodes(recoveryCodes))
+	_, err = db.UpdateOne(ctx, user.ID.Hex(), update, userDBOptions)
+	return err
+}
+
+// DisableUserMFA removes the secret and recovery codes of userID
+func DisableUserMFA(ctx context.Context, db DatabaseClient, userID string) error {
+	user, err := GetUserByID(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	update := Update{}.
+		Set("mfa_enabled", false).
+		Set("mfa_secret", "").
+		Set("recovery_codes", []string{})
+	_, err = db.UpdateOne(ctx, user.ID.Hex(), update, userDBOptions)
+	return err
+}
+
+// AcceptTOTPStep records step as the TOTP time step of the last code
+// accepted for user. Returns false if a code of that step or a later one
+// was accepted before, so each code is only accepted once.
+func AcceptTOTPStep(ctx context.Context, db DatabaseClient, user User, step int64) (bool, error) {
+	count, err := db.UpdateMany(ctx, bson.M{
+		"_id": user.ID,
+		"$or": bson.A{
+			bson.M{"mfa_last_step": bson.M{"$lt": step}},
+			bson.M{"mfa_last_step": bson.M{"$exists": false}},
+		},
+	}, bson.M{"mfa_last_step": step}, userDBOptions)
+	return count > 0, err
+}
+
+// ConsumeRecoveryCode removes code from the user's recovery codes.
+// Returns false if the code does not match.
+func ConsumeRecoveryCode(ctx context.Context, db DatabaseClient, user User, code string) (bool, error) {
+	hash := utils.HashToken(strings.ToLower(strings.TrimSpace(code)))
+	remaining := []string{}
+	found := false
+	for _, c := range user.RecoveryCodes {
+		if !found && subtle.ConstantTimeCompare([]byte(c), []byte(hash)) == 1 {
+			found = true
+			continue
+		}
+		remaining = append(remaining, c)
+	}
+	if !found {
+		return false, nil
+	}
+	_, err := db.UpdateOne(ctx, user.ID.Hex(), Update{}.Set("recovery_codes", remaining), userDBOptions)
+	return err == nil, err
+}
+
+func hashRecoveryCodes(codes []string) []string {
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashToken(code)
+	}
+	return hashes
+}
+
+func DeleteUser(ctx context.Context, db DatabaseClient, userID string) (count int, err error) {
 	_id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		return 0, err
 	}
-	return db.Delete(bson.M{"_id": _id}, userDBOptions)
+	return db.Delete(ctx, bson.M{"_id": _id}, userDBOptions)
 }
 
-func UpdateUser(db DatabaseClient, u User) error {
+// UpdateUser applies the profile fields of u (password, email) to the stored user.
+// Role, ban and verification state are preserved. Use RenameUser to change the username.
+func UpdateUser(ctx context.Context, db DatabaseClient, u User) error {
 	if u.Password != "" {
 		password, err := utils.HashPassword(u.Password)
 		if err != nil {
@@ -94,6 +332,127 @@ func UpdateUser(db DatabaseClient, u User) error {
 		}
 		u.Password = password
 	}
-	_, err := db.UpdateOne(u.ID.Hex(), u, userDBOptions)
+	user, err := GetUserByID(ctx, db, u.ID.Hex())
+	if err != nil {
+		return err
+	}
+	var update Update
+	if u.Password != "" {
+		update = update.Set("password", u.Password)
+	}
+	if u.Email != "" {
+		email, err := NormalizeEmail(u.Email)
+		if err != nil {
+			return err
+		}
+		// changing email requires verification again
+		if email != user.Email {
+			update = update.Set("email", email).Set("email_verified", false)
+		}
+	}
+	if len(update) == 0 {
+		return nil
+	}
+	_, err = db.UpdateOne(ctx, user.ID.Hex(), update, userDBOptions)
+	return err
+}
+
+// SetUserRole changes the role of userID. If the role requires MFA the
+// user has not enabled, its tokens are revoked so it has to enroll.
+func SetUserRole(ctx context.Context, db DatabaseClient, userID string, role Role) error {
+	if !slices.Contains(Roles, role) {
+		return serverErrors.ErrInvalidRole
+	}
+	user, err := GetUserByID(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	if user.Role == role {
+		return nil
+	}
+	if _, err = db.UpdateOne(ctx, user.ID.Hex(), Update{}.Set("role", role), userDBOptions); err != nil {
+		return err
+	}
+	if user.MFAEnabled {
+		return nil
+	}
+	settings, err := GetSettings(ctx, db)
+	if err != nil {
+		return err
+	}
+	if settings.RoleRequiresMFA(role) {
+		return RevokeUserTokens(ctx, db, userID)
+	}
+	return nil
+}
+
+// backfillUserRoles gives users created before roles were assigned the
+// default role
+func backfillUserRoles(ctx context.Context, db DatabaseClient) error {
+	var users []struct {
+		ID        primitive.ObjectID `bson:"_id"`
+		UpdatedAt time.Time          `bson:"updated_at"`
+	}
+	filter := bson.M{"$or": []bson.M{{"role": bson.M{"$exists": false}}, {"role": ""}, {"role": nil}}}
+	if err := db.Get(ctx, filter, userDBOptions, &users); err != nil {
+		return err
+	}
+	for _, u := range users {
+		// assigning the default is not an update of the user
+		update := Update{}.Set("role", DefaultRole).Set("updated_at", u.UpdatedAt)
+		if _, err := db.UpdateOne(ctx, u.ID.Hex(), update, userDBOptions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenameUser changes the username of userID, records the previous
+// username and updates the denormalized username on the user's maps,
+// including those in the trash
+func RenameUser(ctx context.Context, db DatabaseClient, userID string, userName string) error {
+	if err := ValidateUserName(userName); err != nil {
+		return err
+	}
+	userName = strings.ToLower(userName)
+	user, err := GetUserByID(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	if user.UserName == userName {
+		return nil
+	}
+	if _, err := GetUserByUserName(ctx, db, userName); err == nil {
+		return serverErrors.ErrUserExists
+	}
+
+	history := append(user.UserNameHistory, UserNameChange{
+		UserName:  user.UserName,
+		ChangedAt: time.Now().UTC(),
+	})
+	update := Update{}.
+		Set("username", userName).
+		Set("username_history", history)
+	_, err = db.UpdateOne(ctx, user.ID.Hex(), update, userDBOptions)
+	if mongo.IsDuplicateKeyError(err) {
+		return serverErrors.ErrUserExists
+	}
+	if err != nil {
+		return err
+	}
+	opts := mapsDBOptions
+	opts.IncludeDeleted = true
+	var maps []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	listOpts := opts
+	listOpts.Omit = []string{"data"}
+	if err := db.Get(ctx, bson.M{"user_id": userID}, listOpts, &maps); err != nil {
+		return err
+	}
+	_, err = db.UpdateMany(ctx, bson.M{"user_id": userID}, bson.M{"username": userName}, opts)
+	for _, m := range maps {
+		invalidateMaps(m.ID.Hex())
+	}
 	return err
 }
@@ -1,11 +1,13 @@
 package db
 
 import (
+	"context"
 	"testing"
 
 	"github.com/snburman/game-server/errors"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
 )
 
@@ -23,8 +25,14 @@ func createUserResponseData(u User) bson.D {
 		{Key: "_id", Value: u.ID},
 		{Key: "username", Value: u.UserName},
 		{Key: "password", Value: u.Password},
+		{Key: "email", Value: u.Email},
+		{Key: "email_verified", Value: u.EmailVerified},
 		{Key: "role", Value: u.Role},
 		{Key: "banned", Value: u.Banned},
+		{Key: "mfa_enabled", Value: u.MFAEnabled},
+		{Key: "mfa_secret", Value: u.MFASecret},
+		{Key: "recovery_codes", Value: u.RecoveryCodes},
+		{Key: "delete_after", Value: u.DeleteAfter},
 	}
 }
 
@@ -40,7 +48,7 @@ func TestCreateUser(t *testing.T) {
 
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := CreateUser(driver, mockUser)
+		_, err := CreateUser(context.Background(), driver, mockUser)
 
 		// assert
 		assert.Nil(t, err)
@@ -60,12 +68,54 @@ func TestCreateUser(t *testing.T) {
 
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := CreateUser(driver, _mockUser)
+		_, err := CreateUser(context.Background(), driver, _mockUser)
 
 		// assert
 		assert.NotNil(t, err)
 		assert.Equal(t, err, errors.ErrWeakPassword)
 	})
+
+	mt.Run("failure-invalid-email", func(mt *mtest.T) {
+		_mockUser := mockUser
+		_mockUser.Email = "player at example.com"
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := CreateUser(context.Background(), driver, _mockUser)
+
+		// assert
+		assert.Equal(t, err, errors.ErrInvalidEmail)
+	})
+
+	mt.Run("failure-invalid-username", func(mt *mtest.T) {
+		_mockUser := mockUser
+		_mockUser.UserName = "a"
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := CreateUser(context.Background(), driver, _mockUser)
+
+		// assert
+		assert.Equal(t, err, errors.ErrInvalidUserName)
+	})
+
+	mt.Run("failure-duplicate-username", func(mt *mtest.T) {
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{
+				Index:   0,
+				Code:    11000,
+				Message: "duplicate key error",
+			}),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := CreateUser(context.Background(), driver, mockUser)
+
+		// assert
+		assert.Equal(t, err, errors.ErrUserExists)
+	})
 }
 
 func TestGetUserByID(t *testing.T) {
@@ -86,7 +136,7 @@ func TestGetUserByID(t *testing.T) {
 
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		res, err := GetUserByID(driver, mockUser.ID.Hex())
+		res, err := GetUserByID(context.Background(), driver, mockUser.ID.Hex())
 
 		// assert
 		assert.Nil(t, err)
@@ -105,7 +155,7 @@ func TestGetUserByID(t *testing.T) {
 
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		res, err := GetUserByID(driver, mockUser.ID.Hex())
+		res, err := GetUserByID(context.Background(), driver, mockUser.ID.Hex())
 
 		// assert
 		assert.NotNil(t, err)
@@ -131,7 +181,7 @@ func TestGetUserByUserName(t *testing.T) {
 
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		res, err := GetUserByUserName(driver, mockUser.UserName)
+		res, err := GetUserByUserName(context.Background(), driver, mockUser.UserName)
 
 		// assert
 		assert.Nil(t, err)
@@ -150,7 +200,7 @@ func TestGetUserByUserName(t *testing.T) {
 
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		res, err := GetUserByUserName(driver, mockUser.UserName)
+		res, err := GetUserByUserName(context.Background(), driver, mockUser.UserName)
 
 		// assert
 		assert.NotNil(t, err)
@@ -170,7 +220,7 @@ func TestDeleteUser(t *testing.T) {
 
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := DeleteUser(driver, mockUser.ID.Hex())
+		_, err := DeleteUser(context.Background(), driver, mockUser.ID.Hex())
 
 		// assert
 		assert.Nil(t, err)
@@ -184,7 +234,7 @@ func TestDeleteUser(t *testing.T) {
 
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		_, err := DeleteUser(driver, "invalid-id")
+		_, err := DeleteUser(context.Background(), driver, "invalid-id")
 
 		// assert
 		assert.NotNil(t, err)
@@ -198,12 +248,20 @@ func TestUpdateUser(t *testing.T) {
 	mt.Run("success", func(mt *mtest.T) {
 		// arrange for success
 		mt.AddMockResponses(
+			// find existing user
+			mtest.CreateCursorResponse(
+				1,
+				userSource,
+				mtest.FirstBatch,
+				createUserResponseData(mockUser),
+			),
+			CreateCursorEnd(userSource),
 			SuccessResponse,
 		)
 
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		err := UpdateUser(driver, mockUser)
+		err := UpdateUser(context.Background(), driver, mockUser)
 
 		// assert
 		assert.Nil(t, err)
@@ -219,10 +277,319 @@ func TestUpdateUser(t *testing.T) {
 
 		// act
 		driver := NewMockMongoDriver(mt.Client)
-		err := UpdateUser(driver, _mockUser)
+		err := UpdateUser(context.Background(), driver, _mockUser)
+
+		// assert
+		assert.NotNil(t, err)
+		assert.Equal(t, err, errors.ErrWeakPassword)
+	})
+
+	mt.Run("failure-invalid-email", func(mt *mtest.T) {
+		_mockUser := mockUser
+		_mockUser.Email = "not-an-email"
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				1,
+				userSource,
+				mtest.FirstBatch,
+				createUserResponseData(mockUser),
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := UpdateUser(context.Background(), driver, _mockUser)
+
+		// assert
+		assert.Equal(t, err, errors.ErrInvalidEmail)
+	})
+}
+
+func TestGetUserByEmail(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mockUser := createMockUser()
+	mockUser.Email = "player@example.com"
+
+	mt.Run("success", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				1,
+				userSource,
+				mtest.FirstBatch,
+				createUserResponseData(mockUser),
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		res, err := GetUserByEmail(context.Background(), driver, "Player@Example.com")
+
+		// assert
+		assert.Nil(t, err)
+		assert.Equal(t, res.Email, mockUser.Email)
+	})
+
+	mt.Run("failure-user-not-found", func(mt *mtest.T) {
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				userSource,
+				mtest.FirstBatch,
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		_, err := GetUserByEmail(context.Background(), driver, mockUser.Email)
 
 		// assert
 		assert.NotNil(t, err)
+	})
+}
+
+func TestSetUserPassword(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mockUser := createMockUser()
+
+	mt.Run("success", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				1,
+				userSource,
+				mtest.FirstBatch,
+				createUserResponseData(mockUser),
+			),
+			CreateCursorEnd(userSource),
+			SuccessResponse,
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := SetUserPassword(context.Background(), driver, mockUser.ID.Hex(), "newPassword123")
+
+		// assert
+		assert.Nil(t, err)
+	})
+
+	mt.Run("failure-weak-password", func(mt *mtest.T) {
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := SetUserPassword(context.Background(), driver, mockUser.ID.Hex(), "password")
+
+		// assert
 		assert.Equal(t, err, errors.ErrWeakPassword)
 	})
 }
+
+func TestEnableUserMFA(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mockUser := createMockUser()
+
+	mt.Run("success", func(mt *mtest.T) {
+		_mockUser := mockUser
+		_mockUser.MFASecret = "SECRET"
+		// arrange for success
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				1,
+				userSource,
+				mtest.FirstBatch,
+				createUserResponseData(_mockUser),
+			),
+			CreateCursorEnd(userSource),
+			SuccessResponse,
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := EnableUserMFA(context.Background(), driver, mockUser.ID.Hex(), []string{"aaaaa-bbbbb"})
+
+		// assert
+		assert.Nil(t, err)
+	})
+
+	mt.Run("failure-not-pending", func(mt *mtest.T) {
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				1,
+				userSource,
+				mtest.FirstBatch,
+				createUserResponseData(mockUser),
+			),
+			CreateCursorEnd(userSource),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := EnableUserMFA(context.Background(), driver, mockUser.ID.Hex(), []string{"aaaaa-bbbbb"})
+
+		// assert
+		assert.Equal(t, err, errors.ErrMFANotPending)
+	})
+}
+
+func TestConsumeRecoveryCode(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mockUser := createMockUser()
+	mockUser.RecoveryCodes = hashRecoveryCodes([]string{"aaaaa-bbbbb", "ccccc-ddddd"})
+
+	mt.Run("success", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(SuccessResponse)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		ok, err := ConsumeRecoveryCode(context.Background(), driver, mockUser, "CCCCC-DDDDD")
+
+		// assert
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	})
+
+	mt.Run("failure-unknown-code", func(mt *mtest.T) {
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		ok, err := ConsumeRecoveryCode(context.Background(), driver, mockUser, "eeeee-fffff")
+
+		// assert
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestUniqueUserName(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mockUser := createMockUser()
+
+	mt.Run("success", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				0,
+				userSource,
+				mtest.FirstBatch,
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		name, err := UniqueUserName(context.Background(), driver, "Player.One!")
+
+		// assert
+		assert.Nil(t, err)
+		assert.Equal(t, "playerone", name)
+	})
+
+	mt.Run("success-name-taken", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			// base name is taken
+			mtest.CreateCursorResponse(
+				0,
+				userSource,
+				mtest.FirstBatch,
+				createUserResponseData(mockUser),
+			),
+			mtest.CreateCursorResponse(
+				0,
+				userSource,
+				mtest.FirstBatch,
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		name, err := UniqueUserName(context.Background(), driver, mockUser.UserName)
+
+		// assert
+		assert.Nil(t, err)
+		assert.NotEqual(t, mockUser.UserName, name)
+		assert.Contains(t, name, mockUser.UserName)
+	})
+}
+
+func TestRenameUser(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mockUser := createMockUser()
+	mockUser.ID = primitive.NewObjectID()
+
+	mt.Run("success", func(mt *mtest.T) {
+		// arrange for success
+		mt.AddMockResponses(
+			// find existing user
+			mtest.CreateCursorResponse(
+				1,
+				userSource,
+				mtest.FirstBatch,
+				createUserResponseData(mockUser),
+			),
+			CreateCursorEnd(userSource),
+			// new name is free
+			mtest.CreateCursorResponse(
+				0,
+				userSource,
+				mtest.FirstBatch,
+			),
+			// update user
+			SuccessResponse,
+			// find maps
+			mtest.CreateCursorResponse(
+				0,
+				mapSource,
+				mtest.FirstBatch,
+			),
+			// update maps
+			SuccessResponse,
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := RenameUser(context.Background(), driver, mockUser.ID.Hex(), "NewName")
+
+		// assert
+		assert.Nil(t, err)
+	})
+
+	mt.Run("failure-name-taken", func(mt *mtest.T) {
+		taken := createMockUser()
+		taken.ID = primitive.NewObjectID()
+		taken.UserName = "newname"
+		// arrange for failure
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(
+				1,
+				userSource,
+				mtest.FirstBatch,
+				createUserResponseData(mockUser),
+			),
+			CreateCursorEnd(userSource),
+			mtest.CreateCursorResponse(
+				0,
+				userSource,
+				mtest.FirstBatch,
+				createUserResponseData(taken),
+			),
+		)
+
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := RenameUser(context.Background(), driver, mockUser.ID.Hex(), "newname")
+
+		// assert
+		assert.Equal(t, err, errors.ErrUserExists)
+	})
+
+	mt.Run("failure-reserved-name", func(mt *mtest.T) {
+		// act
+		driver := NewMockMongoDriver(mt.Client)
+		err := RenameUser(context.Background(), driver, mockUser.ID.Hex(), "admin")
+
+		// assert
+		assert.Equal(t, err, errors.ErrReservedUserName)
+	})
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/snburman/game-server/errors"
+)
+
+const (
+	MinUserNameLength = 3
+	MaxUserNameLength = 20
+)
+
+// reservedUserNames cannot be registered by players
+var reservedUserNames = []string{
+	"admin", "administrator", "anonymous", "api", "default", "deleted",
+	"game", "help", "me", "mod", "moderator", "null", "official", "player",
+	"root", "server", "staff", "support", "system", "undefined",
+}
+
+// blockedUserNameWords cannot be a word of a username, see userNameWords
+var blockedUserNameWords = []string{
+	"admin", "moderator", "fuck", "shit", "cunt", "bitch", "whore",
+	"slut", "nazi", "hitler",
+}
+
+// ValidateUserName checks length, charset and reserved names.
+// Usernames are compared lowercased.
+func ValidateUserName(userName string) error {
+	name := strings.ToLower(userName)
+	if len(name) < MinUserNameLength || len(name) > MaxUserNameLength {
+		return errors.ErrInvalidUserName
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case (c == '_' || c == '-' || c == '.') && i > 0 && i < len(name)-1:
+		default:
+			return errors.ErrInvalidUserName
+		}
+	}
+	for _, reserved := range reservedUserNames {
+		if name == reserved {
+			return errors.ErrReservedUserName
+		}
+	}
+	for _, word := range userNameWords(name) {
+		if slices.Contains(blockedUserNameWords, word) {
+			return errors.ErrReservedUserName
+		}
+	}
+	return nil
+}
+
+// userNameWords splits name at separators and between letters and
+// digits, so "the_admin2" has the words "the", "admin" and "2" while
+// "badminton" is a single word
+func userNameWords(name string) []string {
+	// class is 0 for separators, 1 for letters and 2 for digits
+	class := func(c byte) int {
+		switch {
+		case c == '_' || c == '-' || c == '.':
+			return 0
+		case c >= '0' && c <= '9':
+			return 2
+		}
+		return 1
+	}
+	var words []string
+	start := 0
+	for i := 1; i <= len(name); i++ {
+		if i < len(name) && class(name[i]) == class(name[start]) {
+			continue
+		}
+		if class(name[start]) != 0 {
+			words = append(words, name[start:i])
+		}
+		start = i
+	}
+	return words
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/snburman/game-server/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUserName(t *testing.T) {
+	tests := []struct {
+		name     string
+		userName string
+		err      error
+	}{
+		{"valid", "player_one", nil},
+		{"valid-mixed-case", "PlayerOne", nil},
+		{"valid-separators", "a.b-c", nil},
+		{"too-short", "ab", errors.ErrInvalidUserName},
+		{"too-long", "abcdefghijklmnopqrstu", errors.ErrInvalidUserName},
+		{"empty", "", errors.ErrInvalidUserName},
+		{"invalid-charset", "player one", errors.ErrInvalidUserName},
+		{"leading-separator", "_player", errors.ErrInvalidUserName},
+		{"trailing-separator", "player.", errors.ErrInvalidUserName},
+		{"reserved", "Admin", errors.ErrReservedUserName},
+		{"blocked-word", "super_admin1", errors.ErrReservedUserName},
+		{"blocked-word-digits", "1admin2", errors.ErrReservedUserName},
+		{"blocked-word-inside-word", "badminton", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateUserName(tt.userName)
+			if tt.err == nil {
+				assert.Nil(t, err)
+				return
+			}
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/snburman/game-server/errors"
+)
+
+// maxAssetSide is the largest width and height of any asset type
+const maxAssetSide = 256
+
+// maxAssetSides limits the width and height of assets by type. Types
+// missing from it are unknown.
+var maxAssetSides = map[AssetType]int{
+	ASSET_TILE:         64,
+	ASSET_OBJECT:       maxAssetSide,
+	ASSET_PORTAL:       64,
+	ASSET_PLAYER_UP:    64,
+	ASSET_PLAYER_DOWN:  64,
+	ASSET_PLAYER_LEFT:  64,
+	ASSET_PLAYER_RIGHT: 64,
+}
+
+// parsePixelData reads the JSON pixel data s of field. Data that is not
+// pixel data is an error of field.
+func parsePixelData(fields errors.FieldErrors, field string, s string) PixelData {
+	var data PixelData
+	if err := json.Unmarshal([]byte(s), &data); err != nil {
+		fields[field] = errors.ErrImageWrongFormat
+	}
+	return data
+}
+
+// parseFrames reads JSON frames, each an error of field frames[i] if it is
+// not pixel data
+func parseFrames(fields errors.FieldErrors, frames []string) []PixelData {
+	var parsed []PixelData
+	for i, frame := range frames {
+		parsed = append(parsed, parsePixelData(fields, fmt.Sprintf("frames[%d]", i), frame))
+	}
+	return parsed
+}
+
+// validateAsset adds an error to fields for each field of a that can not
+// be stored: an unknown type, a size out of the range of the type, pixels
+// outside of the size or animations of missing frames. The channels of
+// every pixel are clamped to 0-255.
+func validateAsset(fields errors.FieldErrors, a PlayerAsset[PixelData]) {
+	maxSide, known := maxAssetSides[a.AssetType]
+	if !known {
+		fields["asset_type"] = errors.ErrUnknownAssetType
+	}
+	for field, side := range map[string]int{"width": a.Width, "height": a.Height} {
+		switch {
+		case side <= 0:
+			fields[field] = errors.ErrInvalidSize
+		case known && side > maxSide:
+			fields[field] = errors.ErrImageTooLarge
+		}
+	}
+	validatePixels(fields, "data", a.Data, a.Width, a.Height)
+	for i, frame := range a.Frames {
+		validatePixels(fields, fmt.Sprintf("frames[%d]", i), frame, a.Width, a.Height)
+	}
+	if err := validateAnimations(len(a.Frames)+1, a.Animations); err != nil {
+		fields["animations"] = errors.ErrInvalidAnimation
+	}
+}
+
+// validatePixels adds an error of field to fields unless every pixel of
+// data is within width and height, and clamps the channels of the pixels.
+// Fields that failed to parse are left as they are.
+func validatePixels(fields errors.FieldErrors, field string, data PixelData, width, height int) {
+	if _, ok := fields[field]; ok {
+		return
+	}
+	for _, row := range data {
+		for i := range row {
+			p := &row[i]
+			if p.X < 0 || p.Y < 0 || p.X >= width || p.Y >= height {
+				fields[field] = errors.ErrSizeMismatch
+			}
+			if len(p.Color) > math.MaxUint8 {
+				fields[field] = errors.ErrImageWrongFormat
+			}
+			p.R = clampChannel(p.R)
+			p.G = clampChannel(p.G)
+			p.B = clampChannel(p.B)
+			p.A = clampChannel(p.A)
+		}
+	}
+}
+
+func clampChannel(c int) int {
+	return min(max(c, 0), math.MaxUint8)
+}
+
+// fieldsError returns fields as an error, or nil if there are none
+func fieldsError(fields errors.FieldErrors) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
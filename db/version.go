@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// versioned is the version of a map or asset, incremented by every update.
+// Clients send back the version they edited to detect concurrent changes.
+type versioned struct {
+	Version int `bson:"version"`
+}
+
+// updateVersion sets the fields of update on the document with _id if it is
+// still at version and increments the version. Returns the new version, or
+// the current version and ErrVersionConflict if the document has changed.
+func updateVersion(ctx context.Context, db DatabaseClient, _id primitive.ObjectID, version int, update Update, opts DatabaseClientOptions) (int, error) {
+	update = slices.Clip(update).Set("version", version+1)
+	// matching the version makes the check and write atomic without a transaction
+	count, err := db.UpdateMany(ctx, bson.M{"_id": _id, "version": version}, update, opts)
+	if err != nil {
+		return version, err
+	}
+	if count > 0 {
+		return version + 1, nil
+	}
+	res, err := db.GetOne(ctx, bson.M{"_id": _id}, opts)
+	if err != nil {
+		return version, err
+	}
+	var current versioned
+	if err := utils.UnmarshalBSON(res, &current); err != nil {
+		return version, err
+	}
+	return current.Version, errors.ErrVersionConflict
+}
+
+// backfillVersions sets the first version on maps and assets created
+// before versions existed
+func backfillVersions(ctx context.Context, db DatabaseClient) error {
+	for _, opts := range []DatabaseClientOptions{mapsDBOptions, assetDBOptions} {
+		opts.IncludeDeleted = true
+		var docs []struct {
+			ID        primitive.ObjectID `bson:"_id"`
+			UpdatedAt time.Time          `bson:"updated_at"`
+		}
+		err := db.Get(ctx, bson.M{"version": bson.M{"$exists": false}}, opts, &docs)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			// versioning is not an update of the document
+			update := Update{}.Set("version", 1).Set("updated_at", doc.UpdatedAt)
+			if _, err := db.UpdateOne(ctx, doc.ID.Hex(), update, opts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+package errors
+
+type APIKeyError = ServerError
+
+const (
+	ErrInvalidAPIKey  APIKeyError = "invalid_api_key"
+	ErrAPIKeyNotFound APIKeyError = "api_key_not_found"
+	ErrAPIKeyScope    APIKeyError = "api_key_scope_denied"
+	ErrRateLimited    APIKeyError = "rate_limited"
+)
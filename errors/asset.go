@@ -7,4 +7,8 @@ const (
 	ErrImageNotFound    AssetError = "image_not_found"
 	ErrCreatingImage    AssetError = "error_creating_image"
 	ErrImageWrongFormat AssetError = "image_wrong_format"
+	ErrImageTooLarge    AssetError = "image_too_large"
+	ErrInvalidScale     AssetError = "invalid_scale"
+	ErrNoDirections     AssetError = "no_directions"
+	ErrInvalidAnimation AssetError = "invalid_animation"
 )
@@ -10,6 +10,32 @@ const (
 	ErrCreatingUser AuthenticationError = "error_creating_user"
 	ErrUpdatingUser AuthenticationError = "error_updating_user"
 	ErrUserBanned   AuthenticationError = "user_banned"
+	ErrUserNotFound AuthenticationError = "user_not_found"
+	// Account Deletion Errors
+	ErrDeletionNotScheduled AuthenticationError = "deletion_not_scheduled"
+	// Username Errors
+	ErrInvalidUserName  AuthenticationError = "invalid_username"
+	ErrReservedUserName AuthenticationError = "reserved_username"
+	// Email Errors
+	ErrInvalidEmail  AuthenticationError = "invalid_email"
+	ErrEmailExists   AuthenticationError = "email_exists"
+	ErrEmailVerified AuthenticationError = "email_already_verified"
+	ErrSendingEmail  AuthenticationError = "error_sending_email"
+	// Token Errors
+	ErrInvalidToken AuthenticationError = "invalid_token"
+	// MFA Errors
+	ErrMFARequired           AuthenticationError = "mfa_required"
+	ErrMFAEnrollmentRequired AuthenticationError = "mfa_enrollment_required"
+	ErrMFAEnabled            AuthenticationError = "mfa_already_enabled"
+	ErrMFANotEnabled         AuthenticationError = "mfa_not_enabled"
+	ErrMFANotPending         AuthenticationError = "mfa_enrollment_not_started"
+	ErrInvalidMFACode        AuthenticationError = "invalid_mfa_code"
+	ErrTooManyAttempts       AuthenticationError = "too_many_attempts"
+	ErrInvalidRole           AuthenticationError = "invalid_role"
+	// External Identity Errors
+	ErrProviderNotFound AuthenticationError = "oidc_provider_not_found"
+	ErrOIDCLogin        AuthenticationError = "oidc_login_failed"
+	ErrIdentityLinked   AuthenticationError = "identity_already_linked"
 )
 
 type AuthenticationError = ServerError
@@ -0,0 +1,9 @@
+package errors
+
+type ExportError = ServerError
+
+const (
+	ErrExportNotFound ExportError = "export_not_found"
+	ErrExportNotReady ExportError = "export_not_ready"
+	ErrExportFailed   ExportError = "export_failed"
+)
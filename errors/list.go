@@ -0,0 +1,9 @@
+package errors
+
+type ListError = ServerError
+
+const (
+	ErrInvalidSort   ListError = "invalid_sort"
+	ErrInvalidCursor ListError = "invalid_cursor"
+	ErrInvalidLimit  ListError = "invalid_limit"
+)
@@ -9,4 +9,5 @@ const (
 	ErrCreatingMap      MapError = "error_creating_map"
 	ErrUpdatingMap      MapError = "error_updating_map"
 	ErrMapWrongFormat   MapError = "map_wrong_format"
+	ErrRevisionNotFound MapError = "revision_not_found"
 )
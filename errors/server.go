@@ -5,6 +5,7 @@ const (
 	ErrInvalidJWT     ServerError = "invalid_jwt"
 	ErrBindingPayload ServerError = "error_binding_payload"
 	ErrServerError    ServerError = "server_error"
+	ErrForbidden      ServerError = "forbidden"
 )
 
 type ServerError string
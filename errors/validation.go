@@ -0,0 +1,26 @@
+package errors
+
+type ValidationError = ServerError
+
+const (
+	ErrInvalidFields    ValidationError = "invalid_fields"
+	ErrFieldRequired    ValidationError = "required"
+	ErrUnknownAssetType ValidationError = "unknown_asset_type"
+	ErrInvalidSize      ValidationError = "invalid_size"
+	ErrSizeMismatch     ValidationError = "size_mismatch"
+)
+
+// FieldErrors maps the invalid fields of a payload to the reason each
+// is invalid
+type FieldErrors map[string]ServerError
+
+func (e FieldErrors) Error() string {
+	return string(ErrInvalidFields)
+}
+
+func (e FieldErrors) JSON() map[string]any {
+	return map[string]any{
+		"error":  ErrInvalidFields,
+		"fields": e,
+	}
+}
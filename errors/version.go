@@ -0,0 +1,9 @@
+package errors
+
+type VersionError = ServerError
+
+const (
+	ErrVersionConflict VersionError = "version_conflict"
+	ErrInvalidVersion  VersionError = "invalid_version"
+	ErrVersionRequired VersionError = "version_required"
+)
@@ -0,0 +1,68 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/snburman/game-server/db"
+)
+
+// WriteArchive writes data to w as a zip archive containing:
+//
+//	profile.json        user profile without secrets
+//	identities.json     linked external identities
+//	assets/<id>.json    asset metadata and pixel data
+//	assets/<id>.png     rendered asset
+//	maps/<id>.json      map with its placed assets
+//	maps/<id>/revisions/<version>.json
+//	                    map revision with its placed assets
+//
+// Maps and assets in the trash are included with their deleted_at set.
+// Chat messages are relayed between connections and never stored,
+// so there is no chat history to include.
+func WriteArchive(w io.Writer, data db.UserExport) error {
+	zw := zip.NewWriter(w)
+	if err := writeJSON(zw, "profile.json", data.User); err != nil {
+		return err
+	}
+	if err := writeJSON(zw, "identities.json", data.Identities); err != nil {
+		return err
+	}
+	for _, asset := range data.Assets {
+		name := "assets/" + asset.ID.Hex()
+		if err := writeJSON(zw, name+".json", asset); err != nil {
+			return err
+		}
+		f, err := zw.Create(name + ".png")
+		if err != nil {
+			return err
+		}
+		if err := db.EncodePNG(f, asset.Data, asset.Width, asset.Height); err != nil {
+			return err
+		}
+	}
+	for _, m := range data.Maps {
+		if err := writeJSON(zw, "maps/"+m.ID.Hex()+".json", m); err != nil {
+			return err
+		}
+	}
+	for _, r := range data.Revisions {
+		name := fmt.Sprintf("maps/%s/revisions/%d.json", r.MapID, r.Version)
+		if err := writeJSON(zw, name, r); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeJSON(zw *zip.Writer, name string, v any) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
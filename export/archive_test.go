@@ -0,0 +1,62 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"image/png"
+	"testing"
+
+	"github.com/snburman/game-server/db"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWriteArchive(t *testing.T) {
+	asset := db.CreateMockPlayerAsset(db.PixelData{
+		{{X: 0, Y: 0, R: 255, A: 255}, {X: 1, Y: 0, G: 255, A: 255}},
+	})
+	asset.ID = primitive.NewObjectID()
+	asset.Width = 2
+	asset.Height = 1
+	m := db.Map[[]db.PlayerAsset[db.PixelData]]{
+		ID:   primitive.NewObjectID(),
+		Name: "map",
+		Data: []db.PlayerAsset[db.PixelData]{asset},
+	}
+	revision := db.MapRevision[[]db.PlayerAsset[db.PixelData]]{
+		MapID:   m.ID.Hex(),
+		Version: 1,
+		Data:    m.Data,
+	}
+	data := db.UserExport{
+		User:      db.User{UserName: "username", Password: "hash"},
+		Assets:    []db.PlayerAsset[db.PixelData]{asset},
+		Maps:      []db.Map[[]db.PlayerAsset[db.PixelData]]{m},
+		Revisions: []db.MapRevision[[]db.PlayerAsset[db.PixelData]]{revision},
+	}
+
+	var buf bytes.Buffer
+	err := WriteArchive(&buf, data)
+	assert.Nil(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Nil(t, err)
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	assert.Contains(t, files, "profile.json")
+	assert.Contains(t, files, "identities.json")
+	assert.Contains(t, files, "assets/"+asset.ID.Hex()+".json")
+	assert.Contains(t, files, "maps/"+m.ID.Hex()+".json")
+	assert.Contains(t, files, "maps/"+m.ID.Hex()+"/revisions/1.json")
+
+	// rendered asset
+	f, err := files["assets/"+asset.ID.Hex()+".png"].Open()
+	assert.Nil(t, err)
+	img, err := png.Decode(f)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, img.Bounds().Dx())
+	r, _, _, _ := img.At(0, 0).RGBA()
+	assert.Equal(t, uint32(0xffff), r)
+}
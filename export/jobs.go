@@ -0,0 +1,182 @@
+package export
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/snburman/game-server/db"
+)
+
+// archives are removed this long after they complete
+const archiveTTL = 24 * time.Hour
+
+// exportTimeout bounds the database reads of a single export
+const exportTimeout = 5 * time.Minute
+
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+type Job struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	Status      JobStatus  `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	path        string
+}
+
+// Manager generates export archives in the background.
+// Jobs are kept in memory and archives in dir, so both
+// are lost on restart and users have to request a new export.
+type Manager struct {
+	mu   sync.Mutex
+	dir  string
+	jobs map[string]*Job
+	// ttl is how long finished jobs are kept, archiveTTL but in tests
+	ttl time.Duration
+}
+
+func NewManager(dir string) *Manager {
+	return &Manager{
+		dir:  dir,
+		jobs: make(map[string]*Job),
+		ttl:  archiveTTL,
+	}
+}
+
+// Start queues an export of userID. If the user already has
+// an export in progress that job is returned instead.
+func (m *Manager) Start(store db.DatabaseClient, userID string) Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, job := range m.jobs {
+		if job.UserID == userID && (job.Status == JobPending || job.Status == JobRunning) {
+			return *job
+		}
+	}
+	job := &Job{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Status:    JobPending,
+		CreatedAt: time.Now().UTC(),
+	}
+	m.jobs[job.ID] = job
+	go m.run(store, job.ID, userID)
+	return *job
+}
+
+// Get returns the job with id
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Open opens the archive of a completed job
+func (m *Manager) Open(job Job) (*os.File, error) {
+	return os.Open(job.path)
+}
+
+// RemoveUser discards the jobs and archives of userID
+func (m *Manager) RemoveUser(userID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, job := range m.jobs {
+		if job.UserID != userID {
+			continue
+		}
+		if job.path != "" {
+			os.Remove(job.path)
+		}
+		delete(m.jobs, id)
+	}
+}
+
+func (m *Manager) run(store db.DatabaseClient, id string, userID string) {
+	m.setStatus(id, JobRunning, "", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	path, err := m.writeArchive(ctx, store, id, userID)
+	if err != nil {
+		log.Println("error exporting user ", userID, ": ", err)
+		m.setStatus(id, JobFailed, err.Error(), "")
+		return
+	}
+	m.setStatus(id, JobDone, "", path)
+}
+
+func (m *Manager) writeArchive(ctx context.Context, store db.DatabaseClient, id string, userID string) (string, error) {
+	data, err := db.ExportUserData(ctx, store, userID)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(m.dir, id+".zip")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", err
+	}
+	err = WriteArchive(f, data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+func (m *Manager) setStatus(id string, status JobStatus, errMsg string, path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		// removed while running
+		if path != "" {
+			os.Remove(path)
+		}
+		return
+	}
+	job.Status = status
+	job.Error = errMsg
+	job.path = path
+	if status == JobDone || status == JobFailed {
+		now := time.Now().UTC()
+		job.CompletedAt = &now
+		time.AfterFunc(m.ttl, func() { m.expire(id) })
+	}
+}
+
+// expire deletes the finished job with id and its archive
+func (m *Manager) expire(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return
+	}
+	if job.path != "" {
+		os.Remove(job.path)
+	}
+	delete(m.jobs, id)
+}
@@ -0,0 +1,35 @@
+package export
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/snburman/game-server/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManagerExpiry(t *testing.T) {
+	store := db.NewMemoryDriver()
+	id, err := db.CreateUser(context.Background(), store, db.User{UserName: "username", Password: "passwordABC123"})
+	assert.Nil(t, err)
+
+	m := NewManager(t.TempDir())
+	m.ttl = 200 * time.Millisecond
+	job := m.Start(store, id.Hex())
+	assert.Eventually(t, func() bool {
+		job, _ = m.Get(job.ID)
+		return job.Status == JobDone
+	}, time.Second, 5*time.Millisecond)
+	_, err = os.Stat(job.path)
+	assert.Nil(t, err)
+
+	// archives expire without another export being started
+	assert.Eventually(t, func() bool {
+		_, ok := m.Get(job.ID)
+		return !ok
+	}, time.Second, 5*time.Millisecond)
+	_, err = os.Stat(job.path)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/snburman/game-server/conn"
+	"github.com/snburman/game-server/db"
+)
+
+const (
+	accountPurgeInterval = time.Hour
+	// revocationLoadInterval bounds how long tokens revoked by another
+	// server stay valid on this one
+	revocationLoadInterval = 10 * time.Second
+)
+
+// StartTokenRevocationLoad loads token revocations and periodically loads
+// those made since, including by other servers sharing the database
+func StartTokenRevocationLoad(ctx context.Context, store db.DatabaseClient) {
+	if err := db.LoadTokenRevocations(ctx, store); err != nil {
+		log.Println("error loading token revocations: ", err)
+	}
+	go func() {
+		ticker := time.NewTicker(revocationLoadInterval)
+		defer ticker.Stop()
+		for {
+			<-ticker.C
+			if err := db.LoadTokenRevocations(ctx, store); err != nil {
+				log.Println("error loading token revocations: ", err)
+			}
+		}
+	}()
+}
+
+// StartAccountPurge periodically deletes accounts whose deletion grace
+// period has ended
+func StartAccountPurge(ctx context.Context, store db.DatabaseClient) {
+	go func() {
+		ticker := time.NewTicker(accountPurgeInterval)
+		defer ticker.Stop()
+		for {
+			purgeAccounts(ctx, store, time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+func purgeAccounts(ctx context.Context, store db.DatabaseClient, now time.Time) {
+	users, err := db.GetUsersScheduledForDeletion(ctx, store, now)
+	if err != nil {
+		log.Println("error finding scheduled deletions: ", err)
+		return
+	}
+	for _, user := range users {
+		userID := user.ID.Hex()
+		conn.CloseUserConns(userID)
+		if _, err := db.DeleteUserData(ctx, store, userID); err != nil {
+			log.Println("error deleting user ", userID, ": ", err)
+			continue
+		}
+		exports.RemoveUser(userID)
+		log.Println("deleted user: ", userID)
+	}
+	// tokens outlive their revocation by at most the refresh token expiry
+	if err := db.PurgeTokenRevocations(ctx, store, now.Add(-refreshTokenExpiry)); err != nil {
+		log.Println("error purging token revocations: ", err)
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/db"
+	"github.com/snburman/game-server/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type MFARolesRequest struct {
+	Roles []db.Role `json:"roles"`
+}
+
+// HandleGetMFARequiredRoles returns the roles that must use MFA
+func HandleGetMFARequiredRoles(c echo.Context) error {
+	ctx := c.Request().Context()
+	settings, err := db.GetSettings(ctx, db.DB)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+	}
+	roles := settings.MFARequiredRoles
+	if roles == nil {
+		roles = []db.Role{}
+	}
+	return c.JSON(http.StatusOK, MFARolesRequest{Roles: roles})
+}
+
+// @Body MFARolesRequest
+//
+// HandleSetMFARequiredRoles replaces the roles that must use MFA
+func HandleSetMFARequiredRoles(c echo.Context) error {
+	ctx := c.Request().Context()
+	var req MFARolesRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, errors.ErrBindingPayload.JSON())
+	}
+	if req.Roles == nil {
+		req.Roles = []db.Role{}
+	}
+	if err := db.SetMFARequiredRoles(ctx, db.DB, req.Roles); err != nil {
+		if err == errors.ErrInvalidRole {
+			return c.JSON(http.StatusBadRequest, errors.ErrInvalidRole.JSON())
+		}
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+type UserRoleRequest struct {
+	Role db.Role `json:"role"`
+}
+
+// @Param id
+//
+// @Body UserRoleRequest
+//
+// HandleSetUserRole changes the role of the user with id
+func HandleSetUserRole(c echo.Context) error {
+	ctx := c.Request().Context()
+	var req UserRoleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, errors.ErrBindingPayload.JSON())
+	}
+	err := db.SetUserRole(ctx, db.DB, c.Param("id"), req.Role)
+	switch {
+	case err == errors.ErrInvalidRole:
+		return c.JSON(http.StatusBadRequest, errors.ErrInvalidRole.JSON())
+	case err == mongo.ErrNoDocuments:
+		return c.JSON(http.StatusNotFound, errors.ErrUserNotFound.JSON())
+	case err != nil:
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+// HandleGetCacheStats returns the hits and misses of the map and
+// character caches since the server started
+func HandleGetCacheStats(c echo.Context) error {
+	return c.JSON(http.StatusOK, db.CacheStats())
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/db"
+	"github.com/snburman/game-server/errors"
+)
+
+type APIKeyRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	RateLimit int      `json:"rate_limit"`
+}
+
+// APIKeyResponse includes the secret, which is only returned on creation and rotation
+type APIKeyResponse struct {
+	db.APIKey
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// HandleGetAPIKeys lists all API keys without secrets
+func HandleGetAPIKeys(c echo.Context) error {
+	ctx := c.Request().Context()
+	keys, err := db.GetAPIKeys(ctx, db.DB)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+	}
+	return c.JSON(http.StatusOK, keys)
+}
+
+// @Body APIKeyRequest
+//
+// HandleCreateAPIKey issues a new API key
+func HandleCreateAPIKey(c echo.Context) error {
+	ctx := c.Request().Context()
+	var req APIKeyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, errors.ErrBindingPayload.JSON())
+	}
+	if req.Name == "" || len(req.Scopes) == 0 || req.RateLimit < 0 {
+		return c.JSON(http.StatusBadRequest, errors.ErrMissingParams.JSON())
+	}
+	key, secret, err := db.CreateAPIKey(ctx, db.DB, db.APIKey{
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		RateLimit: req.RateLimit,
+	})
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+	}
+	return c.JSON(http.StatusCreated, APIKeyResponse{
+		APIKey:       key,
+		ClientID:     key.ID.Hex(),
+		ClientSecret: secret,
+	})
+}
+
+// @Param id
+//
+// HandleRotateAPIKey replaces the secret of an API key
+func HandleRotateAPIKey(c echo.Context) error {
+	ctx := c.Request().Context()
+	id := c.Param("id")
+	secret, err := db.RotateAPIKey(ctx, db.DB, id)
+	if err != nil {
+		switch err {
+		case errors.ErrAPIKeyNotFound:
+			return c.JSON(http.StatusNotFound, errors.ErrAPIKeyNotFound.JSON())
+		case errors.ErrInvalidAPIKey:
+			return c.JSON(http.StatusBadRequest, errors.ErrInvalidAPIKey.JSON())
+		}
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+	}
+	key, err := db.GetAPIKeyByID(ctx, db.DB, id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, errors.ErrAPIKeyNotFound.JSON())
+	}
+	return c.JSON(http.StatusOK, APIKeyResponse{
+		APIKey:       key,
+		ClientID:     key.ID.Hex(),
+		ClientSecret: secret,
+	})
+}
+
+// @Param id
+//
+// HandleRevokeAPIKey permanently disables an API key
+func HandleRevokeAPIKey(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := db.RevokeAPIKey(ctx, db.DB, c.Param("id")); err != nil {
+		if err == errors.ErrAPIKeyNotFound {
+			return c.JSON(http.StatusNotFound, errors.ErrAPIKeyNotFound.JSON())
+		}
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+	}
+	return c.NoContent(http.StatusAccepted)
+}
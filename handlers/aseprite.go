@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/aseprite"
+	"github.com/snburman/game-server/db"
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/middleware"
+)
+
+// maxSheetSide limits the width and height of uploaded sprite sheet images
+const maxSheetSide = 2048
+
+// directionAssetTypes are the character asset types of sprite directions,
+// in the order they are created
+var directionAssetTypes = []struct {
+	direction aseprite.Direction
+	assetType db.AssetType
+}{
+	{aseprite.Up, db.ASSET_PLAYER_UP},
+	{aseprite.Down, db.ASSET_PLAYER_DOWN},
+	{aseprite.Left, db.ASSET_PLAYER_LEFT},
+	{aseprite.Right, db.ASSET_PLAYER_RIGHT},
+}
+
+// @FormParam file .aseprite file, or
+//
+// @FormParam image sprite sheet PNG image and
+//
+// @FormParam sheet sprite sheet JSON data exported by Aseprite
+//
+// @FormParam name
+//
+// HandleImportAseprite creates the character assets of the user in JWT
+// claims from an Aseprite sprite. Each direction named by a tag or layer
+// becomes an asset named <name>_<direction>, see aseprite.Directions.
+// The frames of tagged directions are animated.
+func HandleImportAseprite(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok {
+		return c.JSON(
+			http.StatusUnauthorized,
+			errors.ServerError(errors.ErrInvalidJWT).JSON())
+	}
+	// a sprite sheet uploads an image and its JSON data
+	if err := parseUpload(c, 2*maxPNGBytes+maxFormBytes); err != nil {
+		return uploadError(c, err)
+	}
+	name := c.FormValue("name")
+	if name == "" {
+		return c.JSON(http.StatusBadRequest, errors.ErrMissingParams.JSON())
+	}
+	sprite, err := uploadedSprite(c)
+	if err != nil {
+		return uploadError(c, err)
+	}
+	animations, err := sprite.Directions()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errors.ErrNoDirections.JSON())
+	}
+
+	var characters []db.PlayerAsset[string]
+	for _, d := range directionAssetTypes {
+		a, ok := animations[d.direction]
+		if !ok {
+			continue
+		}
+		character, err := characterAsset(claims.UserID, name+"_"+string(d.direction), d.assetType, string(d.direction), a)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, errors.ServerError(err.Error()).JSON())
+		}
+		characters = append(characters, character)
+	}
+
+	// no asset is created if one of them exists or is invalid
+	ids, err := db.CreatePlayerAssets(ctx, db.DB, characters)
+	if err == errors.ErrImageExists {
+		return c.JSON(http.StatusNotAcceptable, errors.ErrImageExists.JSON())
+	}
+	if fields, ok := err.(errors.FieldErrors); ok {
+		return c.JSON(http.StatusBadRequest, fields.JSON())
+	}
+	if err != nil {
+		log.Println("error creating assets from aseprite sprite: ", err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrCreatingImage.JSON())
+	}
+	insertedIDs := map[db.AssetType]string{}
+	for i, character := range characters {
+		insertedIDs[character.AssetType] = ids[i].Hex()
+	}
+	return c.JSON(http.StatusAccepted, struct {
+		InsertedIDs map[db.AssetType]string `json:"inserted_ids"`
+	}{
+		InsertedIDs: insertedIDs,
+	})
+}
+
+// characterAsset converts the frames of a direction to an asset. Sprites
+// with several frames are animated by an animation named after the direction.
+func characterAsset(userID, name string, assetType db.AssetType, animation string, a aseprite.Animation) (db.PlayerAsset[string], error) {
+	asset := db.PlayerAsset[string]{
+		UserID:    userID,
+		Name:      name,
+		AssetType: assetType,
+		Width:     a.Frames[0].Bounds().Dx(),
+		Height:    a.Frames[0].Bounds().Dy(),
+	}
+	if len(a.Frames) > db.MaxAssetFrames {
+		return asset, errors.ErrInvalidAnimation
+	}
+	for i, img := range a.Frames {
+		data, err := json.Marshal(db.ImagePixelData(img))
+		if err != nil {
+			return asset, errors.ErrImageWrongFormat
+		}
+		if i == 0 {
+			asset.Data = string(data)
+		} else {
+			asset.Frames = append(asset.Frames, string(data))
+		}
+	}
+	if len(a.Frames) > 1 {
+		var anim db.Animation
+		for i, d := range a.Durations {
+			anim.Frames = append(anim.Frames, i)
+			anim.Durations = append(anim.Durations, min(max(int(d.Milliseconds()), 1), db.MaxFrameDuration))
+		}
+		asset.Animations = map[string]db.Animation{animation: anim}
+	}
+	return asset, nil
+}
+
+// uploadedSprite reads the .aseprite file or the sprite sheet of the request
+func uploadedSprite(c echo.Context) (*aseprite.Sprite, error) {
+	if _, err := c.FormFile("file"); err == nil {
+		f, err := openFormFile(c, "file")
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		sprite, err := aseprite.Decode(f, maxPNGSide)
+		if err != nil {
+			return nil, errors.ErrImageWrongFormat
+		}
+		return sprite, nil
+	}
+
+	f, err := openFormFile(c, "image")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := decodePNG(f, maxSheetSide)
+	if err != nil {
+		return nil, err
+	}
+	sheet, err := openFormFile(c, "sheet")
+	if err != nil {
+		return nil, err
+	}
+	defer sheet.Close()
+	sprite, err := aseprite.DecodeSheet(img, sheet, maxPNGSide)
+	if err != nil {
+		return nil, errors.ErrImageWrongFormat
+	}
+	return sprite, nil
+}
@@ -1,37 +1,33 @@
 package handlers
 
 import (
-	"context"
 	"log"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
-	"github.com/snburman/game-server/assets"
 	"github.com/snburman/game-server/db"
 	"github.com/snburman/game-server/errors"
 	"github.com/snburman/game-server/middleware"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
+// HandleGetAssets retrieves a page of shared game images
 func HandleGetAssets(c echo.Context) error {
-	// get images from db
-	res, err := db.MongoDB.Client.Database(db.GameDatabase).Collection(db.ImagesCollection).
-		Find(context.Background(), bson.M{})
+	ctx := c.Request().Context()
+	l, err := listOptions(c)
 	if err != nil {
-		return err
+		return listError(c, err)
 	}
-	var imgs []assets.Image
-	err = res.All(context.Background(), &imgs)
+	// get images from db
+	page, err := db.ListImages(ctx, db.DB, l)
 	if err != nil {
-		log.Println(res)
-		log.Println("error in fetching images", err)
-		return err
+		return listError(c, err)
 	}
-	return c.JSON(200, imgs)
+	return c.JSON(200, page)
 }
 
 // HandlePlayerGetAssets returns player assets by UserID in JWT claims
 func HandleGetPlayerAssets(c echo.Context) error {
+	ctx := c.Request().Context()
 	// get user id from claims
 	claims, ok := c.(middleware.JWTContext)
 	if !ok {
@@ -40,26 +36,31 @@ func HandleGetPlayerAssets(c echo.Context) error {
 			errors.ServerError(errors.ErrInvalidJWT).JSON())
 	}
 
-	assets, err := db.GetPlayerAssetsByUserID(db.MongoDB, claims.UserID)
+	l, err := listOptions(c)
 	if err != nil {
-		log.Println(err)
-		return c.JSON(
-			http.StatusNotFound,
-			errors.ServerError("error_getting_assets").JSON())
+		return listError(c, err)
+	}
+	l.UserID = claims.UserID
+	page, err := db.ListPlayerAssets(ctx, db.DB, l)
+	if err != nil {
+		return listError(c, err)
 	}
 
-	return c.JSON(200, assets)
+	return c.JSON(200, page)
 }
 
 func HandleGetDefaultPlayerCharacter(c echo.Context) error {
-	char, err := db.GetDefaultPlayerCharacter(db.MongoDB)
+	ctx := c.Request().Context()
+	char, err := db.GetDefaultPlayerCharacter(ctx, db.DB)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, errors.ErrImageNotFound.JSON())
 	}
+	setETag(c, char.Version)
 	return c.JSON(http.StatusOK, char)
 }
 
 func HandleCreatePlayerAsset(c echo.Context) error {
+	ctx := c.Request().Context()
 	// get user id from claims
 	claims, ok := c.(middleware.JWTContext)
 	if !ok {
@@ -82,12 +83,19 @@ func HandleCreatePlayerAsset(c echo.Context) error {
 			errors.ServerError(errors.ErrInvalidJWT).JSON())
 	}
 	// create asset
-	id, err := db.CreatePlayerAsset(db.MongoDB, asset)
+	id, err := db.CreatePlayerAsset(ctx, db.DB, asset)
 	if err != nil {
 		if err == errors.ErrImageExists {
 			return c.JSON(http.StatusNotAcceptable,
 				errors.ErrImageExists.JSON())
 		}
+		if fields, ok := err.(errors.FieldErrors); ok {
+			return c.JSON(http.StatusBadRequest, fields.JSON())
+		}
+		if err == errors.ErrImageWrongFormat {
+			return c.JSON(http.StatusBadRequest,
+				errors.ServerError(err.Error()).JSON())
+		}
 		return c.JSON(http.StatusInternalServerError,
 			errors.ServerError(err.Error()).JSON())
 	}
@@ -98,6 +106,7 @@ func HandleCreatePlayerAsset(c echo.Context) error {
 }
 
 func HandleUpdatePlayerAsset(c echo.Context) error {
+	ctx := c.Request().Context()
 	// get user id from claims
 	claims, ok := c.(middleware.JWTContext)
 	if !ok {
@@ -106,8 +115,13 @@ func HandleUpdatePlayerAsset(c echo.Context) error {
 			errors.ServerError(errors.ErrInvalidJWT).JSON(),
 		)
 	}
-	// get asset from req body
-	asset := *new(db.PlayerAsset[string])
+	// get asset from req body, the asset is identified by user and name
+	// and only fields present in the body are changed
+	var asset struct {
+		UserID string `json:"user_id"`
+		Name   string `json:"name"`
+		db.PlayerAssetPatch
+	}
 	if err := c.Bind(&asset); err != nil {
 		return err
 	}
@@ -120,7 +134,7 @@ func HandleUpdatePlayerAsset(c echo.Context) error {
 	}
 
 	// get asset by userID and name
-	existingAsset, err := db.GetPlayerAssetByNameUserID(db.MongoDB, asset.Name, asset.UserID)
+	existingAsset, err := db.GetPlayerAssetByNameUserID(ctx, db.DB, asset.Name, asset.UserID)
 	if err != nil {
 		switch err {
 		case errors.ErrImageNotFound:
@@ -130,26 +144,42 @@ func HandleUpdatePlayerAsset(c echo.Context) error {
 			)
 		}
 	}
-	// assign existing ID
-	asset.ID = existingAsset.ID
 
+	// If-Match takes precedence over a version in the body
+	version, err := ifMatch(c, asset.Version, existingAsset.Version)
+	if err != nil {
+		return preconditionError(c, err, existingAsset.Version)
+	}
+	asset.Version = version
 	// update asset
-	if err = db.UpdatePlayerAsset(db.MongoDB, asset); err != nil {
+	updated, err := db.UpdatePlayerAsset(ctx, db.DB, existingAsset.ID.Hex(), asset.PlayerAssetPatch)
+	if err == errors.ErrVersionConflict {
+		return versionConflict(c, updated)
+	}
+	if fields, ok := err.(errors.FieldErrors); ok {
+		return c.JSON(http.StatusBadRequest, fields.JSON())
+	}
+	if err == errors.ErrImageWrongFormat {
+		return c.JSON(http.StatusBadRequest, errors.ServerError(err.Error()).JSON())
+	}
+	if err != nil {
 		log.Println(err)
 		return c.JSON(
 			http.StatusInternalServerError,
 			errors.ServerError(err.Error()).JSON(),
 		)
 	}
+	setETag(c, updated)
 	return c.NoContent(http.StatusAccepted)
 }
 
 func HandleDeletePlayerAsset(c echo.Context) error {
+	ctx := c.Request().Context()
 	imageID := c.QueryParam("id")
 	if imageID == "" {
 		return c.JSON(http.StatusBadRequest, errors.ErrMissingParams.JSON())
 	}
-	count, err := db.DeletePlayerAsset(db.MongoDB, imageID)
+	count, err := db.DeletePlayerAsset(ctx, db.DB, imageID)
 	if err != nil {
 		return c.JSON(
 			http.StatusInternalServerError,
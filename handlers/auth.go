@@ -3,42 +3,81 @@ package handlers
 import (
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/config"
+	"github.com/snburman/game-server/conn"
 	"github.com/snburman/game-server/db"
 	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/mail"
 	"github.com/snburman/game-server/middleware"
+	"github.com/snburman/game-server/oidc"
 	"github.com/snburman/game-server/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	accessTokenExpiry  = time.Minute * 30
+	refreshTokenExpiry = time.Hour * 7 * 24
 )
 
 type AuthService struct {
-	store *sessions.CookieStore
+	store       *sessions.CookieStore
+	mailer      mail.Mailer
+	mfaAttempts *attemptLimiter
+	providers   map[string]*oidc.Provider
 }
 
 type AuthResponse struct {
 	errors.ServerError `json:"error,omitempty"`
 	Token              string `json:"token"`
 	RefreshToken       string `json:"refresh_token"`
+	// MFAToken is set instead of tokens when a second factor is required
+	MFAToken string `json:"mfa_token,omitempty"`
 }
 
 func NewAuthService() *AuthService {
-	return &AuthService{}
+	store := sessions.NewCookieStore([]byte(config.Env().SECRET))
+	store.Options = &sessions.Options{
+		Path:     "/auth/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(config.Env().SERVER_URL, "https"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	return &AuthService{
+		store:       store,
+		mailer:      mail.NewMailer(),
+		mfaAttempts: newAttemptLimiter(maxMFAAttempts, mfaTokenExpiry),
+		providers:   newOIDCProviders(),
+	}
+}
+
+// newAuthResponse generates access and refresh tokens for userID
+func newAuthResponse(userID string) AuthResponse {
+	return AuthResponse{
+		Token:        utils.GenerateJWT(userID, accessTokenExpiry),
+		RefreshToken: utils.GenerateJWT(userID, refreshTokenExpiry),
+	}
 }
 
 func (a *AuthService) HandleRefreshToken(c echo.Context) error {
+	ctx := c.Request().Context()
 	rt, err := middleware.UnmarshalClientDataContext[string](c)
 	if err != nil {
 		log.Println("missing_refresh_token")
 		return c.NoContent(http.StatusUnauthorized)
 	}
 	claims, err := utils.DecodeJWT(rt)
-	if err != nil || claims.UserID == "" {
+	if err != nil || claims.UserID == "" || claims.Purpose != "" ||
+		db.TokenRevoked(claims.UserID, claims.IssuedAtTime()) {
 		log.Println("bad_refresh_token")
 		return c.NoContent(http.StatusUnauthorized)
 	}
-	user, err := db.GetUserByID(db.MongoDB, claims.UserID)
+	user, err := db.GetUserByID(ctx, db.DB, claims.UserID)
 	if err != nil {
 		log.Println("user_not_found")
 		return c.NoContent(http.StatusUnauthorized)
@@ -47,18 +86,25 @@ func (a *AuthService) HandleRefreshToken(c echo.Context) error {
 		log.Println("user_banned")
 		return c.NoContent(http.StatusUnauthorized)
 	}
+	// MFA required since the refresh token was issued must be enabled
+	// first, tokens are revoked when it is
+	if !user.MFAEnabled {
+		settings, err := db.GetSettings(ctx, db.DB)
+		if err != nil {
+			log.Println("error getting settings: ", err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		if settings.RoleRequiresMFA(user.Role) {
+			return enrollmentRequired(c, user.ID.Hex())
+		}
+	}
 
 	// generate token response
-	token := utils.GenerateJWT(user.ID.Hex(), time.Minute*30)
-	refreshToken := utils.GenerateJWT(user.ID.Hex(), time.Hour*7*24)
-	res := AuthResponse{
-		Token:        token,
-		RefreshToken: refreshToken,
-	}
-	return c.JSON(http.StatusAccepted, res)
+	return c.JSON(http.StatusAccepted, newAuthResponse(user.ID.Hex()))
 }
 
 func (a *AuthService) HandleGetUser(c echo.Context) error {
+	ctx := c.Request().Context()
 	// get user from context
 	claims, ok := c.(middleware.JWTContext)
 	if !ok {
@@ -67,7 +113,7 @@ func (a *AuthService) HandleGetUser(c echo.Context) error {
 		})
 	}
 	// get user from db
-	user, err := db.GetUserByID(db.MongoDB, claims.UserID)
+	user, err := db.GetUserByID(ctx, db.DB, claims.UserID)
 	if err != nil {
 		log.Println("user_not_found")
 		return c.NoContent(http.StatusUnauthorized)
@@ -85,36 +131,56 @@ func (a *AuthService) HandleGetUser(c echo.Context) error {
 }
 
 func (a *AuthService) HandleCreateUser(c echo.Context) error {
+	ctx := c.Request().Context()
 	// get user from context
 	u, err := middleware.UnmarshalClientDataContext[db.User](c)
 	if err != nil {
 		return err
 	}
+	if err := db.ValidateUserName(u.UserName); err != nil {
+		return c.JSON(http.StatusBadRequest, AuthResponse{
+			ServerError: err.(errors.ServerError),
+		})
+	}
 	// check if user exists
-	user, err := db.GetUserByUserName(db.MongoDB, u.UserName)
-	if err == nil && user.UserName == u.UserName {
+	_, err = db.GetUserByUserName(ctx, db.DB, u.UserName)
+	if err == nil {
+		return c.JSON(http.StatusInternalServerError, AuthResponse{
+			ServerError: errors.ErrUserExists,
+		})
+	}
+	// check if email is in use
+	if u.Email != "" {
+		if _, err := db.GetUserByEmail(ctx, db.DB, u.Email); err == nil {
+			return c.JSON(http.StatusInternalServerError, AuthResponse{
+				ServerError: errors.ErrEmailExists,
+			})
+		}
+	}
+	// create user, the unique index catches concurrent signups
+	id, err := db.CreateUser(ctx, db.DB, u)
+	if err == errors.ErrUserExists {
 		return c.JSON(http.StatusInternalServerError, AuthResponse{
 			ServerError: errors.ErrUserExists,
 		})
 	}
-	// create user
-	id, err := db.CreateUser(db.MongoDB, u)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, AuthResponse{
 			ServerError: errors.ServerError(err.Error()),
 		})
 	}
-	// generate token response
-	token := utils.GenerateJWT(id.Hex(), time.Minute*30)
-	refreshToken := utils.GenerateJWT(id.Hex(), time.Hour*7*24)
-	res := AuthResponse{
-		Token:        token,
-		RefreshToken: refreshToken,
+	// optionally verify email
+	if u.Email != "" {
+		if err := a.sendVerificationEmail(ctx, id.Hex(), u.Email); err != nil {
+			log.Println("error sending verification email: ", err)
+		}
 	}
-	return c.JSON(http.StatusCreated, res)
+	// generate token response
+	return c.JSON(http.StatusCreated, newAuthResponse(id.Hex()))
 }
 
 func (a *AuthService) HandleLoginUser(c echo.Context) error {
+	ctx := c.Request().Context()
 	// get user data from context
 	u, err := middleware.UnmarshalClientDataContext[db.User](c)
 	if err != nil {
@@ -126,7 +192,7 @@ func (a *AuthService) HandleLoginUser(c echo.Context) error {
 		})
 	}
 	// get user from db
-	user, err := db.GetUserByUserName(db.MongoDB, u.UserName)
+	user, err := db.GetUserByUserName(ctx, db.DB, u.UserName)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, AuthResponse{
 			ServerError: errors.ErrInvalidCredentials,
@@ -145,48 +211,140 @@ func (a *AuthService) HandleLoginUser(c echo.Context) error {
 			ServerError: errors.ErrUserBanned,
 		})
 	}
-	// generate token response
-	token := utils.GenerateJWT(user.ID.Hex(), time.Minute*30)
-	refreshToken := utils.GenerateJWT(user.ID.Hex(), time.Hour*7*24)
-	res := AuthResponse{
-		Token:        token,
-		RefreshToken: refreshToken,
-	}
-	return c.JSON(http.StatusOK, res)
+	// issue tokens or require a second factor
+	return a.completeLogin(c, user)
 }
 
 func (a *AuthService) HandleUpdateUser(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok || claims.UserID == "" {
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidJWT.JSON())
+	}
 	var user db.User
 	err := c.Bind(&user)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, errors.ErrMissingParams.JSON())
 	}
-	err = db.UpdateUser(db.MongoDB, user)
+	// only allow updating the authenticated user
+	user.ID, err = primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidJWT.JSON())
+	}
+	current, err := db.GetUserByID(ctx, db.DB, claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, errors.ErrInvalidCredentials.JSON())
+	}
+	emailChanged := user.Email != "" && !strings.EqualFold(user.Email, current.Email)
+	if emailChanged {
+		if _, err := db.GetUserByEmail(ctx, db.DB, user.Email); err == nil {
+			return c.JSON(http.StatusBadRequest, errors.ErrEmailExists.JSON())
+		}
+	}
+	err = db.UpdateUser(ctx, db.DB, user)
 	if err != nil {
 		if err.Error() == errors.ErrWeakPassword.Error() {
 			return c.JSON(http.StatusBadRequest, errors.ErrWeakPassword.JSON())
 		}
+		if err == errors.ErrInvalidEmail {
+			return c.JSON(http.StatusBadRequest, errors.ErrInvalidEmail.JSON())
+		}
 		log.Println(err)
 		return c.JSON(http.StatusInternalServerError, errors.ErrUpdatingUser.JSON())
 	}
+	// verify new email
+	if emailChanged {
+		if err := a.sendVerificationEmail(ctx, claims.UserID, user.Email); err != nil {
+			log.Println("error sending verification email: ", err)
+		}
+	}
 	return c.NoContent(http.StatusAccepted)
 }
 
+func (a *AuthService) HandleRenameUser(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok || claims.UserID == "" {
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidJWT.JSON())
+	}
+	var body struct {
+		UserName string `json:"username"`
+	}
+	if err := c.Bind(&body); err != nil || body.UserName == "" {
+		return c.JSON(http.StatusBadRequest, errors.ErrMissingParams.JSON())
+	}
+	err := db.RenameUser(ctx, db.DB, claims.UserID, body.UserName)
+	switch err {
+	case nil:
+		return c.NoContent(http.StatusAccepted)
+	case errors.ErrInvalidUserName, errors.ErrReservedUserName:
+		return c.JSON(http.StatusBadRequest, err.(errors.ServerError).JSON())
+	case errors.ErrUserExists:
+		return c.JSON(http.StatusConflict, errors.ErrUserExists.JSON())
+	default:
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrUpdatingUser.JSON())
+	}
+}
+
+// HandleDeleteUser revokes the user's tokens, closes their connections and
+// deletes the account along with its maps and assets. With a grace period
+// configured the deletion is only scheduled. Logging in again does not
+// cancel it, HandleCancelDeleteUser does.
 func (a *AuthService) HandleDeleteUser(c echo.Context) error {
+	ctx := c.Request().Context()
 	claims, ok := c.(middleware.JWTContext)
 	if !ok || claims.UserID == "" {
 		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidJWT.JSON())
 	}
-	count, err := db.DeleteUser(db.MongoDB, claims.UserID)
+	if err := db.RevokeUserTokens(ctx, db.DB, claims.UserID); err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrUpdatingUser.JSON())
+	}
+	conn.CloseUserConns(claims.UserID)
+
+	if grace := config.AccountDeletionGracePeriod(); grace > 0 {
+		deleteAfter := time.Now().Add(grace).UTC()
+		if err := db.ScheduleUserDeletion(ctx, db.DB, claims.UserID, deleteAfter); err != nil {
+			log.Println(err)
+			return c.JSON(http.StatusInternalServerError, errors.ErrUpdatingUser.JSON())
+		}
+		return c.JSON(http.StatusAccepted, struct {
+			Deleted     int       `json:"deleted"`
+			DeleteAfter time.Time `json:"delete_after"`
+		}{
+			DeleteAfter: deleteAfter,
+		})
+	}
+
+	count, err := db.DeleteUserData(ctx, db.DB, claims.UserID)
 	if err != nil {
 		return c.JSON(
 			http.StatusInternalServerError,
 			errors.ServerError(err.Error()).JSON())
 	}
-
+	exports.RemoveUser(claims.UserID)
 	return c.JSON(http.StatusAccepted, struct {
 		Deleted int `json:"deleted"`
 	}{
 		Deleted: count,
 	})
 }
+
+// HandleCancelDeleteUser cancels a scheduled account deletion
+func (a *AuthService) HandleCancelDeleteUser(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok || claims.UserID == "" {
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidJWT.JSON())
+	}
+	err := db.CancelUserDeletion(ctx, db.DB, claims.UserID)
+	if err == errors.ErrDeletionNotScheduled {
+		return c.JSON(http.StatusBadRequest, errors.ErrDeletionNotScheduled.JSON())
+	}
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrUpdatingUser.JSON())
+	}
+	return c.NoContent(http.StatusAccepted)
+}
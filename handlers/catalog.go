@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/assets"
+	"github.com/snburman/game-server/db"
+	"github.com/snburman/game-server/errors"
+)
+
+const (
+	// the latest catalog is revalidated after catalogMaxAge seconds,
+	// catalogs by version never change
+	catalogMaxAge       = 300
+	catalogImmutableAge = 365 * 24 * 60 * 60
+)
+
+// catalog is the built-in sprite catalog, nil until LoadCatalog succeeds
+var catalog atomic.Pointer[assets.Catalog]
+
+// LoadCatalog reads the built-in sprites from fsys, see assets.LoadCatalog,
+// and syncs the shared game images of store with them
+func LoadCatalog(ctx context.Context, store db.DatabaseClient, fsys fs.FS) error {
+	c, err := assets.LoadCatalog(fsys)
+	if err != nil {
+		return err
+	}
+	changed, err := db.SyncImages(ctx, store, c.Images)
+	if err != nil {
+		return err
+	}
+	if changed > 0 {
+		log.Printf("synced sprite catalog %s: %d images changed", c.Version, changed)
+	}
+	catalog.Store(&c)
+	return nil
+}
+
+// HandleGetCatalog returns the built-in sprite catalog tagged with its version
+func HandleGetCatalog(c echo.Context) error {
+	return serveCatalog(c, "", catalogMaxAge)
+}
+
+// @Param version
+//
+// HandleGetCatalogVersion returns the built-in sprite catalog if it is at
+// version. It can be cached for good.
+func HandleGetCatalogVersion(c echo.Context) error {
+	return serveCatalog(c, c.Param("version"), catalogImmutableAge)
+}
+
+// serveCatalog responds with the catalog, or 304 Not Modified if the
+// client has its version. Other versions are not found.
+func serveCatalog(c echo.Context, version string, maxAge int) error {
+	current := catalog.Load()
+	if current == nil {
+		return c.JSON(http.StatusServiceUnavailable, errors.ErrServerError.JSON())
+	}
+	if version != "" && version != current.Version {
+		return c.JSON(http.StatusNotFound, errors.ErrImageNotFound.JSON())
+	}
+	header := c.Response().Header()
+	header.Set(headerETag, strconv.Quote(current.Version))
+	header.Set(echo.HeaderCacheControl, "public, max-age="+strconv.Itoa(maxAge))
+	if ifNoneMatch(c, current.Version) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSON(http.StatusOK, current)
+}
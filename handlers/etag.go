@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/errors"
+)
+
+const (
+	headerETag    = "ETag"
+	headerIfMatch = "If-Match"
+
+	headerIfNoneMatch = "If-None-Match"
+)
+
+// setETag tags the response with the version of the map or asset it returns.
+// The tag is only the version to send back in If-Match, responses adding
+// to the map or asset, like the player character of HandleGetMapByID, are
+// not tagged by what they add.
+func setETag(c echo.Context, version int) {
+	c.Response().Header().Set(headerETag, strconv.Quote(strconv.Itoa(version)))
+}
+
+// entityTag is an entity tag of an If-Match or If-None-Match header
+type entityTag struct {
+	opaque string
+	weak   bool
+}
+
+// parseEntityTags parses header, "*" or a list of entity tags as in
+// RFC 9110. any is set for "*". Lists may have empty elements.
+func parseEntityTags(header string) (tags []entityTag, any bool, err error) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return nil, true, nil
+	}
+	for header != "" {
+		header = strings.TrimLeft(header, " \t,")
+		if header == "" {
+			break
+		}
+		var tag entityTag
+		if rest, ok := strings.CutPrefix(header, "W/"); ok {
+			tag.weak = true
+			header = rest
+		}
+		if !strings.HasPrefix(header, `"`) {
+			return nil, false, errors.ErrInvalidVersion
+		}
+		end := strings.IndexByte(header[1:], '"')
+		if end < 0 {
+			return nil, false, errors.ErrInvalidVersion
+		}
+		tag.opaque = header[1 : end+1]
+		tags = append(tags, tag)
+		header = strings.TrimLeft(header[end+2:], " \t")
+		if header != "" && header[0] != ',' {
+			return nil, false, errors.ErrInvalidVersion
+		}
+	}
+	if len(tags) == 0 {
+		return nil, false, errors.ErrInvalidVersion
+	}
+	return tags, false, nil
+}
+
+// ifMatch returns the version required by the If-Match header, or fallback,
+// the version in the body, if the header is absent. A write requiring no
+// version returns ErrVersionRequired. "*" requires the current version.
+// If-Match compares tags strongly as in RFC 9110, so weak tags never match
+// and a list of only weak tags returns ErrVersionConflict. The current
+// version is required if it is listed, otherwise the first listed, which
+// conflicts unless the map or asset is updated to it meanwhile.
+func ifMatch(c echo.Context, fallback *int, current int) (*int, error) {
+	header := c.Request().Header.Get(headerIfMatch)
+	if strings.TrimSpace(header) == "" {
+		if fallback == nil {
+			return nil, errors.ErrVersionRequired
+		}
+		return fallback, nil
+	}
+	tags, any, err := parseEntityTags(header)
+	if err != nil {
+		return nil, err
+	}
+	if any {
+		return &current, nil
+	}
+	var versions []int
+	for _, tag := range tags {
+		if tag.weak {
+			continue
+		}
+		version, err := strconv.Atoi(tag.opaque)
+		if err != nil {
+			return nil, errors.ErrInvalidVersion
+		}
+		versions = append(versions, version)
+	}
+	if len(versions) == 0 {
+		return nil, errors.ErrVersionConflict
+	}
+	if slices.Contains(versions, current) {
+		return &current, nil
+	}
+	return &versions[0], nil
+}
+
+// ifNoneMatch reports whether the If-None-Match header lists opaque, the
+// entity tag of the current response, or is "*". If-None-Match compares
+// tags weakly as in RFC 9110. Invalid headers match nothing.
+func ifNoneMatch(c echo.Context, opaque string) bool {
+	header := c.Request().Header.Get(headerIfNoneMatch)
+	if strings.TrimSpace(header) == "" {
+		return false
+	}
+	tags, any, err := parseEntityTags(header)
+	if err != nil {
+		return false
+	}
+	return any || slices.ContainsFunc(tags, func(tag entityTag) bool {
+		return tag.opaque == opaque
+	})
+}
+
+// preconditionError responds to an error returned by ifMatch
+func preconditionError(c echo.Context, err error, current int) error {
+	switch err {
+	case errors.ErrVersionConflict:
+		return versionConflict(c, current)
+	case errors.ErrVersionRequired:
+		return c.JSON(http.StatusPreconditionRequired, errors.ErrVersionRequired.JSON())
+	}
+	return c.JSON(http.StatusBadRequest, errors.ErrInvalidVersion.JSON())
+}
+
+// versionConflict responds to a write against a stale version with the
+// current version of the map or asset
+func versionConflict(c echo.Context, current int) error {
+	setETag(c, current)
+	return c.JSON(http.StatusConflict, struct {
+		Error   string `json:"error"`
+		Version int    `json:"version"`
+	}{
+		Error:   errors.ErrVersionConflict.Error(),
+		Version: current,
+	})
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func requestWith(header, value string) echo.Context {
+	req := httptest.NewRequest(http.MethodPut, "/", nil)
+	if value != "" {
+		req.Header.Set(header, value)
+	}
+	return echo.New().NewContext(req, httptest.NewRecorder())
+}
+
+func TestIfMatch(t *testing.T) {
+	body := 2
+	tests := []struct {
+		name     string
+		header   string
+		fallback *int
+		version  int
+		err      error
+	}{
+		{name: "body version", fallback: &body, version: 2},
+		{name: "no version", err: errors.ErrVersionRequired},
+		{name: "header over body", header: `"3"`, fallback: &body, version: 3},
+		{name: "any", header: "*", version: 5},
+		{name: "current listed", header: `"1", "5"`, version: 5},
+		{name: "first listed", header: `"1", "2"`, version: 1},
+		{name: "empty elements", header: `, "1" ,,`, version: 1},
+		{name: "weak skipped", header: `W/"5", "1"`, version: 1},
+		{name: "only weak", header: `W/"5"`, err: errors.ErrVersionConflict},
+		{name: "unquoted", header: "5", err: errors.ErrInvalidVersion},
+		{name: "not a number", header: `"five"`, err: errors.ErrInvalidVersion},
+		{name: "unterminated", header: `"5`, err: errors.ErrInvalidVersion},
+		{name: "missing comma", header: `"5" "6"`, err: errors.ErrInvalidVersion},
+		{name: "only commas", header: ",", err: errors.ErrInvalidVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, err := ifMatch(requestWith(headerIfMatch, tt.header), tt.fallback, 5)
+			assert.Equal(t, tt.err, err)
+			if tt.err == nil && assert.NotNil(t, version) {
+				assert.Equal(t, tt.version, *version)
+			}
+		})
+	}
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	assert.False(t, ifNoneMatch(requestWith(headerIfNoneMatch, ""), "v1"))
+	assert.True(t, ifNoneMatch(requestWith(headerIfNoneMatch, `"v1"`), "v1"))
+	assert.True(t, ifNoneMatch(requestWith(headerIfNoneMatch, `"v0", W/"v1"`), "v1"))
+	assert.True(t, ifNoneMatch(requestWith(headerIfNoneMatch, "*"), "v1"))
+	assert.True(t, ifNoneMatch(requestWith(headerIfNoneMatch, `"a,b"`), "a,b"))
+	assert.False(t, ifNoneMatch(requestWith(headerIfNoneMatch, `"v0"`), "v1"))
+	assert.False(t, ifNoneMatch(requestWith(headerIfNoneMatch, "v1"), "v1"))
+}
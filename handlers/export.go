@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/db"
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/export"
+	"github.com/snburman/game-server/middleware"
+)
+
+var exports = export.NewManager(filepath.Join(os.TempDir(), "game-server-exports"))
+
+// HandleCreateExport starts generating an archive of the user's data
+func HandleCreateExport(c echo.Context) error {
+	claims, ok := c.(middleware.JWTContext)
+	if !ok || claims.UserID == "" {
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidJWT.JSON())
+	}
+	job := exports.Start(db.DB, claims.UserID)
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// @Param id
+//
+// HandleGetExport returns the status of an export job
+func HandleGetExport(c echo.Context) error {
+	job, err := getExportJob(c)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, errors.ErrExportNotFound.JSON())
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// @Param id
+//
+// HandleDownloadExport streams the archive of a completed export job
+func HandleDownloadExport(c echo.Context) error {
+	job, err := getExportJob(c)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, errors.ErrExportNotFound.JSON())
+	}
+	switch job.Status {
+	case export.JobDone:
+	case export.JobFailed:
+		return c.JSON(http.StatusInternalServerError, errors.ErrExportFailed.JSON())
+	default:
+		return c.JSON(http.StatusConflict, errors.ErrExportNotReady.JSON())
+	}
+	f, err := exports.Open(job)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, errors.ErrExportNotFound.JSON())
+	}
+	defer f.Close()
+	c.Response().Header().Set(
+		echo.HeaderContentDisposition,
+		`attachment; filename="export-`+job.ID+`.zip"`,
+	)
+	return c.Stream(http.StatusOK, "application/zip", f)
+}
+
+// getExportJob returns the job in the id param if it belongs to the user
+func getExportJob(c echo.Context) (export.Job, error) {
+	claims, ok := c.(middleware.JWTContext)
+	if !ok || claims.UserID == "" {
+		return export.Job{}, errors.ErrInvalidJWT
+	}
+	job, ok := exports.Get(c.Param("id"))
+	if !ok || job.UserID != claims.UserID {
+		return export.Job{}, errors.ErrExportNotFound
+	}
+	return job, nil
+}
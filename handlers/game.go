@@ -19,7 +19,7 @@ func HandleGetGame(c echo.Context) error {
 		)
 	}
 	claims, err := utils.DecodeJWT(token)
-	if err != nil || claims.UserID == "" {
+	if err != nil || claims.UserID == "" || claims.Purpose != "" {
 		return c.JSON(
 			http.StatusUnauthorized,
 			errors.ErrInvalidJWT.JSON(),
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/db"
+	"github.com/snburman/game-server/errors"
+)
+
+// @QueryParam sort created, updated, name or popularity
+//
+// @QueryParam order asc or desc
+//
+// @QueryParam cursor next_cursor of the previous page
+//
+// @QueryParam limit page size
+//
+// @QueryParam owner user ID
+//
+// @QueryParam name name prefix
+//
+// @QueryParam type asset type
+//
+// @QueryParam summary "true" leaves out data
+//
+// listOptions reads the pagination, sort and filter query parameters
+func listOptions(c echo.Context) (db.ListOptions, error) {
+	l := db.ListOptions{
+		Sort:       db.ListSort(c.QueryParam("sort")),
+		Cursor:     c.QueryParam("cursor"),
+		UserID:     c.QueryParam("owner"),
+		NamePrefix: c.QueryParam("name"),
+		AssetType:  db.AssetType(c.QueryParam("type")),
+		Summary:    c.QueryParam("summary") == "true",
+	}
+	switch c.QueryParam("order") {
+	case "", "asc":
+	case "desc":
+		l.Descending = true
+	default:
+		return l, errors.ErrInvalidSort
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return l, errors.ErrInvalidLimit
+		}
+		l.Limit = n
+	}
+	return l, nil
+}
+
+// listError responds to an error from listOptions or a db listing
+func listError(c echo.Context, err error) error {
+	switch err {
+	case errors.ErrInvalidSort, errors.ErrInvalidCursor, errors.ErrInvalidLimit:
+		return c.JSON(http.StatusBadRequest, errors.ServerError(err.Error()).JSON())
+	}
+	log.Println("error listing: ", err)
+	return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+}
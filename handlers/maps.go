@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/snburman/game-server/db"
@@ -10,24 +12,29 @@ import (
 	"github.com/snburman/game-server/middleware"
 )
 
-// HandleGetAllMaps retrieves all maps
+// HandleGetAllMaps retrieves a page of maps of every user,
+// see listOptions for the query parameters
 func HandleGetAllMaps(c echo.Context) error {
-	maps, err := db.GetAllMaps(db.MongoDB)
+	ctx := c.Request().Context()
+	l, err := listOptions(c)
 	if err != nil {
-		return c.JSON(
-			http.StatusInternalServerError,
-			errors.ServerError(err.Error()).JSON(),
-		)
+		return listError(c, err)
 	}
-	return c.JSON(http.StatusOK, maps)
+	page, err := db.ListMaps(ctx, db.DB, l)
+	if err != nil {
+		return listError(c, err)
+	}
+	return c.JSON(http.StatusOK, page)
 }
 
 // @QueryParam id
 //
 // @QueryParam userID
 //
-// HandleGetMapByID retrieves a map by ID and appends player character by userID
+// HandleGetMapByID retrieves a map by ID and appends player character by
+// userID. The ETag is the version of the map, see setETag.
 func HandleGetMapByID(c echo.Context) error {
+	ctx := c.Request().Context()
 	id := c.QueryParam("id")
 	userID := c.QueryParam("userID")
 	if id == "" || userID == "" {
@@ -37,7 +44,7 @@ func HandleGetMapByID(c echo.Context) error {
 		)
 	}
 
-	_map, err := db.GetMapByID(db.MongoDB, id)
+	_map, err := db.GetMapByID(ctx, db.DB, id)
 	if err != nil {
 		return c.JSON(
 			http.StatusInternalServerError,
@@ -45,13 +52,14 @@ func HandleGetMapByID(c echo.Context) error {
 		)
 	}
 
-	_map, err = db.AppendMapPlayerCharacter(db.MongoDB, userID, _map)
+	_map, err = db.AppendMapPlayerCharacter(ctx, db.DB, userID, _map)
 	if err != nil {
 		return c.JSON(
 			http.StatusInternalServerError,
 			errors.ServerError(err.Error()).JSON(),
 		)
 	}
+	setETag(c, _map.Version)
 
 	return c.JSON(http.StatusOK, _map)
 }
@@ -60,6 +68,7 @@ func HandleGetMapByID(c echo.Context) error {
 //
 // HandleGetAllMapsByIDs retrieves all maps by IDs
 func HandleGetAllMapsByIDs(c echo.Context) error {
+	ctx := c.Request().Context()
 	ids := c.QueryParams()["ids"]
 	if len(ids) == 0 {
 		return c.JSON(
@@ -68,7 +77,7 @@ func HandleGetAllMapsByIDs(c echo.Context) error {
 		)
 	}
 
-	maps, err := db.GetMapsByIDs(db.MongoDB, ids)
+	maps, err := db.GetMapsByIDs(ctx, db.DB, ids)
 	if err != nil {
 		return c.JSON(
 			http.StatusInternalServerError,
@@ -80,8 +89,10 @@ func HandleGetAllMapsByIDs(c echo.Context) error {
 
 // @Param userID
 //
-// HandleGetPrimaryMap retrieves the primary map by userID and appends player character
+// HandleGetPrimaryMap retrieves the primary map by userID and appends player
+// character. The ETag is the version of the map, see setETag.
 func HandleGetPlayerPrimaryMap(c echo.Context) error {
+	ctx := c.Request().Context()
 	userID := c.Param("userID")
 	if userID == "" {
 		return c.JSON(
@@ -90,7 +101,7 @@ func HandleGetPlayerPrimaryMap(c echo.Context) error {
 		)
 	}
 
-	_map, err := db.GetPrimaryMapByUserID(db.MongoDB, userID)
+	_map, err := db.GetPrimaryMapByUserID(ctx, db.DB, userID)
 	if err != nil {
 		return c.JSON(
 			http.StatusInternalServerError,
@@ -98,35 +109,44 @@ func HandleGetPlayerPrimaryMap(c echo.Context) error {
 		)
 	}
 
-	_map, err = db.AppendMapPlayerCharacter(db.MongoDB, userID, _map)
+	_map, err = db.AppendMapPlayerCharacter(ctx, db.DB, userID, _map)
 	if err != nil {
 		return c.JSON(
 			http.StatusInternalServerError,
 			errors.ServerError(err.Error()).JSON(),
 		)
 	}
+	setETag(c, _map.Version)
 	return c.JSON(http.StatusOK, _map)
 }
 
+// HandleGetPlayerMaps retrieves a page of maps of the user in JWT claims
 func HandleGetPlayerMaps(c echo.Context) error {
+	ctx := c.Request().Context()
 	claims, ok := c.(middleware.JWTContext)
 	if !ok {
 		return c.JSON(
 			http.StatusUnauthorized,
 			errors.ServerError(errors.ErrInvalidJWT).JSON())
 	}
-	maps, err := db.GetMapsByUserID(db.MongoDB, claims.UserID)
+	l, err := listOptions(c)
 	if err != nil {
-		return c.JSON(http.StatusNotFound, errors.ErrMapNotFound)
+		return listError(c, err)
+	}
+	l.UserID = claims.UserID
+	page, err := db.ListMaps(ctx, db.DB, l)
+	if err != nil {
+		return listError(c, err)
 	}
 
-	return c.JSON(http.StatusOK, maps)
+	return c.JSON(http.StatusOK, page)
 }
 
 // @Body Map[string]
 //
 // HandleCreateMap creates a new map
 func HandleCreateMap(c echo.Context) error {
+	ctx := c.Request().Context()
 	claims, ok := c.(middleware.JWTContext)
 	if !ok {
 		return c.JSON(
@@ -147,8 +167,20 @@ func HandleCreateMap(c echo.Context) error {
 			errors.ErrInvalidJWT.JSON(),
 		)
 	}
+	// username is denormalized from the user record
+	user, err := db.GetUserByID(ctx, db.DB, claims.UserID)
+	if err != nil {
+		return c.JSON(
+			http.StatusUnauthorized,
+			errors.ErrInvalidJWT.JSON(),
+		)
+	}
+	_map.UserName = user.UserName
 
-	insertedId, err := db.CreateMap(db.MongoDB, _map)
+	insertedId, err := db.CreateMap(ctx, db.DB, _map)
+	if fields, ok := err.(errors.FieldErrors); ok {
+		return c.JSON(http.StatusBadRequest, fields.JSON())
+	}
 	if err != nil {
 		log.Println(err)
 		return c.JSON(
@@ -165,6 +197,7 @@ func HandleCreateMap(c echo.Context) error {
 //
 // HandleUpdateMap updates an existing map
 func HandleUpdateMap(c echo.Context) error {
+	ctx := c.Request().Context()
 	claims, ok := c.(middleware.JWTContext)
 	if !ok {
 		return c.JSON(
@@ -172,21 +205,27 @@ func HandleUpdateMap(c echo.Context) error {
 			errors.ServerError(errors.ErrInvalidJWT).JSON())
 	}
 
-	_map := *new(db.Map[string])
-	if err := c.Bind(&_map); err != nil {
+	// the map is identified by user and name, only fields present in the
+	// body are changed
+	var body struct {
+		UserID string `json:"user_id"`
+		Name   string `json:"name"`
+		db.MapPatch
+	}
+	if err := c.Bind(&body); err != nil {
 		return c.JSON(
 			http.StatusInternalServerError,
 			errors.ErrBindingPayload.JSON(),
 		)
 	}
 
-	if claims.UserID != _map.UserID {
+	if claims.UserID != body.UserID {
 		return c.JSON(
 			http.StatusUnauthorized,
 			errors.ServerError(errors.ErrInvalidJWT).JSON())
 	}
 
-	existingMap, err := db.GetMapByNameUserID(db.MongoDB, _map.Name, _map.UserID)
+	existingMap, err := db.GetMapByNameUserID(ctx, db.DB, body.Name, body.UserID)
 	if err != nil {
 		return c.JSON(
 			http.StatusInternalServerError,
@@ -194,8 +233,24 @@ func HandleUpdateMap(c echo.Context) error {
 		)
 	}
 
-	_map.ID = existingMap.ID
-	err = db.UpdateMap(db.MongoDB, _map)
+	// If-Match takes precedence over a version in the body
+	version, err := ifMatch(c, body.Version, existingMap.Version)
+	if err != nil {
+		return preconditionError(c, err, existingMap.Version)
+	}
+	body.Version = version
+	body.Author = claims.UserID
+
+	updated, err := db.UpdateMap(ctx, db.DB, existingMap.ID.Hex(), body.MapPatch)
+	if err == errors.ErrVersionConflict {
+		return versionConflict(c, updated)
+	}
+	if fields, ok := err.(errors.FieldErrors); ok {
+		return c.JSON(http.StatusBadRequest, fields.JSON())
+	}
+	if err == errors.ErrMapWrongFormat {
+		return c.JSON(http.StatusBadRequest, errors.ErrMapWrongFormat.JSON())
+	}
 	if err != nil {
 		return c.JSON(
 			http.StatusInternalServerError,
@@ -203,6 +258,7 @@ func HandleUpdateMap(c echo.Context) error {
 		)
 	}
 
+	setETag(c, updated)
 	return c.NoContent(http.StatusAccepted)
 }
 
@@ -210,6 +266,7 @@ func HandleUpdateMap(c echo.Context) error {
 //
 // HandleDeleteMap deletes a map by ID
 func HandleDeleteMap(c echo.Context) error {
+	ctx := c.Request().Context()
 	claims, ok := c.(middleware.JWTContext)
 	if !ok {
 		return c.JSON(
@@ -224,7 +281,7 @@ func HandleDeleteMap(c echo.Context) error {
 			errors.ErrMissingParams.JSON())
 	}
 
-	_map, err := db.GetMapByID(db.MongoDB, id)
+	_map, err := db.GetMapByID(ctx, db.DB, id)
 	if err != nil {
 		return c.JSON(
 			http.StatusInternalServerError,
@@ -238,7 +295,7 @@ func HandleDeleteMap(c echo.Context) error {
 			errors.ServerError(errors.ErrInvalidJWT).JSON())
 	}
 
-	err = db.DeleteMap(db.MongoDB, id)
+	err = db.DeleteMap(ctx, db.DB, id)
 	if err != nil {
 		return c.JSON(
 			http.StatusInternalServerError,
@@ -248,3 +305,22 @@ func HandleDeleteMap(c echo.Context) error {
 
 	return c.NoContent(http.StatusAccepted)
 }
+
+const primaryMapRepairInterval = time.Hour
+
+// StartPrimaryMapRepair periodically repairs users left with zero or
+// several primary maps
+func StartPrimaryMapRepair(ctx context.Context, store db.DatabaseClient) {
+	go func() {
+		ticker := time.NewTicker(primaryMapRepairInterval)
+		defer ticker.Stop()
+		for {
+			if repaired, err := db.RepairPrimaryMaps(ctx, store); err != nil {
+				log.Println("error repairing primary maps: ", err)
+			} else if repaired > 0 {
+				log.Println("repaired primary maps of users: ", repaired)
+			}
+			<-ticker.C
+		}
+	}()
+}
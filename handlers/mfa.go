@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/db"
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/middleware"
+	"github.com/snburman/game-server/utils"
+)
+
+const (
+	mfaIssuer          = "game-server"
+	mfaTokenExpiry     = time.Minute * 5
+	maxMFAAttempts     = 5
+	recoveryCodesCount = 10
+)
+
+type MFAEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	// Code is a TOTP code or a recovery code
+	Code string `json:"code"`
+}
+
+type MFAVerifyResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	// tokens replace those revoked when MFA is enabled
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// completeLogin issues tokens for an authenticated user, or an MFA token
+// if a second factor or MFA enrollment is required
+func (a *AuthService) completeLogin(c echo.Context, user db.User) error {
+	ctx := c.Request().Context()
+	userID := user.ID.Hex()
+	if user.MFAEnabled {
+		// the mfa token is single-use so a login cannot be completed twice
+		token, err := db.CreateUserToken(ctx, db.DB, userID, utils.MFAPendingPurpose, mfaTokenExpiry)
+		if err != nil {
+			log.Println("error creating mfa token: ", err)
+			return c.JSON(http.StatusInternalServerError, AuthResponse{
+				ServerError: errors.ErrServerError,
+			})
+		}
+		return c.JSON(http.StatusAccepted, AuthResponse{
+			ServerError: errors.ErrMFARequired,
+			MFAToken:    token,
+		})
+	}
+	settings, err := db.GetSettings(ctx, db.DB)
+	if err != nil {
+		log.Println("error getting settings: ", err)
+		return c.JSON(http.StatusInternalServerError, AuthResponse{
+			ServerError: errors.ErrServerError,
+		})
+	}
+	if settings.RoleRequiresMFA(user.Role) {
+		return enrollmentRequired(c, userID)
+	}
+	return c.JSON(http.StatusOK, newAuthResponse(userID))
+}
+
+// enrollmentRequired responds with an enrollment token instead of tokens
+// to a user whose role requires MFA but who has not enabled it
+func enrollmentRequired(c echo.Context, userID string) error {
+	return c.JSON(http.StatusForbidden, AuthResponse{
+		ServerError: errors.ErrMFAEnrollmentRequired,
+		MFAToken:    utils.GeneratePurposeJWT(userID, utils.MFAEnrollmentPurpose, uuid.NewString(), mfaTokenExpiry),
+	})
+}
+
+// HandleLoginMFA completes a login with the mfa token and a TOTP or recovery code
+func (a *AuthService) HandleLoginMFA(c echo.Context) error {
+	ctx := c.Request().Context()
+	req, err := middleware.UnmarshalClientDataContext[MFALoginRequest](c)
+	if err != nil {
+		return err
+	}
+	if req.MFAToken == "" || req.Code == "" {
+		return c.JSON(http.StatusUnauthorized, AuthResponse{
+			ServerError: errors.ErrMissingParams,
+		})
+	}
+	claims, err := utils.DecodePurposeJWT(req.MFAToken, utils.MFAPendingPurpose)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, AuthResponse{
+			ServerError: errors.ErrInvalidToken,
+		})
+	}
+	// attempts are limited per user as logging in again issues a new token
+	if !a.mfaAttempts.Allow(claims.UserID) {
+		return c.JSON(http.StatusTooManyRequests, AuthResponse{
+			ServerError: errors.ErrTooManyAttempts,
+		})
+	}
+	user, err := db.GetUserByID(ctx, db.DB, claims.UserID)
+	if err != nil || !user.MFAEnabled {
+		return c.JSON(http.StatusUnauthorized, AuthResponse{
+			ServerError: errors.ErrInvalidCredentials,
+		})
+	}
+	if user.Banned {
+		return c.JSON(http.StatusForbidden, AuthResponse{
+			ServerError: errors.ErrUserBanned,
+		})
+	}
+	if !a.checkSecondFactor(ctx, user, req.Code) {
+		return c.JSON(http.StatusForbidden, AuthResponse{
+			ServerError: errors.ErrInvalidMFACode,
+		})
+	}
+	if _, err := db.ConsumeUserToken(ctx, db.DB, req.MFAToken, utils.MFAPendingPurpose); err != nil {
+		return c.JSON(http.StatusUnauthorized, AuthResponse{
+			ServerError: errors.ErrInvalidToken,
+		})
+	}
+	a.mfaAttempts.Reset(claims.UserID)
+	return c.JSON(http.StatusOK, newAuthResponse(claims.UserID))
+}
+
+// HandleEnrollMFA starts MFA enrollment and returns the secret as an otpauth URI
+func (a *AuthService) HandleEnrollMFA(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok || claims.UserID == "" {
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidJWT.JSON())
+	}
+	user, err := db.GetUserByID(ctx, db.DB, claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidCredentials.JSON())
+	}
+	if user.MFAEnabled {
+		return c.JSON(http.StatusBadRequest, errors.ErrMFAEnabled.JSON())
+	}
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+	}
+	if err = db.SetUserMFASecret(ctx, db.DB, claims.UserID, secret); err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrUpdatingUser.JSON())
+	}
+	return c.JSON(http.StatusOK, MFAEnrollmentResponse{
+		Secret: secret,
+		URI:    utils.TOTPURI(mfaIssuer, user.UserName, secret),
+	})
+}
+
+// HandleVerifyMFA enables MFA once the user proves possession of the secret.
+// Recovery codes are only returned once.
+func (a *AuthService) HandleVerifyMFA(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok || claims.UserID == "" {
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidJWT.JSON())
+	}
+	var req MFACodeRequest
+	if err := c.Bind(&req); err != nil || req.Code == "" {
+		return c.JSON(http.StatusBadRequest, errors.ErrMissingParams.JSON())
+	}
+	user, err := db.GetUserByID(ctx, db.DB, claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidCredentials.JSON())
+	}
+	if user.MFAEnabled {
+		return c.JSON(http.StatusBadRequest, errors.ErrMFAEnabled.JSON())
+	}
+	if user.MFASecret == "" {
+		return c.JSON(http.StatusBadRequest, errors.ErrMFANotPending.JSON())
+	}
+	if !a.checkTOTP(ctx, user, req.Code) {
+		return c.JSON(http.StatusForbidden, errors.ErrInvalidMFACode.JSON())
+	}
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+	}
+	if err = db.EnableUserMFA(ctx, db.DB, claims.UserID, codes); err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrUpdatingUser.JSON())
+	}
+	// tokens issued without a second factor must not outlive enrollment
+	if err = db.RevokeUserTokens(ctx, db.DB, claims.UserID); err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+	}
+
+	res := MFAVerifyResponse{RecoveryCodes: codes}
+	// this client keeps its session, and enrollment required by role
+	// completes the login
+	if !user.Banned {
+		tokens := newAuthResponse(claims.UserID)
+		res.Token = tokens.Token
+		res.RefreshToken = tokens.RefreshToken
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// HandleDisableMFA disables MFA after checking a TOTP or recovery code
+func (a *AuthService) HandleDisableMFA(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok || claims.UserID == "" {
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidJWT.JSON())
+	}
+	var req MFACodeRequest
+	if err := c.Bind(&req); err != nil || req.Code == "" {
+		return c.JSON(http.StatusBadRequest, errors.ErrMissingParams.JSON())
+	}
+	user, err := db.GetUserByID(ctx, db.DB, claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidCredentials.JSON())
+	}
+	if !user.MFAEnabled {
+		return c.JSON(http.StatusBadRequest, errors.ErrMFANotEnabled.JSON())
+	}
+	settings, err := db.GetSettings(ctx, db.DB)
+	if err != nil {
+		log.Println("error getting settings: ", err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+	}
+	if settings.RoleRequiresMFA(user.Role) {
+		return c.JSON(http.StatusForbidden, errors.ErrMFAEnrollmentRequired.JSON())
+	}
+	if !a.checkSecondFactor(ctx, user, req.Code) {
+		return c.JSON(http.StatusForbidden, errors.ErrInvalidMFACode.JSON())
+	}
+	if err = db.DisableUserMFA(ctx, db.DB, claims.UserID); err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrUpdatingUser.JSON())
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+// checkSecondFactor validates a TOTP code or consumes a recovery code
+func (a *AuthService) checkSecondFactor(ctx context.Context, user db.User, code string) bool {
+	if a.checkTOTP(ctx, user, code) {
+		return true
+	}
+	ok, err := db.ConsumeRecoveryCode(ctx, db.DB, user, code)
+	if err != nil {
+		log.Println("error consuming recovery code: ", err)
+	}
+	return ok
+}
+
+// checkTOTP validates a TOTP code that was not accepted before
+func (a *AuthService) checkTOTP(ctx context.Context, user db.User, code string) bool {
+	step, ok := utils.MatchTOTP(user.MFASecret, code, time.Now())
+	if !ok {
+		return false
+	}
+	ok, err := db.AcceptTOTPStep(ctx, db.DB, user, step)
+	if err != nil {
+		log.Println("error accepting totp code: ", err)
+	}
+	return ok
+}
+
+// attemptLimiter counts failed attempts per key for a limited time
+type attemptLimiter struct {
+	mu       sync.Mutex
+	max      int
+	ttl      time.Duration
+	attempts map[string]attempt
+}
+
+type attempt struct {
+	count   int
+	expires time.Time
+}
+
+func newAttemptLimiter(max int, ttl time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		max:      max,
+		ttl:      ttl,
+		attempts: make(map[string]attempt),
+	}
+}
+
+// Allow records an attempt for key and reports whether it is within the limit
+func (l *attemptLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	// remove expired entries
+	for k, a := range l.attempts {
+		if now.After(a.expires) {
+			delete(l.attempts, k)
+		}
+	}
+	a, ok := l.attempts[key]
+	if !ok {
+		a = attempt{expires: now.Add(l.ttl)}
+	}
+	a.count++
+	l.attempts[key] = a
+	return a.count <= l.max
+}
+
+func (l *attemptLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.attempts, key)
+}
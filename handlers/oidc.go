@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/config"
+	"github.com/snburman/game-server/db"
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/middleware"
+	"github.com/snburman/game-server/oidc"
+)
+
+const oidcSessionName = "oidc_login"
+
+// newOIDCProviders builds the providers configured in the environment
+func newOIDCProviders() map[string]*oidc.Provider {
+	var cfgs []oidc.Config
+	serverURL := strings.TrimSuffix(config.Env().SERVER_URL, "/")
+	for _, p := range config.OIDCProviders() {
+		cfgs = append(cfgs, oidc.Config{
+			Name:         p.Name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  serverURL + "/auth/oidc/" + p.Name + "/callback",
+			Scopes:       p.Scopes,
+		})
+	}
+	return oidc.NewProviders(cfgs)
+}
+
+// @Param provider
+//
+// HandleOIDCLogin redirects to the provider's authorization endpoint
+func (a *AuthService) HandleOIDCLogin(c echo.Context) error {
+	provider, ok := a.providers[c.Param("provider")]
+	if !ok {
+		return c.JSON(http.StatusNotFound, errors.ErrProviderNotFound.JSON())
+	}
+	authURL, err := a.startOIDC(c, provider, "")
+	if err == errors.ErrOIDCLogin {
+		return c.JSON(http.StatusBadGateway, errors.ErrOIDCLogin.JSON())
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+	}
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// @Param provider
+//
+// HandleOIDCLink starts linking an identity of the provider to the user of
+// the access token. It responds with the authorization URL to open with the
+// session cookie it sets, the callback then links instead of logging in.
+func (a *AuthService) HandleOIDCLink(c echo.Context) error {
+	claims, ok := c.(middleware.JWTContext)
+	if !ok || claims.UserID == "" {
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidJWT.JSON())
+	}
+	provider, ok := a.providers[c.Param("provider")]
+	if !ok {
+		return c.JSON(http.StatusNotFound, errors.ErrProviderNotFound.JSON())
+	}
+	authURL, err := a.startOIDC(c, provider, claims.UserID)
+	if err == errors.ErrOIDCLogin {
+		return c.JSON(http.StatusBadGateway, errors.ErrOIDCLogin.JSON())
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+	}
+	return c.JSON(http.StatusOK, struct {
+		URL string `json:"url"`
+	}{
+		URL: authURL,
+	})
+}
+
+// startOIDC saves a login session linking to linkUserID, if set, and
+// returns the authorization URL. It fails with ErrOIDCLogin if the
+// provider cannot be reached.
+func (a *AuthService) startOIDC(c echo.Context, provider *oidc.Provider, linkUserID string) (string, error) {
+	state, err1 := oidc.RandomString()
+	nonce, err2 := oidc.RandomString()
+	verifier, err3 := oidc.RandomString()
+	if err1 != nil || err2 != nil || err3 != nil {
+		return "", errors.ErrServerError
+	}
+	authURL, err := provider.AuthCodeURL(c.Request().Context(), state, nonce, verifier)
+	if err != nil {
+		log.Println(err)
+		return "", errors.ErrOIDCLogin
+	}
+
+	session, _ := a.store.Get(c.Request(), oidcSessionName)
+	session.Values["provider"] = provider.Name
+	session.Values["state"] = state
+	session.Values["nonce"] = nonce
+	session.Values["verifier"] = verifier
+	session.Values["link_user_id"] = linkUserID
+	if err = session.Save(c.Request(), c.Response()); err != nil {
+		log.Println(err)
+		return "", err
+	}
+	return authURL, nil
+}
+
+// @Param provider
+//
+// @QueryParam code
+//
+// @QueryParam state
+//
+// HandleOIDCCallback exchanges the authorization code, links or creates
+// the user and responds with the same tokens as a password login
+func (a *AuthService) HandleOIDCCallback(c echo.Context) error {
+	ctx := c.Request().Context()
+	provider, ok := a.providers[c.Param("provider")]
+	if !ok {
+		return c.JSON(http.StatusNotFound, errors.ErrProviderNotFound.JSON())
+	}
+	if c.QueryParam("error") != "" {
+		return c.JSON(http.StatusUnauthorized, errors.ErrOIDCLogin.JSON())
+	}
+
+	// load and clear login session
+	session, err := a.store.Get(c.Request(), oidcSessionName)
+	if err != nil || session.IsNew {
+		return c.JSON(http.StatusUnauthorized, errors.ErrOIDCLogin.JSON())
+	}
+	sessionProvider, _ := session.Values["provider"].(string)
+	state, _ := session.Values["state"].(string)
+	nonce, _ := session.Values["nonce"].(string)
+	verifier, _ := session.Values["verifier"].(string)
+	linkUserID, _ := session.Values["link_user_id"].(string)
+	session.Options.MaxAge = -1
+	if err = session.Save(c.Request(), c.Response()); err != nil {
+		log.Println(err)
+	}
+	if sessionProvider != provider.Name || state == "" ||
+		subtle.ConstantTimeCompare([]byte(state), []byte(c.QueryParam("state"))) != 1 {
+		return c.JSON(http.StatusUnauthorized, errors.ErrOIDCLogin.JSON())
+	}
+
+	claims, err := provider.Exchange(c.Request().Context(), c.QueryParam("code"), verifier, nonce)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusUnauthorized, errors.ErrOIDCLogin.JSON())
+	}
+
+	// link to the logged in user
+	if linkUserID != "" {
+		_, err = db.CreateIdentity(ctx, db.DB, db.Identity{
+			UserID:   linkUserID,
+			Provider: provider.Name,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		})
+		if err == errors.ErrIdentityLinked {
+			return c.JSON(http.StatusConflict, errors.ErrIdentityLinked.JSON())
+		}
+		if err != nil {
+			log.Println(err)
+			return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+		}
+		return c.NoContent(http.StatusCreated)
+	}
+
+	user, err := a.userForIdentity(ctx, provider.Name, claims)
+	if err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, AuthResponse{
+			ServerError: errors.ErrOIDCLogin,
+		})
+	}
+	if user.Banned {
+		return c.JSON(http.StatusForbidden, AuthResponse{
+			ServerError: errors.ErrUserBanned,
+		})
+	}
+	return a.completeLogin(c, user)
+}
+
+// userForIdentity returns the user linked to the identity, linking a user
+// with the same verified email or creating a new user on first login
+func (a *AuthService) userForIdentity(ctx context.Context, provider string, claims *oidc.Claims) (db.User, error) {
+	identity, err := db.GetIdentity(ctx, db.DB, provider, claims.Subject)
+	if err == nil {
+		return db.GetUserByID(ctx, db.DB, identity.UserID)
+	}
+
+	newIdentity := db.Identity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	// both sides must have verified the email to prevent account takeover
+	if claims.Email != "" && claims.EmailVerified {
+		user, err := db.GetUserByEmail(ctx, db.DB, claims.Email)
+		if err == nil && user.EmailVerified {
+			newIdentity.UserID = user.ID.Hex()
+			_, err = db.CreateIdentity(ctx, db.DB, newIdentity)
+			return user, err
+		}
+	}
+
+	// create user on first login
+	base := claims.PreferredUsername
+	if base == "" && claims.Email != "" {
+		base = strings.Split(claims.Email, "@")[0]
+	}
+	if base == "" {
+		base = claims.Name
+	}
+	userName, err := db.UniqueUserName(ctx, db.DB, base)
+	if err != nil {
+		return db.User{}, err
+	}
+	newUser := db.User{UserName: userName}
+	// only keep verified emails that are not in use
+	if claims.Email != "" && claims.EmailVerified {
+		if _, err := db.GetUserByEmail(ctx, db.DB, claims.Email); err != nil {
+			newUser.Email = claims.Email
+			newUser.EmailVerified = true
+		}
+	}
+	id, err := db.CreateExternalUser(ctx, db.DB, newUser)
+	if err != nil {
+		return db.User{}, err
+	}
+	newIdentity.UserID = id.Hex()
+	if _, err = db.CreateIdentity(ctx, db.DB, newIdentity); err != nil {
+		return db.User{}, err
+	}
+	return db.GetUserByID(ctx, db.DB, id.Hex())
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/config"
+	"github.com/snburman/game-server/db"
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/mail"
+	"github.com/snburman/game-server/middleware"
+	"github.com/snburman/game-server/utils"
+)
+
+const (
+	passwordResetExpiry     = time.Hour
+	emailVerificationExpiry = time.Hour * 24
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// HandleForgotPassword emails a password reset token if the email belongs to a user.
+// Always responds with accepted so emails cannot be enumerated.
+func (a *AuthService) HandleForgotPassword(c echo.Context) error {
+	ctx := c.Request().Context()
+	req, err := middleware.UnmarshalClientDataContext[ForgotPasswordRequest](c)
+	if err != nil {
+		return err
+	}
+	if req.Email == "" {
+		return c.JSON(http.StatusBadRequest, errors.ErrMissingParams.JSON())
+	}
+
+	user, err := db.GetUserByEmail(ctx, db.DB, req.Email)
+	if err != nil || user.Banned {
+		return c.NoContent(http.StatusAccepted)
+	}
+	token, err := db.CreateUserToken(ctx, db.DB, user.ID.Hex(), utils.PasswordResetPurpose, passwordResetExpiry)
+	if err != nil {
+		log.Println("error creating reset token: ", err)
+		return c.NoContent(http.StatusAccepted)
+	}
+	err = a.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nUse the following token to reset your password. It expires in %s.\n\n%s\n\nIf you did not request a password reset you can ignore this email.\n",
+			user.UserName, passwordResetExpiry, token,
+		),
+	})
+	if err != nil {
+		log.Println("error sending reset email: ", err)
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+// HandleResetPassword sets a new password using a single-use reset token
+func (a *AuthService) HandleResetPassword(c echo.Context) error {
+	ctx := c.Request().Context()
+	req, err := middleware.UnmarshalClientDataContext[ResetPasswordRequest](c)
+	if err != nil {
+		return err
+	}
+	if req.Token == "" || req.Password == "" {
+		return c.JSON(http.StatusBadRequest, errors.ErrMissingParams.JSON())
+	}
+	// validate before consuming so a weak password does not burn the token
+	if err := utils.ValidatePassword(req.Password); err != nil {
+		return c.JSON(http.StatusBadRequest, errors.ErrWeakPassword.JSON())
+	}
+	userID, err := db.ConsumeUserToken(ctx, db.DB, req.Token, utils.PasswordResetPurpose)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidToken.JSON())
+	}
+	if err := db.SetUserPassword(ctx, db.DB, userID, req.Password); err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrUpdatingUser.JSON())
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+// @QueryParam token
+//
+// HandleVerifyEmail marks the user's email as verified using a single-use token
+func (a *AuthService) HandleVerifyEmail(c echo.Context) error {
+	ctx := c.Request().Context()
+	token := c.QueryParam("token")
+	if token == "" {
+		return c.JSON(http.StatusBadRequest, errors.ErrMissingParams.JSON())
+	}
+	userID, err := db.ConsumeUserToken(ctx, db.DB, token, utils.EmailVerificationPurpose)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidToken.JSON())
+	}
+	if err := db.SetUserEmailVerified(ctx, db.DB, userID); err != nil {
+		log.Println(err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrUpdatingUser.JSON())
+	}
+	return c.String(http.StatusOK, "email verified")
+}
+
+// HandleResendVerification sends a new verification email to the authenticated user
+func (a *AuthService) HandleResendVerification(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok || claims.UserID == "" {
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidJWT.JSON())
+	}
+	user, err := db.GetUserByID(ctx, db.DB, claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidCredentials.JSON())
+	}
+	if user.Email == "" {
+		return c.JSON(http.StatusBadRequest, errors.ErrInvalidEmail.JSON())
+	}
+	if user.EmailVerified {
+		return c.JSON(http.StatusBadRequest, errors.ErrEmailVerified.JSON())
+	}
+	if err := a.sendVerificationEmail(ctx, claims.UserID, user.Email); err != nil {
+		log.Println("error sending verification email: ", err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrSendingEmail.JSON())
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+func (a *AuthService) sendVerificationEmail(ctx context.Context, userID string, email string) error {
+	token, err := db.CreateUserToken(ctx, db.DB, userID, utils.EmailVerificationPurpose, emailVerificationExpiry)
+	if err != nil {
+		return err
+	}
+	return a.mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf(
+			"Follow the link below to verify your email. It expires in %s.\n\n%s/user/email/verify?token=%s\n",
+			emailVerificationExpiry, config.Env().SERVER_URL, token,
+		),
+	})
+}
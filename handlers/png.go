@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	stderrors "errors"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/db"
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/middleware"
+)
+
+const (
+	// maxPNGBytes limits the size of uploaded PNG files
+	maxPNGBytes = 1 << 20
+	// maxFormBytes limits the fields of upload forms besides their files
+	maxFormBytes = 64 << 10
+	// maxPNGSide limits the width and height of uploaded PNG images
+	maxPNGSide = 256
+	// maxPNGScale limits the scale of downloaded PNG images
+	maxPNGScale = 16
+)
+
+// @FormParam file PNG image
+//
+// @FormParam name
+//
+// @FormParam asset_type
+//
+// HandleUploadPlayerAssetPNG creates an asset of the user in JWT claims
+// from a PNG image. Its width and height are the size of the image.
+func HandleUploadPlayerAssetPNG(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok {
+		return c.JSON(
+			http.StatusUnauthorized,
+			errors.ServerError(errors.ErrInvalidJWT).JSON())
+	}
+	if err := parseUpload(c, maxPNGBytes+maxFormBytes); err != nil {
+		return uploadError(c, err)
+	}
+	name := c.FormValue("name")
+	assetType := db.AssetType(c.FormValue("asset_type"))
+	if name == "" || assetType == "" {
+		return c.JSON(http.StatusBadRequest, errors.ErrMissingParams.JSON())
+	}
+	f, err := openFormFile(c, "file")
+	if err != nil {
+		return uploadError(c, err)
+	}
+	defer f.Close()
+	img, err := decodePNG(f, maxPNGSide)
+	if err != nil {
+		return uploadError(c, err)
+	}
+
+	data, err := json.Marshal(db.ImagePixelData(img))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, errors.ErrCreatingImage.JSON())
+	}
+	id, err := db.CreatePlayerAsset(ctx, db.DB, db.PlayerAsset[string]{
+		UserID:    claims.UserID,
+		Name:      name,
+		AssetType: assetType,
+		Width:     img.Bounds().Dx(),
+		Height:    img.Bounds().Dy(),
+		Data:      string(data),
+	})
+	if err == errors.ErrImageExists {
+		return c.JSON(http.StatusNotAcceptable, errors.ErrImageExists.JSON())
+	}
+	if fields, ok := err.(errors.FieldErrors); ok {
+		return c.JSON(http.StatusBadRequest, fields.JSON())
+	}
+	if err != nil {
+		log.Println("error creating asset from png: ", err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrCreatingImage.JSON())
+	}
+	return c.JSON(http.StatusAccepted, db.InsertedIDResponse{
+		InsertedID: id.Hex(),
+	})
+}
+
+// @Param id
+//
+// @QueryParam scale 1 to 16, default 1
+//
+// @QueryParam frame index of an animation frame, default 0
+//
+// HandleGetPlayerAssetPNG renders a frame of an asset of the user in JWT
+// claims as a PNG image with every pixel scaled to a square of scale
+func HandleGetPlayerAssetPNG(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok {
+		return c.JSON(
+			http.StatusUnauthorized,
+			errors.ServerError(errors.ErrInvalidJWT).JSON())
+	}
+	scale := 1
+	if s := c.QueryParam("scale"); s != "" {
+		var err error
+		scale, err = strconv.Atoi(s)
+		if err != nil || scale < 1 || scale > maxPNGScale {
+			return c.JSON(http.StatusBadRequest, errors.ErrInvalidScale.JSON())
+		}
+	}
+	frame := 0
+	if f := c.QueryParam("frame"); f != "" {
+		var err error
+		if frame, err = strconv.Atoi(f); err != nil || frame < 0 {
+			return c.JSON(http.StatusNotFound, errors.ErrImageNotFound.JSON())
+		}
+	}
+	asset, err := db.GetPlayerAssetByID(ctx, db.DB, c.Param("id"))
+	// assets of other users are not found
+	if err == errors.ErrImageNotFound || (err == nil && asset.UserID != claims.UserID) {
+		return c.JSON(http.StatusNotFound, errors.ErrImageNotFound.JSON())
+	}
+	if err != nil {
+		log.Println("error getting asset: ", err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+	}
+
+	data := asset.Data
+	if frame > 0 {
+		if frame > len(asset.Frames) {
+			return c.JSON(http.StatusNotFound, errors.ErrImageNotFound.JSON())
+		}
+		data = asset.Frames[frame-1]
+	}
+
+	var buf bytes.Buffer
+	if err := db.EncodeScaledPNG(&buf, data, asset.Width, asset.Height, scale); err != nil {
+		log.Println("error encoding png: ", err)
+		return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+	}
+	c.Response().Header().Set(
+		echo.HeaderContentDisposition,
+		`attachment; filename="`+asset.ID.Hex()+`.png"`,
+	)
+	return c.Blob(http.StatusOK, "image/png", buf.Bytes())
+}
+
+// parseUpload parses the multipart form of an upload, reading at most
+// limit bytes of the request body
+func parseUpload(c echo.Context, limit int64) error {
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, limit)
+	if _, err := c.MultipartForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if stderrors.As(err, &tooLarge) {
+			return errors.ErrImageTooLarge
+		}
+		return errors.ErrMissingParams
+	}
+	return nil
+}
+
+// openFormFile opens the uploaded file in field, limited to maxPNGBytes
+func openFormFile(c echo.Context, field string) (io.ReadCloser, error) {
+	header, err := c.FormFile(field)
+	if err != nil {
+		return nil, errors.ErrMissingParams
+	}
+	if header.Size > maxPNGBytes {
+		return nil, errors.ErrImageTooLarge
+	}
+	f, err := header.Open()
+	if err != nil {
+		return nil, errors.ErrImageWrongFormat
+	}
+	return f, nil
+}
+
+// uploadError responds to an error reading an uploaded image
+func uploadError(c echo.Context, err error) error {
+	switch err {
+	case errors.ErrMissingParams:
+		return c.JSON(http.StatusBadRequest, errors.ErrMissingParams.JSON())
+	case errors.ErrImageTooLarge:
+		return c.JSON(http.StatusRequestEntityTooLarge, errors.ErrImageTooLarge.JSON())
+	}
+	return c.JSON(http.StatusBadRequest, errors.ErrImageWrongFormat.JSON())
+}
+
+// decodePNG decodes a PNG image after checking its size in the header, so
+// oversized images are rejected before their pixels are allocated
+func decodePNG(r io.Reader, maxSide int) (image.Image, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.ErrImageWrongFormat
+	}
+	config, err := png.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.ErrImageWrongFormat
+	}
+	if config.Width > maxSide || config.Height > maxSide {
+		return nil, errors.ErrImageTooLarge
+	}
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.ErrImageWrongFormat
+	}
+	return img, nil
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func uploadContext(t *testing.T, size int) echo.Context {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	assert.Nil(t, w.WriteField("name", "name"))
+	f, err := w.CreateFormFile("file", "image.png")
+	assert.Nil(t, err)
+	_, err = f.Write(make([]byte, size))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/", &body)
+	req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+	return echo.New().NewContext(req, httptest.NewRecorder())
+}
+
+func TestParseUpload(t *testing.T) {
+	c := uploadContext(t, 1024)
+	assert.Nil(t, parseUpload(c, maxPNGBytes+maxFormBytes))
+	assert.Equal(t, "name", c.FormValue("name"))
+	f, err := openFormFile(c, "file")
+	assert.Nil(t, err)
+	f.Close()
+
+	// the body is not read past the limit
+	c = uploadContext(t, maxPNGBytes+maxFormBytes)
+	assert.Equal(t, errors.ErrImageTooLarge, parseUpload(c, maxPNGBytes+maxFormBytes))
+}
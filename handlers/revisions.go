@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/db"
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/middleware"
+)
+
+// @Param id
+//
+// HandleGetMapRevisions lists the revisions of a map of the user in JWT
+// claims without their data, newest first
+func HandleGetMapRevisions(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok {
+		return c.JSON(
+			http.StatusUnauthorized,
+			errors.ServerError(errors.ErrInvalidJWT).JSON())
+	}
+	id := c.Param("id")
+	if _, err := ownedMap(ctx, claims.UserID, id); err != nil {
+		return revisionError(c, err)
+	}
+
+	revisions, err := db.GetMapRevisions(ctx, db.DB, id)
+	if err != nil {
+		return revisionError(c, err)
+	}
+	return c.JSON(http.StatusOK, revisions)
+}
+
+// @Param id
+//
+// @Param version
+//
+// HandleGetMapRevision retrieves a revision of a map of the user in JWT claims
+func HandleGetMapRevision(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok {
+		return c.JSON(
+			http.StatusUnauthorized,
+			errors.ServerError(errors.ErrInvalidJWT).JSON())
+	}
+	id := c.Param("id")
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		return revisionError(c, errors.ErrInvalidVersion)
+	}
+	if _, err := ownedMap(ctx, claims.UserID, id); err != nil {
+		return revisionError(c, err)
+	}
+
+	revision, err := db.GetMapRevision(ctx, db.DB, id, version)
+	if err != nil {
+		return revisionError(c, err)
+	}
+	return c.JSON(http.StatusOK, revision)
+}
+
+// @Param id
+//
+// @QueryParam from version
+//
+// @QueryParam to version
+//
+// HandleDiffMapRevisions lists the assets added, removed and moved between
+// two revisions of a map of the user in JWT claims
+func HandleDiffMapRevisions(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok {
+		return c.JSON(
+			http.StatusUnauthorized,
+			errors.ServerError(errors.ErrInvalidJWT).JSON())
+	}
+	id := c.Param("id")
+	from, err := strconv.Atoi(c.QueryParam("from"))
+	if err != nil {
+		return revisionError(c, errors.ErrInvalidVersion)
+	}
+	to, err := strconv.Atoi(c.QueryParam("to"))
+	if err != nil {
+		return revisionError(c, errors.ErrInvalidVersion)
+	}
+	if _, err := ownedMap(ctx, claims.UserID, id); err != nil {
+		return revisionError(c, err)
+	}
+
+	diff, err := db.DiffMapRevisions(ctx, db.DB, id, from, to)
+	if err != nil {
+		return revisionError(c, err)
+	}
+	return c.JSON(http.StatusOK, diff)
+}
+
+// @Param id
+//
+// @Param version
+//
+// @Body {"message": string, "version": int} optional
+//
+// HandleRollbackMap saves a revision of a map of the user in JWT claims as
+// its latest revision. The current version is checked as in HandleUpdateMap.
+func HandleRollbackMap(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok {
+		return c.JSON(
+			http.StatusUnauthorized,
+			errors.ServerError(errors.ErrInvalidJWT).JSON())
+	}
+	id := c.Param("id")
+	revision, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		return revisionError(c, errors.ErrInvalidVersion)
+	}
+	var body db.MapPatch
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(
+			http.StatusNotAcceptable,
+			errors.ErrBindingPayload.JSON(),
+		)
+	}
+	current, err := ownedMap(ctx, claims.UserID, id)
+	if err != nil {
+		return revisionError(c, err)
+	}
+	if body.Version, err = ifMatch(c, body.Version, current.Version); err != nil {
+		return preconditionError(c, err, current.Version)
+	}
+	body.Author = claims.UserID
+
+	updated, err := db.RollbackMap(ctx, db.DB, id, revision, body)
+	if err == errors.ErrVersionConflict {
+		return versionConflict(c, updated)
+	}
+	if err != nil {
+		return revisionError(c, err)
+	}
+	setETag(c, updated)
+	return c.NoContent(http.StatusAccepted)
+}
+
+// ownedMap returns the map with id, ErrMapNotFound unless it exists and
+// ErrInvalidJWT unless it belongs to userID
+func ownedMap(ctx context.Context, userID string, id string) (db.Map[[]db.PlayerAsset[db.PixelData]], error) {
+	_map, err := db.GetMapByID(ctx, db.DB, id)
+	if err != nil {
+		return _map, errors.ErrMapNotFound
+	}
+	if _map.UserID != userID {
+		return _map, errors.ErrInvalidJWT
+	}
+	return _map, nil
+}
+
+// revisionError responds to an error of a revision handler
+func revisionError(c echo.Context, err error) error {
+	// revisions saved before map assets were validated
+	if fields, ok := err.(errors.FieldErrors); ok {
+		return c.JSON(http.StatusBadRequest, fields.JSON())
+	}
+	switch err {
+	case errors.ErrMapNotFound, errors.ErrRevisionNotFound:
+		return c.JSON(http.StatusNotFound, errors.ServerError(err.Error()).JSON())
+	case errors.ErrInvalidJWT:
+		return c.JSON(http.StatusUnauthorized, errors.ErrInvalidJWT.JSON())
+	case errors.ErrInvalidVersion:
+		return c.JSON(http.StatusBadRequest, errors.ErrInvalidVersion.JSON())
+	}
+	log.Println("error handling map revisions: ", err)
+	return c.JSON(http.StatusInternalServerError, errors.ErrServerError.JSON())
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/db"
+	"github.com/snburman/game-server/errors"
+	"github.com/snburman/game-server/middleware"
+)
+
+// deleted maps and assets can be restored until they are purged
+const (
+	trashRetention     = 30 * 24 * time.Hour
+	trashPurgeInterval = time.Hour
+)
+
+// HandleGetTrash retrieves the deleted maps and assets of the user in JWT claims
+func HandleGetTrash(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok {
+		return c.JSON(
+			http.StatusUnauthorized,
+			errors.ServerError(errors.ErrInvalidJWT).JSON())
+	}
+
+	trash, err := db.GetTrash(ctx, db.DB, claims.UserID)
+	if err != nil {
+		return c.JSON(
+			http.StatusInternalServerError,
+			errors.ServerError(err.Error()).JSON(),
+		)
+	}
+	return c.JSON(http.StatusOK, trash)
+}
+
+// @Param id
+//
+// HandleRestoreMap restores a deleted map of the user in JWT claims
+func HandleRestoreMap(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok {
+		return c.JSON(
+			http.StatusUnauthorized,
+			errors.ServerError(errors.ErrInvalidJWT).JSON())
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(
+			http.StatusBadRequest,
+			errors.ErrMissingParams.JSON())
+	}
+
+	err := db.RestoreMap(ctx, db.DB, claims.UserID, id)
+	switch err {
+	case nil:
+		return c.NoContent(http.StatusAccepted)
+	case errors.ErrMapNotFound:
+		return c.JSON(http.StatusNotFound, errors.ErrMapNotFound.JSON())
+	case errors.ErrMapExists:
+		return c.JSON(http.StatusConflict, errors.ErrMapExists.JSON())
+	default:
+		return c.JSON(
+			http.StatusInternalServerError,
+			errors.ServerError(err.Error()).JSON(),
+		)
+	}
+}
+
+// @Param id
+//
+// HandleRestorePlayerAsset restores a deleted asset of the user in JWT claims
+func HandleRestorePlayerAsset(c echo.Context) error {
+	ctx := c.Request().Context()
+	claims, ok := c.(middleware.JWTContext)
+	if !ok {
+		return c.JSON(
+			http.StatusUnauthorized,
+			errors.ServerError(errors.ErrInvalidJWT).JSON())
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(
+			http.StatusBadRequest,
+			errors.ErrMissingParams.JSON())
+	}
+
+	err := db.RestorePlayerAsset(ctx, db.DB, claims.UserID, id)
+	switch err {
+	case nil:
+		return c.NoContent(http.StatusAccepted)
+	case errors.ErrImageNotFound:
+		return c.JSON(http.StatusNotFound, errors.ErrImageNotFound.JSON())
+	case errors.ErrImageExists:
+		return c.JSON(http.StatusConflict, errors.ErrImageExists.JSON())
+	default:
+		return c.JSON(
+			http.StatusInternalServerError,
+			errors.ServerError(err.Error()).JSON(),
+		)
+	}
+}
+
+// StartTrashPurge periodically deletes maps and assets that have been
+// in the trash longer than the retention period
+func StartTrashPurge(ctx context.Context, store db.DatabaseClient) {
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+		for {
+			if purged, err := db.PurgeTrash(ctx, store, time.Now().Add(-trashRetention)); err != nil {
+				log.Println("error purging trash: ", err)
+			} else if purged > 0 {
+				log.Println("purged trash documents: ", purged)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,37 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogMailer writes messages to Path, or to the standard logger
+// if Path is empty. Intended for local development.
+type LogMailer struct {
+	mu   sync.Mutex
+	Path string
+}
+
+func (l *LogMailer) Send(msg Message) error {
+	entry := fmt.Sprintf(
+		"--- %s\nTo: %s\nSubject: %s\n\n%s\n",
+		time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body,
+	)
+	if l.Path == "" {
+		log.Print(entry)
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(entry)
+	return err
+}
@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"github.com/snburman/game-server/config"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// NewMailer returns an SMTPMailer when SMTP_HOST is set,
+// otherwise a LogMailer for local development
+func NewMailer() Mailer {
+	env := config.Env()
+	if env.SMTP_HOST == "" {
+		return &LogMailer{Path: env.MAIL_LOG_PATH}
+	}
+	port := env.SMTP_PORT
+	if port == "" {
+		port = "587"
+	}
+	return &SMTPMailer{
+		Host:     env.SMTP_HOST,
+		Port:     port,
+		Username: env.SMTP_USERNAME,
+		Password: env.SMTP_PASSWORD,
+		From:     env.SMTP_FROM,
+	}
+}
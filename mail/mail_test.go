@@ -0,0 +1,38 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogMailerSend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	mailer := &LogMailer{Path: path}
+
+	err := mailer.Send(Message{
+		To:      "player@example.com",
+		Subject: "subject",
+		Body:    "body",
+	})
+	assert.Nil(t, err)
+
+	b, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), "To: player@example.com")
+	assert.Contains(t, string(b), "Subject: subject")
+	assert.Contains(t, string(b), "body")
+}
+
+func TestFormatMessage(t *testing.T) {
+	msg := formatMessage("game@example.com", Message{
+		To:      "player@example.com\r\nBcc: other@example.com",
+		Subject: "subject",
+		Body:    "body",
+	})
+	assert.False(t, strings.Contains(string(msg), "\r\nBcc:"), "expected header injection to be stripped")
+	assert.True(t, strings.HasSuffix(string(msg), "\r\n\r\nbody"))
+}
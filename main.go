@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"log"
 	"net/http"
 	"os"
 
@@ -8,9 +10,22 @@ import (
 	"github.com/snburman/game-server/db"
 	"github.com/snburman/game-server/handlers"
 	"github.com/snburman/game-server/middleware"
+	"github.com/snburman/game-server/utils"
 )
 
 func main() {
+	ctx := context.Background()
+
+	// apply pending migrations and exit
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		db.Open()
+		if err := db.Migrate(ctx, db.DB); err != nil {
+			log.Fatal("error applying migrations: ", err)
+		}
+		log.Println("migrations applied")
+		return
+	}
+
 	e := echo.New()
 	// use cors
 	e.Use(middleware.MiddlewareCORS)
@@ -33,8 +48,37 @@ func main() {
 	e.GET("/user", middleware.MiddlewareJWT(authService.HandleGetUser))
 	e.POST("/user/create", middleware.MiddleWareClientHeaders(middleware.MiddlewareClientDTO(authService.HandleCreateUser)))
 	e.POST("/user/login", middleware.MiddleWareClientHeaders(middleware.MiddlewareClientDTO(authService.HandleLoginUser)))
+	e.POST("/user/login/mfa", middleware.MiddleWareClientHeaders(middleware.MiddlewareClientDTO(authService.HandleLoginMFA)))
 	e.PATCH("/user/update", middleware.MiddlewareJWT(authService.HandleUpdateUser))
+	e.PATCH("/user/username", middleware.MiddlewareJWT(authService.HandleRenameUser))
 	e.DELETE("/user/delete", middleware.MiddlewareJWT(authService.HandleDeleteUser))
+	e.POST("/user/delete/cancel", middleware.MiddlewareJWT(authService.HandleCancelDeleteUser))
+	e.POST("/user/export", middleware.MiddlewareJWT(handlers.HandleCreateExport))
+	e.GET("/user/export/:id", middleware.MiddlewareJWT(handlers.HandleGetExport))
+	e.GET("/user/export/:id/download", middleware.MiddlewareJWT(handlers.HandleDownloadExport))
+	e.POST("/user/password/forgot", middleware.MiddleWareClientHeaders(middleware.MiddlewareClientDTO(authService.HandleForgotPassword)))
+	e.POST("/user/password/reset", middleware.MiddleWareClientHeaders(middleware.MiddlewareClientDTO(authService.HandleResetPassword)))
+	e.GET("/user/email/verify", authService.HandleVerifyEmail)
+	e.POST("/user/email/verify", middleware.MiddlewareJWT(authService.HandleResendVerification))
+	// two-factor authentication
+	e.POST("/user/mfa/enroll", middleware.MiddlewareJWTWithPurpose(utils.MFAEnrollmentPurpose, authService.HandleEnrollMFA))
+	e.POST("/user/mfa/verify", middleware.MiddlewareJWTWithPurpose(utils.MFAEnrollmentPurpose, authService.HandleVerifyMFA))
+	e.POST("/user/mfa/disable", middleware.MiddlewareJWT(authService.HandleDisableMFA))
+
+	// external identity providers
+	e.GET("/auth/oidc/:provider/login", authService.HandleOIDCLogin)
+	e.GET("/auth/oidc/:provider/callback", authService.HandleOIDCCallback)
+	e.POST("/auth/oidc/:provider/link", middleware.MiddlewareJWT(authService.HandleOIDCLink))
+
+	// admin endpoints
+	e.GET("/admin/settings/mfa", middleware.MiddlewareAdmin(handlers.HandleGetMFARequiredRoles))
+	e.PUT("/admin/settings/mfa", middleware.MiddlewareAdmin(handlers.HandleSetMFARequiredRoles))
+	e.GET("/admin/keys", middleware.MiddlewareAdmin(handlers.HandleGetAPIKeys))
+	e.POST("/admin/keys", middleware.MiddlewareAdmin(handlers.HandleCreateAPIKey))
+	e.POST("/admin/keys/:id/rotate", middleware.MiddlewareAdmin(handlers.HandleRotateAPIKey))
+	e.DELETE("/admin/keys/:id", middleware.MiddlewareAdmin(handlers.HandleRevokeAPIKey))
+	e.PUT("/admin/users/:id/role", middleware.MiddlewareAdmin(handlers.HandleSetUserRole))
+	e.GET("/admin/cache", middleware.MiddlewareAdmin(handlers.HandleGetCacheStats))
 
 	// game endpoints
 	//
@@ -52,12 +96,20 @@ func main() {
 	//
 	// all assets
 	e.GET("/assets", middleware.MiddlewareJWT(handlers.HandleGetAssets))
+	// built-in sprite catalog
+	e.GET("/assets/catalog", handlers.HandleGetCatalog)
+	e.GET("/assets/catalog/:version", handlers.HandleGetCatalogVersion)
 	// assets by player
 	e.GET("/assets/player", middleware.MiddlewareJWT(handlers.HandleGetPlayerAssets))
 	// default player character
 	e.POST("/assets/player", middleware.MiddlewareJWT(handlers.HandleCreatePlayerAsset))
 	e.PATCH("/assets/player", middleware.MiddlewareJWT(handlers.HandleUpdatePlayerAsset))
 	e.DELETE("/assets/player", middleware.MiddlewareJWT(handlers.HandleDeletePlayerAsset))
+	// png import and export
+	e.POST("/assets/player/png", middleware.MiddlewareJWT(handlers.HandleUploadPlayerAssetPNG))
+	e.GET("/assets/player/:id/png", middleware.MiddlewareJWT(handlers.HandleGetPlayerAssetPNG))
+	// character assets from aseprite files or sprite sheets
+	e.POST("/assets/player/aseprite", middleware.MiddlewareJWT(handlers.HandleImportAseprite))
 
 	// maps
 	e.GET("/maps", middleware.MiddlewareJWT(handlers.HandleGetAllMaps))
@@ -66,9 +118,26 @@ func main() {
 	e.GET("/maps/player", middleware.MiddlewareJWT(handlers.HandleGetPlayerMaps))
 	e.GET("/maps/:id", middleware.MiddlewareJWT(handlers.HandleGetMapByID))
 	e.DELETE("/maps/:id", middleware.MiddlewareJWT(handlers.HandleDeleteMap))
+	// map revisions
+	e.GET("/maps/:id/revisions", middleware.MiddlewareJWT(handlers.HandleGetMapRevisions))
+	e.GET("/maps/:id/revisions/:version", middleware.MiddlewareJWT(handlers.HandleGetMapRevision))
+	e.POST("/maps/:id/revisions/:version/rollback", middleware.MiddlewareJWT(handlers.HandleRollbackMap))
+	e.GET("/maps/:id/diff", middleware.MiddlewareJWT(handlers.HandleDiffMapRevisions))
+
+	// trash
+	e.GET("/trash", middleware.MiddlewareJWT(handlers.HandleGetTrash))
+	e.POST("/trash/maps/:id/restore", middleware.MiddlewareJWT(handlers.HandleRestoreMap))
+	e.POST("/trash/assets/:id/restore", middleware.MiddlewareJWT(handlers.HandleRestorePlayerAsset))
 
 	// database
-	db.NewMongoDriver()
+	db.Connect(ctx)
+	handlers.StartTokenRevocationLoad(ctx, db.DB)
+	handlers.StartAccountPurge(ctx, db.DB)
+	handlers.StartPrimaryMapRepair(ctx, db.DB)
+	handlers.StartTrashPurge(ctx, db.DB)
+	if err := handlers.LoadCatalog(ctx, db.DB, os.DirFS(".")); err != nil {
+		log.Println("error loading sprite catalog: ", err)
+	}
 
 	PORT := os.Getenv("PORT")
 	if PORT == "" {
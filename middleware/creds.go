@@ -4,9 +4,10 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/labstack/echo/v4"
-	"github.com/snburman/game-server/config"
+	"github.com/snburman/game-server/db"
 	"github.com/snburman/game-server/errors"
 )
 
@@ -62,15 +63,28 @@ func UnmarshalClientDataContext[T any](c echo.Context) (T, error) {
 	return data, nil
 }
 
+// apiKeyLimiter limits requests per API key per minute
+var apiKeyLimiter = NewRateLimiter(time.Minute)
+
+// MiddleWareClientHeaders authenticates the API key sent as CLIENT_ID and
+// CLIENT_SECRET headers and checks its scopes and rate limit
 func MiddleWareClientHeaders(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		clientID := c.Request().Header.Get("CLIENT_ID")
 		clientSecret := c.Request().Header.Get("CLIENT_SECRET")
 
-		if clientID != config.Env().CLIENT_ID || clientSecret != config.Env().CLIENT_SECRET {
+		key, err := db.AuthenticateAPIKey(c.Request().Context(), db.DB, clientID, clientSecret)
+		if err != nil {
 			log.Println("invalid_client_credentials")
 			return c.NoContent(http.StatusUnauthorized)
 		}
+		if !key.Allows(c.Path()) {
+			log.Println("api_key_scope_denied: ", key.Name, c.Path())
+			return c.JSON(http.StatusForbidden, errors.ErrAPIKeyScope.JSON())
+		}
+		if !apiKeyLimiter.Allow(key.ID.Hex(), key.RateLimit) {
+			return c.JSON(http.StatusTooManyRequests, errors.ErrRateLimited.JSON())
+		}
 		return next(c)
 	}
 }
@@ -4,6 +4,8 @@ import (
 	"net/http"
 
 	"github.com/labstack/echo/v4"
+	"github.com/snburman/game-server/config"
+	"github.com/snburman/game-server/db"
 	"github.com/snburman/game-server/errors"
 	"github.com/snburman/game-server/utils"
 )
@@ -14,6 +16,12 @@ type JWTContext struct {
 }
 
 func MiddlewareJWT(next echo.HandlerFunc) echo.HandlerFunc {
+	// purpose tokens are not valid for authentication
+	return MiddlewareJWTWithPurpose("", next)
+}
+
+// MiddlewareJWTWithPurpose accepts access tokens and tokens issued for purpose
+func MiddlewareJWTWithPurpose(purpose utils.TokenPurpose, next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		token, err := utils.ParseJWTHeader(c)
 		if token == "" || err != nil {
@@ -23,7 +31,9 @@ func MiddlewareJWT(next echo.HandlerFunc) echo.HandlerFunc {
 			)
 		}
 		claims, err := utils.DecodeJWT(token)
-		if err != nil || claims.UserID == "" {
+		if err != nil || claims.UserID == "" ||
+			(claims.Purpose != "" && claims.Purpose != purpose) ||
+			db.TokenRevoked(claims.UserID, claims.IssuedAtTime()) {
 			return c.JSON(
 				http.StatusUnauthorized,
 				errors.AuthenticationError(errors.ErrInvalidJWT).JSON(),
@@ -36,3 +46,18 @@ func MiddlewareJWT(next echo.HandlerFunc) echo.HandlerFunc {
 		return next(ctx)
 	}
 }
+
+// MiddlewareAdmin only allows access tokens of the admin user
+func MiddlewareAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return MiddlewareJWT(func(c echo.Context) error {
+		claims, ok := c.(JWTContext)
+		adminID := config.Env().ADMIN_ID
+		if !ok || adminID == "" || claims.UserID != adminID {
+			return c.JSON(
+				http.StatusForbidden,
+				errors.ErrForbidden.JSON(),
+			)
+		}
+		return next(c)
+	})
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter counts requests per key in fixed windows
+type RateLimiter struct {
+	mu      sync.Mutex
+	window  time.Duration
+	windows map[string]rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func NewRateLimiter(window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		window:  window,
+		windows: make(map[string]rateWindow),
+	}
+}
+
+// Allow records a request for key and reports whether it is within limit.
+// A limit of 0 or less is unlimited.
+func (r *RateLimiter) Allow(key string, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	w, ok := r.windows[key]
+	if !ok || now.Sub(w.start) >= r.window {
+		w = rateWindow{start: now}
+	}
+	w.count++
+	r.windows[key] = w
+	return w.count <= limit
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	limiter := NewRateLimiter(time.Minute)
+
+	assert.True(t, limiter.Allow("key", 2))
+	assert.True(t, limiter.Allow("key", 2))
+	assert.False(t, limiter.Allow("key", 2))
+	// other keys are counted separately
+	assert.True(t, limiter.Allow("other_key", 2))
+	// no limit
+	assert.True(t, limiter.Allow("unlimited", 0))
+}
+
+func TestRateLimiterWindow(t *testing.T) {
+	limiter := NewRateLimiter(time.Millisecond * 10)
+
+	assert.True(t, limiter.Allow("key", 1))
+	assert.False(t, limiter.Allow("key", 1))
+	time.Sleep(time.Millisecond * 20)
+	assert.True(t, limiter.Allow("key", 1))
+}
@@ -0,0 +1,197 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+const mockClientID = "client_id"
+
+// mockProvider is a minimal OpenID Connect provider issuing one authorization code
+type mockProvider struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	code      string
+	challenge string
+	nonce     string
+	claims    Claims
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{key: key, code: "auth_code"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                m.server.URL,
+			AuthorizationEndpoint: m.server.URL + "/authorize",
+			TokenEndpoint:         m.server.URL + "/token",
+			JWKSURI:               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]jwk{
+			"keys": {{
+				Kid: "key_1",
+				Kty: "RSA",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != m.code || CodeChallenge(r.Form.Get("code_verifier")) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(tokenResponse{Error: "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(tokenResponse{
+			AccessToken: "access_token",
+			IDToken:     m.idToken(t, m.claims),
+			TokenType:   "Bearer",
+		})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	m.claims = Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.server.URL,
+			Subject:   "subject_1",
+			Audience:  jwt.ClaimStrings{mockClientID},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+		Email:             "player@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "player",
+	}
+	return m
+}
+
+func (m *mockProvider) idToken(t *testing.T, claims Claims) string {
+	claims.Nonce = m.nonce
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "key_1"
+	s, err := token.SignedString(m.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// authorize simulates the user approving the login at the provider
+func (m *mockProvider) authorize(t *testing.T, authURL string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.challenge = u.Query().Get("code_challenge")
+	m.nonce = u.Query().Get("nonce")
+}
+
+func newTestProvider(m *mockProvider) *Provider {
+	return NewProvider(Config{
+		Name:        "mock",
+		Issuer:      m.server.URL,
+		ClientID:    mockClientID,
+		RedirectURL: "http://localhost/auth/oidc/mock/callback",
+	})
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(m)
+
+	authURL, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	assert.Nil(t, err)
+
+	u, err := url.Parse(authURL)
+	assert.Nil(t, err)
+	assert.Equal(t, m.server.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(t, "state", u.Query().Get("state"))
+	assert.Equal(t, "nonce", u.Query().Get("nonce"))
+	assert.Equal(t, CodeChallenge("verifier"), u.Query().Get("code_challenge"))
+	assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+}
+
+func TestExchange(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		m := newMockProvider(t)
+		p := newTestProvider(m)
+		authURL, _ := p.AuthCodeURL(ctx, "state", "nonce", "verifier")
+		m.authorize(t, authURL)
+
+		claims, err := p.Exchange(ctx, m.code, "verifier", "nonce")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "subject_1", claims.Subject)
+		assert.Equal(t, "player@example.com", claims.Email)
+		assert.True(t, claims.EmailVerified)
+	})
+
+	t.Run("failure-wrong-verifier", func(t *testing.T) {
+		m := newMockProvider(t)
+		p := newTestProvider(m)
+		authURL, _ := p.AuthCodeURL(ctx, "state", "nonce", "verifier")
+		m.authorize(t, authURL)
+
+		_, err := p.Exchange(ctx, m.code, "other_verifier", "nonce")
+
+		assert.ErrorIs(t, err, ErrExchange)
+	})
+
+	t.Run("failure-wrong-nonce", func(t *testing.T) {
+		m := newMockProvider(t)
+		p := newTestProvider(m)
+		authURL, _ := p.AuthCodeURL(ctx, "state", "nonce", "verifier")
+		m.authorize(t, authURL)
+
+		_, err := p.Exchange(ctx, m.code, "verifier", "other_nonce")
+
+		assert.ErrorIs(t, err, ErrInvalidIDToken)
+	})
+
+	t.Run("failure-wrong-audience", func(t *testing.T) {
+		m := newMockProvider(t)
+		m.claims.Audience = jwt.ClaimStrings{"other_client"}
+		p := newTestProvider(m)
+		authURL, _ := p.AuthCodeURL(ctx, "state", "nonce", "verifier")
+		m.authorize(t, authURL)
+
+		_, err := p.Exchange(ctx, m.code, "verifier", "nonce")
+
+		assert.ErrorIs(t, err, ErrInvalidIDToken)
+	})
+
+	t.Run("failure-expired", func(t *testing.T) {
+		m := newMockProvider(t)
+		m.claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+		p := newTestProvider(m)
+		authURL, _ := p.AuthCodeURL(ctx, "state", "nonce", "verifier")
+		m.authorize(t, authURL)
+
+		_, err := p.Exchange(ctx, m.code, "verifier", "nonce")
+
+		assert.ErrorIs(t, err, ErrInvalidIDToken)
+	})
+}
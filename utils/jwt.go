@@ -11,18 +11,37 @@ import (
 	"github.com/snburman/game-server/config"
 )
 
+type TokenPurpose string
+
+const (
+	PasswordResetPurpose     TokenPurpose = "password_reset"
+	EmailVerificationPurpose TokenPurpose = "email_verification"
+	// MFAPendingPurpose is issued after a password login when a second factor is required
+	MFAPendingPurpose TokenPurpose = "mfa_pending"
+	// MFAEnrollmentPurpose only allows enrolling in MFA when required by role
+	MFAEnrollmentPurpose TokenPurpose = "mfa_enrollment"
+)
+
 type JWTClaims struct {
 	jwt.RegisteredClaims
 	UserID string `json:"user_id"`
+	// Purpose is empty for access and refresh tokens
+	Purpose TokenPurpose `json:"purpose,omitempty"`
+	// IssuedAtMilli is the issue time in Unix milliseconds. iat only has
+	// seconds, too coarse to compare with token revocations.
+	IssuedAtMilli int64 `json:"iat_ms,omitempty"`
 }
 
 func GenerateJWT(UserID string, expiry time.Duration) string {
 	// set claims
+	now := time.Now()
 	claims := JWTClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiry)),
 		},
-		UserID: UserID,
+		UserID:        UserID,
+		IssuedAtMilli: now.UnixMilli(),
 	}
 	// generate token
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -35,6 +54,39 @@ func GenerateJWT(UserID string, expiry time.Duration) string {
 	return t
 }
 
+// GeneratePurposeJWT generates a token that is only valid for purpose.
+// tokenID is stored as the jti claim so the token can be made single-use.
+func GeneratePurposeJWT(UserID string, purpose TokenPurpose, tokenID string, expiry time.Duration) string {
+	claims := JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
+		},
+		UserID:  UserID,
+		Purpose: purpose,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	t, err := token.SignedString([]byte(config.Env().SECRET))
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// DecodePurposeJWT decodes a token and rejects it if not issued for purpose
+func DecodePurposeJWT(token string, purpose TokenPurpose) (*JWTClaims, error) {
+	claims, err := DecodeJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose || claims.ID == "" {
+		return nil, errors.New("invalid_jwt_purpose")
+	}
+	return claims, nil
+}
+
 func ParseJWTHeader(c echo.Context) (string, error) {
 	auth := c.Request().Header["Authorization"]
 	if len(auth) == 0 {
@@ -64,3 +116,15 @@ func DecodeJWT(token string) (*JWTClaims, error) {
 	}
 	return claims, nil
 }
+
+// IssuedAtTime returns the issue time with millisecond precision if the
+// token has it, else the iat claim or the zero time if missing
+func (c *JWTClaims) IssuedAtTime() time.Time {
+	if c.IssuedAtMilli != 0 {
+		return time.UnixMilli(c.IssuedAtMilli)
+	}
+	if c.IssuedAt == nil {
+		return time.Time{}
+	}
+	return c.IssuedAt.Time
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIssuedAtTime(t *testing.T) {
+	t.Setenv("SECRET", "secret")
+	before := time.Now().Truncate(time.Millisecond)
+	claims, err := DecodeJWT(GenerateJWT("user", time.Minute))
+	assert.Nil(t, err)
+
+	// iat keeps second precision, the issue time has milliseconds
+	assert.Equal(t, claims.IssuedAt.Time, claims.IssuedAt.Truncate(time.Second))
+	assert.False(t, claims.IssuedAtTime().Before(before))
+	assert.False(t, claims.IssuedAtTime().After(time.Now()))
+
+	// tokens issued before iat_ms fall back to iat
+	claims.IssuedAtMilli = 0
+	assert.Equal(t, claims.IssuedAt.Time, claims.IssuedAtTime())
+}
@@ -7,10 +7,11 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
+// ValidatePassword checks password strength rules
+func ValidatePassword(password string) error {
 	// password must contain one lowercase, one uppercase, one special character, and be at least 8 characters long
 	if len(password) < 8 {
-		return "", errors.ErrWeakPassword
+		return errors.ErrWeakPassword
 	}
 	upper := false
 	lower := false
@@ -27,7 +28,14 @@ func HashPassword(password string) (string, error) {
 		}
 	}
 	if !(upper && lower && number) {
-		return "", errors.ErrWeakPassword
+		return errors.ErrWeakPassword
+	}
+	return nil
+}
+
+func HashPassword(password string) (string, error) {
+	if err := ValidatePassword(password); err != nil {
+		return "", err
 	}
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 10)
 	return string(bytes), err
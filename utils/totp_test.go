@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateTOTPCode(t *testing.T) {
+	// RFC 6238 test vector for SHA1, truncated to 6 digits
+	secret := base32NoPadding.EncodeToString([]byte("12345678901234567890"))
+	code, err := GenerateTOTPCode(secret, time.Unix(59, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, "287082", code)
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.Nil(t, err)
+	now := time.Now()
+	code, err := GenerateTOTPCode(secret, now)
+	assert.Nil(t, err)
+
+	assert.True(t, ValidateTOTP(secret, code, now))
+	assert.True(t, ValidateTOTP(secret, code, now.Add(totpPeriod*time.Second)))
+	assert.False(t, ValidateTOTP(secret, code, now.Add(5*totpPeriod*time.Second)))
+	assert.False(t, ValidateTOTP(secret, "", now))
+
+	step, ok := MatchTOTP(secret, code, now.Add(totpPeriod*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/totpPeriod, step)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("game-server", "player", "SECRET")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/game-server:player?"))
+	assert.Contains(t, uri, "secret=SECRET")
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	assert.Nil(t, err)
+	assert.Len(t, codes, 10)
+	for _, code := range codes {
+		assert.Len(t, code, 11)
+	}
+	assert.NotEqual(t, codes[0], codes[1])
+}